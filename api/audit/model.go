@@ -0,0 +1,47 @@
+package audit
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+)
+
+const EventPasswordReset = "password.reset"
+const EventPasswordChanged = "password.changed"
+const EventEmailChanged = "email.changed"
+const EventEmailChangeOverride = "email.change_override"
+const EventMfaEnabled = "mfa.enabled"
+const EventMfaDisabled = "mfa.disabled"
+const EventMfaRecoveryCodeUsed = "mfa.recovery_code_used"
+const EventWebauthnRegistered = "webauthn.registered"
+const EventWebauthnRemoved = "webauthn.removed"
+const EventAccountLocked = "account.locked"
+const EventAccountUnlocked = "account.unlocked"
+const EventSessionRevoked = "session.revoked"
+const EventSessionsRevoked = "session.revoked_all"
+const EventIdentityLinked = "identity.linked"
+const EventIdentityUnlinked = "identity.unlinked"
+const EventApiKeyCreated = "api_key.created"
+const EventApiKeyRevoked = "api_key.revoked"
+
+// Event is an append-only record of a security relevant action of the user
+type Event struct {
+	ID        uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4()"`
+	UserID    uuid.UUID  `gorm:"type:uuid;not null"`
+	ActorID   *uuid.UUID `gorm:"type:uuid;null;default:null"`
+	Event     string     `gorm:"type:varchar(60);not null"`
+	Ip        string     `gorm:"type:varchar(45);null;default:null"`
+	UserAgent string     `gorm:"type:text;null;default:null"`
+	CreatedAt time.Time  `gorm:"type:timestamp;not null"`
+}
+
+func (Event) TableName() string {
+	return "audit_events"
+}
+
+func (p *Event) BeforeCreate(tx *gorm.DB) (err error) {
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	return
+}
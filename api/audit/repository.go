@@ -0,0 +1,30 @@
+package audit
+
+import (
+	"github.com/apiboxgo/library-utils/api_init"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"time"
+)
+
+func CreateEvent(event *Event) error {
+	return api_init.GetDbh().Create(event).Error
+}
+
+// Record writes the event of the user with client address and user agent of the request
+func Record(c *gin.Context, event string, userId uuid.UUID, actorId *uuid.UUID) error {
+	return CreateEvent(&Event{
+		UserID:    userId,
+		ActorID:   actorId,
+		Event:     event,
+		Ip:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		CreatedAt: time.Now(),
+	})
+}
+
+func GetUserEvents(userId uuid.UUID, event string) ([]Event, error) {
+	var result []Event
+	err := api_init.GetDbh().Where("user_id = ? AND event = ?", userId, event).Order("created_at DESC").Find(&result).Error
+	return result, err
+}
@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"github.com/google/uuid"
+	"strings"
+	"time"
+	"user-service/env"
+)
+
+type actionTokenConfig struct {
+	TokenTTL       time.Duration
+	ResendInterval time.Duration
+	ResendWindow   time.Duration
+	ResendLimit    int
+	Url            string
+}
+
+// actionTokenMailData is passed to templates of emails with a token link
+type actionTokenMailData struct {
+	Email     string
+	Link      string
+	ExpiresIn string
+}
+
+// loadActionTokenConfig reads settings of the token purpose, for example AUTH_VERIFICATION_TOKEN_TTL for
+// the AUTH_VERIFICATION prefix. Url is a format string with a single %s for the token.
+func loadActionTokenConfig(prefix string, tokenTTL time.Duration) actionTokenConfig {
+	return actionTokenConfig{
+		TokenTTL:       env.Duration(prefix+"_TOKEN_TTL", tokenTTL),
+		ResendInterval: env.Duration(prefix+"_RESEND_INTERVAL", time.Minute),
+		ResendWindow:   env.Duration(prefix+"_RESEND_WINDOW", time.Hour),
+		ResendLimit:    env.Int(prefix+"_RESEND_LIMIT", 5),
+		Url:            env.String(prefix+"_URL", "http://127.0.0.1:8081/?token=%s"),
+	}
+}
+
+// issueActionToken stores hash of a new single-use token and returns the token itself
+func issueActionToken(userId uuid.UUID, purpose string, ttl time.Duration, now time.Time) (string, error) {
+	raw, hash, err := NewOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = CreateActionToken(&ActionToken{
+		UserID:    userId,
+		Purpose:   purpose,
+		TokenHash: hash,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	})
+	return raw, err
+}
+
+// isActionTokenLimited checks that the user did not request too many tokens of the purpose recently. A retry of the
+// send job is not limited: its first attempt passed the limit, and the token it issued before the send failed would
+// suppress the mail the user is waiting for.
+func isActionTokenLimited(userId uuid.UUID, purpose string, config actionTokenConfig, isRetry bool, now time.Time) (bool, error) {
+	if isRetry {
+		return false, nil
+	}
+
+	issued, err := GetActionTokensIssuedSince(userId, purpose, now.Add(-config.ResendWindow))
+	if err != nil {
+		return false, err
+	}
+
+	if len(issued) >= config.ResendLimit {
+		return true, nil
+	}
+	return len(issued) > 0 && issued[0].After(now.Add(-config.ResendInterval)), nil
+}
+
+// formatTTL prints 24h instead of 24h0m0s
+func formatTTL(ttl time.Duration) string {
+	value := ttl.String()
+	if strings.HasSuffix(value, "m0s") {
+		value = strings.TrimSuffix(value, "0s")
+	}
+	if strings.HasSuffix(value, "h0m") {
+		value = strings.TrimSuffix(value, "0m")
+	}
+	return value
+}
@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"user-service/env"
+	"user-service/ratelimit"
+)
+
+// ApiKeyPrefix starts every key, so keys are told apart from access tokens and found by secret scanners
+const ApiKeyPrefix = "usk_"
+
+// ApiKeyScopeRead allows safe methods only, ApiKeyScopeWrite allows any method, ApiKeyScopeAdmin passes the roles of
+// the user, without it the key acts as a user without roles
+const ApiKeyScopeRead = "read"
+const ApiKeyScopeWrite = "write"
+const ApiKeyScopeAdmin = "admin"
+
+var ApiKeyScopes = []string{ApiKeyScopeRead, ApiKeyScopeWrite, ApiKeyScopeAdmin}
+
+// apiKeyTouchInterval limits writes of the last use time
+const apiKeyTouchInterval = time.Minute
+
+// apiKeyPrefixLength is the beginning of the key kept in plain text, the prefix and 8 random characters
+const apiKeyPrefixLength = len(ApiKeyPrefix) + 8
+
+const apiKeyNameLength = 100
+
+var ErrApiKeyExpiry = errors.New(ErrorApiKeyExpiry)
+var ErrApiKeyLimit = errors.New(ErrorApiKeyLimit)
+
+type apiKeyConfig struct {
+	// Limit of active keys of one user
+	Limit int
+	// MaxTTL is the longest lifetime of a key, 0 allows keys without expiry
+	MaxTTL time.Duration
+}
+
+func loadApiKeyConfig() apiKeyConfig {
+	return apiKeyConfig{
+		Limit:  env.Int("AUTH_API_KEY_LIMIT", 20),
+		MaxTTL: env.Duration("AUTH_API_KEY_MAX_TTL", 0),
+	}
+}
+
+// requestApiKey returns the API key of the request, it is sent as a bearer token or in X-Api-Key
+func requestApiKey(c *gin.Context) string {
+	raw := BearerToken(c)
+	if strings.HasPrefix(raw, ApiKeyPrefix) {
+		return raw
+	}
+	if raw != "" {
+		return ""
+	}
+	return strings.TrimSpace(c.GetHeader(ratelimit.HeaderApiKey))
+}
+
+// findActiveApiKey returns the key which is neither revoked nor expired, nil means there is no such key
+func findActiveApiKey(raw string, now time.Time) (*ApiKey, error) {
+	if !strings.HasPrefix(raw, ApiKeyPrefix) {
+		return nil, nil
+	}
+
+	apiKey, err := GetApiKeyByHash(HashToken(raw))
+	if err != nil || apiKey == nil {
+		return nil, err
+	}
+	if apiKey.RevokedAt != nil || (apiKey.ExpiresAt != nil && !apiKey.ExpiresAt.After(now)) {
+		return nil, nil
+	}
+	return apiKey, nil
+}
+
+// apiKeyAllowsMethod tells whether the scopes of the key allow the request method
+func apiKeyAllowsMethod(scopes []string, method string) bool {
+	if slices.Contains(scopes, ApiKeyScopeWrite) {
+		return true
+	}
+
+	isSafe := method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+	return isSafe && slices.Contains(scopes, ApiKeyScopeRead)
+}
+
+// createApiKey issues the key for the user and returns it with the raw key, which is shown once. The expiry is
+// required and limited when AUTH_API_KEY_MAX_TTL is set.
+func createApiKey(userId uuid.UUID, request RequestApiKeyDto, now time.Time) (*ApiKey, string, error) {
+	config := loadApiKeyConfig()
+
+	if request.ExpiresAt != nil && !request.ExpiresAt.After(now) {
+		return nil, "", ErrApiKeyExpiry
+	}
+	if config.MaxTTL > 0 && (request.ExpiresAt == nil || request.ExpiresAt.After(now.Add(config.MaxTTL))) {
+		return nil, "", ErrApiKeyExpiry
+	}
+
+	count, err := CountActiveApiKeys(userId, now)
+	if err != nil {
+		return nil, "", err
+	}
+	if count >= int64(config.Limit) {
+		return nil, "", ErrApiKeyLimit
+	}
+
+	token, _, err := NewOpaqueToken()
+	if err != nil {
+		return nil, "", err
+	}
+	raw := ApiKeyPrefix + token
+
+	scopes := []string{}
+	for _, scope := range ApiKeyScopes {
+		if slices.Contains(request.Scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	name := []rune(strings.TrimSpace(request.Name))
+	if len(name) > apiKeyNameLength {
+		name = name[:apiKeyNameLength]
+	}
+
+	apiKey := &ApiKey{
+		UserID:    userId,
+		Name:      string(name),
+		Prefix:    raw[:apiKeyPrefixLength],
+		KeyHash:   HashToken(raw),
+		Scopes:    strings.Join(scopes, " "),
+		ExpiresAt: request.ExpiresAt,
+		CreatedAt: now,
+	}
+	return apiKey, raw, CreateApiKey(apiKey)
+}
+
+func apiKeyDto(apiKey ApiKey) ApiKeyDto {
+	return ApiKeyDto{
+		ID:         apiKey.ID,
+		Name:       apiKey.Name,
+		Prefix:     apiKey.Prefix,
+		Scopes:     strings.Fields(apiKey.Scopes),
+		ExpiresAt:  apiKey.ExpiresAt,
+		LastUsedAt: apiKey.LastUsedAt,
+		LastUsedIp: apiKey.LastUsedIp,
+		CreatedAt:  apiKey.CreatedAt,
+	}
+}
@@ -0,0 +1,230 @@
+package auth
+
+import (
+	"encoding/json"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/google/uuid"
+	"time"
+)
+
+// ============================== Request DTO ==========================================================================
+
+type RequestLoginDto struct {
+	Email    string `json:"email" binding:"required,email" example:"Some user email"`
+	Password string `json:"password" binding:"required" example:"Some user password"`
+}
+
+type RequestRefreshTokenDto struct {
+	RefreshToken string `json:"refresh_token" binding:"required" example:"Refresh token"`
+}
+
+type RequestTokenDto struct {
+	Token string `json:"token" binding:"required" example:"Token from the link"`
+}
+
+type RequestMagicLinkDto struct {
+	Token string `form:"token" binding:"required" example:"Token from the link"`
+}
+
+type RequestEmailDto struct {
+	Email string `json:"email" binding:"required,email" example:"Some user email"`
+}
+
+type RequestResetPasswordDto struct {
+	Token    string `json:"token" binding:"required" example:"Token from the link"`
+	Password string `json:"password" binding:"required" example:"New password"`
+}
+
+type RequestChangePasswordDto struct {
+	CurrentPassword string `json:"current_password" binding:"required" example:"Current password"`
+	Password        string `json:"password" binding:"required" example:"New password"`
+}
+
+type RequestMfaCodeDto struct {
+	Code string `json:"code" binding:"required" example:"123456"`
+}
+
+// RequestMfaDisableDto needs the code or the recovery code, admin disabling MFA of another user sends neither
+type RequestMfaDisableDto struct {
+	Code         string `json:"code" example:"123456"`
+	RecoveryCode string `json:"recovery_code" example:"abcde-fghij"`
+}
+
+// RequestMfaVerifyDto needs one of the code, the recovery code or the passkey assertion
+type RequestMfaVerifyDto struct {
+	MfaToken     string          `json:"mfa_token" binding:"required" example:"Token from the login response"`
+	Code         string          `json:"code" example:"123456"`
+	RecoveryCode string          `json:"recovery_code" example:"abcde-fghij"`
+	Webauthn     json.RawMessage `json:"webauthn" swaggertype:"object"`
+}
+
+// RequestWebauthnRegisterDto carries the PublicKeyCredential returned by navigator.credentials.create()
+type RequestWebauthnRegisterDto struct {
+	SessionToken string          `json:"session_token" binding:"required" example:"Token from the begin response"`
+	Name         string          `json:"name" example:"Laptop"`
+	Credential   json.RawMessage `json:"credential" binding:"required" swaggertype:"object"`
+}
+
+// RequestWebauthnLoginDto carries the PublicKeyCredential returned by navigator.credentials.get()
+type RequestWebauthnLoginDto struct {
+	SessionToken string          `json:"session_token" binding:"required" example:"Token from the begin response"`
+	Credential   json.RawMessage `json:"credential" binding:"required" swaggertype:"object"`
+}
+
+type RequestEmailChangeDto struct {
+	Email    string `json:"email" binding:"required,email" example:"New user email"`
+	Override bool   `json:"override" example:"false"`
+}
+
+// ============================== Response DTO =========================================================================
+
+type ErrorResponseDto struct {
+	Message string `json:"message"`
+}
+
+type SuccessResponseDto struct {
+	Message string `json:"message"`
+}
+
+type AccountStatusErrorDto struct {
+	Message        string     `json:"message"`
+	Status         string     `json:"status"`
+	Reason         string     `json:"reason"`
+	SuspendedUntil *time.Time `json:"suspended_until"`
+}
+
+// TokenPairDto has no refresh token and must_change_password set when the password has expired, the access token then
+// works only for POST /auth/password/change
+type TokenPairDto struct {
+	AccessToken        string `json:"access_token"`
+	RefreshToken       string `json:"refresh_token"`
+	TokenType          string `json:"token_type"`
+	ExpiresIn          int64  `json:"expires_in"`
+	MustChangePassword bool   `json:"must_change_password,omitempty"`
+}
+
+// MfaChallengeDto is returned by login instead of tokens when the second factor is enabled. Webauthn has options for
+// navigator.credentials.get() when the user has passkeys.
+type MfaChallengeDto struct {
+	MfaRequired bool                          `json:"mfa_required"`
+	MfaToken    string                        `json:"mfa_token"`
+	ExpiresIn   int64                         `json:"expires_in"`
+	Webauthn    *protocol.CredentialAssertion `json:"webauthn,omitempty" swaggertype:"object"`
+}
+
+type MfaTotpEnrollmentDto struct {
+	Secret     string `json:"secret"`
+	OtpauthUri string `json:"otpauth_uri"`
+}
+
+type MfaRecoveryCodesDto struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// WebauthnCeremonyDto has options for navigator.credentials.create() or get(), the session token is sent back with
+// the result
+type WebauthnCeremonyDto struct {
+	SessionToken string `json:"session_token"`
+	Options      any    `json:"options" swaggertype:"object"`
+}
+
+type WebauthnCredentialDto struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	Transports []string   `json:"transports"`
+	BackedUp   bool       `json:"backed_up"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// SessionDto is a signed-in device, Current marks the session of the request
+type SessionDto struct {
+	ID         uuid.UUID `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	Ip         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+// RequestSessionsDeleteDto of sign out everywhere, ExceptCurrent keeps the session of the request
+type RequestSessionsDeleteDto struct {
+	ExceptCurrent bool `form:"except_current"`
+}
+
+// RequestLoginHistoryDto pages the history back in time, Before is the created_at of the last event of the previous page
+type RequestLoginHistoryDto struct {
+	Limit  int       `form:"limit" example:"50"`
+	Before time.Time `form:"before" time_format:"2006-01-02T15:04:05Z07:00" example:"2026-10-19T12:00:00Z"`
+}
+
+// LoginEventDto is a sign-in attempt, FailureReason is empty for a success
+type LoginEventDto struct {
+	ID            uuid.UUID `json:"id"`
+	Method        string    `json:"method"`
+	Success       bool      `json:"success"`
+	FailureReason string    `json:"failure_reason"`
+	Ip            string    `json:"ip"`
+	UserAgent     string    `json:"user_agent"`
+	Country       string    `json:"country"`
+	City          string    `json:"city"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// ExternalLoginDto has the URL of the provider the browser is sent to, the provider redirects back with code and state
+type ExternalLoginDto struct {
+	AuthorizationUrl string `json:"authorization_url"`
+	ExpiresIn        int64  `json:"expires_in"`
+}
+
+// RequestExternalFinishDto has the query of the provider redirect, the callback page of the frontend sends it as is
+type RequestExternalFinishDto struct {
+	Code  string `json:"code" binding:"required"`
+	State string `json:"state" binding:"required"`
+}
+
+type ExternalProvidersDto struct {
+	Providers []string `json:"providers"`
+}
+
+// UserIdentityDto is an account of an external provider linked to the user
+type UserIdentityDto struct {
+	ID         uuid.UUID  `json:"id"`
+	Provider   string     `json:"provider"`
+	Email      string     `json:"email"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// RequestApiKeyDto creates a personal API key, without ExpiresAt the key does not expire
+type RequestApiKeyDto struct {
+	Name      string     `json:"name" binding:"required,max=100" example:"CI deploy"`
+	Scopes    []string   `json:"scopes" binding:"required,min=1,dive,oneof=read write admin" example:"read"`
+	ExpiresAt *time.Time `json:"expires_at" example:"2027-01-01T00:00:00Z"`
+}
+
+// ApiKeyDto is a personal API key, Prefix is the beginning of the key to tell keys apart
+type ApiKeyDto struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	LastUsedIp string     `json:"last_used_ip"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// ApiKeyCreatedDto has the key itself, it is shown only once
+type ApiKeyCreatedDto struct {
+	ApiKeyDto
+	Key string `json:"key"`
+}
+
+type TokenClaimsDto struct {
+	UserID    uuid.UUID  `json:"user_id"`
+	Roles     []string   `json:"roles"`
+	Scope     string     `json:"scope"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
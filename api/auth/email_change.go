@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"time"
+	"user-service/api/user"
+	"user-service/mailer"
+)
+
+const TokenPurposeEmailChange = "email_change"
+const MailTemplateEmailChangeConfirm = "email_change_confirm"
+const MailTemplateEmailChangeNotice = "email_change_notice"
+const MailTemplateEmailChanged = "email_changed"
+
+type emailChangeMailData struct {
+	Email     string
+	NewEmail  string
+	Link      string
+	ExpiresIn string
+}
+
+var ErrTooManyRequests = errors.New(ErrorTooManyRequests)
+
+// requestEmailChange stores the pending email, sends the confirmation link to it and the notice to the current
+// address. Tokens of previous requests stop working.
+func requestEmailChange(ctx context.Context, account *user.UserItemResultDto, email string, locale string) error {
+	config := loadActionTokenConfig("AUTH_EMAIL_CHANGE", 24*time.Hour)
+	now := time.Now()
+
+	isLimited, err := isActionTokenLimited(account.ID, TokenPurposeEmailChange, config, false, now)
+	if err != nil {
+		return err
+	}
+	if isLimited {
+		return ErrTooManyRequests
+	}
+
+	if err := RevokeActionTokens(account.ID, TokenPurposeEmailChange, now); err != nil {
+		return err
+	}
+
+	if err := user.SetPendingEmail(account.ID, email, now); err != nil {
+		return err
+	}
+
+	raw, err := issueActionToken(account.ID, TokenPurposeEmailChange, config.TokenTTL, now)
+	if err != nil {
+		return err
+	}
+
+	data := emailChangeMailData{
+		Email:     account.Email,
+		NewEmail:  email,
+		Link:      fmt.Sprintf(config.Url, raw),
+		ExpiresIn: formatTTL(config.TokenTTL),
+	}
+	if err := mailer.SendTemplate(ctx, email, locale, MailTemplateEmailChangeConfirm, data); err != nil {
+		return err
+	}
+
+	return mailer.SendTemplate(ctx, account.Email, locale, MailTemplateEmailChangeNotice, data)
+}
+
+// confirmEmailChange swaps the email of the token owner, it returns uuid.Nil for an invalid token
+func confirmEmailChange(token string) (uuid.UUID, error) {
+	now := time.Now()
+	actionToken, err := UseActionToken(TokenPurposeEmailChange, HashToken(token), now)
+	if err != nil || actionToken == nil {
+		return uuid.Nil, err
+	}
+
+	email, err := user.ApplyPendingEmail(actionToken.UserID, now)
+	if err != nil || email == "" {
+		return uuid.Nil, err
+	}
+
+	return actionToken.UserID, nil
+}
+
+// overrideEmailChange sets the email without confirmation and notifies the previous address
+func overrideEmailChange(ctx context.Context, account *user.UserItemResultDto, email string, locale string) error {
+	if err := user.ChangeEmail(account.ID, email, time.Now()); err != nil {
+		return err
+	}
+
+	return mailer.SendTemplate(ctx, account.Email, locale, MailTemplateEmailChanged, emailChangeMailData{
+		Email:    account.Email,
+		NewEmail: email,
+	})
+}
@@ -0,0 +1,211 @@
+package auth
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"golang.org/x/oauth2"
+	"net/http"
+	"time"
+	"user-service/api/user"
+	"user-service/env"
+	"user-service/idp"
+)
+
+const ExternalPurposeLogin = "login"
+const ExternalPurposeLink = "link"
+
+// ExternalLoginDeviceCookie binds the state to the browser which started the sign-in, a callback URL opened on another
+// device does not work there
+const ExternalLoginDeviceCookie = "external_login_device"
+
+var ErrExternalLoginStateInvalid = errors.New(ErrorExternalLoginStateInvalid)
+var ErrExternalAccountExists = errors.New(ErrorExternalAccountExists)
+var ErrExternalSignUpDenied = errors.New(ErrorExternalSignUpDenied)
+var ErrExternalIdentityLinked = errors.New(ErrorExternalIdentityLinked)
+var ErrExternalProviderLinked = errors.New(ErrorExternalProviderLinked)
+
+type externalLoginConfig struct {
+	StateTTL time.Duration
+	// AutoLink signs in the existing user with the same email, both the provider and the user must have it verified
+	AutoLink bool
+	SignUp   bool
+}
+
+func loadExternalLoginConfig() externalLoginConfig {
+	return externalLoginConfig{
+		StateTTL: env.Duration("AUTH_EXTERNAL_STATE_TTL", 10*time.Minute),
+		AutoLink: env.Bool("AUTH_EXTERNAL_AUTO_LINK", true),
+		SignUp:   env.Bool("AUTH_EXTERNAL_SIGN_UP", true),
+	}
+}
+
+func setExternalLoginDeviceCookie(c *gin.Context, value string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(ExternalLoginDeviceCookie, value, maxAge, "/", "", env.Bool("AUTH_EXTERNAL_COOKIE_SECURE", true), true)
+}
+
+// externalLoginHash is the stored hash of the state, it is mixed with the device secret like the magic link
+func externalLoginHash(state string, device string) string {
+	return HashToken(state + "." + device)
+}
+
+// beginExternalLogin stores the state of the sign-in at the provider and returns the URL the browser is sent to,
+// userId is set when the identity is linked to the signed-in user
+func beginExternalLogin(c *gin.Context, provider *idp.Provider, userId *uuid.UUID, purpose string, now time.Time) (*ExternalLoginDto, error) {
+	config := loadExternalLoginConfig()
+
+	state, _, err := NewOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	device, _, err := NewOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, _, err := NewOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	verifier := oauth2.GenerateVerifier()
+	authorizationUrl, err := provider.AuthCodeUrl(state, nonce, verifier)
+	if err != nil {
+		return nil, err
+	}
+
+	err = CreateExternalLoginState(&ExternalLoginState{
+		UserID:       userId,
+		Provider:     provider.Name,
+		Purpose:      purpose,
+		StateHash:    externalLoginHash(state, device),
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    now.Add(config.StateTTL),
+		CreatedAt:    now,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	setExternalLoginDeviceCookie(c, device, int(config.StateTTL.Seconds()))
+	return &ExternalLoginDto{
+		AuthorizationUrl: authorizationUrl,
+		ExpiresIn:        int64(config.StateTTL.Seconds()),
+	}, nil
+}
+
+// finishExternalLogin uses the state sent from the device which started the sign-in and exchanges the code at the
+// provider. An unknown, expired or used state is ErrExternalLoginStateInvalid, a rejected code is idp.ErrInvalidIdentity.
+func finishExternalLogin(c *gin.Context, provider *idp.Provider, purpose string, request RequestExternalFinishDto, now time.Time) (*ExternalLoginState, *idp.Identity, error) {
+	device, _ := c.Cookie(ExternalLoginDeviceCookie)
+	if device == "" {
+		return nil, nil, ErrExternalLoginStateInvalid
+	}
+
+	state, err := UseExternalLoginState(provider.Name, purpose, externalLoginHash(request.State, device), now)
+	if err != nil {
+		return nil, nil, err
+	}
+	if state == nil {
+		return nil, nil, ErrExternalLoginStateInvalid
+	}
+
+	setExternalLoginDeviceCookie(c, "", -1)
+
+	identity, err := provider.Exchange(c.Request.Context(), request.Code, state.CodeVerifier, state.Nonce)
+	return state, identity, err
+}
+
+// resolveExternalAccount returns the user of the identity. A linked identity signs its user in. Otherwise the identity
+// is linked to the user with the same email when both sides have it verified, else a new user is signed up. An email
+// which is not verified on either side is ErrExternalAccountExists, so nobody takes over an account by a provider
+// which does not check emails. The second result is the identity linked by the call.
+func resolveExternalAccount(provider *idp.Provider, identity *idp.Identity, now time.Time) (uuid.UUID, *UserIdentity, error) {
+	linked, err := GetUserIdentity(provider.Name, identity.Subject)
+	if err != nil {
+		return uuid.Nil, nil, err
+	}
+
+	if linked != nil {
+		return linked.UserID, nil, TouchUserIdentity(linked.ID, identity.Email, now)
+	}
+
+	config := loadExternalLoginConfig()
+	created := &UserIdentity{
+		Provider:   provider.Name,
+		Subject:    identity.Subject,
+		Email:      identity.Email,
+		CreatedAt:  now,
+		LastUsedAt: &now,
+	}
+
+	existing, err := user.GetOneByEmail(identity.Email)
+	if err != nil {
+		return uuid.Nil, nil, err
+	}
+
+	if existing != nil && existing.ID != uuid.Nil {
+		if !config.AutoLink || !identity.EmailVerified || existing.EmailVerifiedAt == nil {
+			return uuid.Nil, nil, ErrExternalAccountExists
+		}
+
+		created.UserID = existing.ID
+		if err := linkUserIdentity(created); err != nil {
+			return uuid.Nil, nil, err
+		}
+		return existing.ID, created, nil
+	}
+
+	if !config.SignUp || !identity.EmailVerified {
+		return uuid.Nil, nil, ErrExternalSignUpDenied
+	}
+
+	// The user signs in only by the provider until a password is set with the reset
+	account := &user.User{
+		Email:           identity.Email,
+		EmailVerifiedAt: &now,
+		CreatedAt:       now,
+	}
+	if err := CreateExternalUser(account, created); err != nil {
+		return uuid.Nil, nil, err
+	}
+	return account.ID, nil, nil
+}
+
+// linkUserIdentity links the identity to the user, the provider account may be linked to one user only and the user
+// may have one account of each provider
+func linkUserIdentity(identity *UserIdentity) error {
+	linked, err := GetUserIdentity(identity.Provider, identity.Subject)
+	if err != nil {
+		return err
+	}
+	if linked != nil {
+		return ErrExternalIdentityLinked
+	}
+
+	identities, err := GetUserIdentities(identity.UserID)
+	if err != nil {
+		return err
+	}
+	for _, current := range identities {
+		if current.Provider == identity.Provider {
+			return ErrExternalProviderLinked
+		}
+	}
+
+	return CreateUserIdentity(identity)
+}
+
+// isLastSignInMethod tells whether the user would have no way to sign in without the identity, identities are all
+// identities of the user including the one to unlink
+func isLastSignInMethod(account *user.UserItemFullResultDto, identities []UserIdentity) (bool, error) {
+	if account.Password != "" || len(identities) > 1 {
+		return false, nil
+	}
+
+	hasPasskeys, err := HasWebauthnCredentials(account.ID)
+	return !hasPasskeys, err
+}
@@ -0,0 +1,2755 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"github.com/apiboxgo/library-utils/dictionary"
+	"github.com/apiboxgo/library-utils/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/google/uuid"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"user-service/api/audit"
+	"user-service/api/job"
+	"user-service/api/user"
+	_ "user-service/docs"
+	"user-service/idp"
+)
+
+// dummyPasswordHash is compared when the email is unknown, so the response time does not reveal registered emails
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, _ := user.HashPassword(uuid.NewString())
+	return hash
+})
+
+// ================================== Login ============================================================================
+//	@title			Login
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// Login godoc
+// @Summary      Login
+// @Description  Exchange email and password for an access and refresh token pair. When the password has expired only
+// @Description  an access token for POST /auth/password/change is returned with must_change_password set. With
+// @Description  two-factor authentication enabled MfaChallengeDto is returned, tokens are issued by POST /auth/mfa/verify.
+// @Description  Repeated failures of the account or the client delay next attempts and lock the account for a while,
+// @Description  429 is returned with the Retry-After header then.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        request body RequestLoginDto true "Credentials"
+// @Success      200 {object}  TokenPairDto
+// @Failure      401 {object}  ErrorResponseDto
+// @Failure      403 {object}  AccountStatusErrorDto
+// @Failure      422 {object}  ErrorResponseDto
+// @Failure      429 {object}  ErrorResponseDto
+// @Router       /auth/login [post]
+func Login(c *gin.Context) {
+	var requestLoginDto RequestLoginDto
+	if err := c.ShouldBindJSON(&requestLoginDto); err != nil {
+		utils.LogError(dictionary.ErrorParsingRequestBody, err)
+		c.JSON(http.StatusUnprocessableEntity, &ErrorResponseDto{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if !checkLoginThrottle(c, requestLoginDto.Email) {
+		recordLoginFailed(c, uuid.Nil, LoginMethodPassword, LoginFailureTooManyAttempts)
+		return
+	}
+
+	account, err := user.GetOneByEmail(requestLoginDto.Email)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	if account == nil || account.ID == uuid.Nil {
+		user.VerifyPassword(dummyPasswordHash(), requestLoginDto.Password)
+		recordLoginFailure(c, requestLoginDto.Email, uuid.Nil)
+		recordLoginFailed(c, uuid.Nil, LoginMethodPassword, LoginFailureInvalidCredentials)
+		c.JSON(http.StatusUnauthorized, &ErrorResponseDto{
+			Message: ErrorInvalidCredentials,
+		})
+		return
+	}
+
+	if !user.VerifyPassword(account.Password, requestLoginDto.Password) || !account.DeletedAt.IsZero() {
+		recordLoginFailure(c, account.Email, account.ID)
+		recordLoginFailed(c, account.ID, LoginMethodPassword, LoginFailureInvalidCredentials)
+		c.JSON(http.StatusUnauthorized, &ErrorResponseDto{
+			Message: ErrorInvalidCredentials,
+		})
+		return
+	}
+
+	err = user.CheckAccountStatus(account.ID, account.Status, account.SuspendedUntil, account.StatusReason)
+	if !abortAccountStatus(c, err) {
+		recordLoginFailed(c, account.ID, LoginMethodPassword, LoginFailureAccountStatus)
+		return
+	}
+
+	if account.EmailVerifiedAt == nil && IsRestrictedForUnverified(ActionLogin) {
+		recordLoginFailed(c, account.ID, LoginMethodPassword, LoginFailureEmailNotVerified)
+		c.JSON(http.StatusForbidden, &ErrorResponseDto{
+			Message: ErrorEmailNotVerified,
+		})
+		return
+	}
+
+	// Old algorithms and costs are upgraded while the plain password is known, a failure does not block the login
+	if err := user.RehashPassword(account.ID, account.Password, requestLoginDto.Password); err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+	}
+
+	isMfaEnabled, err := IsMfaEnabled(account.ID)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	if isMfaEnabled {
+		issueMfaChallenge(c, account.ID)
+		return
+	}
+
+	completeLogin(c, account, LoginMethodPassword)
+}
+
+// ================================== Refresh token ====================================================================
+//	@title			Refresh token
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// Refresh godoc
+// @Summary      Refresh token
+// @Description  Exchange the refresh token for a new token pair. The used refresh token is revoked, repeated use of it
+// @Description  ends the session. A session idle longer than AUTH_SESSION_IDLE_TIMEOUT or older than
+// @Description  AUTH_SESSION_ABSOLUTE_TIMEOUT can not be refreshed.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        request body RequestRefreshTokenDto true "Refresh token"
+// @Success      200 {object}  TokenPairDto
+// @Failure      401 {object}  ErrorResponseDto
+// @Failure      403 {object}  AccountStatusErrorDto
+// @Failure      422 {object}  ErrorResponseDto
+// @Router       /auth/refresh [post]
+func Refresh(c *gin.Context) {
+	var requestRefreshTokenDto RequestRefreshTokenDto
+	if err := c.ShouldBindJSON(&requestRefreshTokenDto); err != nil {
+		utils.LogError(dictionary.ErrorParsingRequestBody, err)
+		c.JSON(http.StatusUnprocessableEntity, &ErrorResponseDto{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	refreshToken, err := GetRefreshTokenByHash(HashToken(requestRefreshTokenDto.RefreshToken))
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	now := time.Now()
+	if refreshToken == nil || !refreshToken.ExpiresAt.After(now) {
+		c.JSON(http.StatusUnauthorized, &ErrorResponseDto{
+			Message: ErrorInvalidRefreshToken,
+		})
+		return
+	}
+
+	if refreshToken.RevokedAt != nil {
+		revokeRefreshTokenFamily(c, refreshToken, now)
+		return
+	}
+
+	session, err := GetSession(refreshToken.FamilyID)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	if session == nil || !isSessionActive(session, LoadConfig(), now) {
+		if _, err := RevokeSession(refreshToken.UserID, refreshToken.FamilyID, now); err != nil {
+			utils.LogError(dictionary.SomethingWrong, err)
+		}
+
+		c.JSON(http.StatusUnauthorized, &ErrorResponseDto{
+			Message: ErrorSessionExpired,
+		})
+		return
+	}
+
+	account, ok := checkAccount(c, refreshToken.UserID)
+	if !ok {
+		return
+	}
+
+	// The expired password is changed with the restricted token from the login, accounts without a password are
+	// loaded with the hash only on this rare path
+	if user.PasswordExpired(account.MustChangePassword, account.PasswordChangedAt, account.CreatedAt, now) {
+		full, err := user.GetOneFullById(account.ID)
+		if err != nil {
+			utils.LogError(dictionary.SomethingWrong, err)
+			c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+				Message: dictionary.SomethingWrong,
+			})
+			return
+		}
+
+		if full.Password != "" {
+			c.JSON(http.StatusForbidden, &ErrorResponseDto{
+				Message: ErrorPasswordChangeRequired,
+			})
+			return
+		}
+	}
+
+	pair, replacement, err := newTokenPair(session, now)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	isRotated, err := RotateRefreshToken(refreshToken, replacement, now)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	if !isRotated {
+		revokeRefreshTokenFamily(c, refreshToken, now)
+		return
+	}
+
+	touchSession(c, session.ID, now)
+	c.JSON(http.StatusOK, pair)
+}
+
+// ================================== Logout ===========================================================================
+//	@title			Logout
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// Logout godoc
+// @Summary      Logout
+// @Description  End the session of the refresh token, all its tokens stop working
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        request body RequestRefreshTokenDto true "Refresh token"
+// @Success      200 {object}  SuccessResponseDto
+// @Failure      422 {object}  ErrorResponseDto
+// @Router       /auth/logout [post]
+func Logout(c *gin.Context) {
+	var requestRefreshTokenDto RequestRefreshTokenDto
+	if err := c.ShouldBindJSON(&requestRefreshTokenDto); err != nil {
+		utils.LogError(dictionary.ErrorParsingRequestBody, err)
+		c.JSON(http.StatusUnprocessableEntity, &ErrorResponseDto{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	refreshToken, err := GetRefreshTokenByHash(HashToken(requestRefreshTokenDto.RefreshToken))
+	if err == nil && refreshToken != nil {
+		_, err = RevokeSession(refreshToken.UserID, refreshToken.FamilyID, time.Now())
+	}
+
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, &SuccessResponseDto{
+		Message: LogoutSuccessful,
+	})
+}
+
+// ================================== Validate token ===================================================================
+//	@title			Validate token
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// ValidateToken godoc
+// @Summary      Validate token
+// @Description  Check the access token and the account status, returns claims of the token
+// @Tags         auth
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200 {object}  TokenClaimsDto
+// @Failure      401 {object}  ErrorResponseDto
+// @Failure      403 {object}  AccountStatusErrorDto
+// @Router       /auth/validate [get]
+func ValidateToken(c *gin.Context) {
+	claims := GetClaims(c)
+
+	// API keys may have no expiry
+	var expiresAt *time.Time
+	if claims.ExpiresAt != nil {
+		expiresAt = &claims.ExpiresAt.Time
+	}
+
+	c.JSON(http.StatusOK, &TokenClaimsDto{
+		UserID:    claims.UserID(),
+		Roles:     claims.Roles,
+		Scope:     claims.Scope,
+		ExpiresAt: expiresAt,
+	})
+}
+
+// ================================== Verify email =====================================================================
+//	@title			Verify email
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// VerifyEmail godoc
+// @Summary      Verify email
+// @Description  Confirm the email address with the token from the verification link, the token can be used once
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        request body RequestTokenDto true "Verification token"
+// @Success      200 {object}  SuccessResponseDto
+// @Failure      400 {object}  ErrorResponseDto
+// @Failure      422 {object}  ErrorResponseDto
+// @Router       /auth/verify-email [post]
+func VerifyEmail(c *gin.Context) {
+	var requestTokenDto RequestTokenDto
+	if err := c.ShouldBindJSON(&requestTokenDto); err != nil {
+		utils.LogError(dictionary.ErrorParsingRequestBody, err)
+		c.JSON(http.StatusUnprocessableEntity, &ErrorResponseDto{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	now := time.Now()
+	actionToken, err := UseActionToken(TokenPurposeVerifyEmail, HashToken(requestTokenDto.Token), now)
+	if err == nil && actionToken != nil {
+		err = user.MarkEmailVerified(actionToken.UserID, now)
+	}
+
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	if actionToken == nil {
+		c.JSON(http.StatusBadRequest, &ErrorResponseDto{
+			Message: ErrorInvalidActionToken,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, &SuccessResponseDto{
+		Message: EmailVerifiedSuccessful,
+	})
+}
+
+// ================================== Resend verification ==============================================================
+//	@title			Resend verification
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// ResendVerification godoc
+// @Summary      Resend verification
+// @Description  Send a new verification link. The answer is the same for unknown, verified and rate limited
+// @Description  emails and does not wait for the mail, so it can not be used to find registered addresses.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        request body RequestEmailDto true "Email"
+// @Success      202 {object}  SuccessResponseDto
+// @Failure      422 {object}  ErrorResponseDto
+// @Router       /auth/resend-verification [post]
+func ResendVerification(c *gin.Context) {
+	var requestEmailDto RequestEmailDto
+	if err := c.ShouldBindJSON(&requestEmailDto); err != nil {
+		utils.LogError(dictionary.ErrorParsingRequestBody, err)
+		c.JSON(http.StatusUnprocessableEntity, &ErrorResponseDto{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	payload := emailJobPayload{Email: requestEmailDto.Email, Locale: requestLocale(c)}
+	if _, err := job.Enqueue(JobTypeVerificationRequested, "", payload, nil); err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+	}
+
+	c.JSON(http.StatusAccepted, &SuccessResponseDto{
+		Message: VerificationSent,
+	})
+}
+
+// ================================== Forgot password ==================================================================
+//	@title			Forgot password
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// ForgotPassword godoc
+// @Summary      Forgot password
+// @Description  Send the password reset link. The answer is always 202 and does not wait for the mail, so it can not
+// @Description  be used to find registered addresses.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        request body RequestEmailDto true "Email"
+// @Success      202 {object}  SuccessResponseDto
+// @Failure      422 {object}  ErrorResponseDto
+// @Router       /auth/password/forgot [post]
+func ForgotPassword(c *gin.Context) {
+	var requestEmailDto RequestEmailDto
+	if err := c.ShouldBindJSON(&requestEmailDto); err != nil {
+		utils.LogError(dictionary.ErrorParsingRequestBody, err)
+		c.JSON(http.StatusUnprocessableEntity, &ErrorResponseDto{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	payload := emailJobPayload{Email: requestEmailDto.Email, Locale: requestLocale(c)}
+	if _, err := job.Enqueue(JobTypePasswordResetRequested, "", payload, nil); err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+	}
+
+	c.JSON(http.StatusAccepted, &SuccessResponseDto{
+		Message: PasswordResetSent,
+	})
+}
+
+// ================================== Reset password ===================================================================
+//	@title			Reset password
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// ResetPassword godoc
+// @Summary      Reset password
+// @Description  Set the new password with the token from the reset link. The token can be used once, all refresh
+// @Description  and access tokens of the user issued before the reset stop working.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        request body RequestResetPasswordDto true "Token and new password"
+// @Success      200 {object}  SuccessResponseDto
+// @Failure      400 {object}  ErrorResponseDto
+// @Failure      422 {object}  user.ValidationErrorResponseDto
+// @Failure      429 {object}  ErrorResponseDto
+// @Router       /auth/password/reset [post]
+func ResetPassword(c *gin.Context) {
+	var requestResetPasswordDto RequestResetPasswordDto
+	if err := c.ShouldBindJSON(&requestResetPasswordDto); err != nil {
+		utils.LogError(dictionary.ErrorParsingRequestBody, err)
+		c.JSON(http.StatusUnprocessableEntity, &ErrorResponseDto{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if !checkLoginThrottle(c, "") {
+		return
+	}
+
+	violations, err := checkResetPasswordPolicy(requestResetPasswordDto.Token, requestResetPasswordDto.Password)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	if len(violations) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, &user.ValidationErrorResponseDto{
+			Message: user.ErrorPasswordPolicy,
+			Errors:  violations,
+		})
+		return
+	}
+
+	userId, err := resetPassword(requestResetPasswordDto.Token, requestResetPasswordDto.Password)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	if userId == uuid.Nil {
+		recordLoginFailure(c, "", uuid.Nil)
+		c.JSON(http.StatusBadRequest, &ErrorResponseDto{
+			Message: ErrorInvalidActionToken,
+		})
+		return
+	}
+
+	if err := audit.Record(c, audit.EventPasswordReset, userId, nil); err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+	}
+
+	c.JSON(http.StatusOK, &SuccessResponseDto{
+		Message: PasswordResetSuccessful,
+	})
+}
+
+// ================================== Change password ==================================================================
+//	@title			Change password
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// ChangePassword godoc
+// @Summary      Change password
+// @Description  Replace the password of the authenticated user, the current password is required. Also accepts the
+// @Description  restricted token issued for an expired password. Other sessions are ended, a new token pair is returned.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request body RequestChangePasswordDto true "Current and new password"
+// @Success      200 {object}  TokenPairDto
+// @Failure      400 {object}  ErrorResponseDto
+// @Failure      401 {object}  ErrorResponseDto
+// @Failure      422 {object}  user.ValidationErrorResponseDto
+// @Failure      429 {object}  ErrorResponseDto
+// @Router       /auth/password/change [post]
+func ChangePassword(c *gin.Context) {
+	var requestChangePasswordDto RequestChangePasswordDto
+	if err := c.ShouldBindJSON(&requestChangePasswordDto); err != nil {
+		utils.LogError(dictionary.ErrorParsingRequestBody, err)
+		c.JSON(http.StatusUnprocessableEntity, &ErrorResponseDto{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	value, _ := c.Get(ContextAccount)
+	account, _ := value.(*user.UserItemResultDto)
+
+	if !checkLoginThrottle(c, account.Email) {
+		return
+	}
+
+	current, err := user.GetOneByEmail(account.Email)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	if !user.VerifyPassword(current.Password, requestChangePasswordDto.CurrentPassword) {
+		recordLoginFailure(c, account.Email, account.ID)
+		c.JSON(http.StatusBadRequest, &ErrorResponseDto{
+			Message: ErrorCurrentPasswordInvalid,
+		})
+		return
+	}
+
+	violations, err := passwordViolations(account.ID, account.Email, requestChangePasswordDto.Password)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	if len(violations) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, &user.ValidationErrorResponseDto{
+			Message: user.ErrorPasswordPolicy,
+			Errors:  violations,
+		})
+		return
+	}
+
+	if err := changePassword(account.ID, requestChangePasswordDto.Password, time.Now()); err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	if err := audit.Record(c, audit.EventPasswordChanged, account.ID, nil); err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+	}
+
+	startSession(c, account.ID)
+}
+
+// ================================== Request email change =============================================================
+//	@title			Request email change
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// RequestEmailChange godoc
+// @Summary      Request email change
+// @Description  Store the new email as pending and send the confirmation link to it, the current address gets
+// @Description  a notice. The email is swapped only on confirmation. Admin can set the email at once with override,
+// @Description  such change is audited.
+// @Tags         user
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path string true "User id (UUID)"
+// @Param        request body RequestEmailChangeDto true "New email"
+// @Success      200 {object}  SuccessResponseDto
+// @Success      202 {object}  SuccessResponseDto
+// @Failure      403 {object}  ErrorResponseDto
+// @Failure      404 {object}  ErrorResponseDto
+// @Failure      409 {object}  ErrorResponseDto
+// @Failure      422 {object}  ErrorResponseDto
+// @Failure      429 {object}  ErrorResponseDto
+// @Router       /user/{id}/email-change [post]
+func RequestEmailChange(c *gin.Context) {
+	var requestUserIdDTO user.RequestUserIdDTO
+	var requestEmailChangeDto RequestEmailChangeDto
+	if err := c.ShouldBindUri(&requestUserIdDTO); err != nil {
+		utils.LogError(dictionary.ErrorParsingRequestBody, err)
+		c.JSON(http.StatusUnprocessableEntity, &ErrorResponseDto{
+			Message: err.Error(),
+		})
+		return
+	}
+	if err := c.ShouldBindJSON(&requestEmailChangeDto); err != nil {
+		utils.LogError(dictionary.ErrorParsingRequestBody, err)
+		c.JSON(http.StatusUnprocessableEntity, &ErrorResponseDto{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	claims := GetClaims(c)
+	actorId := claims.UserID()
+	isAdmin := slices.Contains(claims.Roles, user.RoleAdmin)
+	isStaff := isAdmin || slices.Contains(claims.Roles, user.RoleSupport)
+	if (actorId.String() != requestUserIdDTO.ID && !isStaff) || (requestEmailChangeDto.Override && !isAdmin) {
+		c.JSON(http.StatusForbidden, &ErrorResponseDto{
+			Message: ErrorAccessDenied,
+		})
+		return
+	}
+
+	account, err := user.GetOneById(requestUserIdDTO)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	if account.ID == uuid.Nil {
+		c.JSON(http.StatusNotFound, &ErrorResponseDto{
+			Message: fmt.Sprintf(dictionary.UserByIdNotFound, requestUserIdDTO.ID),
+		})
+		return
+	}
+
+	if requestEmailChangeDto.Email == account.Email {
+		c.JSON(http.StatusUnprocessableEntity, &ErrorResponseDto{
+			Message: ErrorEmailUnchanged,
+		})
+		return
+	}
+
+	existing, err := user.GetOneByEmail(requestEmailChangeDto.Email)
+	if err == nil && existing != nil && existing.ID != uuid.Nil && existing.ID != account.ID {
+		err = user.ErrEmailTaken
+	}
+
+	if err == nil && requestEmailChangeDto.Override {
+		err = overrideEmailChange(c.Request.Context(), account, requestEmailChangeDto.Email, requestLocale(c))
+		if err == nil {
+			err = audit.Record(c, audit.EventEmailChangeOverride, account.ID, &actorId)
+		}
+	} else if err == nil {
+		err = requestEmailChange(c.Request.Context(), account, requestEmailChangeDto.Email, requestLocale(c))
+	}
+
+	if errors.Is(err, user.ErrEmailTaken) {
+		c.JSON(http.StatusConflict, &ErrorResponseDto{
+			Message: fmt.Sprintf(ErrorEmailTaken, requestEmailChangeDto.Email),
+		})
+		return
+	}
+
+	if errors.Is(err, ErrTooManyRequests) {
+		c.JSON(http.StatusTooManyRequests, &ErrorResponseDto{
+			Message: ErrorTooManyRequests,
+		})
+		return
+	}
+
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	if requestEmailChangeDto.Override {
+		c.JSON(http.StatusOK, &SuccessResponseDto{
+			Message: EmailChangedSuccessful,
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, &SuccessResponseDto{
+		Message: EmailChangeRequested,
+	})
+}
+
+// ================================== Confirm email change =============================================================
+//	@title			Confirm email change
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// ConfirmEmailChange godoc
+// @Summary      Confirm email change
+// @Description  Swap the email with the pending one using the token sent to the new address. Uniqueness of the
+// @Description  email is checked again, because it could be taken after the request.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        request body RequestTokenDto true "Confirmation token"
+// @Success      200 {object}  SuccessResponseDto
+// @Failure      400 {object}  ErrorResponseDto
+// @Failure      409 {object}  ErrorResponseDto
+// @Failure      422 {object}  ErrorResponseDto
+// @Router       /auth/email-change/confirm [post]
+func ConfirmEmailChange(c *gin.Context) {
+	var requestTokenDto RequestTokenDto
+	if err := c.ShouldBindJSON(&requestTokenDto); err != nil {
+		utils.LogError(dictionary.ErrorParsingRequestBody, err)
+		c.JSON(http.StatusUnprocessableEntity, &ErrorResponseDto{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	userId, err := confirmEmailChange(requestTokenDto.Token)
+	if errors.Is(err, user.ErrEmailTaken) {
+		c.JSON(http.StatusConflict, &ErrorResponseDto{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	if userId == uuid.Nil {
+		c.JSON(http.StatusBadRequest, &ErrorResponseDto{
+			Message: ErrorInvalidActionToken,
+		})
+		return
+	}
+
+	if err := audit.Record(c, audit.EventEmailChanged, userId, &userId); err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+	}
+
+	c.JSON(http.StatusOK, &SuccessResponseDto{
+		Message: EmailChangedSuccessful,
+	})
+}
+
+// ================================== Verify second factor =============================================================
+//	@title			Verify second factor
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// VerifyMfa godoc
+// @Summary      Verify second factor
+// @Description  Finish the login with the TOTP code, a recovery code or the passkey assertion for the webauthn options
+// @Description  of the login response. Each code is accepted once, a wrong code ends the challenge and the login has
+// @Description  to be repeated.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        request body RequestMfaVerifyDto true "Challenge token from the login and the code"
+// @Success      200 {object}  TokenPairDto
+// @Failure      401 {object}  ErrorResponseDto
+// @Failure      403 {object}  AccountStatusErrorDto
+// @Failure      422 {object}  ErrorResponseDto
+// @Failure      429 {object}  ErrorResponseDto
+// @Router       /auth/mfa/verify [post]
+func VerifyMfa(c *gin.Context) {
+	var requestMfaVerifyDto RequestMfaVerifyDto
+	if err := c.ShouldBindJSON(&requestMfaVerifyDto); err != nil {
+		utils.LogError(dictionary.ErrorParsingRequestBody, err)
+		c.JSON(http.StatusUnprocessableEntity, &ErrorResponseDto{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	now := time.Now()
+	hash := HashToken(requestMfaVerifyDto.MfaToken)
+	challenge, err := GetActionToken(TokenPurposeMfaChallenge, hash, now)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	if challenge == nil {
+		c.JSON(http.StatusUnauthorized, &ErrorResponseDto{
+			Message: ErrorInvalidActionToken,
+		})
+		return
+	}
+
+	account, err := user.GetOneFullById(challenge.UserID)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	if !checkLoginThrottle(c, account.Email) {
+		recordLoginFailed(c, account.ID, LoginMethodMfa, LoginFailureTooManyAttempts)
+		return
+	}
+
+	var isVerified bool
+	if len(requestMfaVerifyDto.Webauthn) > 0 {
+		isVerified, err = verifyWebauthnSecondFactor(challenge.UserID, hash, requestMfaVerifyDto.Webauthn, now)
+	} else {
+		isVerified, err = verifySecondFactor(challenge.UserID, requestMfaVerifyDto.Code, requestMfaVerifyDto.RecoveryCode, now)
+	}
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	// The challenge is single use either way, so the code can not be guessed without the password
+	used, err := UseActionToken(TokenPurposeMfaChallenge, hash, now)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	if !isVerified || used == nil {
+		recordLoginFailure(c, account.Email, account.ID)
+		recordLoginFailed(c, account.ID, LoginMethodMfa, LoginFailureInvalidMfaCode)
+		c.JSON(http.StatusUnauthorized, &ErrorResponseDto{
+			Message: ErrorInvalidMfaCode,
+		})
+		return
+	}
+
+	if account.ID == uuid.Nil || !account.DeletedAt.IsZero() {
+		recordLoginFailed(c, account.ID, LoginMethodMfa, LoginFailureInvalidCredentials)
+		c.JSON(http.StatusUnauthorized, &ErrorResponseDto{
+			Message: ErrorInvalidCredentials,
+		})
+		return
+	}
+
+	// The account could be suspended while the user was typing the code
+	err = user.CheckAccountStatus(account.ID, account.Status, account.SuspendedUntil, account.StatusReason)
+	if !abortAccountStatus(c, err) {
+		recordLoginFailed(c, account.ID, LoginMethodMfa, LoginFailureAccountStatus)
+		return
+	}
+
+	if requestMfaVerifyDto.Code == "" && len(requestMfaVerifyDto.Webauthn) == 0 {
+		if err := audit.Record(c, audit.EventMfaRecoveryCodeUsed, account.ID, nil); err != nil {
+			utils.LogError(dictionary.SomethingWrong, err)
+		}
+	}
+
+	completeLogin(c, account, LoginMethodMfa)
+}
+
+// ================================== Enroll TOTP ======================================================================
+//	@title			Enroll TOTP
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// EnrollTotp godoc
+// @Summary      Enroll TOTP
+// @Description  Create a TOTP secret for the authenticated user. The secret and otpauth URI are shown once, the second
+// @Description  factor is enabled after POST /user/{id}/mfa/totp/verify with a code from the authenticator app.
+// @Tags         user
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path string true "User id (UUID)"
+// @Success      200 {object}  MfaTotpEnrollmentDto
+// @Failure      403 {object}  ErrorResponseDto
+// @Failure      409 {object}  ErrorResponseDto
+// @Router       /user/{id}/mfa/totp [post]
+func EnrollTotp(c *gin.Context) {
+	account, ok := bindMfaAccount(c, false)
+	if !ok {
+		return
+	}
+
+	enrollment, err := enrollTotp(account, time.Now())
+	if errors.Is(err, ErrMfaAlreadyEnabled) {
+		c.JSON(http.StatusConflict, &ErrorResponseDto{
+			Message: ErrorMfaAlreadyEnabled,
+		})
+		return
+	}
+
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// ================================== Confirm TOTP =====================================================================
+//	@title			Confirm TOTP
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// ConfirmTotp godoc
+// @Summary      Confirm TOTP
+// @Description  Enable the enrolled TOTP secret with a code from the authenticator app. Returns one-time recovery
+// @Description  codes, they are not shown again.
+// @Tags         user
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path string true "User id (UUID)"
+// @Param        request body RequestMfaCodeDto true "Code from the authenticator app"
+// @Success      200 {object}  MfaRecoveryCodesDto
+// @Failure      400 {object}  ErrorResponseDto
+// @Failure      403 {object}  ErrorResponseDto
+// @Failure      409 {object}  ErrorResponseDto
+// @Router       /user/{id}/mfa/totp/verify [post]
+func ConfirmTotp(c *gin.Context) {
+	account, ok := bindMfaAccount(c, false)
+	if !ok {
+		return
+	}
+
+	var requestMfaCodeDto RequestMfaCodeDto
+	if err := c.ShouldBindJSON(&requestMfaCodeDto); err != nil {
+		utils.LogError(dictionary.ErrorParsingRequestBody, err)
+		c.JSON(http.StatusUnprocessableEntity, &ErrorResponseDto{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	codes, err := confirmTotp(account.ID, requestMfaCodeDto.Code, time.Now())
+	switch {
+	case errors.Is(err, ErrMfaAlreadyEnabled):
+		c.JSON(http.StatusConflict, &ErrorResponseDto{
+			Message: ErrorMfaAlreadyEnabled,
+		})
+		return
+	case errors.Is(err, ErrMfaNotEnrolled):
+		c.JSON(http.StatusBadRequest, &ErrorResponseDto{
+			Message: ErrorMfaNotEnrolled,
+		})
+		return
+	case err != nil:
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	if codes == nil {
+		c.JSON(http.StatusBadRequest, &ErrorResponseDto{
+			Message: ErrorInvalidMfaCode,
+		})
+		return
+	}
+
+	if err := audit.Record(c, audit.EventMfaEnabled, account.ID, nil); err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+	}
+
+	c.JSON(http.StatusOK, &MfaRecoveryCodesDto{
+		RecoveryCodes: codes,
+	})
+}
+
+// ================================== Disable TOTP =====================================================================
+//	@title			Disable TOTP
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// DisableTotp godoc
+// @Summary      Disable TOTP
+// @Description  Disable the second factor and remove recovery codes. The user confirms it with a code or a recovery
+// @Description  code, admin can disable it for another user without a code (for a lost device), it is audited.
+// @Tags         user
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path string true "User id (UUID)"
+// @Param        request body RequestMfaDisableDto true "Code or recovery code"
+// @Success      200 {object}  SuccessResponseDto
+// @Failure      400 {object}  ErrorResponseDto
+// @Failure      403 {object}  ErrorResponseDto
+// @Failure      429 {object}  ErrorResponseDto
+// @Router       /user/{id}/mfa/totp [delete]
+func DisableTotp(c *gin.Context) {
+	account, ok := bindMfaAccount(c, true)
+	if !ok {
+		return
+	}
+
+	// Admin may send no body at all
+	var requestMfaDisableDto RequestMfaDisableDto
+	if err := c.ShouldBindJSON(&requestMfaDisableDto); err != nil && !errors.Is(err, io.EOF) {
+		utils.LogError(dictionary.ErrorParsingRequestBody, err)
+		c.JSON(http.StatusUnprocessableEntity, &ErrorResponseDto{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	actorId := GetClaims(c).UserID()
+	if actorId == account.ID {
+		if !checkLoginThrottle(c, account.Email) {
+			return
+		}
+
+		isVerified, err := verifySecondFactor(account.ID, requestMfaDisableDto.Code, requestMfaDisableDto.RecoveryCode, time.Now())
+		if err != nil {
+			utils.LogError(dictionary.SomethingWrong, err)
+			c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+				Message: dictionary.SomethingWrong,
+			})
+			return
+		}
+
+		if !isVerified {
+			recordLoginFailure(c, account.Email, account.ID)
+			c.JSON(http.StatusBadRequest, &ErrorResponseDto{
+				Message: ErrorInvalidMfaCode,
+			})
+			return
+		}
+	}
+
+	if err := DeleteMfa(account.ID); err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	if err := audit.Record(c, audit.EventMfaDisabled, account.ID, &actorId); err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+	}
+
+	c.JSON(http.StatusOK, &SuccessResponseDto{
+		Message: MfaDisabledSuccessful,
+	})
+}
+
+// ================================== Request magic link ===============================================================
+//	@title			Request magic link
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// RequestMagicLink godoc
+// @Summary      Request magic link
+// @Description  Email a single-use sign-in link. The link works only in the browser which requested it, the response
+// @Description  sets the magic_link_device cookie for that. The answer is always 202 and does not wait for the mail,
+// @Description  so it can not be used to find registered addresses.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        request body RequestEmailDto true "Email"
+// @Success      202 {object}  SuccessResponseDto
+// @Failure      422 {object}  ErrorResponseDto
+// @Router       /auth/magic-link [post]
+func RequestMagicLink(c *gin.Context) {
+	var requestEmailDto RequestEmailDto
+	if err := c.ShouldBindJSON(&requestEmailDto); err != nil {
+		utils.LogError(dictionary.ErrorParsingRequestBody, err)
+		c.JSON(http.StatusUnprocessableEntity, &ErrorResponseDto{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	device, err := newMagicLinkDevice(c, loadMagicLinkConfig())
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	payload := emailJobPayload{Email: requestEmailDto.Email, Locale: requestLocale(c)}
+	if _, err := job.Enqueue(JobTypeMagicLinkRequested, "", payload, []byte(device)); err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+	}
+
+	c.JSON(http.StatusAccepted, &SuccessResponseDto{
+		Message: MagicLinkSent,
+	})
+}
+
+// ================================== Consume magic link ===============================================================
+//	@title			Consume magic link
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// ConsumeMagicLink godoc
+// @Summary      Consume magic link
+// @Description  Exchange the token from the sign-in link for tokens. The request must carry the magic_link_device
+// @Description  cookie of the browser which requested the link. The email is confirmed by the link, with two-factor
+// @Description  authentication enabled MfaChallengeDto is returned.
+// @Tags         auth
+// @Produce      json
+// @Param        token query string true "Token from the link"
+// @Success      200 {object}  TokenPairDto
+// @Failure      401 {object}  ErrorResponseDto
+// @Failure      403 {object}  AccountStatusErrorDto
+// @Failure      422 {object}  ErrorResponseDto
+// @Failure      429 {object}  ErrorResponseDto
+// @Router       /auth/magic-link/consume [get]
+func ConsumeMagicLink(c *gin.Context) {
+	var requestMagicLinkDto RequestMagicLinkDto
+	if err := c.ShouldBindQuery(&requestMagicLinkDto); err != nil {
+		utils.LogError(dictionary.ErrorParsingRequestBody, err)
+		c.JSON(http.StatusUnprocessableEntity, &ErrorResponseDto{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if !checkLoginThrottle(c, "") {
+		recordLoginFailed(c, uuid.Nil, LoginMethodMagicLink, LoginFailureTooManyAttempts)
+		return
+	}
+
+	now := time.Now()
+	device, _ := c.Cookie(MagicLinkDeviceCookie)
+	userId, err := useMagicLink(requestMagicLinkDto.Token, device, now)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	if userId == uuid.Nil {
+		recordLoginFailure(c, "", uuid.Nil)
+		recordLoginFailed(c, uuid.Nil, LoginMethodMagicLink, LoginFailureInvalidToken)
+		c.JSON(http.StatusUnauthorized, &ErrorResponseDto{
+			Message: ErrorInvalidMagicLink,
+		})
+		return
+	}
+
+	setMagicLinkDeviceCookie(c, "", -1)
+
+	account, err := user.GetOneFullById(userId)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	if account.ID == uuid.Nil || !account.DeletedAt.IsZero() {
+		recordLoginFailed(c, account.ID, LoginMethodMagicLink, LoginFailureInvalidCredentials)
+		c.JSON(http.StatusUnauthorized, &ErrorResponseDto{
+			Message: ErrorInvalidCredentials,
+		})
+		return
+	}
+
+	err = user.CheckAccountStatus(account.ID, account.Status, account.SuspendedUntil, account.StatusReason)
+	if !abortAccountStatus(c, err) {
+		recordLoginFailed(c, account.ID, LoginMethodMagicLink, LoginFailureAccountStatus)
+		return
+	}
+
+	// The link was delivered to the mailbox, so the address is confirmed as well
+	if err := user.MarkEmailVerified(account.ID, now); err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+	}
+
+	isMfaEnabled, err := IsMfaEnabled(account.ID)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	if isMfaEnabled {
+		issueMfaChallenge(c, account.ID)
+		return
+	}
+
+	completeLogin(c, account, LoginMethodMagicLink)
+}
+
+// ================================== Begin passkey registration =======================================================
+//	@title			Begin passkey registration
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// BeginWebauthnRegistration godoc
+// @Summary      Begin passkey registration
+// @Description  Start the WebAuthn registration of a passkey or a security key for the authenticated user. Options are
+// @Description  passed to navigator.credentials.create(), the result is sent to register/finish with the session token.
+// @Tags         user
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path string true "User id (UUID)"
+// @Success      200 {object}  WebauthnCeremonyDto
+// @Failure      403 {object}  ErrorResponseDto
+// @Router       /user/{id}/webauthn/register/begin [post]
+func BeginWebauthnRegistration(c *gin.Context) {
+	account, ok := bindMfaAccount(c, false)
+	if !ok {
+		return
+	}
+
+	ceremony, err := beginWebauthnRegistration(account, time.Now())
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, ceremony)
+}
+
+// ================================== Finish passkey registration ======================================================
+//	@title			Finish passkey registration
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// FinishWebauthnRegistration godoc
+// @Summary      Finish passkey registration
+// @Description  Verify the attestation and store the credential. From now on the passkey is the second factor of the
+// @Description  password login and can be used for the passwordless login.
+// @Tags         user
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path string true "User id (UUID)"
+// @Param        request body RequestWebauthnRegisterDto true "Session token and the PublicKeyCredential"
+// @Success      201 {object}  WebauthnCredentialDto
+// @Failure      400 {object}  ErrorResponseDto
+// @Failure      403 {object}  ErrorResponseDto
+// @Failure      409 {object}  ErrorResponseDto
+// @Router       /user/{id}/webauthn/register/finish [post]
+func FinishWebauthnRegistration(c *gin.Context) {
+	account, ok := bindMfaAccount(c, false)
+	if !ok {
+		return
+	}
+
+	var requestWebauthnRegisterDto RequestWebauthnRegisterDto
+	if err := c.ShouldBindJSON(&requestWebauthnRegisterDto); err != nil {
+		utils.LogError(dictionary.ErrorParsingRequestBody, err)
+		c.JSON(http.StatusUnprocessableEntity, &ErrorResponseDto{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	credential, err := finishWebauthnRegistration(account, requestWebauthnRegisterDto, time.Now())
+	switch {
+	case errors.Is(err, ErrWebauthnSessionInvalid):
+		c.JSON(http.StatusBadRequest, &ErrorResponseDto{
+			Message: ErrorWebauthnSessionInvalid,
+		})
+		return
+	case errors.Is(err, ErrWebauthnResponseInvalid):
+		utils.LogInfo(err.Error())
+		c.JSON(http.StatusBadRequest, &ErrorResponseDto{
+			Message: ErrorWebauthnResponseInvalid,
+		})
+		return
+	case errors.Is(err, ErrWebauthnCredentialExists):
+		c.JSON(http.StatusConflict, &ErrorResponseDto{
+			Message: ErrorWebauthnCredentialExists,
+		})
+		return
+	case err != nil:
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	if err := audit.Record(c, audit.EventWebauthnRegistered, account.ID, nil); err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+	}
+
+	c.JSON(http.StatusCreated, webauthnCredentialDto(*credential))
+}
+
+// ================================== List passkeys ====================================================================
+//	@title			List passkeys
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// GetWebauthnCredentialList godoc
+// @Summary      List passkeys
+// @Description  Registered passkeys and security keys of the user, admin can see them for any user.
+// @Tags         user
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path string true "User id (UUID)"
+// @Success      200 {array}   WebauthnCredentialDto
+// @Failure      403 {object}  ErrorResponseDto
+// @Router       /user/{id}/webauthn/credentials [get]
+func GetWebauthnCredentialList(c *gin.Context) {
+	account, ok := bindMfaAccount(c, true)
+	if !ok {
+		return
+	}
+
+	credentials, err := GetWebauthnCredentials(account.ID)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	result := make([]WebauthnCredentialDto, 0, len(credentials))
+	for _, credential := range credentials {
+		result = append(result, webauthnCredentialDto(credential))
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// ================================== Delete passkey ===================================================================
+//	@title			Delete passkey
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// DeleteWebauthnCredentialById godoc
+// @Summary      Delete passkey
+// @Description  Remove the passkey of the user, admin can remove it for another user (for a lost device), it is audited.
+// @Tags         user
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path string true "User id (UUID)"
+// @Param        credentialId path string true "Passkey id (UUID)"
+// @Success      200 {object}  SuccessResponseDto
+// @Failure      403 {object}  ErrorResponseDto
+// @Failure      404 {object}  ErrorResponseDto
+// @Router       /user/{id}/webauthn/credentials/{credentialId} [delete]
+func DeleteWebauthnCredentialById(c *gin.Context) {
+	account, ok := bindMfaAccount(c, true)
+	if !ok {
+		return
+	}
+
+	credentialId, err := uuid.Parse(c.Param("credentialId"))
+	if err != nil {
+		utils.LogError(dictionary.ErrorParsingRequestBody, err)
+		c.JSON(http.StatusUnprocessableEntity, &ErrorResponseDto{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	isDeleted, err := DeleteWebauthnCredential(account.ID, credentialId)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	if !isDeleted {
+		c.JSON(http.StatusNotFound, &ErrorResponseDto{
+			Message: fmt.Sprintf(ErrorWebauthnCredentialNotFound, credentialId),
+		})
+		return
+	}
+
+	actorId := GetClaims(c).UserID()
+	if err := audit.Record(c, audit.EventWebauthnRemoved, account.ID, &actorId); err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+	}
+
+	c.JSON(http.StatusOK, &SuccessResponseDto{
+		Message: WebauthnCredentialDeleted,
+	})
+}
+
+// ================================== Begin passkey login ==============================================================
+//	@title			Begin passkey login
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// BeginWebauthnLogin godoc
+// @Summary      Begin passkey login
+// @Description  Start the passwordless login. Options are passed to navigator.credentials.get(), the authenticator
+// @Description  picks the account, the result is sent to login/finish with the session token.
+// @Tags         auth
+// @Produce      json
+// @Success      200 {object}  WebauthnCeremonyDto
+// @Router       /auth/webauthn/login/begin [post]
+func BeginWebauthnLogin(c *gin.Context) {
+	ceremony, err := beginWebauthnLogin(time.Now())
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, ceremony)
+}
+
+// ================================== Finish passkey login =============================================================
+//	@title			Finish passkey login
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// FinishWebauthnLogin godoc
+// @Summary      Finish passkey login
+// @Description  Verify the assertion and issue tokens. The passkey is verified by the user (PIN or biometrics), so
+// @Description  no second factor is asked. The session token is single use.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        request body RequestWebauthnLoginDto true "Session token and the PublicKeyCredential"
+// @Success      200 {object}  TokenPairDto
+// @Failure      401 {object}  ErrorResponseDto
+// @Failure      403 {object}  AccountStatusErrorDto
+// @Failure      422 {object}  ErrorResponseDto
+// @Failure      429 {object}  ErrorResponseDto
+// @Router       /auth/webauthn/login/finish [post]
+func FinishWebauthnLogin(c *gin.Context) {
+	var requestWebauthnLoginDto RequestWebauthnLoginDto
+	if err := c.ShouldBindJSON(&requestWebauthnLoginDto); err != nil {
+		utils.LogError(dictionary.ErrorParsingRequestBody, err)
+		c.JSON(http.StatusUnprocessableEntity, &ErrorResponseDto{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if !checkLoginThrottle(c, "") {
+		recordLoginFailed(c, uuid.Nil, LoginMethodPasskey, LoginFailureTooManyAttempts)
+		return
+	}
+
+	userId, err := finishWebauthnLogin(requestWebauthnLoginDto, time.Now())
+	switch {
+	case errors.Is(err, ErrWebauthnSessionInvalid):
+		c.JSON(http.StatusUnauthorized, &ErrorResponseDto{
+			Message: ErrorWebauthnSessionInvalid,
+		})
+		return
+	case errors.Is(err, ErrWebauthnResponseInvalid):
+		utils.LogInfo(err.Error())
+		recordLoginFailure(c, "", uuid.Nil)
+		recordLoginFailed(c, uuid.Nil, LoginMethodPasskey, LoginFailureInvalidToken)
+		c.JSON(http.StatusUnauthorized, &ErrorResponseDto{
+			Message: ErrorWebauthnResponseInvalid,
+		})
+		return
+	case err != nil:
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	account, err := user.GetOneFullById(userId)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	if account.ID == uuid.Nil || !account.DeletedAt.IsZero() {
+		recordLoginFailed(c, account.ID, LoginMethodPasskey, LoginFailureInvalidCredentials)
+		c.JSON(http.StatusUnauthorized, &ErrorResponseDto{
+			Message: ErrorInvalidCredentials,
+		})
+		return
+	}
+
+	err = user.CheckAccountStatus(account.ID, account.Status, account.SuspendedUntil, account.StatusReason)
+	if !abortAccountStatus(c, err) {
+		recordLoginFailed(c, account.ID, LoginMethodPasskey, LoginFailureAccountStatus)
+		return
+	}
+
+	if account.EmailVerifiedAt == nil && IsRestrictedForUnverified(ActionLogin) {
+		recordLoginFailed(c, account.ID, LoginMethodPasskey, LoginFailureEmailNotVerified)
+		c.JSON(http.StatusForbidden, &ErrorResponseDto{
+			Message: ErrorEmailNotVerified,
+		})
+		return
+	}
+
+	completeLogin(c, account, LoginMethodPasskey)
+}
+
+// ================================== Session list =====================================================================
+//	@title			Session list
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// GetSessionList godoc
+// @Summary      Session list
+// @Description  List signed-in devices of the user with the address and the time of the last activity. Staff can list
+// @Description  sessions of any user.
+// @Tags         user
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path string true "User id (UUID)"
+// @Success      200 {array}   SessionDto
+// @Failure      403 {object}  ErrorResponseDto
+// @Failure      404 {object}  ErrorResponseDto
+// @Router       /user/{id}/sessions [get]
+func GetSessionList(c *gin.Context) {
+	account, ok := bindAccount(c, user.RoleAdmin, user.RoleSupport)
+	if !ok {
+		return
+	}
+
+	config := LoadConfig()
+	now := time.Now()
+	sessions, err := GetUserSessions(account.ID, now.Add(-config.SessionIdleTimeout), now)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	currentId := GetClaims(c).SessionId()
+	result := make([]SessionDto, 0, len(sessions))
+	for _, session := range sessions {
+		result = append(result, sessionDto(session, currentId))
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// ================================== Delete session ===================================================================
+//	@title			Delete session
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// DeleteSessionById godoc
+// @Summary      Delete session
+// @Description  Sign the device out, its access and refresh tokens stop working at once. Staff can end sessions of any
+// @Description  user, for example a compromised one, it is audited.
+// @Tags         user
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path string true "User id (UUID)"
+// @Param        sessionId path string true "Session id (UUID)"
+// @Success      200 {object}  SuccessResponseDto
+// @Failure      403 {object}  ErrorResponseDto
+// @Failure      404 {object}  ErrorResponseDto
+// @Router       /user/{id}/sessions/{sessionId} [delete]
+func DeleteSessionById(c *gin.Context) {
+	account, ok := bindAccount(c, user.RoleAdmin, user.RoleSupport)
+	if !ok {
+		return
+	}
+
+	sessionId, err := uuid.Parse(c.Param("sessionId"))
+	if err != nil {
+		utils.LogError(dictionary.ErrorParsingRequestBody, err)
+		c.JSON(http.StatusUnprocessableEntity, &ErrorResponseDto{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	isRevoked, err := RevokeSession(account.ID, sessionId, time.Now())
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	if !isRevoked {
+		c.JSON(http.StatusNotFound, &ErrorResponseDto{
+			Message: fmt.Sprintf(ErrorSessionNotFound, sessionId),
+		})
+		return
+	}
+
+	actorId := GetClaims(c).UserID()
+	if err := audit.Record(c, audit.EventSessionRevoked, account.ID, &actorId); err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+	}
+
+	c.JSON(http.StatusOK, &SuccessResponseDto{
+		Message: SessionRevoked,
+	})
+}
+
+// ================================== Sign out everywhere ==============================================================
+//	@title			Sign out everywhere
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// DeleteSessions godoc
+// @Summary      Sign out everywhere
+// @Description  End all sessions of the user. With except_current the session of the request stays signed in, for
+// @Description  example after the user has noticed an unknown device. It is audited.
+// @Tags         user
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path string true "User id (UUID)"
+// @Param        except_current query bool false "Keep the session of the request"
+// @Success      200 {object}  SuccessResponseDto
+// @Failure      403 {object}  ErrorResponseDto
+// @Failure      404 {object}  ErrorResponseDto
+// @Router       /user/{id}/sessions [delete]
+func DeleteSessions(c *gin.Context) {
+	account, ok := bindAccount(c, user.RoleAdmin, user.RoleSupport)
+	if !ok {
+		return
+	}
+
+	var requestSessionsDeleteDto RequestSessionsDeleteDto
+	if err := c.ShouldBindQuery(&requestSessionsDeleteDto); err != nil {
+		utils.LogError(dictionary.ErrorParsingRequestBody, err)
+		c.JSON(http.StatusUnprocessableEntity, &ErrorResponseDto{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	keep := uuid.Nil
+	if requestSessionsDeleteDto.ExceptCurrent {
+		keep = GetClaims(c).SessionId()
+	}
+
+	count, err := RevokeUserSessions(account.ID, keep, time.Now())
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	actorId := GetClaims(c).UserID()
+	if err := audit.Record(c, audit.EventSessionsRevoked, account.ID, &actorId); err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+	}
+
+	c.JSON(http.StatusOK, &SuccessResponseDto{
+		Message: fmt.Sprintf(SessionsRevoked, count),
+	})
+}
+
+// ================================== Login history ====================================================================
+//	@title			Login history
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// GetLoginList godoc
+// @Summary      Login history
+// @Description  Sign-in attempts of the user, successful and failed, with the method, address, user agent and the
+// @Description  location by GeoIP, the recent ones first. Pass created_at of the last event as before for the next
+// @Description  page. Staff can read the history of any user.
+// @Tags         user
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path string true "User id (UUID)"
+// @Param        limit query int false "Limit, 50 by default, 200 at most"
+// @Param        before query string false "Events before the time (RFC 3339)"
+// @Success      200 {array}   LoginEventDto
+// @Failure      403 {object}  ErrorResponseDto
+// @Failure      404 {object}  ErrorResponseDto
+// @Failure      422 {object}  ErrorResponseDto
+// @Router       /user/{id}/logins [get]
+func GetLoginList(c *gin.Context) {
+	account, ok := bindAccount(c, user.RoleAdmin, user.RoleSupport)
+	if !ok {
+		return
+	}
+
+	var requestLoginHistoryDto RequestLoginHistoryDto
+	if err := c.ShouldBindQuery(&requestLoginHistoryDto); err != nil {
+		utils.LogError(dictionary.ErrorParsingRequestBody, err)
+		c.JSON(http.StatusUnprocessableEntity, &ErrorResponseDto{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	limit := requestLoginHistoryDto.Limit
+	if limit <= 0 {
+		limit = loginHistoryDefaultLimit
+	}
+	if limit > loginHistoryMaxLimit {
+		limit = loginHistoryMaxLimit
+	}
+
+	before := requestLoginHistoryDto.Before
+	if before.IsZero() {
+		before = time.Now()
+	}
+
+	events, err := GetLoginEvents(account.ID, before, limit)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	result := make([]LoginEventDto, 0, len(events))
+	for _, event := range events {
+		result = append(result, loginEventDto(event))
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// ================================== External providers ===============================================================
+//	@title			External providers
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// GetExternalProviderList godoc
+// @Summary      External providers
+// @Description  Names of the configured identity providers (IDP_PROVIDERS), the login page shows a button for each.
+// @Tags         auth
+// @Produce      json
+// @Success      200 {object}  ExternalProvidersDto
+// @Router       /auth/external/providers [get]
+func GetExternalProviderList(c *gin.Context) {
+	providers := idp.Providers()
+	if providers == nil {
+		providers = []string{}
+	}
+
+	c.JSON(http.StatusOK, &ExternalProvidersDto{
+		Providers: providers,
+	})
+}
+
+// ================================== Begin external login =============================================================
+//	@title			Begin external login
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// BeginExternalLogin godoc
+// @Summary      Begin external login
+// @Description  Start the sign-in by the identity provider. Send the browser to authorization_url, the provider
+// @Description  redirects it to the callback page of the frontend (IDP_REDIRECT_URL) with code and state. The response
+// @Description  sets the external_login_device cookie, the sign-in is finished only by the same browser.
+// @Tags         auth
+// @Produce      json
+// @Param        provider path string true "Provider name"
+// @Success      200 {object}  ExternalLoginDto
+// @Failure      404 {object}  ErrorResponseDto
+// @Router       /auth/external/{provider}/begin [post]
+func BeginExternalLogin(c *gin.Context) {
+	provider, ok := bindExternalProvider(c)
+	if !ok {
+		return
+	}
+
+	result, err := beginExternalLogin(c, provider, nil, ExternalPurposeLogin, time.Now())
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// ================================== Finish external login ============================================================
+//	@title			Finish external login
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// FinishExternalLogin godoc
+// @Summary      Finish external login
+// @Description  Exchange the code of the provider for tokens. The user of the linked identity is signed in. An unknown
+// @Description  identity is linked to the user with the same email when the provider and the user both have it
+// @Description  verified (AUTH_EXTERNAL_AUTO_LINK), otherwise 409 asks to sign in and link the provider. Without such
+// @Description  user a new one is signed up (AUTH_EXTERNAL_SIGN_UP). With two-factor authentication enabled
+// @Description  MfaChallengeDto is returned.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        provider path string true "Provider name"
+// @Param        request body RequestExternalFinishDto true "Code and state of the provider redirect"
+// @Success      200 {object}  TokenPairDto
+// @Failure      401 {object}  ErrorResponseDto
+// @Failure      403 {object}  AccountStatusErrorDto
+// @Failure      404 {object}  ErrorResponseDto
+// @Failure      409 {object}  ErrorResponseDto
+// @Failure      422 {object}  ErrorResponseDto
+// @Failure      429 {object}  ErrorResponseDto
+// @Router       /auth/external/{provider}/finish [post]
+func FinishExternalLogin(c *gin.Context) {
+	provider, ok := bindExternalProvider(c)
+	if !ok {
+		return
+	}
+
+	var requestExternalFinishDto RequestExternalFinishDto
+	if err := c.ShouldBindJSON(&requestExternalFinishDto); err != nil {
+		utils.LogError(dictionary.ErrorParsingRequestBody, err)
+		c.JSON(http.StatusUnprocessableEntity, &ErrorResponseDto{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if !checkLoginThrottle(c, "") {
+		recordLoginFailed(c, uuid.Nil, LoginMethodExternal, LoginFailureTooManyAttempts)
+		return
+	}
+
+	now := time.Now()
+	_, identity, err := finishExternalLogin(c, provider, ExternalPurposeLogin, requestExternalFinishDto, now)
+	switch {
+	case errors.Is(err, ErrExternalLoginStateInvalid):
+		c.JSON(http.StatusUnauthorized, &ErrorResponseDto{
+			Message: ErrorExternalLoginStateInvalid,
+		})
+		return
+	case errors.Is(err, idp.ErrInvalidIdentity):
+		utils.LogInfo(err.Error())
+		recordLoginFailure(c, "", uuid.Nil)
+		recordLoginFailed(c, uuid.Nil, LoginMethodExternal, LoginFailureInvalidToken)
+		c.JSON(http.StatusUnauthorized, &ErrorResponseDto{
+			Message: ErrorExternalIdentityInvalid,
+		})
+		return
+	case err != nil:
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	userId, linked, err := resolveExternalAccount(provider, identity, now)
+	switch {
+	case errors.Is(err, ErrExternalAccountExists):
+		c.JSON(http.StatusConflict, &ErrorResponseDto{
+			Message: ErrorExternalAccountExists,
+		})
+		return
+	case errors.Is(err, ErrExternalProviderLinked):
+		c.JSON(http.StatusConflict, &ErrorResponseDto{
+			Message: ErrorExternalProviderLinked,
+		})
+		return
+	case errors.Is(err, ErrExternalSignUpDenied):
+		c.JSON(http.StatusForbidden, &ErrorResponseDto{
+			Message: ErrorExternalSignUpDenied,
+		})
+		return
+	case err != nil:
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	if linked != nil {
+		if err := audit.Record(c, audit.EventIdentityLinked, userId, nil); err != nil {
+			utils.LogError(dictionary.SomethingWrong, err)
+		}
+	}
+
+	account, err := user.GetOneFullById(userId)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	if account.ID == uuid.Nil || !account.DeletedAt.IsZero() {
+		recordLoginFailed(c, account.ID, LoginMethodExternal, LoginFailureInvalidCredentials)
+		c.JSON(http.StatusUnauthorized, &ErrorResponseDto{
+			Message: ErrorInvalidCredentials,
+		})
+		return
+	}
+
+	err = user.CheckAccountStatus(account.ID, account.Status, account.SuspendedUntil, account.StatusReason)
+	if !abortAccountStatus(c, err) {
+		recordLoginFailed(c, account.ID, LoginMethodExternal, LoginFailureAccountStatus)
+		return
+	}
+
+	if account.EmailVerifiedAt == nil && IsRestrictedForUnverified(ActionLogin) {
+		recordLoginFailed(c, account.ID, LoginMethodExternal, LoginFailureEmailNotVerified)
+		c.JSON(http.StatusForbidden, &ErrorResponseDto{
+			Message: ErrorEmailNotVerified,
+		})
+		return
+	}
+
+	isMfaEnabled, err := IsMfaEnabled(account.ID)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	if isMfaEnabled {
+		issueMfaChallenge(c, account.ID)
+		return
+	}
+
+	completeLogin(c, account, LoginMethodExternal)
+}
+
+// ================================== Identity list ====================================================================
+//	@title			Identity list
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// GetUserIdentityList godoc
+// @Summary      Identity list
+// @Description  Accounts of external identity providers linked to the user, staff can see them for any user.
+// @Tags         user
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path string true "User id (UUID)"
+// @Success      200 {array}   UserIdentityDto
+// @Failure      403 {object}  ErrorResponseDto
+// @Failure      404 {object}  ErrorResponseDto
+// @Router       /user/{id}/identities [get]
+func GetUserIdentityList(c *gin.Context) {
+	account, ok := bindAccount(c, user.RoleAdmin, user.RoleSupport)
+	if !ok {
+		return
+	}
+
+	identities, err := GetUserIdentities(account.ID)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	result := make([]UserIdentityDto, 0, len(identities))
+	for _, identity := range identities {
+		result = append(result, userIdentityDto(identity))
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// ================================== Begin identity link ==============================================================
+//	@title			Begin identity link
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// BeginUserIdentityLink godoc
+// @Summary      Begin identity link
+// @Description  Start linking an account of the identity provider to the authenticated user. The browser is sent to
+// @Description  authorization_url like for the external login, the callback page sends code and state to
+// @Description  identities/{provider}/finish.
+// @Tags         user
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path string true "User id (UUID)"
+// @Param        provider path string true "Provider name"
+// @Success      200 {object}  ExternalLoginDto
+// @Failure      403 {object}  ErrorResponseDto
+// @Failure      404 {object}  ErrorResponseDto
+// @Router       /user/{id}/identities/{provider}/begin [post]
+func BeginUserIdentityLink(c *gin.Context) {
+	account, ok := bindMfaAccount(c, false)
+	if !ok {
+		return
+	}
+
+	provider, ok := bindExternalProvider(c)
+	if !ok {
+		return
+	}
+
+	result, err := beginExternalLogin(c, provider, &account.ID, ExternalPurposeLink, time.Now())
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// ================================== Finish identity link =============================================================
+//	@title			Finish identity link
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// FinishUserIdentityLink godoc
+// @Summary      Finish identity link
+// @Description  Exchange the code of the provider and link its account to the user. The email of the provider does
+// @Description  not have to match, the user proved both accounts. An account linked to another user is refused.
+// @Tags         user
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path string true "User id (UUID)"
+// @Param        provider path string true "Provider name"
+// @Param        request body RequestExternalFinishDto true "Code and state of the provider redirect"
+// @Success      201 {object}  UserIdentityDto
+// @Failure      400 {object}  ErrorResponseDto
+// @Failure      403 {object}  ErrorResponseDto
+// @Failure      404 {object}  ErrorResponseDto
+// @Failure      409 {object}  ErrorResponseDto
+// @Failure      422 {object}  ErrorResponseDto
+// @Router       /user/{id}/identities/{provider}/finish [post]
+func FinishUserIdentityLink(c *gin.Context) {
+	account, ok := bindMfaAccount(c, false)
+	if !ok {
+		return
+	}
+
+	provider, ok := bindExternalProvider(c)
+	if !ok {
+		return
+	}
+
+	var requestExternalFinishDto RequestExternalFinishDto
+	if err := c.ShouldBindJSON(&requestExternalFinishDto); err != nil {
+		utils.LogError(dictionary.ErrorParsingRequestBody, err)
+		c.JSON(http.StatusUnprocessableEntity, &ErrorResponseDto{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	now := time.Now()
+	state, identity, err := finishExternalLogin(c, provider, ExternalPurposeLink, requestExternalFinishDto, now)
+	if err == nil && (state.UserID == nil || *state.UserID != account.ID) {
+		err = ErrExternalLoginStateInvalid
+	}
+
+	var created *UserIdentity
+	if err == nil {
+		created = &UserIdentity{
+			UserID:     account.ID,
+			Provider:   provider.Name,
+			Subject:    identity.Subject,
+			Email:      identity.Email,
+			CreatedAt:  now,
+			LastUsedAt: &now,
+		}
+		err = linkUserIdentity(created)
+	}
+
+	switch {
+	case errors.Is(err, ErrExternalLoginStateInvalid):
+		c.JSON(http.StatusBadRequest, &ErrorResponseDto{
+			Message: ErrorExternalLoginStateInvalid,
+		})
+		return
+	case errors.Is(err, idp.ErrInvalidIdentity):
+		utils.LogInfo(err.Error())
+		c.JSON(http.StatusBadRequest, &ErrorResponseDto{
+			Message: ErrorExternalIdentityInvalid,
+		})
+		return
+	case errors.Is(err, ErrExternalIdentityLinked):
+		c.JSON(http.StatusConflict, &ErrorResponseDto{
+			Message: ErrorExternalIdentityLinked,
+		})
+		return
+	case errors.Is(err, ErrExternalProviderLinked):
+		c.JSON(http.StatusConflict, &ErrorResponseDto{
+			Message: ErrorExternalProviderLinked,
+		})
+		return
+	case err != nil:
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	if err := audit.Record(c, audit.EventIdentityLinked, account.ID, nil); err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+	}
+
+	c.JSON(http.StatusCreated, userIdentityDto(*created))
+}
+
+// ================================== Unlink identity ==================================================================
+//	@title			Unlink identity
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// DeleteUserIdentityById godoc
+// @Summary      Unlink identity
+// @Description  Unlink the account of the identity provider, admin can unlink it for another user, it is audited. The
+// @Description  only way to sign in of a user without a password and passkeys is not unlinked.
+// @Tags         user
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path string true "User id (UUID)"
+// @Param        identityId path string true "Identity id (UUID)"
+// @Success      200 {object}  SuccessResponseDto
+// @Failure      403 {object}  ErrorResponseDto
+// @Failure      404 {object}  ErrorResponseDto
+// @Failure      409 {object}  ErrorResponseDto
+// @Router       /user/{id}/identities/{identityId} [delete]
+func DeleteUserIdentityById(c *gin.Context) {
+	account, ok := bindMfaAccount(c, true)
+	if !ok {
+		return
+	}
+
+	identityId, err := uuid.Parse(c.Param("identityId"))
+	if err != nil {
+		utils.LogError(dictionary.ErrorParsingRequestBody, err)
+		c.JSON(http.StatusUnprocessableEntity, &ErrorResponseDto{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	identities, err := GetUserIdentities(account.ID)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	if !slices.ContainsFunc(identities, func(identity UserIdentity) bool { return identity.ID == identityId }) {
+		c.JSON(http.StatusNotFound, &ErrorResponseDto{
+			Message: fmt.Sprintf(ErrorExternalIdentityNotFound, identityId),
+		})
+		return
+	}
+
+	fullAccount, err := user.GetOneFullById(account.ID)
+	isLast := false
+	if err == nil {
+		isLast, err = isLastSignInMethod(fullAccount, identities)
+	}
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	if isLast {
+		c.JSON(http.StatusConflict, &ErrorResponseDto{
+			Message: ErrorExternalLastSignInMethod,
+		})
+		return
+	}
+
+	isDeleted, err := DeleteUserIdentity(account.ID, identityId)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	if !isDeleted {
+		c.JSON(http.StatusNotFound, &ErrorResponseDto{
+			Message: fmt.Sprintf(ErrorExternalIdentityNotFound, identityId),
+		})
+		return
+	}
+
+	actorId := GetClaims(c).UserID()
+	if err := audit.Record(c, audit.EventIdentityUnlinked, account.ID, &actorId); err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+	}
+
+	c.JSON(http.StatusOK, &SuccessResponseDto{
+		Message: ExternalIdentityUnlinked,
+	})
+}
+
+// ================================== Create API key ===================================================================
+//	@title			Create API key
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// CreateUserApiKey godoc
+// @Summary      Create API key
+// @Description  Create a personal API key for machine access on behalf of the user, for example for CI scripts. The
+// @Description  key is returned only once, send it as "Authorization: Bearer <key>" or in X-Api-Key. The read scope
+// @Description  allows GET requests, write allows any request, admin passes the roles of the user. API keys can not
+// @Description  manage credentials, sessions and other keys, this needs a signed-in user.
+// @Tags         user
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path string true "User id (UUID)"
+// @Param        request body RequestApiKeyDto true "Name, scopes and expiry"
+// @Success      201 {object}  ApiKeyCreatedDto
+// @Failure      403 {object}  ErrorResponseDto
+// @Failure      409 {object}  ErrorResponseDto
+// @Failure      422 {object}  ErrorResponseDto
+// @Router       /user/{id}/api-keys [post]
+func CreateUserApiKey(c *gin.Context) {
+	account, ok := bindAccount(c)
+	if !ok {
+		return
+	}
+
+	var requestApiKeyDto RequestApiKeyDto
+	if err := c.ShouldBindJSON(&requestApiKeyDto); err != nil {
+		utils.LogError(dictionary.ErrorParsingRequestBody, err)
+		c.JSON(http.StatusUnprocessableEntity, &ErrorResponseDto{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	apiKey, raw, err := createApiKey(account.ID, requestApiKeyDto, time.Now())
+	switch {
+	case errors.Is(err, ErrApiKeyExpiry):
+		c.JSON(http.StatusUnprocessableEntity, &ErrorResponseDto{
+			Message: ErrorApiKeyExpiry,
+		})
+		return
+	case errors.Is(err, ErrApiKeyLimit):
+		c.JSON(http.StatusConflict, &ErrorResponseDto{
+			Message: ErrorApiKeyLimit,
+		})
+		return
+	case err != nil:
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	if err := audit.Record(c, audit.EventApiKeyCreated, account.ID, nil); err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+	}
+
+	c.JSON(http.StatusCreated, &ApiKeyCreatedDto{
+		ApiKeyDto: apiKeyDto(*apiKey),
+		Key:       raw,
+	})
+}
+
+// ================================== API key list =====================================================================
+//	@title			API key list
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// GetApiKeyList godoc
+// @Summary      API key list
+// @Description  Personal API keys of the user which are not revoked, with the scopes, expiry and the last use. The keys
+// @Description  themselves are not shown. Staff can see keys of any user.
+// @Tags         user
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path string true "User id (UUID)"
+// @Success      200 {array}   ApiKeyDto
+// @Failure      403 {object}  ErrorResponseDto
+// @Failure      404 {object}  ErrorResponseDto
+// @Router       /user/{id}/api-keys [get]
+func GetApiKeyList(c *gin.Context) {
+	account, ok := bindAccount(c, user.RoleAdmin, user.RoleSupport)
+	if !ok {
+		return
+	}
+
+	apiKeys, err := GetApiKeys(account.ID)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	result := make([]ApiKeyDto, 0, len(apiKeys))
+	for _, apiKey := range apiKeys {
+		result = append(result, apiKeyDto(apiKey))
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// ================================== Revoke API key ===================================================================
+//	@title			Revoke API key
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// DeleteApiKeyById godoc
+// @Summary      Revoke API key
+// @Description  Revoke the API key, it stops working at once. Admin can revoke keys of any user, it is audited.
+// @Tags         user
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path string true "User id (UUID)"
+// @Param        keyId path string true "API key id (UUID)"
+// @Success      200 {object}  SuccessResponseDto
+// @Failure      403 {object}  ErrorResponseDto
+// @Failure      404 {object}  ErrorResponseDto
+// @Router       /user/{id}/api-keys/{keyId} [delete]
+func DeleteApiKeyById(c *gin.Context) {
+	account, ok := bindAccount(c, user.RoleAdmin)
+	if !ok {
+		return
+	}
+
+	keyId, err := uuid.Parse(c.Param("keyId"))
+	if err != nil {
+		utils.LogError(dictionary.ErrorParsingRequestBody, err)
+		c.JSON(http.StatusUnprocessableEntity, &ErrorResponseDto{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	isRevoked, err := RevokeApiKey(account.ID, keyId, time.Now())
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	if !isRevoked {
+		c.JSON(http.StatusNotFound, &ErrorResponseDto{
+			Message: fmt.Sprintf(ErrorApiKeyNotFound, keyId),
+		})
+		return
+	}
+
+	actorId := GetClaims(c).UserID()
+	if err := audit.Record(c, audit.EventApiKeyRevoked, account.ID, &actorId); err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+	}
+
+	c.JSON(http.StatusOK, &SuccessResponseDto{
+		Message: ApiKeyRevoked,
+	})
+}
+
+// === Sys
+// completeLogin issues tokens to the user who passed all login checks, the expired password limits them to the change.
+// Accounts without a password signed in by a link or a passkey have nothing to change.
+func completeLogin(c *gin.Context, account *user.UserItemFullResultDto, method string) {
+	resetLoginFailures(account.Email)
+	recordLoginSucceeded(c, account, method)
+
+	now := time.Now()
+	if account.Password != "" && user.PasswordExpired(account.MustChangePassword, account.PasswordChangedAt, account.CreatedAt, now) {
+		pair, err := passwordChangeToken(account, now)
+		if err != nil {
+			utils.LogError(dictionary.SomethingWrong, err)
+			c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+				Message: dictionary.SomethingWrong,
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, pair)
+		return
+	}
+
+	startSession(c, account.ID)
+}
+
+// issueMfaChallenge starts the second step of the login, users with passkeys also get the assertion options
+func issueMfaChallenge(c *gin.Context, userId uuid.UUID) {
+	config := loadMfaConfig()
+	now := time.Now()
+	raw, err := issueActionToken(userId, TokenPurposeMfaChallenge, config.ChallengeTTL, now)
+
+	hasPasskeys := false
+	if err == nil {
+		hasPasskeys, err = HasWebauthnCredentials(userId)
+	}
+
+	var assertion *protocol.CredentialAssertion
+	if err == nil && hasPasskeys {
+		assertion, err = beginWebauthnSecondFactor(userId, HashToken(raw), now)
+	}
+
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, &MfaChallengeDto{
+		MfaRequired: true,
+		MfaToken:    raw,
+		ExpiresIn:   int64(config.ChallengeTTL.Seconds()),
+		Webauthn:    assertion,
+	})
+}
+
+// newTokenPair signs the access token with actual roles of the user and prepares the refresh token of the session for
+// storing, the refresh token does not outlive the session
+func newTokenPair(session *Session, now time.Time) (*TokenPairDto, *RefreshToken, error) {
+	config := LoadConfig()
+
+	roles, err := user.GetUserRoles(session.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	accessToken, err := IssueAccessToken(config, session.UserID, session.ID, roles, "", now)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	raw, hash, err := NewOpaqueToken()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pair := &TokenPairDto{
+		AccessToken:  accessToken,
+		RefreshToken: raw,
+		TokenType:    TokenTypeBearer,
+		ExpiresIn:    int64(config.AccessTokenTTL.Seconds()),
+	}
+
+	expiresAt := now.Add(config.RefreshTokenTTL)
+	if expiresAt.After(session.ExpiresAt) {
+		expiresAt = session.ExpiresAt
+	}
+
+	refreshToken := &RefreshToken{
+		UserID:    session.UserID,
+		FamilyID:  session.ID,
+		TokenHash: hash,
+		ExpiresAt: expiresAt,
+		CreatedAt: now,
+	}
+
+	return pair, refreshToken, nil
+}
+
+// revokeRefreshTokenFamily handles reuse of a rotated refresh token, it is treated as a stolen token and the session
+// is ended
+func revokeRefreshTokenFamily(c *gin.Context, refreshToken *RefreshToken, now time.Time) {
+	if _, err := RevokeSession(refreshToken.UserID, refreshToken.FamilyID, now); err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+	}
+
+	c.JSON(http.StatusUnauthorized, &ErrorResponseDto{
+		Message: ErrorInvalidRefreshToken,
+	})
+}
+
+// requestLocale returns the preferred language of the client, emails fall back to the default locale
+func requestLocale(c *gin.Context) string {
+	language, _, _ := strings.Cut(c.GetHeader("Accept-Language"), ",")
+	language, _, _ = strings.Cut(language, ";")
+	return strings.TrimSpace(language)
+}
+
+func webauthnCredentialDto(credential WebauthnCredential) WebauthnCredentialDto {
+	transports := []string{}
+	if credential.Transports != "" {
+		transports = strings.Split(credential.Transports, ",")
+	}
+
+	return WebauthnCredentialDto{
+		ID:         credential.ID,
+		Name:       credential.Name,
+		Transports: transports,
+		BackedUp:   credential.BackupState,
+		LastUsedAt: credential.LastUsedAt,
+		CreatedAt:  credential.CreatedAt,
+	}
+}
+
+func userIdentityDto(identity UserIdentity) UserIdentityDto {
+	return UserIdentityDto{
+		ID:         identity.ID,
+		Provider:   identity.Provider,
+		Email:      identity.Email,
+		LastUsedAt: identity.LastUsedAt,
+		CreatedAt:  identity.CreatedAt,
+	}
+}
+
+// bindExternalProvider loads the provider of the uri, providers missing in IDP_PROVIDERS are not found
+func bindExternalProvider(c *gin.Context) (*idp.Provider, bool) {
+	provider := idp.LoadProvider(c.Param("provider"))
+	if provider == nil {
+		c.JSON(http.StatusNotFound, &ErrorResponseDto{
+			Message: fmt.Sprintf(ErrorExternalProviderNotFound, c.Param("provider")),
+		})
+		return nil, false
+	}
+	return provider, true
+}
+
+// bindMfaAccount loads the user from the uri, MFA is managed by the user, allowAdmin lets admin act for others
+func bindMfaAccount(c *gin.Context, allowAdmin bool) (*user.UserItemResultDto, bool) {
+	if allowAdmin {
+		return bindAccount(c, user.RoleAdmin)
+	}
+	return bindAccount(c)
+}
+
+// bindAccount reads the user of the /user/{id} route, it is allowed to the token owner and to staff with any of
+// the roles
+func bindAccount(c *gin.Context, staffRoles ...string) (*user.UserItemResultDto, bool) {
+	var requestUserIdDTO user.RequestUserIdDTO
+	if err := c.ShouldBindUri(&requestUserIdDTO); err != nil {
+		utils.LogError(dictionary.ErrorParsingRequestBody, err)
+		c.JSON(http.StatusUnprocessableEntity, &ErrorResponseDto{
+			Message: err.Error(),
+		})
+		return nil, false
+	}
+
+	claims := GetClaims(c)
+	isStaff := slices.ContainsFunc(staffRoles, func(role string) bool {
+		return slices.Contains(claims.Roles, role)
+	})
+	if claims.Subject != requestUserIdDTO.ID && !isStaff {
+		c.JSON(http.StatusForbidden, &ErrorResponseDto{
+			Message: ErrorAccessDenied,
+		})
+		return nil, false
+	}
+
+	account, err := user.GetOneById(requestUserIdDTO)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return nil, false
+	}
+
+	if account.ID == uuid.Nil {
+		c.JSON(http.StatusNotFound, &ErrorResponseDto{
+			Message: fmt.Sprintf(dictionary.UserByIdNotFound, requestUserIdDTO.ID),
+		})
+		return nil, false
+	}
+	return account, true
+}
@@ -0,0 +1,1623 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/apiboxgo/library-utils/api_init"
+	"github.com/apiboxgo/library-utils/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/google/uuid"
+	"github.com/pquerna/otp/totp"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+	"user-service/api/audit"
+	"user-service/api/job"
+	"user-service/api/lockout"
+	"user-service/api/user"
+	"user-service/geoip"
+	"user-service/idp"
+	"user-service/mailer"
+	"user-service/ratelimit"
+)
+
+var db *gorm.DB
+var sentMail = mailer.NewMemoryMailer()
+
+func init() {
+	api_init.TestInit("../../")
+	db = api_init.InitGlobal.Dbh
+	mailer.SetDefault(sentMail)
+	geoip.SetDefault(geoip.NewStaticLocator(map[string]geoip.Location{
+		"198.51.100.1": {Country: "DE", City: "Berlin"},
+		"203.0.113.1":  {Country: "FR", City: "Paris"},
+	}))
+
+	if os.Getenv("AUTH_TOKEN_SECRET") == "" {
+		_ = os.Setenv("AUTH_TOKEN_SECRET", "test-secret")
+	}
+	if os.Getenv("AUTH_MFA_ENCRYPTION_KEY") == "" {
+		_ = os.Setenv("AUTH_MFA_ENCRYPTION_KEY", "dGVzdC1tZmEta2V5LTMyLWJ5dGVzLWxvbmctLS0tLS0=")
+	}
+}
+
+func TestLogin_SuccessfulResult(t *testing.T) {
+	clearDbTables(t)
+	createUser(t, "test_user_1@user.com", user.StatusActive, nil)
+
+	var result TokenPairDto
+	w := login(t, "test_user_1@user.com", "123123123", &result)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, result.AccessToken)
+	assert.NotEmpty(t, result.RefreshToken)
+
+	var claims TokenClaimsDto
+	w = sendRequest(t, UriAuth+UriAuthValidate, "GET", nil, result.AccessToken, &claims)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestLogin_RehashesLegacyHash(t *testing.T) {
+	clearDbTables(t)
+	account := createUser(t, "test_user_1@user.com", user.StatusActive, nil)
+	assert.True(t, strings.HasPrefix(account.Password, "$2a$"))
+
+	var result TokenPairDto
+	w := login(t, "test_user_1@user.com", "123123123", &result)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	updated, err := user.GetOneByEmail("test_user_1@user.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, strings.HasPrefix(updated.Password, "$argon2id$"))
+	assert.Nil(t, updated.PasswordChangedAt)
+
+	w = login(t, "test_user_1@user.com", "123123123", &result)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestLogin_WrongPassword(t *testing.T) {
+	clearDbTables(t)
+	createUser(t, "test_user_1@user.com", user.StatusActive, nil)
+
+	var result ErrorResponseDto
+	w := login(t, "test_user_1@user.com", "wrong-password", &result)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, ErrorInvalidCredentials, result.Message)
+
+	w = login(t, "unknown@user.com", "123123123", &result)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, ErrorInvalidCredentials, result.Message)
+}
+
+func TestLogin_BackoffAfterFreeAttempts(t *testing.T) {
+	clearDbTables(t)
+	t.Setenv("LOCKOUT_ACCOUNT_FREE_ATTEMPTS", "1")
+	t.Setenv("LOCKOUT_BACKOFF_BASE", "1m")
+	createUser(t, "test_user_1@user.com", user.StatusActive, nil)
+
+	var result ErrorResponseDto
+	w := login(t, "test_user_1@user.com", "wrong-password", &result)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = login(t, "test_user_1@user.com", "wrong-password", &result)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// The correct password is not checked while the delay lasts
+	w = login(t, "test_user_1@user.com", "123123123", &result)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, lockout.ErrorTooManyAttempts, result.Message)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+
+	var pair TokenPairDto
+	w = login(t, "test_user_2@user.com", "123123123", &pair)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestLogin_LockoutAndAdminUnlock(t *testing.T) {
+	clearDbTables(t)
+	t.Setenv("LOCKOUT_ACCOUNT_THRESHOLD", "3")
+	t.Setenv("LOCKOUT_BACKOFF_BASE", "0")
+	account := createUser(t, "test_user_1@user.com", user.StatusActive, nil)
+	admin := createUser(t, "admin@user.com", user.StatusActive, nil)
+	db.Create(&user.UserRole{UserID: admin.ID, Role: user.RoleAdmin, CreatedAt: time.Now()})
+
+	var adminPair TokenPairDto
+	login(t, "admin@user.com", "123123123", &adminPair)
+
+	var result ErrorResponseDto
+	for i := 0; i < 3; i++ {
+		w := login(t, "Test_User_1@user.com", "wrong-password", &result)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	}
+
+	runJobs(t)
+	message, isSent := sentMail.Last("test_user_1@user.com")
+	assert.True(t, isSent)
+	assert.Equal(t, "Your account is temporarily locked", message.Subject)
+
+	events, err := audit.GetUserEvents(account.ID, audit.EventAccountLocked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(events))
+
+	w := login(t, "test_user_1@user.com", "123123123", &result)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+	var unlockResult SuccessResponseDto
+	uri := fmt.Sprintf(user.UriUser+user.UriUserUnlockS, account.ID)
+	w = sendRequest(t, uri, "POST", nil, adminPair.AccessToken, &unlockResult)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	events, err = audit.GetUserEvents(account.ID, audit.EventAccountUnlocked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, admin.ID, *events[0].ActorID)
+
+	var pair TokenPairDto
+	w = login(t, "test_user_1@user.com", "123123123", &pair)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestLogin_SuspendedUser(t *testing.T) {
+	clearDbTables(t)
+	until := time.Now().Add(time.Hour)
+	createUser(t, "test_user_1@user.com", user.StatusSuspended, &until)
+
+	var result AccountStatusErrorDto
+	w := login(t, "test_user_1@user.com", "123123123", &result)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, user.StatusSuspended, result.Status)
+	assert.NotNil(t, result.SuspendedUntil)
+}
+
+func TestLogin_ExpiredSuspension(t *testing.T) {
+	clearDbTables(t)
+	until := time.Now().Add(-time.Minute)
+	account := createUser(t, "test_user_1@user.com", user.StatusSuspended, &until)
+
+	var result TokenPairDto
+	w := login(t, "test_user_1@user.com", "123123123", &result)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var status string
+	db.Model(&user.User{}).Select("status").Where("id = ?", account.ID).Scan(&status)
+	assert.Equal(t, user.StatusActive, status)
+}
+
+func TestValidate_SuspendedAfterLogin(t *testing.T) {
+	clearDbTables(t)
+	account := createUser(t, "test_user_1@user.com", user.StatusActive, nil)
+
+	var pair TokenPairDto
+	login(t, "test_user_1@user.com", "123123123", &pair)
+
+	db.Model(&user.User{}).Where("id = ?", account.ID).Update("status", user.StatusBanned)
+
+	var result AccountStatusErrorDto
+	w := sendRequest(t, UriAuth+UriAuthValidate, "GET", nil, pair.AccessToken, &result)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, user.StatusBanned, result.Status)
+}
+
+func TestRefresh_RotationAndReuse(t *testing.T) {
+	clearDbTables(t)
+	createUser(t, "test_user_1@user.com", user.StatusActive, nil)
+
+	var pair TokenPairDto
+	login(t, "test_user_1@user.com", "123123123", &pair)
+
+	var rotated TokenPairDto
+	w := refresh(t, pair.RefreshToken, &rotated)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEqual(t, pair.RefreshToken, rotated.RefreshToken)
+
+	var result ErrorResponseDto
+	w = refresh(t, pair.RefreshToken, &result)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// reuse of the rotated token revokes the whole family
+	w = refresh(t, rotated.RefreshToken, &result)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestSessions_ListAndRemoteSignOut(t *testing.T) {
+	clearDbTables(t)
+	account := createUser(t, "test_user_1@user.com", user.StatusActive, nil)
+	support := createUser(t, "support@user.com", user.StatusActive, nil)
+	db.Create(&user.UserRole{UserID: support.ID, Role: user.RoleSupport, CreatedAt: time.Now()})
+
+	var laptop, phone TokenPairDto
+	login(t, "test_user_1@user.com", "123123123", &laptop)
+	login(t, "test_user_1@user.com", "123123123", &phone)
+
+	var sessions []SessionDto
+	uri := fmt.Sprintf(user.UriUser+user.UriUserSessionsS, account.ID)
+	w := sendRequest(t, uri, "GET", nil, laptop.AccessToken, &sessions)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 2, len(sessions))
+
+	var phoneSession SessionDto
+	for _, session := range sessions {
+		if !session.Current {
+			phoneSession = session
+		}
+	}
+	assert.NotEqual(t, uuid.Nil, phoneSession.ID)
+
+	var result SuccessResponseDto
+	w = sendRequest(t, fmt.Sprintf(user.UriUser+user.UriUserSessionS, account.ID, phoneSession.ID), "DELETE", nil, laptop.AccessToken, &result)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// The access token of the ended session stops working before it expires
+	var claims TokenClaimsDto
+	w = sendRequest(t, UriAuth+UriAuthValidate, "GET", nil, phone.AccessToken, &claims)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	var errorResult ErrorResponseDto
+	w = refresh(t, phone.RefreshToken, &errorResult)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	var supportPair TokenPairDto
+	login(t, "support@user.com", "123123123", &supportPair)
+	w = sendRequest(t, uri, "DELETE", nil, supportPair.AccessToken, &result)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, fmt.Sprintf(SessionsRevoked, 1), result.Message)
+
+	w = sendRequest(t, UriAuth+UriAuthValidate, "GET", nil, laptop.AccessToken, &claims)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	events, err := audit.GetUserEvents(account.ID, audit.EventSessionsRevoked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, support.ID, *events[0].ActorID)
+}
+
+func TestSessions_IdleTimeout(t *testing.T) {
+	clearDbTables(t)
+	t.Setenv("AUTH_SESSION_IDLE_TIMEOUT", "1h")
+	account := createUser(t, "test_user_1@user.com", user.StatusActive, nil)
+
+	var pair TokenPairDto
+	login(t, "test_user_1@user.com", "123123123", &pair)
+	db.Model(&Session{}).Where("user_id = ?", account.ID).Update("last_seen_at", time.Now().Add(-2*time.Hour))
+
+	var claims TokenClaimsDto
+	w := sendRequest(t, UriAuth+UriAuthValidate, "GET", nil, pair.AccessToken, &claims)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	var result ErrorResponseDto
+	w = refresh(t, pair.RefreshToken, &result)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, ErrorSessionExpired, result.Message)
+}
+
+func TestAdminRoutes_RequireRole(t *testing.T) {
+	clearDbTables(t)
+	account := createUser(t, "test_user_1@user.com", user.StatusActive, nil)
+
+	var pair TokenPairDto
+	login(t, "test_user_1@user.com", "123123123", &pair)
+
+	body, _ := json.Marshal(map[string]any{"operation": user.BulkOperationRestore, "all": true, "dry_run": true})
+	var result ErrorResponseDto
+	w := sendRequest(t, user.UriUser+user.UriUserBulk, "POST", bytes.NewBuffer(body), pair.AccessToken, &result)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, ErrorAccessDenied, result.Message)
+
+	db.Create(&user.UserRole{UserID: account.ID, Role: user.RoleAdmin, CreatedAt: time.Now()})
+	login(t, "test_user_1@user.com", "123123123", &pair)
+
+	var bulkResult user.BulkResultDto
+	w = sendRequest(t, user.UriUser+user.UriUserBulk, "POST", bytes.NewBuffer(body), pair.AccessToken, &bulkResult)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestVerifyEmail_SingleUse(t *testing.T) {
+	clearDbTables(t)
+	account := createUser(t, "test_user_1@user.com", user.StatusActive, nil)
+	db.Model(&user.User{}).Where("id = ?", account.ID).Update("email_verified_at", nil)
+
+	token, err := issueActionToken(account.ID, TokenPurposeVerifyEmail, time.Hour, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := json.Marshal(RequestTokenDto{Token: token})
+	var result SuccessResponseDto
+	w := sendRequest(t, UriAuth+UriAuthVerifyEmail, "POST", bytes.NewBuffer(body), "", &result)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, EmailVerifiedSuccessful, result.Message)
+
+	resultDto, err := user.GetOneById(user.RequestUserIdDTO{ID: account.ID.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotNil(t, resultDto.EmailVerifiedAt)
+
+	var errorResult ErrorResponseDto
+	w = sendRequest(t, UriAuth+UriAuthVerifyEmail, "POST", bytes.NewBuffer(body), "", &errorResult)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, ErrorInvalidActionToken, errorResult.Message)
+}
+
+func TestVerifyEmail_ExpiredToken(t *testing.T) {
+	clearDbTables(t)
+	account := createUser(t, "test_user_1@user.com", user.StatusActive, nil)
+
+	token, err := issueActionToken(account.ID, TokenPurposeVerifyEmail, time.Hour, time.Now().Add(-2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := json.Marshal(RequestTokenDto{Token: token})
+	var result ErrorResponseDto
+	w := sendRequest(t, UriAuth+UriAuthVerifyEmail, "POST", bytes.NewBuffer(body), "", &result)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestResendVerification_RateLimited(t *testing.T) {
+	clearDbTables(t)
+	account := createUser(t, "test_user_1@user.com", user.StatusActive, nil)
+	db.Model(&user.User{}).Where("id = ?", account.ID).Update("email_verified_at", nil)
+
+	body, _ := json.Marshal(RequestEmailDto{Email: "test_user_1@user.com"})
+	var result SuccessResponseDto
+	for i := 0; i < 3; i++ {
+		w := sendRequest(t, UriAuth+UriAuthResendVerification, "POST", bytes.NewBuffer(body), "", &result)
+		assert.Equal(t, http.StatusAccepted, w.Code)
+	}
+
+	_, isSent := sentMail.Last("test_user_1@user.com")
+	assert.False(t, isSent)
+	runJobs(t)
+
+	var count int64
+	db.Model(&ActionToken{}).Where("user_id = ?", account.ID).Count(&count)
+	assert.Equal(t, int64(1), count)
+
+	message, isSent := sentMail.Last("test_user_1@user.com")
+	assert.True(t, isSent)
+	assert.Contains(t, message.Text, "token=")
+
+	body, _ = json.Marshal(RequestEmailDto{Email: "unknown@user.com"})
+	w := sendRequest(t, UriAuth+UriAuthResendVerification, "POST", bytes.NewBuffer(body), "", &result)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+}
+
+func TestAdminRoutes_UnverifiedEmail(t *testing.T) {
+	clearDbTables(t)
+	account := createUser(t, "test_user_1@user.com", user.StatusActive, nil)
+	db.Create(&user.UserRole{UserID: account.ID, Role: user.RoleAdmin, CreatedAt: time.Now()})
+	db.Model(&user.User{}).Where("id = ?", account.ID).Update("email_verified_at", nil)
+
+	var pair TokenPairDto
+	w := login(t, "test_user_1@user.com", "123123123", &pair)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	body, _ := json.Marshal(map[string]any{"operation": user.BulkOperationRestore, "all": true, "dry_run": true})
+	var result ErrorResponseDto
+	w = sendRequest(t, user.UriUser+user.UriUserBulk, "POST", bytes.NewBuffer(body), pair.AccessToken, &result)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, ErrorEmailNotVerified, result.Message)
+}
+
+func TestForgotPassword_UnknownEmail(t *testing.T) {
+	clearDbTables(t)
+
+	body, _ := json.Marshal(RequestEmailDto{Email: "unknown@user.com"})
+	var result SuccessResponseDto
+	w := sendRequest(t, UriAuth+UriAuthPasswordForgot, "POST", bytes.NewBuffer(body), "", &result)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, PasswordResetSent, result.Message)
+}
+
+func TestForgotPassword_SendsLink(t *testing.T) {
+	clearDbTables(t)
+	createUser(t, "test_user_1@user.com", user.StatusActive, nil)
+
+	body, _ := json.Marshal(RequestEmailDto{Email: "test_user_1@user.com"})
+	var result SuccessResponseDto
+	w := sendRequest(t, UriAuth+UriAuthPasswordForgot, "POST", bytes.NewBuffer(body), "", &result)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	runJobs(t)
+
+	message, isSent := sentMail.Last("test_user_1@user.com")
+	assert.True(t, isSent)
+	assert.Equal(t, "Reset your password", message.Subject)
+
+	token := regexp.MustCompile(`token=([\w-]+)`).FindStringSubmatch(message.Text)
+	assert.Equal(t, 2, len(token))
+
+	body, _ = json.Marshal(RequestResetPasswordDto{Token: token[1], Password: "Violet-Kettle-93-Harbor"})
+	w = sendRequest(t, UriAuth+UriAuthPasswordReset, "POST", bytes.NewBuffer(body), "", &result)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	sendLog, err := mailer.GetSendLogByRecipient("test_user_1@user.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, mailer.SendStatusSent, sendLog[0].Status)
+}
+
+func TestForgotPassword_RetryAfterFailedSend(t *testing.T) {
+	clearDbTables(t)
+	sentMail.Reset()
+	createUser(t, "test_user_1@user.com", user.StatusActive, nil)
+	t.Setenv("MAIL_SEND_ATTEMPTS", "1")
+	mailer.SetDefault(&failingMailer{failures: 1})
+	defer mailer.SetDefault(sentMail)
+
+	body, _ := json.Marshal(RequestEmailDto{Email: "test_user_1@user.com"})
+	var result SuccessResponseDto
+	w := sendRequest(t, UriAuth+UriAuthPasswordForgot, "POST", bytes.NewBuffer(body), "", &result)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	runJobs(t)
+
+	_, isSent := sentMail.Last("test_user_1@user.com")
+	assert.False(t, isSent)
+
+	// The retry sends a new link although the failed attempt has already issued a token
+	if err := db.Exec("update jobs set run_at = ?", time.Now()).Error; err != nil {
+		t.Fatal(err)
+	}
+	runJobs(t)
+
+	message, isSent := sentMail.Last("test_user_1@user.com")
+	if assert.True(t, isSent) {
+		assert.Equal(t, "Reset your password", message.Subject)
+	}
+}
+
+func TestResetPassword_RevokesTokens(t *testing.T) {
+	clearDbTables(t)
+	account := createUser(t, "test_user_1@user.com", user.StatusActive, nil)
+
+	var pair TokenPairDto
+	login(t, "test_user_1@user.com", "123123123", &pair)
+	accessToken, err := IssueAccessToken(LoadConfig(), account.ID, uuid.Nil, nil, "", time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := issueActionToken(account.ID, TokenPurposePasswordReset, time.Hour, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := json.Marshal(RequestResetPasswordDto{Token: token, Password: "Violet-Kettle-93-Harbor"})
+	var result SuccessResponseDto
+	w := sendRequest(t, UriAuth+UriAuthPasswordReset, "POST", bytes.NewBuffer(body), "", &result)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var errorResult ErrorResponseDto
+	w = refresh(t, pair.RefreshToken, &errorResult)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = sendRequest(t, UriAuth+UriAuthValidate, "GET", nil, accessToken, &errorResult)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = login(t, "test_user_1@user.com", "Violet-Kettle-93-Harbor", &pair)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	events, err := audit.GetUserEvents(account.ID, audit.EventPasswordReset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(events))
+
+	w = sendRequest(t, UriAuth+UriAuthPasswordReset, "POST", bytes.NewBuffer(body), "", &errorResult)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, ErrorInvalidActionToken, errorResult.Message)
+}
+
+func TestResetPassword_WeakPassword(t *testing.T) {
+	clearDbTables(t)
+	account := createUser(t, "test_user_1@user.com", user.StatusActive, nil)
+
+	token, err := issueActionToken(account.ID, TokenPurposePasswordReset, time.Hour, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := json.Marshal(RequestResetPasswordDto{Token: token, Password: "Test_User_1!"})
+	var result user.ValidationErrorResponseDto
+	w := sendRequest(t, UriAuth+UriAuthPasswordReset, "POST", bytes.NewBuffer(body), "", &result)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, user.ErrorPasswordPolicy, result.Message)
+	assert.Equal(t, user.PasswordRuleBannedSubstring, result.Errors[0].Rule)
+
+	var count int64
+	db.Model(&ActionToken{}).Where("user_id = ? AND used_at IS NULL", account.ID).Count(&count)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestLogin_ExpiredPasswordRestricted(t *testing.T) {
+	clearDbTables(t)
+	t.Setenv("USER_PASSWORD_MAX_AGE", "720h")
+	account := createUser(t, "test_user_1@user.com", user.StatusActive, nil)
+	if err := db.Model(&user.User{}).Where("id = ?", account.ID).Update("password_changed_at", time.Now().Add(-800*time.Hour)).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	var pair TokenPairDto
+	w := login(t, "test_user_1@user.com", "123123123", &pair)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, pair.MustChangePassword)
+	assert.Empty(t, pair.RefreshToken)
+
+	var errorResult ErrorResponseDto
+	w = sendRequest(t, UriAuth+UriAuthValidate, "GET", nil, pair.AccessToken, &errorResult)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, ErrorPasswordChangeRequired, errorResult.Message)
+
+	body, _ := json.Marshal(RequestChangePasswordDto{CurrentPassword: "123123123", Password: "Violet-Kettle-93-Harbor"})
+	var changed TokenPairDto
+	w = sendRequest(t, UriAuth+UriAuthPasswordChange, "POST", bytes.NewBuffer(body), pair.AccessToken, &changed)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.False(t, changed.MustChangePassword)
+	assert.NotEmpty(t, changed.RefreshToken)
+
+	var claims TokenClaimsDto
+	w = sendRequest(t, UriAuth+UriAuthValidate, "GET", nil, changed.AccessToken, &claims)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	updated, err := user.GetOneByEmail("test_user_1@user.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, updated.MustChangePassword)
+}
+
+func TestChangePassword_RejectsReusedPassword(t *testing.T) {
+	clearDbTables(t)
+	createUser(t, "test_user_1@user.com", user.StatusActive, nil)
+
+	var pair TokenPairDto
+	login(t, "test_user_1@user.com", "123123123", &pair)
+
+	body, _ := json.Marshal(RequestChangePasswordDto{CurrentPassword: "123123123", Password: "Violet-Kettle-93-Harbor"})
+	var changed TokenPairDto
+	w := sendRequest(t, UriAuth+UriAuthPasswordChange, "POST", bytes.NewBuffer(body), pair.AccessToken, &changed)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	body, _ = json.Marshal(RequestChangePasswordDto{CurrentPassword: "Violet-Kettle-93-Harbor", Password: "Violet-Kettle-93-Harbor"})
+	var result user.ValidationErrorResponseDto
+	w = sendRequest(t, UriAuth+UriAuthPasswordChange, "POST", bytes.NewBuffer(body), changed.AccessToken, &result)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, user.PasswordRuleReused, result.Errors[0].Rule)
+
+	body, _ = json.Marshal(RequestChangePasswordDto{CurrentPassword: "wrong-password", Password: "Amber-Lantern-41-Quiet"})
+	var errorResult ErrorResponseDto
+	w = sendRequest(t, UriAuth+UriAuthPasswordChange, "POST", bytes.NewBuffer(body), changed.AccessToken, &errorResult)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, ErrorCurrentPasswordInvalid, errorResult.Message)
+}
+
+func TestMfa_EnrollConfirmAndLogin(t *testing.T) {
+	clearDbTables(t)
+	account := createUser(t, "test_user_1@user.com", user.StatusActive, nil)
+
+	var pair TokenPairDto
+	login(t, "test_user_1@user.com", "123123123", &pair)
+
+	var enrollment MfaTotpEnrollmentDto
+	w := sendRequest(t, fmt.Sprintf(user.UriUser+user.UriUserMfaTotpS, account.ID), "POST", nil, pair.AccessToken, &enrollment)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, enrollment.OtpauthUri, "otpauth://totp/")
+
+	// The secret is stored encrypted and bound to the user
+	stored, err := GetMfaTotp(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotContains(t, stored.SecretEncrypted, enrollment.Secret)
+	secret, err := decryptMfaSecret(account.ID, stored.SecretEncrypted)
+	assert.Nil(t, err)
+	assert.Equal(t, enrollment.Secret, secret)
+
+	code, _ := totp.GenerateCode(enrollment.Secret, time.Now())
+	body, _ := json.Marshal(RequestMfaCodeDto{Code: code})
+	var recovery MfaRecoveryCodesDto
+	w = sendRequest(t, fmt.Sprintf(user.UriUser+user.UriUserMfaTotpVerifyS, account.ID), "POST", bytes.NewBuffer(body), pair.AccessToken, &recovery)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, mfaRecoveryCodeCount, len(recovery.RecoveryCodes))
+
+	var challenge MfaChallengeDto
+	w = login(t, "test_user_1@user.com", "123123123", &challenge)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, challenge.MfaRequired)
+
+	// The code used for the confirmation can not be replayed, the failed attempt ends the challenge
+	body, _ = json.Marshal(RequestMfaVerifyDto{MfaToken: challenge.MfaToken, Code: code})
+	var errorResult ErrorResponseDto
+	w = sendRequest(t, UriAuth+UriAuthMfaVerify, "POST", bytes.NewBuffer(body), "", &errorResult)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, ErrorInvalidMfaCode, errorResult.Message)
+
+	body, _ = json.Marshal(RequestMfaVerifyDto{MfaToken: challenge.MfaToken, RecoveryCode: recovery.RecoveryCodes[0]})
+	w = sendRequest(t, UriAuth+UriAuthMfaVerify, "POST", bytes.NewBuffer(body), "", &errorResult)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	login(t, "test_user_1@user.com", "123123123", &challenge)
+	body, _ = json.Marshal(RequestMfaVerifyDto{MfaToken: challenge.MfaToken, RecoveryCode: strings.ToUpper(recovery.RecoveryCodes[0])})
+	var mfaPair TokenPairDto
+	w = sendRequest(t, UriAuth+UriAuthMfaVerify, "POST", bytes.NewBuffer(body), "", &mfaPair)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, mfaPair.RefreshToken)
+
+	// Recovery codes are single use
+	login(t, "test_user_1@user.com", "123123123", &challenge)
+	body, _ = json.Marshal(RequestMfaVerifyDto{MfaToken: challenge.MfaToken, RecoveryCode: recovery.RecoveryCodes[0]})
+	w = sendRequest(t, UriAuth+UriAuthMfaVerify, "POST", bytes.NewBuffer(body), "", &errorResult)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestMfa_DisableRequiresCode(t *testing.T) {
+	clearDbTables(t)
+	account := createUser(t, "test_user_1@user.com", user.StatusActive, nil)
+
+	var pair TokenPairDto
+	login(t, "test_user_1@user.com", "123123123", &pair)
+
+	var enrollment MfaTotpEnrollmentDto
+	sendRequest(t, fmt.Sprintf(user.UriUser+user.UriUserMfaTotpS, account.ID), "POST", nil, pair.AccessToken, &enrollment)
+	code, _ := totp.GenerateCode(enrollment.Secret, time.Now())
+	body, _ := json.Marshal(RequestMfaCodeDto{Code: code})
+	var recovery MfaRecoveryCodesDto
+	sendRequest(t, fmt.Sprintf(user.UriUser+user.UriUserMfaTotpVerifyS, account.ID), "POST", bytes.NewBuffer(body), pair.AccessToken, &recovery)
+
+	var result ErrorResponseDto
+	w := sendRequest(t, fmt.Sprintf(user.UriUser+user.UriUserMfaTotpS, account.ID), "POST", nil, pair.AccessToken, &result)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	body, _ = json.Marshal(RequestMfaDisableDto{Code: "000000"})
+	w = sendRequest(t, fmt.Sprintf(user.UriUser+user.UriUserMfaTotpS, account.ID), "DELETE", bytes.NewBuffer(body), pair.AccessToken, &result)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	body, _ = json.Marshal(RequestMfaDisableDto{RecoveryCode: recovery.RecoveryCodes[1]})
+	w = sendRequest(t, fmt.Sprintf(user.UriUser+user.UriUserMfaTotpS, account.ID), "DELETE", bytes.NewBuffer(body), pair.AccessToken, &result)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = login(t, "test_user_1@user.com", "123123123", &pair)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, pair.RefreshToken)
+
+	events, err := audit.GetUserEvents(account.ID, audit.EventMfaDisabled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(events))
+}
+
+func TestWebauthn_RegisterAndPasswordlessLogin(t *testing.T) {
+	clearDbTables(t)
+	account := createUser(t, "test_user_1@user.com", user.StatusActive, nil)
+
+	var pair TokenPairDto
+	login(t, "test_user_1@user.com", "123123123", &pair)
+
+	authenticator := newSoftAuthenticator(t)
+	w := registerPasskey(t, authenticator, account.ID.String(), pair.AccessToken)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var credentials []WebauthnCredentialDto
+	w = sendRequest(t, fmt.Sprintf(user.UriUser+user.UriUserWebauthnCredentialsS, account.ID), "GET", nil, pair.AccessToken, &credentials)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 1, len(credentials))
+	assert.Equal(t, "Laptop", credentials[0].Name)
+	assert.Equal(t, []string{"internal"}, credentials[0].Transports)
+
+	// The same authenticator is excluded from the second registration
+	var errorResult ErrorResponseDto
+	w = registerPasskey(t, authenticator, account.ID.String(), pair.AccessToken)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	var ceremony softCeremonyDto[protocol.CredentialAssertion]
+	w = sendRequest(t, UriAuth+UriAuthWebauthnLoginBegin, "POST", nil, "", &ceremony)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	body, _ := json.Marshal(RequestWebauthnLoginDto{
+		SessionToken: ceremony.SessionToken,
+		Credential:   authenticator.get(t, ceremony.Options),
+	})
+	var passkeyPair TokenPairDto
+	w = sendRequest(t, UriAuth+UriAuthWebauthnLoginFinish, "POST", bytes.NewBuffer(body), "", &passkeyPair)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, passkeyPair.RefreshToken)
+
+	// The session is single use
+	w = sendRequest(t, UriAuth+UriAuthWebauthnLoginFinish, "POST", bytes.NewBuffer(body), "", &errorResult)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, ErrorWebauthnSessionInvalid, errorResult.Message)
+
+	// A signature counter which did not grow means a cloned key
+	sendRequest(t, UriAuth+UriAuthWebauthnLoginBegin, "POST", nil, "", &ceremony)
+	authenticator.signCount--
+	body, _ = json.Marshal(RequestWebauthnLoginDto{
+		SessionToken: ceremony.SessionToken,
+		Credential:   authenticator.get(t, ceremony.Options),
+	})
+	w = sendRequest(t, UriAuth+UriAuthWebauthnLoginFinish, "POST", bytes.NewBuffer(body), "", &errorResult)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, ErrorWebauthnResponseInvalid, errorResult.Message)
+}
+
+func TestWebauthn_SecondFactor(t *testing.T) {
+	clearDbTables(t)
+	account := createUser(t, "test_user_1@user.com", user.StatusActive, nil)
+
+	var pair TokenPairDto
+	login(t, "test_user_1@user.com", "123123123", &pair)
+
+	authenticator := newSoftAuthenticator(t)
+	registerPasskey(t, authenticator, account.ID.String(), pair.AccessToken)
+
+	var challenge struct {
+		MfaChallengeDto
+		Webauthn protocol.CredentialAssertion `json:"webauthn"`
+	}
+	w := login(t, "test_user_1@user.com", "123123123", &challenge)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, challenge.MfaRequired)
+	assert.Equal(t, 1, len(challenge.Webauthn.Response.AllowedCredentials))
+
+	body, _ := json.Marshal(RequestMfaVerifyDto{
+		MfaToken: challenge.MfaToken,
+		Webauthn: authenticator.get(t, challenge.Webauthn),
+	})
+	var mfaPair TokenPairDto
+	w = sendRequest(t, UriAuth+UriAuthMfaVerify, "POST", bytes.NewBuffer(body), "", &mfaPair)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, mfaPair.RefreshToken)
+
+	var credentials []WebauthnCredentialDto
+	sendRequest(t, fmt.Sprintf(user.UriUser+user.UriUserWebauthnCredentialsS, account.ID), "GET", nil, pair.AccessToken, &credentials)
+	assert.NotNil(t, credentials[0].LastUsedAt)
+
+	var result SuccessResponseDto
+	w = sendRequest(t, fmt.Sprintf(user.UriUser+user.UriUserWebauthnCredentialS, account.ID, credentials[0].ID), "DELETE", nil, pair.AccessToken, &result)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = login(t, "test_user_1@user.com", "123123123", &pair)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, pair.RefreshToken)
+
+	events, err := audit.GetUserEvents(account.ID, audit.EventWebauthnRemoved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(events))
+}
+
+func TestMagicLink_DeviceBoundSingleUse(t *testing.T) {
+	clearDbTables(t)
+	if err := db.Create(&user.User{Email: "test_user_1@user.com", Status: user.StatusActive}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := json.Marshal(RequestEmailDto{Email: "test_user_1@user.com"})
+	var result SuccessResponseDto
+	w := sendRequest(t, UriAuth+UriAuthMagicLink, "POST", bytes.NewBuffer(body), "", &result)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, MagicLinkSent, result.Message)
+	device := magicLinkDevice(t, w)
+	runJobs(t)
+
+	var queued job.Job
+	db.Where("type = ?", JobTypeMagicLinkRequested).First(&queued)
+	assert.Nil(t, queued.Input)
+
+	message, isSent := sentMail.Last("test_user_1@user.com")
+	assert.True(t, isSent)
+	assert.Equal(t, "Your sign-in link", message.Subject)
+	token := regexp.MustCompile(`token=([\w-]+)`).FindStringSubmatch(message.Text)
+	assert.Equal(t, 2, len(token))
+
+	// Unknown emails get the cookie as well
+	body, _ = json.Marshal(RequestEmailDto{Email: "unknown@user.com"})
+	w = sendRequest(t, UriAuth+UriAuthMagicLink, "POST", bytes.NewBuffer(body), "", &result)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	otherDevice := magicLinkDevice(t, w)
+
+	// The link opened on another device does not work and is not used up
+	var errorResult ErrorResponseDto
+	w = consumeMagicLink(t, token[1], nil, &errorResult)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, ErrorInvalidMagicLink, errorResult.Message)
+	w = consumeMagicLink(t, token[1], otherDevice, &errorResult)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	var pair TokenPairDto
+	w = consumeMagicLink(t, token[1], device, &pair)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, pair.RefreshToken)
+
+	w = consumeMagicLink(t, token[1], device, &errorResult)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// The account has no password, so the password login is not possible
+	w = login(t, "test_user_1@user.com", "Violet-Kettle-93-Harbor", &errorResult)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestEmailChange_ConfirmSwapsEmail(t *testing.T) {
+	clearDbTables(t)
+	account := createUser(t, "test_user_1@user.com", user.StatusActive, nil)
+
+	var pair TokenPairDto
+	login(t, "test_user_1@user.com", "123123123", &pair)
+
+	body, _ := json.Marshal(RequestEmailChangeDto{Email: "test_user_2@user.com"})
+	var result SuccessResponseDto
+	w := sendRequest(t, fmt.Sprintf(user.UriUser+user.UriUserEmailChangeS, account.ID), "POST", bytes.NewBuffer(body), pair.AccessToken, &result)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	_, isNoticeSent := sentMail.Last("test_user_1@user.com")
+	assert.True(t, isNoticeSent)
+
+	message, isSent := sentMail.Last("test_user_2@user.com")
+	assert.True(t, isSent)
+	token := regexp.MustCompile(`token=([\w-]+)`).FindStringSubmatch(message.Text)
+
+	resultDto, _ := user.GetOneById(user.RequestUserIdDTO{ID: account.ID.String()})
+	assert.Equal(t, "test_user_1@user.com", resultDto.Email)
+	assert.Equal(t, "test_user_2@user.com", resultDto.PendingEmail)
+
+	body, _ = json.Marshal(RequestTokenDto{Token: token[1]})
+	w = sendRequest(t, UriAuth+UriAuthEmailChangeConfirm, "POST", bytes.NewBuffer(body), "", &result)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	resultDto, _ = user.GetOneById(user.RequestUserIdDTO{ID: account.ID.String()})
+	assert.Equal(t, "test_user_2@user.com", resultDto.Email)
+	assert.Equal(t, "", resultDto.PendingEmail)
+}
+
+func TestEmailChange_TakenBeforeConfirm(t *testing.T) {
+	clearDbTables(t)
+	account := createUser(t, "test_user_1@user.com", user.StatusActive, nil)
+
+	var pair TokenPairDto
+	login(t, "test_user_1@user.com", "123123123", &pair)
+
+	body, _ := json.Marshal(RequestEmailChangeDto{Email: "test_user_2@user.com"})
+	var result ErrorResponseDto
+	sendRequest(t, fmt.Sprintf(user.UriUser+user.UriUserEmailChangeS, account.ID), "POST", bytes.NewBuffer(body), pair.AccessToken, &result)
+
+	message, _ := sentMail.Last("test_user_2@user.com")
+	token := regexp.MustCompile(`token=([\w-]+)`).FindStringSubmatch(message.Text)
+
+	createUser(t, "test_user_2@user.com", user.StatusActive, nil)
+
+	body, _ = json.Marshal(RequestTokenDto{Token: token[1]})
+	w := sendRequest(t, UriAuth+UriAuthEmailChangeConfirm, "POST", bytes.NewBuffer(body), "", &result)
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestEmailChange_OverrideRequiresAdmin(t *testing.T) {
+	clearDbTables(t)
+	account := createUser(t, "test_user_1@user.com", user.StatusActive, nil)
+	admin := createUser(t, "admin@user.com", user.StatusActive, nil)
+	db.Create(&user.UserRole{UserID: admin.ID, Role: user.RoleAdmin, CreatedAt: time.Now()})
+
+	var pair TokenPairDto
+	login(t, "test_user_1@user.com", "123123123", &pair)
+
+	uri := fmt.Sprintf(user.UriUser+user.UriUserEmailChangeS, account.ID)
+	body, _ := json.Marshal(RequestEmailChangeDto{Email: "test_user_2@user.com", Override: true})
+	var result SuccessResponseDto
+	w := sendRequest(t, uri, "POST", bytes.NewBuffer(body), pair.AccessToken, &result)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	login(t, "admin@user.com", "123123123", &pair)
+	w = sendRequest(t, uri, "POST", bytes.NewBuffer(body), pair.AccessToken, &result)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	resultDto, _ := user.GetOneById(user.RequestUserIdDTO{ID: account.ID.String()})
+	assert.Equal(t, "test_user_2@user.com", resultDto.Email)
+
+	events, err := audit.GetUserEvents(account.ID, audit.EventEmailChangeOverride)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, admin.ID, *events[0].ActorID)
+}
+
+func TestLoginHistory_RecordsAttempts(t *testing.T) {
+	clearDbTables(t)
+	account := createUser(t, "test_user_1@user.com", user.StatusActive, nil)
+
+	var result ErrorResponseDto
+	w := loginFrom(t, "test_user_1@user.com", "wrong-password", "Firefox", "198.51.100.1", &result)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	var pair TokenPairDto
+	w = loginFrom(t, "test_user_1@user.com", "123123123", "Firefox", "198.51.100.1", &pair)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var events []LoginEventDto
+	w = sendRequest(t, fmt.Sprintf(user.UriUser+user.UriUserLoginsS, account.ID), "GET", nil, pair.AccessToken, &events)
+	assert.Equal(t, http.StatusOK, w.Code)
+	if assert.Equal(t, 2, len(events)) {
+		assert.True(t, events[0].Success)
+		assert.Equal(t, LoginMethodPassword, events[0].Method)
+		assert.Equal(t, "DE", events[0].Country)
+		assert.Equal(t, "Berlin", events[0].City)
+		assert.Equal(t, "Firefox", events[0].UserAgent)
+		assert.False(t, events[1].Success)
+		assert.Equal(t, LoginFailureInvalidCredentials, events[1].FailureReason)
+	}
+
+	w = sendRequest(t, fmt.Sprintf(user.UriUser+user.UriUserLoginsS+"?limit=1&before=%s", account.ID, events[0].CreatedAt.UTC().Format(time.RFC3339Nano)), "GET", nil, pair.AccessToken, &events)
+	assert.Equal(t, http.StatusOK, w.Code)
+	if assert.Equal(t, 1, len(events)) {
+		assert.False(t, events[0].Success)
+	}
+
+	other := createUser(t, "test_user_2@user.com", user.StatusActive, nil)
+	w = sendRequest(t, fmt.Sprintf(user.UriUser+user.UriUserLoginsS, other.ID), "GET", nil, pair.AccessToken, &result)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestLoginHistory_NewDeviceAlert(t *testing.T) {
+	clearDbTables(t)
+	sentMail.Reset()
+	createUser(t, "test_user_1@user.com", user.StatusActive, nil)
+
+	// The first sign-in has nothing to compare with
+	var pair TokenPairDto
+	loginFrom(t, "test_user_1@user.com", "123123123", "Firefox", "198.51.100.1", &pair)
+	loginFrom(t, "test_user_1@user.com", "123123123", "Firefox", "198.51.100.1", &pair)
+	runJobs(t)
+	_, isSent := sentMail.Last("test_user_1@user.com")
+	assert.False(t, isSent)
+
+	loginFrom(t, "test_user_1@user.com", "123123123", "Chrome", "198.51.100.1", &pair)
+	runJobs(t)
+	message, isSent := sentMail.Last("test_user_1@user.com")
+	if assert.True(t, isSent) {
+		assert.Equal(t, "New sign-in to your account", message.Subject)
+		assert.Contains(t, message.Text, "Chrome")
+		assert.Contains(t, message.Text, "Berlin, DE")
+	}
+
+	// A known device from another country is reported as well
+	sentMail.Reset()
+	loginFrom(t, "test_user_1@user.com", "123123123", "Firefox", "203.0.113.1", &pair)
+	runJobs(t)
+	message, isSent = sentMail.Last("test_user_1@user.com")
+	if assert.True(t, isSent) {
+		assert.Contains(t, message.Text, "Paris, FR")
+	}
+
+	sentMail.Reset()
+	loginFrom(t, "test_user_1@user.com", "123123123", "Chrome", "203.0.113.1", &pair)
+	runJobs(t)
+	_, isSent = sentMail.Last("test_user_1@user.com")
+	assert.False(t, isSent)
+}
+
+func TestLoginDeviceHash(t *testing.T) {
+	chrome := "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36"
+	chromeUpdated := "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/127.0.0.0 Safari/537.36"
+	edge := chrome + " Edg/126.0.0.0"
+	safari := "Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Mobile/15E148 Safari/604.1"
+
+	browser, platform := userAgentFamily(safari)
+	assert.Equal(t, "safari", browser)
+	assert.Equal(t, "ios", platform)
+
+	assert.Equal(t, loginDeviceHash(chrome), loginDeviceHash(chromeUpdated))
+	assert.NotEqual(t, loginDeviceHash(chrome), loginDeviceHash(edge))
+	assert.NotEqual(t, loginDeviceHash(chrome), loginDeviceHash(safari))
+}
+
+// === Sys
+func TestExternalLogin_SignUpAndAutoLink(t *testing.T) {
+	clearDbTables(t)
+	provider := startIdentityProvider(t)
+
+	// The state is bound to the browser which started the sign-in
+	provider.SetIdentity(idp.Identity{Subject: "248289761001", Email: "test_user_1@user.com", EmailVerified: true})
+	code, state, _ := beginExternal(t, provider, fmt.Sprintf(UriAuth+UriAuthExternalBeginS, "mock"), "")
+	var errorResult ErrorResponseDto
+	w := finishExternal(t, fmt.Sprintf(UriAuth+UriAuthExternalFinishS, "mock"), code, state, nil, "", &errorResult)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, ErrorExternalLoginStateInvalid, errorResult.Message)
+
+	// An unknown verified email signs up a new user
+	var pair TokenPairDto
+	w = externalLogin(t, provider, &pair)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, pair.RefreshToken)
+
+	account, _ := user.GetOneByEmail("test_user_1@user.com")
+	assert.NotNil(t, account.EmailVerifiedAt)
+	assert.Equal(t, "", account.Password)
+
+	// The linked subject signs the same user in, the email of the provider may change
+	provider.SetIdentity(idp.Identity{Subject: "248289761001", Email: "renamed@example.com", EmailVerified: true})
+	w = externalLogin(t, provider, &pair)
+	assert.Equal(t, http.StatusOK, w.Code)
+	identities, _ := GetUserIdentities(account.ID)
+	assert.Equal(t, 1, len(identities))
+	assert.Equal(t, "renamed@example.com", identities[0].Email)
+
+	// The verified email of an existing user is linked
+	existing := createUser(t, "test_user_2@user.com", user.StatusActive, nil)
+	provider.SetIdentity(idp.Identity{Subject: "248289761002", Email: "test_user_2@user.com", EmailVerified: true})
+	w = externalLogin(t, provider, &pair)
+	assert.Equal(t, http.StatusOK, w.Code)
+	identities, _ = GetUserIdentities(existing.ID)
+	assert.Equal(t, 1, len(identities))
+	events, _ := audit.GetUserEvents(existing.ID, audit.EventIdentityLinked)
+	assert.Equal(t, 1, len(events))
+
+	// The email not verified by the provider or by the user is not linked
+	db.Create(&user.User{Email: "test_user_3@user.com", Status: user.StatusActive})
+	provider.SetIdentity(idp.Identity{Subject: "248289761003", Email: "test_user_3@user.com", EmailVerified: true})
+	w = externalLogin(t, provider, &errorResult)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, ErrorExternalAccountExists, errorResult.Message)
+
+	provider.SetIdentity(idp.Identity{Subject: "248289761004", Email: "test_user_4@user.com"})
+	w = externalLogin(t, provider, &errorResult)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, ErrorExternalSignUpDenied, errorResult.Message)
+
+	w = sendRequest(t, fmt.Sprintf(UriAuth+UriAuthExternalBeginS, "unknown"), "POST", nil, "", &errorResult)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestIdentities_LinkAndUnlink(t *testing.T) {
+	clearDbTables(t)
+	provider := startIdentityProvider(t)
+	account := createUser(t, "test_user_1@user.com", user.StatusActive, nil)
+	other := createUser(t, "test_user_2@user.com", user.StatusActive, nil)
+
+	var pair, otherPair TokenPairDto
+	login(t, "test_user_1@user.com", "123123123", &pair)
+	login(t, "test_user_2@user.com", "123123123", &otherPair)
+
+	// The email of the linked account does not have to match
+	provider.SetIdentity(idp.Identity{Subject: "583231", Email: "octocat@example.com"})
+	var identity UserIdentityDto
+	w := linkIdentity(t, provider, account.ID, pair.AccessToken, &identity)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "mock", identity.Provider)
+
+	// The linked account signs the user in
+	var loginPair TokenPairDto
+	w = externalLogin(t, provider, &loginPair)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var errorResult ErrorResponseDto
+	w = linkIdentity(t, provider, other.ID, otherPair.AccessToken, &errorResult)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, ErrorExternalIdentityLinked, errorResult.Message)
+
+	var identities []UserIdentityDto
+	uri := fmt.Sprintf(user.UriUser+user.UriUserIdentitiesS, account.ID)
+	w = sendRequest(t, uri, "GET", nil, pair.AccessToken, &identities)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 1, len(identities))
+
+	w = sendRequest(t, uri, "GET", nil, otherPair.AccessToken, &errorResult)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	var result SuccessResponseDto
+	w = sendRequest(t, fmt.Sprintf(user.UriUser+user.UriUserIdentityS, account.ID, identity.ID), "DELETE", nil, pair.AccessToken, &result)
+	assert.Equal(t, http.StatusOK, w.Code)
+	events, _ := audit.GetUserEvents(account.ID, audit.EventIdentityUnlinked)
+	assert.Equal(t, 1, len(events))
+
+	// The only identity of a user without a password is kept
+	provider.SetIdentity(idp.Identity{Subject: "583232", Email: "test_user_3@user.com", EmailVerified: true})
+	w = externalLogin(t, provider, &loginPair)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	created, _ := user.GetOneByEmail("test_user_3@user.com")
+	createdIdentities, _ := GetUserIdentities(created.ID)
+	w = sendRequest(t, fmt.Sprintf(user.UriUser+user.UriUserIdentityS, created.ID, createdIdentities[0].ID), "DELETE", nil, loginPair.AccessToken, &errorResult)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, ErrorExternalLastSignInMethod, errorResult.Message)
+}
+
+func TestRateLimitKey_ApiKey(t *testing.T) {
+	key := RateLimitKey()
+	bucket := func(header string, value string) string {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		c.Request.Header.Set(header, value)
+		return key(c)
+	}
+
+	// The key is not looked up, both ways to send it share the bucket which does not reveal the key
+	raw := ApiKeyPrefix + "test-key"
+	assert.Equal(t, ratelimit.SecretKey(raw), bucket("Authorization", TokenTypeBearer+" "+raw))
+	assert.Equal(t, ratelimit.SecretKey(raw), bucket(ratelimit.HeaderApiKey, raw))
+	assert.NotContains(t, bucket(ratelimit.HeaderApiKey, raw), "test-key")
+}
+
+func TestApiKeys_ScopesAndRevocation(t *testing.T) {
+	clearDbTables(t)
+	account := createUser(t, "test_user_1@user.com", user.StatusActive, nil)
+	admin := createUser(t, "admin@user.com", user.StatusActive, nil)
+	db.Create(&user.UserRole{UserID: admin.ID, Role: user.RoleAdmin, CreatedAt: time.Now()})
+
+	var pair TokenPairDto
+	login(t, "test_user_1@user.com", "123123123", &pair)
+
+	uri := fmt.Sprintf(user.UriUser+user.UriUserApiKeysS, account.ID)
+	body, _ := json.Marshal(RequestApiKeyDto{Name: "CI", Scopes: []string{ApiKeyScopeRead}})
+	var created ApiKeyCreatedDto
+	w := sendRequest(t, uri, "POST", bytes.NewBuffer(body), pair.AccessToken, &created)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.True(t, strings.HasPrefix(created.Key, ApiKeyPrefix))
+	assert.Equal(t, created.Key[:len(created.Prefix)], created.Prefix)
+
+	// The key is accepted as a bearer token and in X-Api-Key
+	var claims TokenClaimsDto
+	w = sendRequest(t, UriAuth+UriAuthValidate, "GET", nil, created.Key, &claims)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, account.ID, claims.UserID)
+	assert.Equal(t, ApiKeyScopeRead, claims.Scope)
+
+	var apiKeys []ApiKeyDto
+	w = sendApiKeyRequest(t, uri, "GET", nil, created.Key, &apiKeys)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 1, len(apiKeys))
+	assert.NotNil(t, apiKeys[0].LastUsedAt)
+
+	// The read scope allows only safe methods, keys can not issue keys
+	var errorResult ErrorResponseDto
+	w = sendApiKeyRequest(t, fmt.Sprintf(user.UriUser+user.UriUserUnlockS, account.ID), "POST", nil, created.Key, &errorResult)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, ErrorApiKeyScope, errorResult.Message)
+	w = sendApiKeyRequest(t, uri, "POST", bytes.NewBuffer(body), created.Key, &errorResult)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, ErrorApiKeyNotAllowed, errorResult.Message)
+
+	past := time.Now().Add(-time.Hour)
+	body, _ = json.Marshal(RequestApiKeyDto{Name: "expired", Scopes: []string{ApiKeyScopeRead}, ExpiresAt: &past})
+	w = sendRequest(t, uri, "POST", bytes.NewBuffer(body), pair.AccessToken, &errorResult)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	// Roles of the user are passed only with the admin scope
+	var adminPair TokenPairDto
+	login(t, "admin@user.com", "123123123", &adminPair)
+	adminUri := fmt.Sprintf(user.UriUser+user.UriUserApiKeysS, admin.ID)
+	unlockUri := fmt.Sprintf(user.UriUser+user.UriUserUnlockS, account.ID)
+
+	var writeKey, adminKey ApiKeyCreatedDto
+	body, _ = json.Marshal(RequestApiKeyDto{Name: "write", Scopes: []string{ApiKeyScopeWrite}})
+	sendRequest(t, adminUri, "POST", bytes.NewBuffer(body), adminPair.AccessToken, &writeKey)
+	body, _ = json.Marshal(RequestApiKeyDto{Name: "admin", Scopes: []string{ApiKeyScopeWrite, ApiKeyScopeAdmin}})
+	sendRequest(t, adminUri, "POST", bytes.NewBuffer(body), adminPair.AccessToken, &adminKey)
+
+	w = sendRequest(t, unlockUri, "POST", nil, writeKey.Key, &errorResult)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, ErrorAccessDenied, errorResult.Message)
+
+	var result SuccessResponseDto
+	w = sendRequest(t, unlockUri, "POST", nil, adminKey.Key, &result)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Admin revokes the key of the user, it stops working at once
+	w = sendRequest(t, fmt.Sprintf(user.UriUser+user.UriUserApiKeyS, account.ID, created.ID), "DELETE", nil, adminPair.AccessToken, &result)
+	assert.Equal(t, http.StatusOK, w.Code)
+	events, _ := audit.GetUserEvents(account.ID, audit.EventApiKeyRevoked)
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, admin.ID, *events[0].ActorID)
+
+	w = sendRequest(t, UriAuth+UriAuthValidate, "GET", nil, created.Key, &errorResult)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func clearDbTables(t *testing.T) {
+	if err := db.Exec("truncate table jobs, login_events, login_failures, mail_send_log, audit_events, action_tokens, refresh_tokens, user_roles, Users restart identity cascade").Error; err != nil {
+		utils.Dump(err)
+		t.Fatal(err)
+	}
+}
+
+// runJobs runs the queued jobs like a worker does, the mails of the email flows are sent by jobs
+// failingMailer fails the first sends and delivers the next ones to sentMail
+type failingMailer struct {
+	failures int
+}
+
+func (m *failingMailer) Send(ctx context.Context, message mailer.Message) error {
+	if m.failures > 0 {
+		m.failures--
+		return errors.New("mail server is unavailable")
+	}
+	return sentMail.Send(ctx, message)
+}
+
+func runJobs(t *testing.T) {
+	InitAuthJobs()
+	pool := job.NewPool(job.LoadConfig())
+	for {
+		processed, err := pool.RunOnce(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !processed {
+			return
+		}
+	}
+}
+
+func createUser(t *testing.T, email string, status string, suspendedUntil *time.Time) user.User {
+	hash, err := bcrypt.GenerateFromPassword([]byte("123123123"), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	account := user.User{
+		Email:           email,
+		Password:        string(hash),
+		Status:          status,
+		SuspendedUntil:  suspendedUntil,
+		EmailVerifiedAt: &now,
+	}
+	if err := db.Create(&account).Error; err != nil {
+		t.Fatal(err)
+	}
+	return account
+}
+
+func login(t *testing.T, email string, password string, result any) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(RequestLoginDto{Email: email, Password: password})
+	return sendRequest(t, UriAuth+UriAuthLogin, "POST", bytes.NewBuffer(body), "", result)
+}
+
+// loginFrom signs in with the user agent and the client address of another device
+func loginFrom(t *testing.T, email string, password string, userAgent string, ip string, result any) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(RequestLoginDto{Email: email, Password: password})
+	req, err := http.NewRequest("POST", UriAuth+UriAuthLogin, bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.RemoteAddr = ip + ":12345"
+
+	router := gin.Default()
+	InitAuthRoutes(router)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func refresh(t *testing.T, refreshToken string, result any) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(RequestRefreshTokenDto{RefreshToken: refreshToken})
+	return sendRequest(t, UriAuth+UriAuthRefresh, "POST", bytes.NewBuffer(body), "", result)
+}
+
+func sendRequest(
+	t *testing.T,
+	uri string,
+	method string,
+	body io.Reader,
+	accessToken string,
+	result any,
+) *httptest.ResponseRecorder {
+
+	//Init
+
+	router := gin.Default()
+	InitAuthRoutes(router)
+	user.InitUserAdminRoutes(router.Group("", RequireAuth(), RequireVerifiedEmail(ActionAdmin), RequireRole(user.RoleAdmin, user.RoleSupport)))
+
+	// Creating test request
+	req, err := http.NewRequest(method, uri, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if accessToken != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("%s %s", TokenTypeBearer, accessToken))
+	}
+
+	//Sending test request
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	//Parsing result
+
+	err = json.NewDecoder(w.Body).Decode(&result)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return w
+}
+
+// sendApiKeyRequest sends the API key in X-Api-Key instead of the Authorization header
+func sendApiKeyRequest(t *testing.T, uri string, method string, body io.Reader, apiKey string, result any) *httptest.ResponseRecorder {
+	router := gin.Default()
+	InitAuthRoutes(router)
+
+	req, err := http.NewRequest(method, uri, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(ratelimit.HeaderApiKey, apiKey)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func magicLinkDevice(t *testing.T, w *httptest.ResponseRecorder) *http.Cookie {
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == MagicLinkDeviceCookie {
+			assert.True(t, cookie.HttpOnly)
+			return cookie
+		}
+	}
+	t.Fatal("magic link device cookie is not set")
+	return nil
+}
+
+func consumeMagicLink(t *testing.T, token string, device *http.Cookie, result any) *httptest.ResponseRecorder {
+	router := gin.Default()
+	InitAuthRoutes(router)
+
+	req, err := http.NewRequest("GET", UriAuth+UriAuthMagicLinkConsume+"?token="+token, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if device != nil {
+		req.AddCookie(device)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+// startIdentityProvider configures the mock OpenID provider as the provider named mock
+func startIdentityProvider(t *testing.T) *idp.MockProvider {
+	provider, err := idp.NewMockProvider("user-service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(provider.Close)
+
+	t.Setenv("IDP_PROVIDERS", "mock")
+	t.Setenv("IDP_MOCK_ISSUER", provider.URL())
+	t.Setenv("IDP_MOCK_CLIENT_ID", provider.ClientID)
+	t.Setenv("IDP_MOCK_CLIENT_SECRET", "secret")
+	return provider
+}
+
+// beginExternal starts the sign-in and passes it at the provider, it returns the code and state of the redirect and
+// the device cookie
+func beginExternal(t *testing.T, provider *idp.MockProvider, uri string, accessToken string) (string, string, *http.Cookie) {
+	var started ExternalLoginDto
+	w := sendRequest(t, uri, "POST", nil, accessToken, &started)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var device *http.Cookie
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == ExternalLoginDeviceCookie {
+			device = cookie
+		}
+	}
+	if device == nil {
+		t.Fatal("external login device cookie is not set")
+	}
+
+	code, state, err := provider.SignIn(started.AuthorizationUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code, state, device
+}
+
+func finishExternal(t *testing.T, uri string, code string, state string, device *http.Cookie, accessToken string, result any) *httptest.ResponseRecorder {
+	router := gin.Default()
+	InitAuthRoutes(router)
+
+	body, _ := json.Marshal(RequestExternalFinishDto{Code: code, State: state})
+	req, err := http.NewRequest("POST", uri, bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if device != nil {
+		req.AddCookie(device)
+	}
+	if accessToken != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("%s %s", TokenTypeBearer, accessToken))
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+// externalLogin signs in by the identity set at the mock provider
+func externalLogin(t *testing.T, provider *idp.MockProvider, result any) *httptest.ResponseRecorder {
+	code, state, device := beginExternal(t, provider, fmt.Sprintf(UriAuth+UriAuthExternalBeginS, "mock"), "")
+	return finishExternal(t, fmt.Sprintf(UriAuth+UriAuthExternalFinishS, "mock"), code, state, device, "", result)
+}
+
+// linkIdentity links the identity set at the mock provider to the user
+func linkIdentity(t *testing.T, provider *idp.MockProvider, userId uuid.UUID, accessToken string, result any) *httptest.ResponseRecorder {
+	code, state, device := beginExternal(t, provider, fmt.Sprintf(user.UriUser+user.UriUserIdentityLinkBeginS, userId, "mock"), accessToken)
+	return finishExternal(t, fmt.Sprintf(user.UriUser+user.UriUserIdentityLinkFinishS, userId, "mock"), code, state, device, accessToken, result)
+}
+
+// softCeremonyDto is WebauthnCeremonyDto with typed options
+type softCeremonyDto[T any] struct {
+	SessionToken string `json:"session_token"`
+	Options      T      `json:"options"`
+}
+
+func registerPasskey(t *testing.T, authenticator *softAuthenticator, userId string, accessToken string) *httptest.ResponseRecorder {
+	var ceremony softCeremonyDto[protocol.CredentialCreation]
+	w := sendRequest(t, fmt.Sprintf(user.UriUser+user.UriUserWebauthnRegisterBeginS, userId), "POST", nil, accessToken, &ceremony)
+	if w.Code != http.StatusOK {
+		t.Fatal(w.Code)
+	}
+
+	body, _ := json.Marshal(RequestWebauthnRegisterDto{
+		SessionToken: ceremony.SessionToken,
+		Name:         "Laptop",
+		Credential:   authenticator.create(t, ceremony.Options),
+	})
+	var result WebauthnCredentialDto
+	return sendRequest(t, fmt.Sprintf(user.UriUser+user.UriUserWebauthnRegisterFinishS, userId), "POST", bytes.NewBuffer(body), accessToken, &result)
+}
+
+// softAuthenticator is a platform authenticator with a P-256 key and "none" attestation, it answers the ceremonies
+// the way a browser and an authenticator do together
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialId []byte
+	userHandle   []byte
+	signCount    uint32
+	config       webauthnConfig
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	credentialId := make([]byte, 32)
+	_, _ = rand.Read(credentialId)
+
+	return &softAuthenticator{
+		key:          key,
+		credentialId: credentialId,
+		config:       loadWebauthnConfig(),
+	}
+}
+
+func (authenticator *softAuthenticator) create(t *testing.T, options protocol.CredentialCreation) json.RawMessage {
+	// Browsers refuse to create an excluded credential, it is sent anyway, so the server check is tested
+	userId, _ := options.Response.User.ID.(string)
+	authenticator.userHandle, _ = base64.RawURLEncoding.DecodeString(userId)
+
+	publicKey, err := authenticator.key.PublicKey.ECDH()
+	if err != nil {
+		t.Fatal(err)
+	}
+	point := publicKey.Bytes()
+	coseKey, _ := webauthncbor.Marshal(map[int]any{1: 2, 3: -7, -1: 1, -2: point[1:33], -3: point[33:]})
+
+	attested := make([]byte, 16)
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(authenticator.credentialId)))
+	attested = append(attested, authenticator.credentialId...)
+	attested = append(attested, coseKey...)
+
+	attestationObject, _ := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": authenticator.authData(0x45, attested),
+	})
+
+	return authenticator.credential(map[string]any{
+		"clientDataJSON":    authenticator.clientData("webauthn.create", options.Response.Challenge),
+		"attestationObject": encodeBase64Url(attestationObject),
+		"transports":        []string{"internal"},
+	})
+}
+
+func (authenticator *softAuthenticator) get(t *testing.T, options protocol.CredentialAssertion) json.RawMessage {
+	authenticator.signCount++
+	authData := authenticator.authData(0x05, nil)
+	clientData := authenticator.clientData("webauthn.get", options.Response.Challenge)
+
+	clientDataJson, _ := base64.RawURLEncoding.DecodeString(clientData)
+	clientDataHash := sha256.Sum256(clientDataJson)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, authenticator.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return authenticator.credential(map[string]any{
+		"clientDataJSON":    clientData,
+		"authenticatorData": encodeBase64Url(authData),
+		"signature":         encodeBase64Url(signature),
+		"userHandle":        encodeBase64Url(authenticator.userHandle),
+	})
+}
+
+func (authenticator *softAuthenticator) authData(flags byte, attested []byte) []byte {
+	rpIdHash := sha256.Sum256([]byte(authenticator.config.RPID))
+	data := append(rpIdHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, authenticator.signCount)
+	return append(data, attested...)
+}
+
+func (authenticator *softAuthenticator) clientData(ceremony string, challenge protocol.URLEncodedBase64) string {
+	data, _ := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": encodeBase64Url(challenge),
+		"origin":    authenticator.config.Origins[0],
+	})
+	return encodeBase64Url(data)
+}
+
+func (authenticator *softAuthenticator) credential(response map[string]any) json.RawMessage {
+	data, _ := json.Marshal(map[string]any{
+		"id":       encodeBase64Url(authenticator.credentialId),
+		"rawId":    encodeBase64Url(authenticator.credentialId),
+		"type":     "public-key",
+		"response": response,
+	})
+	return data
+}
+
+func encodeBase64Url(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
@@ -0,0 +1,242 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/apiboxgo/library-utils/dictionary"
+	"github.com/apiboxgo/library-utils/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"slices"
+	"strings"
+	"time"
+	"user-service/api/job"
+	"user-service/api/user"
+	"user-service/geoip"
+	"user-service/mailer"
+)
+
+const (
+	LoginMethodPassword  = "password"
+	LoginMethodMfa       = "mfa"
+	LoginMethodMagicLink = "magic_link"
+	LoginMethodPasskey   = "passkey"
+	LoginMethodExternal  = "external"
+)
+
+const (
+	LoginFailureInvalidCredentials = "invalid_credentials"
+	LoginFailureInvalidMfaCode     = "invalid_mfa_code"
+	LoginFailureInvalidToken       = "invalid_token"
+	LoginFailureTooManyAttempts    = "too_many_attempts"
+	LoginFailureAccountStatus      = "account_status"
+	LoginFailureEmailNotVerified   = "email_not_verified"
+)
+
+const MailTemplateNewDeviceLogin = "new_device_login"
+
+// JobTypeNewDeviceLogin sends the new device alert, the sign-in does not wait for the mail
+const JobTypeNewDeviceLogin = "auth.new_device_login"
+
+const (
+	loginHistoryDefaultLimit = 50
+	loginHistoryMaxLimit     = 200
+)
+
+type newDeviceLoginMailData struct {
+	Email     string
+	Time      string
+	Ip        string
+	Location  string
+	UserAgent string
+}
+
+type newDeviceLoginPayload struct {
+	Locale string                 `json:"locale"`
+	Mail   newDeviceLoginMailData `json:"mail"`
+}
+
+// recordLoginFailed writes the failed attempt to the history, userId is uuid.Nil when the account is not known.
+// Failures of the bookkeeping are only logged.
+func recordLoginFailed(c *gin.Context, userId uuid.UUID, method string, reason string) {
+	event := newLoginEvent(c, userId, method, time.Now())
+	event.FailureReason = reason
+	if err := CreateLoginEvent(event); err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+	}
+}
+
+// recordLoginSucceeded writes the sign-in to the history and alerts the owner when the device or the country was not
+// seen before. The first sign-in of the account is not reported.
+func recordLoginSucceeded(c *gin.Context, account *user.UserItemFullResultDto, method string) {
+	now := time.Now()
+	event := newLoginEvent(c, account.ID, method, now)
+	event.Success = true
+	if err := CreateLoginEvent(event); err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		return
+	}
+
+	isNew, err := isNewLoginDevice(event)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		return
+	}
+
+	if isNew {
+		notifyNewDeviceLogin(c, account.Email, event)
+	}
+}
+
+func isNewLoginDevice(event *LoginEvent) (bool, error) {
+	hasLogin, err := HasSuccessfulLogin(*event.UserID, event.CreatedAt)
+	if err != nil || !hasLogin {
+		return false, err
+	}
+
+	isKnown, err := HasSuccessfulLogin(*event.UserID, event.CreatedAt, "device_hash = ?", event.DeviceHash)
+	if err == nil && !isKnown {
+		isKnown, err = hasLoginFromDevice(*event.UserID, event.CreatedAt, event.DeviceHash)
+	}
+	if err != nil || !isKnown {
+		return !isKnown, err
+	}
+
+	// The country is unknown without the GeoIP database or for private addresses, it is not a reason to alert
+	if event.Country == "" {
+		return false, nil
+	}
+
+	isKnown, err = HasSuccessfulLogin(*event.UserID, event.CreatedAt, "country = ?", event.Country)
+	return !isKnown, err
+}
+
+// hasLoginFromDevice compares the fingerprint with user agents of sign-ins whose hash was computed differently,
+// for example from the whole user agent before the fingerprint was derived from the browser and the platform
+func hasLoginFromDevice(userId uuid.UUID, before time.Time, deviceHash string) (bool, error) {
+	userAgents, err := GetLoginUserAgents(userId, before)
+	if err != nil {
+		return false, err
+	}
+	return slices.ContainsFunc(userAgents, func(userAgent string) bool {
+		return loginDeviceHash(userAgent) == deviceHash
+	}), nil
+}
+
+func notifyNewDeviceLogin(c *gin.Context, email string, event *LoginEvent) {
+	_, err := job.Enqueue(JobTypeNewDeviceLogin, "", newDeviceLoginPayload{
+		Locale: requestLocale(c),
+		Mail: newDeviceLoginMailData{
+			Email:     email,
+			Time:      event.CreatedAt.UTC().Format(time.RFC1123),
+			Ip:        event.Ip,
+			Location:  loginLocation(event.Country, event.City),
+			UserAgent: event.UserAgent,
+		},
+	}, nil)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+	}
+}
+
+func runNewDeviceLoginJob(ctx context.Context, alertJob *job.Job, progress job.Progress) (any, error) {
+	var payload newDeviceLoginPayload
+	if err := json.Unmarshal([]byte(alertJob.Payload), &payload); err != nil {
+		return nil, job.Permanent(err)
+	}
+	return nil, mailer.SendTemplate(ctx, payload.Mail.Email, payload.Locale, MailTemplateNewDeviceLogin, payload.Mail)
+}
+
+func newLoginEvent(c *gin.Context, userId uuid.UUID, method string, now time.Time) *LoginEvent {
+	userAgent := sessionUserAgent(c)
+	location := geoip.Lookup(c.ClientIP())
+
+	event := &LoginEvent{
+		Method:     method,
+		Ip:         c.ClientIP(),
+		UserAgent:  userAgent,
+		DeviceHash: loginDeviceHash(userAgent),
+		Country:    location.Country,
+		City:       location.City,
+		CreatedAt:  now,
+	}
+	if userId != uuid.Nil {
+		event.UserID = &userId
+	}
+	return event
+}
+
+// loginDeviceHash is the fingerprint of the device: the browser family and the platform parsed from the user agent,
+// so browser updates and version numbers do not make the device new
+func loginDeviceHash(userAgent string) string {
+	browser, platform := userAgentFamily(userAgent)
+	sum := sha256.Sum256([]byte(browser + "|" + platform))
+	return hex.EncodeToString(sum[:])
+}
+
+// userAgentFamily returns the browser family and the platform of the user agent, "other" when they are not known.
+// Tokens are checked in order, as for example Chrome user agents mention Safari and Edge ones mention Chrome.
+func userAgentFamily(userAgent string) (string, string) {
+	browser := firstFamily(userAgent, [][2]string{
+		{"Edg", "edge"},
+		{"OPR/", "opera"},
+		{"Opera", "opera"},
+		{"SamsungBrowser", "samsung"},
+		{"YaBrowser", "yandex"},
+		{"CriOS", "chrome"},
+		{"Chrome", "chrome"},
+		{"FxiOS", "firefox"},
+		{"Firefox", "firefox"},
+		{"Safari", "safari"},
+	})
+	platform := firstFamily(userAgent, [][2]string{
+		{"Windows", "windows"},
+		{"Android", "android"},
+		{"iPhone", "ios"},
+		{"iPad", "ios"},
+		{"iPod", "ios"},
+		{"CrOS", "chromeos"},
+		{"Mac OS X", "macos"},
+		{"Macintosh", "macos"},
+		{"Linux", "linux"},
+	})
+	return browser, platform
+}
+
+func firstFamily(userAgent string, families [][2]string) string {
+	for _, family := range families {
+		if strings.Contains(userAgent, family[0]) {
+			return family[1]
+		}
+	}
+	return "other"
+}
+
+func loginLocation(country string, city string) string {
+	var parts []string
+	for _, part := range []string{city, country} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) == 0 {
+		return "unknown"
+	}
+	return strings.Join(parts, ", ")
+}
+
+func loginEventDto(event LoginEvent) LoginEventDto {
+	return LoginEventDto{
+		ID:            event.ID,
+		Method:        event.Method,
+		Success:       event.Success,
+		FailureReason: event.FailureReason,
+		Ip:            event.Ip,
+		UserAgent:     event.UserAgent,
+		Country:       event.Country,
+		City:          event.City,
+		CreatedAt:     event.CreatedAt,
+	}
+}
@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"net/http"
+	"time"
+	"user-service/api/job"
+	"user-service/api/user"
+	"user-service/env"
+	"user-service/mailer"
+)
+
+const TokenPurposeMagicLink = "magic_link"
+const MailTemplateMagicLink = "magic_link"
+
+// MagicLinkDeviceCookie binds the link to the browser which requested it, a link forwarded to another device does not
+// work there
+const MagicLinkDeviceCookie = "magic_link_device"
+
+func loadMagicLinkConfig() actionTokenConfig {
+	return loadActionTokenConfig("AUTH_MAGIC_LINK", 15*time.Minute)
+}
+
+// JobTypeMagicLinkRequested sends the link requested by email, the answer does not wait for the lookup and the mail.
+// The device secret is the job input, which is cleared when the job is finished.
+const JobTypeMagicLinkRequested = "auth.magic_link_requested"
+
+// newMagicLinkDevice returns a new device secret. It is issued for every request, also for unknown emails, so the
+// cookie does not reveal registered addresses.
+func newMagicLinkDevice(c *gin.Context, config actionTokenConfig) (string, error) {
+	device, _, err := NewOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	setMagicLinkDeviceCookie(c, device, int(config.TokenTTL.Seconds()))
+	return device, nil
+}
+
+func setMagicLinkDeviceCookie(c *gin.Context, value string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(MagicLinkDeviceCookie, value, maxAge, UriAuth+UriAuthMagicLink, "", env.Bool("AUTH_MAGIC_LINK_COOKIE_SECURE", true), true)
+}
+
+// magicLinkHash is the stored hash of the link token, it is mixed with the device secret, so the token alone does not
+// match anything
+func magicLinkHash(token string, device string) string {
+	return HashToken(token + "." + device)
+}
+
+// sendMagicLink issues the sign-in token bound to the device and emails the link, nothing is sent when the user
+// requested links too often
+func sendMagicLink(ctx context.Context, userId uuid.UUID, email string, device string, locale string, isRetry bool) error {
+	config := loadMagicLinkConfig()
+
+	now := time.Now()
+	isLimited, err := isActionTokenLimited(userId, TokenPurposeMagicLink, config, isRetry, now)
+	if err != nil || isLimited {
+		return err
+	}
+
+	raw, _, err := NewOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	err = CreateActionToken(&ActionToken{
+		UserID:    userId,
+		Purpose:   TokenPurposeMagicLink,
+		TokenHash: magicLinkHash(raw, device),
+		ExpiresAt: now.Add(config.TokenTTL),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return err
+	}
+
+	return mailer.SendTemplate(ctx, email, locale, MailTemplateMagicLink, actionTokenMailData{
+		Email:     email,
+		Link:      fmt.Sprintf(config.Url, raw),
+		ExpiresIn: formatTTL(config.TokenTTL),
+	})
+}
+
+// runMagicLinkRequestedJob sends the link when the email belongs to a user which is not deleted
+func runMagicLinkRequestedJob(ctx context.Context, requestedJob *job.Job, progress job.Progress) (any, error) {
+	var payload emailJobPayload
+	if err := json.Unmarshal([]byte(requestedJob.Payload), &payload); err != nil {
+		return nil, job.Permanent(err)
+	}
+
+	account, err := user.GetOneByEmail(payload.Email)
+	if err != nil || account == nil || account.ID == uuid.Nil || !account.DeletedAt.IsZero() {
+		return nil, err
+	}
+
+	return nil, sendMagicLink(ctx, account.ID, account.Email, string(requestedJob.Input), payload.Locale, requestedJob.Attempts > 1)
+}
+
+// useMagicLink uses the token sent from the device which requested it, uuid.Nil means the token is invalid, expired,
+// used or opened on another device
+func useMagicLink(token string, device string, now time.Time) (uuid.UUID, error) {
+	if token == "" || device == "" {
+		return uuid.Nil, nil
+	}
+
+	actionToken, err := UseActionToken(TokenPurposeMagicLink, magicLinkHash(token, device), now)
+	if err != nil || actionToken == nil {
+		return uuid.Nil, err
+	}
+	return actionToken.UserID, nil
+}
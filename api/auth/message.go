@@ -0,0 +1,55 @@
+package auth
+
+const ErrorInvalidCredentials = "Invalid email or password"
+const ErrorInvalidToken = "Invalid or expired token"
+const ErrorInvalidRefreshToken = "Invalid or expired refresh token"
+const ErrorAccessDenied = "Access denied"
+const ErrorTokenSecretMissing = "AUTH_TOKEN_SECRET is not set"
+const LogoutSuccessful = "Logged out"
+const ErrorEmailNotVerified = "Email is not verified"
+const ErrorInvalidActionToken = "Invalid, expired or already used token"
+const EmailVerifiedSuccessful = "Email verified"
+const VerificationSent = "If the email is registered and not verified, the verification link has been sent"
+const PasswordResetSent = "If the email is registered, the password reset link has been sent"
+const PasswordResetSuccessful = "Password changed"
+const ErrorEmailUnchanged = "New email is the same as the current one"
+const ErrorEmailTaken = "Email %s is already used"
+const EmailChangeRequested = "Confirmation link has been sent to the new email"
+const EmailChangedSuccessful = "Email changed"
+const ErrorTooManyRequests = "Too many requests, try again later"
+const ErrorPasswordChangeRequired = "Password has expired, change it with POST /auth/password/change"
+const ErrorCurrentPasswordInvalid = "Current password is invalid"
+const ErrorMfaKeyInvalid = "AUTH_MFA_ENCRYPTION_KEY must be a base64 encoded 32 byte key"
+const ErrorMfaAlreadyEnabled = "Two-factor authentication is already enabled"
+const ErrorMfaNotEnrolled = "Two-factor authentication is not enrolled"
+const ErrorInvalidMfaCode = "Invalid two-factor authentication code"
+const MfaDisabledSuccessful = "Two-factor authentication disabled"
+const ErrorWebauthnSessionInvalid = "Invalid or expired passkey session, start the ceremony again"
+const ErrorWebauthnResponseInvalid = "Passkey response is not valid"
+const ErrorWebauthnCredentialExists = "Passkey is already registered"
+const ErrorWebauthnCredentialUnknown = "Passkey is not registered"
+const ErrorWebauthnCredentialCloned = "Passkey signature counter did not grow, the key may be cloned"
+const ErrorWebauthnCredentialNotFound = "Passkey %s not found"
+const WebauthnCredentialDeleted = "Passkey removed"
+const MagicLinkSent = "If the email is registered, the sign-in link has been sent"
+const ErrorInvalidMagicLink = "Invalid, expired or already used sign-in link, or it was opened on another device"
+const ErrorSessionExpired = "Session has ended, sign in again"
+const ErrorSessionNotFound = "Session %s not found"
+const SessionRevoked = "Session ended"
+const SessionsRevoked = "%d sessions ended"
+const ErrorExternalProviderNotFound = "Identity provider %s is not configured"
+const ErrorExternalLoginStateInvalid = "Invalid or expired sign-in state, or it was finished on another device, start again"
+const ErrorExternalIdentityInvalid = "Identity provider rejected the sign-in"
+const ErrorExternalAccountExists = "Account with this email exists, sign in and link the provider in the account settings"
+const ErrorExternalSignUpDenied = "Sign-up by the identity provider is not allowed, the provider must confirm the email"
+const ErrorExternalIdentityLinked = "This account of the provider is already linked to another user"
+const ErrorExternalProviderLinked = "Another account of the provider is already linked, unlink it first"
+const ErrorExternalIdentityNotFound = "Identity %s not found"
+const ErrorExternalLastSignInMethod = "Identity is the only way to sign in, set a password or add a passkey first"
+const ExternalIdentityUnlinked = "Identity unlinked"
+const ErrorApiKeyNotAllowed = "API keys can not be used here, sign in"
+const ErrorApiKeyScope = "API key scopes do not allow the request"
+const ErrorApiKeyExpiry = "API key expiry must be in the future and within AUTH_API_KEY_MAX_TTL"
+const ErrorApiKeyLimit = "Too many API keys, revoke unused ones"
+const ErrorApiKeyNotFound = "API key %s not found"
+const ApiKeyRevoked = "API key revoked"
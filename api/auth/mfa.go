@@ -0,0 +1,245 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"strings"
+	"time"
+	"user-service/api/user"
+	"user-service/env"
+)
+
+const TokenPurposeMfaChallenge = "mfa_challenge"
+
+const mfaTotpPeriod = 30
+const mfaTotpDigits = otp.DigitsSix
+const mfaRecoveryCodeCount = 10
+
+var ErrMfaAlreadyEnabled = errors.New(ErrorMfaAlreadyEnabled)
+var ErrMfaNotEnrolled = errors.New(ErrorMfaNotEnrolled)
+
+// recoveryCodeEncoding gives codes without padding and easily confused symbols
+var recoveryCodeEncoding = base32.NewEncoding("abcdefghijkmnpqrstuvwxyz23456789").WithPadding(base32.NoPadding)
+
+type mfaConfig struct {
+	Issuer       string
+	Skew         int64
+	ChallengeTTL time.Duration
+}
+
+func loadMfaConfig() mfaConfig {
+	return mfaConfig{
+		Issuer:       env.String("AUTH_MFA_ISSUER", "user-service"),
+		Skew:         int64(env.Int("AUTH_MFA_TOTP_SKEW", 1)),
+		ChallengeTTL: env.Duration("AUTH_MFA_CHALLENGE_TTL", 5*time.Minute),
+	}
+}
+
+// IsMfaEnabled reports that the login of the user needs the second factor: confirmed TOTP or a registered passkey
+func IsMfaEnabled(userId uuid.UUID) (bool, error) {
+	mfaTotp, err := GetMfaTotp(userId)
+	if err != nil || (mfaTotp != nil && mfaTotp.ConfirmedAt != nil) {
+		return err == nil, err
+	}
+	return HasWebauthnCredentials(userId)
+}
+
+// enrollTotp creates a new secret, it starts working after confirmation with a code. A previous unconfirmed
+// enrollment is replaced.
+func enrollTotp(account *user.UserItemResultDto, now time.Time) (*MfaTotpEnrollmentDto, error) {
+	current, err := GetMfaTotp(account.ID)
+	if err != nil {
+		return nil, err
+	}
+	if current != nil && current.ConfirmedAt != nil {
+		return nil, ErrMfaAlreadyEnabled
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      loadMfaConfig().Issuer,
+		AccountName: account.Email,
+		Period:      mfaTotpPeriod,
+		Digits:      mfaTotpDigits,
+		Algorithm:   otp.AlgorithmSHA1,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted, err := encryptMfaSecret(account.ID, key.Secret())
+	if err != nil {
+		return nil, err
+	}
+
+	err = ReplaceMfaTotp(&MfaTotp{
+		UserID:          account.ID,
+		SecretEncrypted: encrypted,
+		CreatedAt:       now,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &MfaTotpEnrollmentDto{
+		Secret:     key.Secret(),
+		OtpauthUri: key.URL(),
+	}, nil
+}
+
+// confirmTotp enables the enrolled secret and returns new recovery codes, they are shown only once
+func confirmTotp(userId uuid.UUID, code string, now time.Time) ([]string, error) {
+	mfaTotp, err := GetMfaTotp(userId)
+	if err != nil {
+		return nil, err
+	}
+	if mfaTotp == nil {
+		return nil, ErrMfaNotEnrolled
+	}
+	if mfaTotp.ConfirmedAt != nil {
+		return nil, ErrMfaAlreadyEnabled
+	}
+
+	step, err := matchTotpStep(mfaTotp, code, now)
+	if err != nil || step == 0 {
+		return nil, err
+	}
+
+	codes, models, err := newRecoveryCodes(userId, now)
+	if err != nil {
+		return nil, err
+	}
+	return codes, ConfirmMfaTotp(userId, step, models, now)
+}
+
+// verifySecondFactor checks the TOTP code or, when it is empty, the recovery code. Both are accepted only once.
+func verifySecondFactor(userId uuid.UUID, code string, recoveryCode string, now time.Time) (bool, error) {
+	if code == "" {
+		if recoveryCode == "" {
+			return false, nil
+		}
+		return UseMfaRecoveryCode(userId, HashToken(normalizeRecoveryCode(recoveryCode)), now)
+	}
+
+	mfaTotp, err := GetMfaTotp(userId)
+	if err != nil || mfaTotp == nil || mfaTotp.ConfirmedAt == nil {
+		return false, err
+	}
+
+	step, err := matchTotpStep(mfaTotp, code, now)
+	if err != nil || step == 0 {
+		return false, err
+	}
+	return UseMfaTotpStep(userId, step)
+}
+
+// matchTotpStep returns the time step of the code within the allowed clock skew, 0 means the code does not match
+func matchTotpStep(mfaTotp *MfaTotp, code string, now time.Time) (int64, error) {
+	secret, err := decryptMfaSecret(mfaTotp.UserID, mfaTotp.SecretEncrypted)
+	if err != nil {
+		return 0, err
+	}
+
+	current := now.Unix() / mfaTotpPeriod
+	skew := loadMfaConfig().Skew
+	for step := current - skew; step <= current+skew; step++ {
+		expected, err := totp.GenerateCodeCustom(secret, time.Unix(step*mfaTotpPeriod, 0), totp.ValidateOpts{
+			Period:    mfaTotpPeriod,
+			Digits:    mfaTotpDigits,
+			Algorithm: otp.AlgorithmSHA1,
+		})
+		if err != nil {
+			return 0, err
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(strings.TrimSpace(code))) == 1 {
+			return step, nil
+		}
+	}
+	return 0, nil
+}
+
+func newRecoveryCodes(userId uuid.UUID, now time.Time) ([]string, []MfaRecoveryCode, error) {
+	codes := make([]string, 0, mfaRecoveryCodeCount)
+	models := make([]MfaRecoveryCode, 0, mfaRecoveryCodeCount)
+
+	for range mfaRecoveryCodeCount {
+		buffer := make([]byte, 7)
+		if _, err := rand.Read(buffer); err != nil {
+			return nil, nil, err
+		}
+
+		code := recoveryCodeEncoding.EncodeToString(buffer)[:10]
+		codes = append(codes, code[:5]+"-"+code[5:])
+		models = append(models, MfaRecoveryCode{
+			UserID:    userId,
+			CodeHash:  HashToken(code),
+			CreatedAt: now,
+		})
+	}
+	return codes, models, nil
+}
+
+// normalizeRecoveryCode accepts the code typed with spaces, dashes or in upper case
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// encryptMfaSecret encrypts with AES-GCM, the user id is authenticated data, so a secret copied to another user
+// does not decrypt
+func encryptMfaSecret(userId uuid.UUID, secret string) (string, error) {
+	aead, err := mfaCipher()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(secret), userId[:])
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func decryptMfaSecret(userId uuid.UUID, encrypted string) (string, error) {
+	aead, err := mfaCipher()
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New(ErrorMfaKeyInvalid)
+	}
+
+	secret, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], userId[:])
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
+
+func mfaCipher() (cipher.AEAD, error) {
+	config := LoadConfig()
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(config.MfaEncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,356 @@
+package auth
+
+import (
+	"errors"
+	"github.com/apiboxgo/library-utils/dictionary"
+	"github.com/apiboxgo/library-utils/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"user-service/api/user"
+	"user-service/ratelimit"
+)
+
+// ContextClaims is the gin context key of the access token claims
+const ContextClaims = "auth_claims"
+
+// ContextAccount is the gin context key of the authenticated user loaded by RequireAuth
+const ContextAccount = "auth_account"
+
+// ContextApiKey is the gin context key of the API key the request is authenticated with
+const ContextApiKey = "auth_api_key"
+
+// RequireAuth accepts a valid access token or API key of an existing user whose account is not suspended or banned
+func RequireAuth() gin.HandlerFunc {
+	return requireAuth(false, true)
+}
+
+// RequirePasswordChangeAuth is RequireAuth which also accepts the token restricted to the password change, API keys
+// are not accepted
+func RequirePasswordChangeAuth() gin.HandlerFunc {
+	return requireAuth(true, false)
+}
+
+// RequireInteractiveAuth is RequireAuth without API keys, credentials and keys themselves are managed only by the
+// user who signed in
+func RequireInteractiveAuth() gin.HandlerFunc {
+	return requireAuth(false, false)
+}
+
+// OptionalAuth lets through requests without credentials, a request with an access token or API key is checked like
+// RequireAuth
+func OptionalAuth() gin.HandlerFunc {
+	requireAuth := RequireAuth()
+
+	return func(c *gin.Context) {
+		if BearerToken(c) == "" && requestApiKey(c) == "" {
+			c.Next()
+			return
+		}
+
+		requireAuth(c)
+	}
+}
+
+// RequireRole accepts the authenticated user having any of the roles, it must follow RequireAuth
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := GetClaims(c)
+		if claims == nil {
+			abortUnauthorized(c)
+			return
+		}
+
+		for _, role := range roles {
+			if slices.Contains(claims.Roles, role) {
+				c.Next()
+				return
+			}
+		}
+
+		c.AbortWithStatusJSON(http.StatusForbidden, &ErrorResponseDto{
+			Message: ErrorAccessDenied,
+		})
+	}
+}
+
+// RequireVerifiedEmail rejects users with unverified email when the action is listed in
+// AUTH_UNVERIFIED_RESTRICTED_ACTIONS, it must follow RequireAuth
+func RequireVerifiedEmail(action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, _ := c.Get(ContextAccount)
+		account, _ := value.(*user.UserItemResultDto)
+		if account == nil {
+			abortUnauthorized(c)
+			return
+		}
+
+		if account.EmailVerifiedAt == nil && IsRestrictedForUnverified(action) {
+			c.AbortWithStatusJSON(http.StatusForbidden, &ErrorResponseDto{
+				Message: ErrorEmailNotVerified,
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RateLimitKey keys rate limits by the user of a valid access token or by the hash of the API key, nothing is loaded
+// from the database. A revoked or suspended user still shares the bucket with own earlier requests. An unknown API
+// key gets own bucket as well, credential checks are still throttled by lockout counters of the client address.
+func RateLimitKey() ratelimit.KeyFunc {
+	config := LoadConfig()
+
+	return func(c *gin.Context) string {
+		if raw := requestApiKey(c); raw != "" {
+			return ratelimit.SecretKey(raw)
+		}
+
+		raw := BearerToken(c)
+		if raw == "" {
+			return ""
+		}
+
+		claims, err := ParseAccessToken(config, raw)
+		if err != nil {
+			return ""
+		}
+		return "user:" + claims.Subject
+	}
+}
+
+// GetClaims returns claims of the authenticated request or nil
+func GetClaims(c *gin.Context) *Claims {
+	value, exists := c.Get(ContextClaims)
+	if !exists {
+		return nil
+	}
+
+	claims, _ := value.(*Claims)
+	return claims
+}
+
+// === Sys
+// requireAuth checks the access token, allowPasswordChange lets through the token restricted to the password change,
+// allowApiKey lets through API keys
+func requireAuth(allowPasswordChange bool, allowApiKey bool) gin.HandlerFunc {
+	config := LoadConfig()
+
+	return func(c *gin.Context) {
+		if raw := requestApiKey(c); raw != "" {
+			if !allowApiKey {
+				c.AbortWithStatusJSON(http.StatusForbidden, &ErrorResponseDto{
+					Message: ErrorApiKeyNotAllowed,
+				})
+				return
+			}
+
+			requireApiKey(c, raw)
+			return
+		}
+
+		raw := BearerToken(c)
+		if raw == "" {
+			abortUnauthorized(c)
+			return
+		}
+
+		claims, err := ParseAccessToken(config, raw)
+		if err != nil {
+			abortUnauthorized(c)
+			return
+		}
+
+		account, ok := checkAccount(c, claims.UserID())
+		if !ok {
+			return
+		}
+
+		// Tokens issued before the password change belong to sessions which were revoked by the change
+		if account.PasswordChangedAt != nil && claims.IssuedAt != nil &&
+			claims.IssuedAt.Before(account.PasswordChangedAt.Truncate(time.Second)) {
+			abortUnauthorized(c)
+			return
+		}
+
+		if !checkSession(c, config, claims) {
+			return
+		}
+
+		if claims.Scope == ScopePasswordChange && !allowPasswordChange {
+			c.AbortWithStatusJSON(http.StatusForbidden, &ErrorResponseDto{
+				Message: ErrorPasswordChangeRequired,
+			})
+			return
+		}
+
+		c.Set(ContextClaims, claims)
+		c.Set(ContextAccount, account)
+		c.Set(user.ContextActorId, claims.Subject)
+		c.Set(user.ContextActorRoles, claims.Roles)
+		c.Next()
+	}
+}
+
+// BearerToken returns the token of the Authorization header, empty when there is none
+func BearerToken(c *gin.Context) string {
+	header := c.GetHeader("Authorization")
+	if len(header) <= len(TokenTypeBearer)+1 || !strings.EqualFold(header[:len(TokenTypeBearer)], TokenTypeBearer) {
+		return ""
+	}
+	return strings.TrimSpace(header[len(TokenTypeBearer)+1:])
+}
+
+// requireApiKey authenticates the request by the API key, the claims carry scopes of the key and roles of the user
+// only with the admin scope
+func requireApiKey(c *gin.Context, raw string) {
+	now := time.Now()
+	apiKey, err := findActiveApiKey(raw, now)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	if apiKey == nil {
+		abortUnauthorized(c)
+		return
+	}
+
+	account, ok := checkAccount(c, apiKey.UserID)
+	if !ok {
+		return
+	}
+
+	scopes := strings.Fields(apiKey.Scopes)
+	if !apiKeyAllowsMethod(scopes, c.Request.Method) {
+		c.AbortWithStatusJSON(http.StatusForbidden, &ErrorResponseDto{
+			Message: ErrorApiKeyScope,
+		})
+		return
+	}
+
+	var roles []string
+	if slices.Contains(scopes, ApiKeyScopeAdmin) {
+		roles, err = user.GetUserRoles(apiKey.UserID)
+		if err != nil {
+			utils.LogError(dictionary.SomethingWrong, err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, &ErrorResponseDto{
+				Message: dictionary.SomethingWrong,
+			})
+			return
+		}
+	}
+
+	if apiKey.LastUsedAt == nil || apiKey.LastUsedAt.Before(now.Add(-apiKeyTouchInterval)) {
+		if err := TouchApiKey(apiKey.ID, c.ClientIP(), now, now.Add(-apiKeyTouchInterval)); err != nil {
+			utils.LogError(dictionary.SomethingWrong, err)
+		}
+	}
+
+	claims := &Claims{
+		Roles: roles,
+		Scope: apiKey.Scopes,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:  apiKey.UserID.String(),
+			IssuedAt: jwt.NewNumericDate(apiKey.CreatedAt),
+		},
+	}
+	if apiKey.ExpiresAt != nil {
+		claims.ExpiresAt = jwt.NewNumericDate(*apiKey.ExpiresAt)
+	}
+
+	c.Set(ContextClaims, claims)
+	c.Set(ContextAccount, account)
+	c.Set(ContextApiKey, apiKey)
+	c.Set(user.ContextActorId, claims.Subject)
+	c.Set(user.ContextActorRoles, claims.Roles)
+	c.Next()
+}
+
+// checkAccount loads the user on every request, so suspension takes effect before the token expires
+func checkAccount(c *gin.Context, id uuid.UUID) (*user.UserItemResultDto, bool) {
+	account, err := user.GetOneById(user.RequestUserIdDTO{ID: id.String()})
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return nil, false
+	}
+
+	if account == nil || account.ID == uuid.Nil || !account.DeletedAt.IsZero() {
+		abortUnauthorized(c)
+		return nil, false
+	}
+
+	err = user.CheckAccountStatus(account.ID, account.Status, account.SuspendedUntil, account.StatusReason)
+	return account, abortAccountStatus(c, err)
+}
+
+// checkSession rejects tokens of ended sessions, so a signed out device loses access at once and not when the token
+// expires. The token restricted to the password change has no session.
+func checkSession(c *gin.Context, config Config, claims *Claims) bool {
+	sessionId := claims.SessionId()
+	if sessionId == uuid.Nil {
+		return true
+	}
+
+	session, err := GetSession(sessionId)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return false
+	}
+
+	now := time.Now()
+	if session == nil || session.UserID != claims.UserID() || !isSessionActive(session, config, now) {
+		abortUnauthorized(c)
+		return false
+	}
+
+	if session.LastSeenAt.Before(now.Add(-sessionTouchInterval)) {
+		touchSession(c, session.ID, now)
+	}
+	return true
+}
+
+// abortAccountStatus writes 403 for a blocked account and returns true when the account can be used
+func abortAccountStatus(c *gin.Context, err error) bool {
+	if err == nil {
+		return true
+	}
+
+	var statusError *user.AccountStatusError
+	if errors.As(err, &statusError) {
+		c.AbortWithStatusJSON(http.StatusForbidden, &AccountStatusErrorDto{
+			Message:        statusError.Error(),
+			Status:         statusError.Status,
+			Reason:         statusError.Reason,
+			SuspendedUntil: statusError.SuspendedUntil,
+		})
+		return false
+	}
+
+	utils.LogError(dictionary.SomethingWrong, err)
+	c.AbortWithStatusJSON(http.StatusInternalServerError, &ErrorResponseDto{
+		Message: dictionary.SomethingWrong,
+	})
+	return false
+}
+
+func abortUnauthorized(c *gin.Context) {
+	c.Header("WWW-Authenticate", TokenTypeBearer)
+	c.AbortWithStatusJSON(http.StatusUnauthorized, &ErrorResponseDto{
+		Message: ErrorInvalidToken,
+	})
+}
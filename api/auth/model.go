@@ -0,0 +1,223 @@
+package auth
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+)
+
+// RefreshToken is stored as SHA-256 hash. All tokens issued by rotation of one login share the family,
+// so reuse of a rotated token revokes the whole family.
+type RefreshToken struct {
+	ID         uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4()"`
+	UserID     uuid.UUID  `gorm:"type:uuid;not null"`
+	FamilyID   uuid.UUID  `gorm:"type:uuid;not null"`
+	TokenHash  string     `gorm:"type:varchar(64);not null;unique"`
+	ExpiresAt  time.Time  `gorm:"type:timestamp;not null"`
+	RevokedAt  *time.Time `gorm:"type:timestamp;null;default:null"`
+	ReplacedBy *uuid.UUID `gorm:"type:uuid;null;default:null"`
+	CreatedAt  time.Time  `gorm:"type:timestamp;not null"`
+}
+
+func (p *RefreshToken) BeforeCreate(tx *gorm.DB) (err error) {
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	return
+}
+
+// Session is a sign-in of the user on a device. Refresh tokens of the session use its id as the family, LastSeenAt
+// is moved by refresh and by authenticated requests for the idle timeout.
+type Session struct {
+	ID         uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4()"`
+	UserID     uuid.UUID  `gorm:"type:uuid;not null"`
+	UserAgent  string     `gorm:"type:varchar(512);not null;default:''"`
+	Ip         string     `gorm:"type:varchar(45);not null;default:''"`
+	CreatedAt  time.Time  `gorm:"type:timestamp;not null"`
+	LastSeenAt time.Time  `gorm:"type:timestamp;not null"`
+	ExpiresAt  time.Time  `gorm:"type:timestamp;not null"`
+	RevokedAt  *time.Time `gorm:"type:timestamp;null;default:null"`
+}
+
+func (p *Session) BeforeCreate(tx *gorm.DB) (err error) {
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	return
+}
+
+// LoginEvent is a sign-in attempt, UserID is nil for unknown emails and FailureReason is empty for a success
+type LoginEvent struct {
+	ID            uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4()"`
+	UserID        *uuid.UUID `gorm:"type:uuid;null;default:null"`
+	Method        string     `gorm:"type:varchar(16);not null"`
+	Success       bool       `gorm:"not null"`
+	FailureReason string     `gorm:"type:varchar(32);not null;default:''"`
+	Ip            string     `gorm:"type:varchar(45);not null;default:''"`
+	UserAgent     string     `gorm:"type:varchar(512);not null;default:''"`
+	DeviceHash    string     `gorm:"type:varchar(64);not null;default:''"`
+	Country       string     `gorm:"type:varchar(2);not null;default:''"`
+	City          string     `gorm:"type:varchar(100);not null;default:''"`
+	CreatedAt     time.Time  `gorm:"type:timestamp;not null"`
+}
+
+func (p *LoginEvent) BeforeCreate(tx *gorm.DB) (err error) {
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	return
+}
+
+// ActionToken is a single-use token sent by email, for example to verify the address. Only its hash is stored.
+type ActionToken struct {
+	ID        uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4()"`
+	UserID    uuid.UUID  `gorm:"type:uuid;not null"`
+	Purpose   string     `gorm:"type:varchar(32);not null"`
+	TokenHash string     `gorm:"type:varchar(64);not null;unique"`
+	ExpiresAt time.Time  `gorm:"type:timestamp;not null"`
+	UsedAt    *time.Time `gorm:"type:timestamp;null;default:null"`
+	CreatedAt time.Time  `gorm:"type:timestamp;not null"`
+}
+
+func (p *ActionToken) BeforeCreate(tx *gorm.DB) (err error) {
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	return
+}
+
+// MfaTotp is the TOTP second factor of the user. The secret is encrypted with AUTH_MFA_ENCRYPTION_KEY, LastUsedStep
+// is the time step of the last accepted code, so a code can not be replayed.
+type MfaTotp struct {
+	UserID          uuid.UUID  `gorm:"type:uuid;primaryKey"`
+	SecretEncrypted string     `gorm:"type:text;not null"`
+	ConfirmedAt     *time.Time `gorm:"type:timestamp;null;default:null"`
+	LastUsedStep    int64      `gorm:"not null;default:0"`
+	CreatedAt       time.Time  `gorm:"type:timestamp;not null"`
+}
+
+func (MfaTotp) TableName() string {
+	return "mfa_totp"
+}
+
+// MfaRecoveryCode is a one-time code which replaces the TOTP code when the device is lost, only its hash is stored
+type MfaRecoveryCode struct {
+	ID        uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4()"`
+	UserID    uuid.UUID  `gorm:"type:uuid;not null"`
+	CodeHash  string     `gorm:"type:varchar(64);not null"`
+	UsedAt    *time.Time `gorm:"type:timestamp;null;default:null"`
+	CreatedAt time.Time  `gorm:"type:timestamp;not null"`
+}
+
+func (p *MfaRecoveryCode) BeforeCreate(tx *gorm.DB) (err error) {
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	return
+}
+
+// WebauthnCredential is a passkey or a security key of the user. SignCount is the last signature counter reported by
+// the authenticator, a counter which does not grow means the key may be cloned.
+type WebauthnCredential struct {
+	ID              uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4()"`
+	UserID          uuid.UUID  `gorm:"type:uuid;not null"`
+	CredentialID    []byte     `gorm:"type:bytea;not null;unique"`
+	PublicKey       []byte     `gorm:"type:bytea;not null"`
+	AttestationType string     `gorm:"type:varchar(32);not null;default:''"`
+	Aaguid          []byte     `gorm:"type:bytea;null;default:null"`
+	SignCount       uint32     `gorm:"type:bigint;not null;default:0"`
+	Transports      string     `gorm:"type:varchar(255);not null;default:''"`
+	BackupEligible  bool       `gorm:"not null;default:false"`
+	BackupState     bool       `gorm:"not null;default:false"`
+	Name            string     `gorm:"type:varchar(100);not null;default:''"`
+	LastUsedAt      *time.Time `gorm:"type:timestamp;null;default:null"`
+	CreatedAt       time.Time  `gorm:"type:timestamp;not null"`
+}
+
+func (p *WebauthnCredential) BeforeCreate(tx *gorm.DB) (err error) {
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	return
+}
+
+// WebauthnSession keeps the challenge of a started registration or login until the client finishes it, the client
+// gets the token, only its hash is stored
+type WebauthnSession struct {
+	ID          uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4()"`
+	UserID      *uuid.UUID `gorm:"type:uuid;null;default:null"`
+	Purpose     string     `gorm:"type:varchar(32);not null"`
+	TokenHash   string     `gorm:"type:varchar(64);not null;unique"`
+	SessionData string     `gorm:"type:text;not null"`
+	ExpiresAt   time.Time  `gorm:"type:timestamp;not null"`
+	CreatedAt   time.Time  `gorm:"type:timestamp;not null"`
+}
+
+func (p *WebauthnSession) BeforeCreate(tx *gorm.DB) (err error) {
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	return
+}
+
+// UserIdentity links the account of an external identity provider to the user, Subject is unique within the provider
+type UserIdentity struct {
+	ID         uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4()"`
+	UserID     uuid.UUID  `gorm:"type:uuid;not null"`
+	Provider   string     `gorm:"type:varchar(32);not null"`
+	Subject    string     `gorm:"type:varchar(255);not null"`
+	Email      string     `gorm:"type:varchar(255);not null;default:''"`
+	CreatedAt  time.Time  `gorm:"type:timestamp;not null"`
+	LastUsedAt *time.Time `gorm:"type:timestamp;null;default:null"`
+}
+
+func (p *UserIdentity) BeforeCreate(tx *gorm.DB) (err error) {
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	return
+}
+
+// ExternalLoginState keeps the nonce and the PKCE verifier of a sign-in started at the provider until the callback,
+// the client gets the state, only its hash bound to the device is stored
+type ExternalLoginState struct {
+	ID           uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4()"`
+	UserID       *uuid.UUID `gorm:"type:uuid;null;default:null"`
+	Provider     string     `gorm:"type:varchar(32);not null"`
+	Purpose      string     `gorm:"type:varchar(32);not null"`
+	StateHash    string     `gorm:"type:varchar(64);not null;unique"`
+	Nonce        string     `gorm:"type:varchar(64);not null;default:''"`
+	CodeVerifier string     `gorm:"type:varchar(128);not null"`
+	ExpiresAt    time.Time  `gorm:"type:timestamp;not null"`
+	CreatedAt    time.Time  `gorm:"type:timestamp;not null"`
+}
+
+func (p *ExternalLoginState) BeforeCreate(tx *gorm.DB) (err error) {
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	return
+}
+
+// ApiKey is a personal key for machine access on behalf of the user, Scopes are space separated. Only the hash of the
+// key is stored, Prefix is its beginning to tell keys apart.
+type ApiKey struct {
+	ID         uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4()"`
+	UserID     uuid.UUID  `gorm:"type:uuid;not null"`
+	Name       string     `gorm:"type:varchar(100);not null"`
+	Prefix     string     `gorm:"type:varchar(16);not null"`
+	KeyHash    string     `gorm:"type:varchar(64);not null;unique"`
+	Scopes     string     `gorm:"type:varchar(255);not null"`
+	ExpiresAt  *time.Time `gorm:"type:timestamp;null;default:null"`
+	LastUsedAt *time.Time `gorm:"type:timestamp;null;default:null"`
+	LastUsedIp string     `gorm:"type:varchar(45);not null;default:''"`
+	RevokedAt  *time.Time `gorm:"type:timestamp;null;default:null"`
+	CreatedAt  time.Time  `gorm:"type:timestamp;not null"`
+}
+
+func (p *ApiKey) BeforeCreate(tx *gorm.DB) (err error) {
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	return
+}
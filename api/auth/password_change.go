@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"github.com/google/uuid"
+	"time"
+	"user-service/api/user"
+)
+
+// passwordViolations checks the new password against the policy and the password history of the user
+func passwordViolations(userId uuid.UUID, email string, password string) ([]user.ValidationErrorDto, error) {
+	if violations := user.CheckPasswordPolicy(password, email); len(violations) > 0 {
+		return violations, nil
+	}
+	return user.CheckPasswordHistory(userId, password)
+}
+
+// passwordChangeToken returns the access token which only allows to change the expired password, the account is
+// flagged so the change is required even if USER_PASSWORD_MAX_AGE is raised later
+func passwordChangeToken(account *user.UserItemFullResultDto, now time.Time) (*TokenPairDto, error) {
+	if !account.MustChangePassword {
+		if err := user.SetPasswordMustChange(account.ID, now); err != nil {
+			return nil, err
+		}
+	}
+
+	config := LoadConfig()
+	accessToken, err := IssueAccessToken(config, account.ID, uuid.Nil, nil, ScopePasswordChange, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPairDto{
+		AccessToken:        accessToken,
+		TokenType:          TokenTypeBearer,
+		ExpiresIn:          int64(config.AccessTokenTTL.Seconds()),
+		MustChangePassword: true,
+	}, nil
+}
+
+// changePassword stores the new password and ends all sessions of the user
+func changePassword(userId uuid.UUID, password string, now time.Time) error {
+	if err := user.ChangePassword(userId, password, now); err != nil {
+		return err
+	}
+
+	_, err := RevokeUserSessions(userId, uuid.Nil, now)
+	return err
+}
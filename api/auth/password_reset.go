@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/apiboxgo/library-utils/api_init"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+	"user-service/api/job"
+	"user-service/api/user"
+	"user-service/mailer"
+)
+
+const TokenPurposePasswordReset = "password_reset"
+const MailTemplatePasswordReset = "password_reset"
+
+// sendPasswordReset issues the reset token and sends the link to the user, nothing is sent when the user
+// requested the link too often
+func sendPasswordReset(ctx context.Context, userId uuid.UUID, email string, locale string, isRetry bool) error {
+	config := loadActionTokenConfig("AUTH_PASSWORD_RESET", time.Hour)
+
+	now := time.Now()
+	isLimited, err := isActionTokenLimited(userId, TokenPurposePasswordReset, config, isRetry, now)
+	if err != nil || isLimited {
+		return err
+	}
+
+	raw, err := issueActionToken(userId, TokenPurposePasswordReset, config.TokenTTL, now)
+	if err != nil {
+		return err
+	}
+
+	return mailer.SendTemplate(ctx, email, locale, MailTemplatePasswordReset, actionTokenMailData{
+		Email:     email,
+		Link:      fmt.Sprintf(config.Url, raw),
+		ExpiresIn: formatTTL(config.TokenTTL),
+	})
+}
+
+// runPasswordResetRequestedJob sends the link when the email belongs to a user which is not deleted
+func runPasswordResetRequestedJob(ctx context.Context, requestedJob *job.Job, progress job.Progress) (any, error) {
+	var payload emailJobPayload
+	if err := json.Unmarshal([]byte(requestedJob.Payload), &payload); err != nil {
+		return nil, job.Permanent(err)
+	}
+
+	account, err := user.GetOneByEmail(payload.Email)
+	if err != nil || account == nil || account.ID == uuid.Nil || !account.DeletedAt.IsZero() {
+		return nil, err
+	}
+
+	return nil, sendPasswordReset(ctx, account.ID, account.Email, payload.Locale, requestedJob.Attempts > 1)
+}
+
+// checkResetPasswordPolicy checks the new password against the policy of the token owner, it does not use the token.
+// An invalid token gives no violations, resetPassword reports it.
+func checkResetPasswordPolicy(token string, password string) ([]user.ValidationErrorDto, error) {
+	actionToken, err := GetActionToken(TokenPurposePasswordReset, HashToken(token), time.Now())
+	if err != nil || actionToken == nil {
+		return nil, err
+	}
+
+	account, err := user.GetOneById(user.RequestUserIdDTO{ID: actionToken.UserID.String()})
+	if err != nil {
+		return nil, err
+	}
+	return passwordViolations(account.ID, account.Email, password)
+}
+
+// resetPassword sets the new password of the token owner and revokes all refresh tokens of the user.
+// Access tokens issued before the change are rejected by RequireAuth. It returns uuid.Nil for an invalid token.
+// The token is used in the transaction of the password change, so a failed change leaves the token valid.
+func resetPassword(token string, password string) (uuid.UUID, error) {
+	passwordHash, err := user.HashPassword(password)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	now := time.Now()
+	var actionToken *ActionToken
+	err = api_init.GetDbh().Transaction(func(tx *gorm.DB) error {
+		var err error
+		actionToken, err = useActionToken(tx, TokenPurposePasswordReset, HashToken(token), now)
+		if err != nil || actionToken == nil {
+			return err
+		}
+		return user.UpdateUserPasswordTx(tx, actionToken.UserID, passwordHash, now)
+	})
+	if err != nil || actionToken == nil {
+		return uuid.Nil, err
+	}
+
+	if _, err := RevokeUserSessions(actionToken.UserID, uuid.Nil, now); err != nil {
+		return uuid.Nil, err
+	}
+
+	// The link was delivered to the mailbox, so the address is confirmed as well
+	if err := user.MarkEmailVerified(actionToken.UserID, now); err != nil {
+		return uuid.Nil, err
+	}
+
+	return actionToken.UserID, nil
+}
@@ -0,0 +1,493 @@
+package auth
+
+import (
+	"errors"
+	"github.com/apiboxgo/library-utils/api_init"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+	"user-service/api/user"
+)
+
+func CreateRefreshToken(refreshToken *RefreshToken) error {
+	return api_init.GetDbh().Create(refreshToken).Error
+}
+
+func GetRefreshTokenByHash(hash string) (*RefreshToken, error) {
+	var result RefreshToken
+	err := api_init.GetDbh().Raw("SELECT * FROM refresh_tokens WHERE token_hash = $1 LIMIT 1", hash).Scan(&result).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if result.ID == uuid.Nil {
+		return nil, nil
+	}
+	return &result, nil
+}
+
+// RotateRefreshToken revokes the used token and stores its replacement in one transaction.
+// It returns false when the used token was revoked concurrently.
+func RotateRefreshToken(used *RefreshToken, replacement *RefreshToken, now time.Time) (bool, error) {
+	isRotated := false
+	err := api_init.GetDbh().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(replacement).Error; err != nil {
+			return err
+		}
+
+		update := tx.Model(&RefreshToken{}).
+			Where("id = ? AND revoked_at IS NULL", used.ID).
+			Updates(map[string]interface{}{
+				"revoked_at":  now,
+				"replaced_by": replacement.ID,
+			})
+		if update.Error != nil {
+			return update.Error
+		}
+
+		isRotated = update.RowsAffected > 0
+		if !isRotated {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	return isRotated, err
+}
+
+func RevokeRefreshToken(hash string, now time.Time) error {
+	return api_init.GetDbh().Model(&RefreshToken{}).
+		Where("token_hash = ? AND revoked_at IS NULL", hash).
+		Update("revoked_at", now).Error
+}
+
+// CreateSession stores the new session with its first refresh token
+func CreateSession(session *Session, refreshToken *RefreshToken) error {
+	return api_init.GetDbh().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(session).Error; err != nil {
+			return err
+		}
+		return tx.Create(refreshToken).Error
+	})
+}
+
+func GetSession(id uuid.UUID) (*Session, error) {
+	var result Session
+	err := api_init.GetDbh().Raw("SELECT * FROM sessions WHERE id = $1 LIMIT 1", id).Scan(&result).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if result.ID == uuid.Nil {
+		return nil, nil
+	}
+	return &result, nil
+}
+
+// GetUserSessions returns sessions which are not revoked, expired or idle since idleSince, the recent ones first
+func GetUserSessions(userId uuid.UUID, idleSince time.Time, now time.Time) ([]Session, error) {
+	var result []Session
+	err := api_init.GetDbh().
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ? AND last_seen_at > ?", userId, now, idleSince).
+		Order("last_seen_at DESC").
+		Find(&result).Error
+	return result, err
+}
+
+// TouchSession records activity of the session, it is written only when the last one is older than seenBefore, so
+// frequent requests do not update the row every time
+func TouchSession(id uuid.UUID, ip string, userAgent string, now time.Time, seenBefore time.Time) error {
+	return api_init.GetDbh().Model(&Session{}).
+		Where("id = ? AND last_seen_at < ?", id, seenBefore).
+		Updates(map[string]interface{}{
+			"last_seen_at": now,
+			"ip":           ip,
+			"user_agent":   userAgent,
+		}).Error
+}
+
+// RevokeSession ends the session of the user with its refresh tokens and runs the revoke hooks, false means there is
+// no such active session
+func RevokeSession(userId uuid.UUID, id uuid.UUID, now time.Time) (bool, error) {
+	isRevoked := false
+	err := api_init.GetDbh().Transaction(func(tx *gorm.DB) error {
+		update := tx.Model(&Session{}).
+			Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userId).
+			Update("revoked_at", now)
+		if update.Error != nil {
+			return update.Error
+		}
+		isRevoked = update.RowsAffected > 0
+
+		err := tx.Model(&RefreshToken{}).
+			Where("family_id = ? AND user_id = ? AND revoked_at IS NULL", id, userId).
+			Update("revoked_at", now).Error
+		if err != nil {
+			return err
+		}
+		return runSessionRevokeHooks(tx, userId, id, uuid.Nil, now)
+	})
+	return isRevoked, err
+}
+
+// RevokeUserSessions ends all sessions of the user except keep, uuid.Nil keeps none, and runs the revoke hooks. It returns
+// the number of ended sessions.
+func RevokeUserSessions(userId uuid.UUID, keep uuid.UUID, now time.Time) (int64, error) {
+	var count int64
+	err := api_init.GetDbh().Transaction(func(tx *gorm.DB) error {
+		update := tx.Model(&Session{}).
+			Where("user_id = ? AND id <> ? AND revoked_at IS NULL", userId, keep).
+			Update("revoked_at", now)
+		if update.Error != nil {
+			return update.Error
+		}
+		count = update.RowsAffected
+
+		err := tx.Model(&RefreshToken{}).
+			Where("user_id = ? AND family_id <> ? AND revoked_at IS NULL", userId, keep).
+			Update("revoked_at", now).Error
+		if err != nil {
+			return err
+		}
+		return runSessionRevokeHooks(tx, userId, uuid.Nil, keep, now)
+	})
+	return count, err
+}
+
+func CreateLoginEvent(event *LoginEvent) error {
+	return api_init.GetDbh().Create(event).Error
+}
+
+// GetLoginEvents returns attempts of the user before the time, the recent ones first
+func GetLoginEvents(userId uuid.UUID, before time.Time, limit int) ([]LoginEvent, error) {
+	var result []LoginEvent
+	err := api_init.GetDbh().
+		Where("user_id = ? AND created_at < ?", userId, before).
+		Order("created_at DESC").
+		Limit(limit).
+		Find(&result).Error
+	return result, err
+}
+
+// HasSuccessfulLogin tells whether the user has signed in before the time, conditions narrow the sign-ins down, for
+// example "device_hash = ?"
+func HasSuccessfulLogin(userId uuid.UUID, before time.Time, conditions ...any) (bool, error) {
+	query := api_init.GetDbh().Model(&LoginEvent{}).
+		Where("user_id = ? AND success AND created_at < ?", userId, before)
+	if len(conditions) > 0 {
+		query = query.Where(conditions[0], conditions[1:]...)
+	}
+
+	var count int64
+	err := query.Limit(1).Count(&count).Error
+	return count > 0, err
+}
+
+// GetLoginUserAgents returns distinct user agents of successful sign-ins of the user before the time
+func GetLoginUserAgents(userId uuid.UUID, before time.Time) ([]string, error) {
+	var result []string
+	err := api_init.GetDbh().Model(&LoginEvent{}).
+		Where("user_id = ? AND success AND created_at < ?", userId, before).
+		Distinct().Pluck("user_agent", &result).Error
+	return result, err
+}
+
+func CreateActionToken(actionToken *ActionToken) error {
+	return api_init.GetDbh().Create(actionToken).Error
+}
+
+// UseActionToken marks the token as used and returns it, nil means the token is unknown, expired or already used
+func UseActionToken(purpose string, hash string, now time.Time) (*ActionToken, error) {
+	return useActionToken(api_init.GetDbh(), purpose, hash, now)
+}
+
+func useActionToken(tx *gorm.DB, purpose string, hash string, now time.Time) (*ActionToken, error) {
+	var result []ActionToken
+	err := tx.Raw(
+		"UPDATE action_tokens SET used_at = ? WHERE token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ? RETURNING *",
+		now, hash, purpose, now,
+	).Scan(&result).Error
+	if err != nil || len(result) == 0 {
+		return nil, err
+	}
+	return &result[0], nil
+}
+
+// GetActionToken returns the token without using it, nil means the token is unknown, expired or already used
+func GetActionToken(purpose string, hash string, now time.Time) (*ActionToken, error) {
+	var result ActionToken
+	err := api_init.GetDbh().
+		Where("token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", hash, purpose, now).
+		Limit(1).
+		Find(&result).Error
+	if err != nil || result.ID == uuid.Nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// RevokeActionTokens marks unused tokens of the purpose as used, so only the latest issued token works
+func RevokeActionTokens(userId uuid.UUID, purpose string, now time.Time) error {
+	return api_init.GetDbh().Model(&ActionToken{}).
+		Where("user_id = ? AND purpose = ? AND used_at IS NULL", userId, purpose).
+		Update("used_at", now).Error
+}
+
+// GetActionTokensIssuedSince returns creation times of the user tokens, the newest first
+func GetActionTokensIssuedSince(userId uuid.UUID, purpose string, since time.Time) ([]time.Time, error) {
+	var result []time.Time
+	err := api_init.GetDbh().Model(&ActionToken{}).
+		Where("user_id = ? AND purpose = ? AND created_at > ?", userId, purpose, since).
+		Order("created_at DESC").
+		Pluck("created_at", &result).Error
+	return result, err
+}
+
+func GetMfaTotp(userId uuid.UUID) (*MfaTotp, error) {
+	var result MfaTotp
+	err := api_init.GetDbh().Where("user_id = ?", userId).Limit(1).Find(&result).Error
+	if err != nil || result.UserID == uuid.Nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ReplaceMfaTotp stores the new unconfirmed secret instead of the previous enrollment
+func ReplaceMfaTotp(mfaTotp *MfaTotp) error {
+	return api_init.GetDbh().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", mfaTotp.UserID).Delete(&MfaTotp{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(mfaTotp).Error
+	})
+}
+
+// ConfirmMfaTotp enables the second factor and replaces recovery codes of the user
+func ConfirmMfaTotp(userId uuid.UUID, step int64, codes []MfaRecoveryCode, now time.Time) error {
+	return api_init.GetDbh().Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&MfaTotp{}).
+			Where("user_id = ?", userId).
+			Updates(map[string]interface{}{
+				"confirmed_at":   now,
+				"last_used_step": step,
+			}).Error
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Where("user_id = ?", userId).Delete(&MfaRecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&codes).Error
+	})
+}
+
+// UseMfaTotpStep accepts the time step only once, false means a code of this or a later step was already used
+func UseMfaTotpStep(userId uuid.UUID, step int64) (bool, error) {
+	update := api_init.GetDbh().Model(&MfaTotp{}).
+		Where("user_id = ? AND confirmed_at IS NOT NULL AND last_used_step < ?", userId, step).
+		Update("last_used_step", step)
+	return update.RowsAffected > 0, update.Error
+}
+
+// UseMfaRecoveryCode marks the code as used, false means the code is unknown or already used
+func UseMfaRecoveryCode(userId uuid.UUID, hash string, now time.Time) (bool, error) {
+	update := api_init.GetDbh().Model(&MfaRecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userId, hash).
+		Update("used_at", now)
+	return update.RowsAffected > 0, update.Error
+}
+
+func DeleteMfa(userId uuid.UUID) error {
+	return api_init.GetDbh().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userId).Delete(&MfaRecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userId).Delete(&MfaTotp{}).Error
+	})
+}
+
+func GetWebauthnCredentials(userId uuid.UUID) ([]WebauthnCredential, error) {
+	var result []WebauthnCredential
+	err := api_init.GetDbh().Where("user_id = ?", userId).Order("created_at").Find(&result).Error
+	return result, err
+}
+
+func HasWebauthnCredentials(userId uuid.UUID) (bool, error) {
+	var count int64
+	err := api_init.GetDbh().Model(&WebauthnCredential{}).Where("user_id = ?", userId).Count(&count).Error
+	return count > 0, err
+}
+
+// GetWebauthnCredentialOwner returns the user of the credential id sent by the authenticator, uuid.Nil means unknown
+func GetWebauthnCredentialOwner(credentialId []byte) (uuid.UUID, error) {
+	var result WebauthnCredential
+	err := api_init.GetDbh().Where("credential_id = ?", credentialId).Limit(1).Find(&result).Error
+	return result.UserID, err
+}
+
+func CreateWebauthnCredential(credential *WebauthnCredential) error {
+	return api_init.GetDbh().Create(credential).Error
+}
+
+// UseWebauthnCredential stores the new signature counter, false means the counter was already used by a concurrent
+// login, which is a sign of a cloned key. Authenticators without a counter always report 0.
+func UseWebauthnCredential(id uuid.UUID, signCount uint32, backupState bool, now time.Time) (bool, error) {
+	update := api_init.GetDbh().Model(&WebauthnCredential{}).
+		Where("id = ? AND (sign_count < ? OR (sign_count = 0 AND ? = 0))", id, signCount, signCount).
+		Updates(map[string]interface{}{
+			"sign_count":   signCount,
+			"backup_state": backupState,
+			"last_used_at": now,
+		})
+	return update.RowsAffected > 0, update.Error
+}
+
+// DeleteWebauthnCredential removes the credential of the user, false means it does not exist
+func DeleteWebauthnCredential(userId uuid.UUID, id uuid.UUID) (bool, error) {
+	result := api_init.GetDbh().Where("id = ? AND user_id = ?", id, userId).Delete(&WebauthnCredential{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// CreateWebauthnSession stores the session and drops expired ones, abandoned ceremonies are never finished
+func CreateWebauthnSession(session *WebauthnSession) error {
+	return api_init.GetDbh().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("expires_at < ?", session.CreatedAt).Delete(&WebauthnSession{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(session).Error
+	})
+}
+
+// UseWebauthnSession deletes the session and returns it, nil means the token is unknown, expired or already used
+func UseWebauthnSession(purpose string, hash string, now time.Time) (*WebauthnSession, error) {
+	var result []WebauthnSession
+	err := api_init.GetDbh().Raw(
+		"DELETE FROM webauthn_sessions WHERE token_hash = ? AND purpose = ? RETURNING *",
+		hash, purpose,
+	).Scan(&result).Error
+	if err != nil || len(result) == 0 || !result[0].ExpiresAt.After(now) {
+		return nil, err
+	}
+	return &result[0], nil
+}
+
+// GetUserIdentity returns the identity of the provider account, nil means the account is not linked
+func GetUserIdentity(provider string, subject string) (*UserIdentity, error) {
+	var result UserIdentity
+	err := api_init.GetDbh().Where("provider = ? AND subject = ?", provider, subject).Limit(1).Find(&result).Error
+	if err != nil || result.ID == uuid.Nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func GetUserIdentities(userId uuid.UUID) ([]UserIdentity, error) {
+	var result []UserIdentity
+	err := api_init.GetDbh().Where("user_id = ?", userId).Order("created_at").Find(&result).Error
+	return result, err
+}
+
+func CreateUserIdentity(identity *UserIdentity) error {
+	return api_init.GetDbh().Create(identity).Error
+}
+
+// CreateExternalUser registers the user signed up by the provider together with the identity
+func CreateExternalUser(account *user.User, identity *UserIdentity) error {
+	return api_init.GetDbh().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(account).Error; err != nil {
+			return err
+		}
+
+		identity.UserID = account.ID
+		return tx.Create(identity).Error
+	})
+}
+
+func TouchUserIdentity(id uuid.UUID, email string, now time.Time) error {
+	return api_init.GetDbh().Model(&UserIdentity{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"email":        email,
+			"last_used_at": now,
+		}).Error
+}
+
+// DeleteUserIdentity unlinks the identity of the user, false means it does not exist
+func DeleteUserIdentity(userId uuid.UUID, id uuid.UUID) (bool, error) {
+	result := api_init.GetDbh().Where("id = ? AND user_id = ?", id, userId).Delete(&UserIdentity{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// CreateExternalLoginState stores the state and drops expired ones, users often do not come back from the provider
+func CreateExternalLoginState(state *ExternalLoginState) error {
+	return api_init.GetDbh().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("expires_at < ?", state.CreatedAt).Delete(&ExternalLoginState{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(state).Error
+	})
+}
+
+// UseExternalLoginState deletes the state and returns it, nil means the state is unknown, expired or already used
+func UseExternalLoginState(provider string, purpose string, hash string, now time.Time) (*ExternalLoginState, error) {
+	var result []ExternalLoginState
+	err := api_init.GetDbh().Raw(
+		"DELETE FROM external_login_states WHERE state_hash = ? AND provider = ? AND purpose = ? RETURNING *",
+		hash, provider, purpose,
+	).Scan(&result).Error
+	if err != nil || len(result) == 0 || !result[0].ExpiresAt.After(now) {
+		return nil, err
+	}
+	return &result[0], nil
+}
+
+func CreateApiKey(apiKey *ApiKey) error {
+	return api_init.GetDbh().Create(apiKey).Error
+}
+
+// GetApiKeyByHash returns the key with the hash, revoked and expired keys included, nil means it is unknown
+func GetApiKeyByHash(hash string) (*ApiKey, error) {
+	var result ApiKey
+	err := api_init.GetDbh().Where("key_hash = ?", hash).Limit(1).Find(&result).Error
+	if err != nil || result.ID == uuid.Nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetApiKeys returns keys of the user which are not revoked, expired ones included, so the owner sees them
+func GetApiKeys(userId uuid.UUID) ([]ApiKey, error) {
+	var result []ApiKey
+	err := api_init.GetDbh().Where("user_id = ? AND revoked_at IS NULL", userId).Order("created_at").Find(&result).Error
+	return result, err
+}
+
+func CountActiveApiKeys(userId uuid.UUID, now time.Time) (int64, error) {
+	var count int64
+	err := api_init.GetDbh().Model(&ApiKey{}).
+		Where("user_id = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", userId, now).
+		Count(&count).Error
+	return count, err
+}
+
+// TouchApiKey records the use of the key, it is written only when the last one is older than usedBefore
+func TouchApiKey(id uuid.UUID, ip string, now time.Time, usedBefore time.Time) error {
+	return api_init.GetDbh().Model(&ApiKey{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", id, usedBefore).
+		Updates(map[string]interface{}{
+			"last_used_at": now,
+			"last_used_ip": ip,
+		}).Error
+}
+
+// RevokeApiKey revokes the key of the user, false means there is no such key which is not revoked
+func RevokeApiKey(userId uuid.UUID, id uuid.UUID, now time.Time) (bool, error) {
+	result := api_init.GetDbh().Model(&ApiKey{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userId).
+		Update("revoked_at", now)
+	return result.RowsAffected > 0, result.Error
+}
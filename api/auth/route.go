@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"github.com/gin-gonic/gin"
+	"user-service/api/user"
+)
+
+const UriAuth = "/auth"
+const UriAuthLogin = "/login"
+const UriAuthRefresh = "/refresh"
+const UriAuthLogout = "/logout"
+const UriAuthValidate = "/validate"
+const UriAuthVerifyEmail = "/verify-email"
+const UriAuthResendVerification = "/resend-verification"
+const UriAuthPasswordForgot = "/password/forgot"
+const UriAuthPasswordReset = "/password/reset"
+const UriAuthPasswordChange = "/password/change"
+const UriAuthEmailChangeConfirm = "/email-change/confirm"
+const UriAuthMfaVerify = "/mfa/verify"
+const UriAuthMagicLink = "/magic-link"
+const UriAuthMagicLinkConsume = "/magic-link/consume"
+const UriAuthWebauthnLoginBegin = "/webauthn/login/begin"
+const UriAuthWebauthnLoginFinish = "/webauthn/login/finish"
+const UriAuthExternalProviders = "/external/providers"
+const UriAuthExternalBegin = "/external/:provider/begin"
+const UriAuthExternalBeginS = "/external/%s/begin"
+const UriAuthExternalFinish = "/external/:provider/finish"
+const UriAuthExternalFinishS = "/external/%s/finish"
+
+func InitAuthRoutes(route *gin.Engine) {
+	group := route.Group(UriAuth)
+	group.POST(UriAuthLogin, Login)
+	group.POST(UriAuthRefresh, Refresh)
+	group.POST(UriAuthLogout, Logout)
+	group.GET(UriAuthValidate, RequireAuth(), ValidateToken)
+	group.POST(UriAuthVerifyEmail, VerifyEmail)
+	group.POST(UriAuthResendVerification, ResendVerification)
+	group.POST(UriAuthPasswordForgot, ForgotPassword)
+	group.POST(UriAuthPasswordReset, ResetPassword)
+	group.POST(UriAuthPasswordChange, RequirePasswordChangeAuth(), ChangePassword)
+	group.POST(UriAuthEmailChangeConfirm, ConfirmEmailChange)
+	group.POST(UriAuthMfaVerify, VerifyMfa)
+	group.POST(UriAuthMagicLink, RequestMagicLink)
+	group.GET(UriAuthMagicLinkConsume, ConsumeMagicLink)
+	group.POST(UriAuthWebauthnLoginBegin, BeginWebauthnLogin)
+	group.POST(UriAuthWebauthnLoginFinish, FinishWebauthnLogin)
+	group.GET(UriAuthExternalProviders, GetExternalProviderList)
+	group.POST(UriAuthExternalBegin, BeginExternalLogin)
+	group.POST(UriAuthExternalFinish, FinishExternalLogin)
+
+	// These requests live under /user, but they need the token owner, so they are served by auth. Credentials are
+	// managed only by the signed-in user, not by API keys
+	route.POST(user.UriUser+user.UriUserEmailChange, RequireInteractiveAuth(), RequestEmailChange)
+	route.POST(user.UriUser+user.UriUserMfaTotp, RequireInteractiveAuth(), EnrollTotp)
+	route.POST(user.UriUser+user.UriUserMfaTotpVerify, RequireInteractiveAuth(), ConfirmTotp)
+	route.DELETE(user.UriUser+user.UriUserMfaTotp, RequireInteractiveAuth(), DisableTotp)
+	route.POST(user.UriUser+user.UriUserWebauthnRegisterBegin, RequireInteractiveAuth(), BeginWebauthnRegistration)
+	route.POST(user.UriUser+user.UriUserWebauthnRegisterFinish, RequireInteractiveAuth(), FinishWebauthnRegistration)
+	route.GET(user.UriUser+user.UriUserWebauthnCredentials, RequireAuth(), GetWebauthnCredentialList)
+	route.DELETE(user.UriUser+user.UriUserWebauthnCredential, RequireInteractiveAuth(), DeleteWebauthnCredentialById)
+	route.GET(user.UriUser+user.UriUserSessions, RequireAuth(), GetSessionList)
+	route.DELETE(user.UriUser+user.UriUserSessions, RequireInteractiveAuth(), DeleteSessions)
+	route.DELETE(user.UriUser+user.UriUserSession, RequireInteractiveAuth(), DeleteSessionById)
+	route.GET(user.UriUser+user.UriUserLogins, RequireAuth(), GetLoginList)
+	route.GET(user.UriUser+user.UriUserIdentities, RequireAuth(), GetUserIdentityList)
+	route.POST(user.UriUser+user.UriUserIdentityLinkBegin, RequireInteractiveAuth(), BeginUserIdentityLink)
+	route.POST(user.UriUser+user.UriUserIdentityLinkFinish, RequireInteractiveAuth(), FinishUserIdentityLink)
+	route.DELETE(user.UriUser+user.UriUserIdentity, RequireInteractiveAuth(), DeleteUserIdentityById)
+	route.POST(user.UriUser+user.UriUserApiKeys, RequireInteractiveAuth(), CreateUserApiKey)
+	route.GET(user.UriUser+user.UriUserApiKeys, RequireAuth(), GetApiKeyList)
+	route.DELETE(user.UriUser+user.UriUserApiKey, RequireInteractiveAuth(), DeleteApiKeyById)
+}
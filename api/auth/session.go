@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"github.com/apiboxgo/library-utils/dictionary"
+	"github.com/apiboxgo/library-utils/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// sessionTouchInterval limits writes of the last seen time, the idle timeout is checked with this precision
+const sessionTouchInterval = time.Minute
+
+const sessionUserAgentLength = 512
+
+// startSession signs the user in on the device of the request and writes the token pair of the new session
+func startSession(c *gin.Context, userId uuid.UUID) {
+	now := time.Now()
+	session := &Session{
+		ID:         uuid.New(),
+		UserID:     userId,
+		UserAgent:  sessionUserAgent(c),
+		Ip:         c.ClientIP(),
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(LoadConfig().SessionAbsoluteTimeout),
+	}
+
+	pair, refreshToken, err := newTokenPair(session, now)
+	if err == nil {
+		err = CreateSession(session, refreshToken)
+	}
+
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, pair)
+}
+
+// SessionRevokeHook revokes what was issued in sessions of the user, it runs in the transaction of the revocation.
+// sessionId is the revoked session, uuid.Nil means all sessions of the user except keep (uuid.Nil keeps none).
+type SessionRevokeHook func(tx *gorm.DB, userId uuid.UUID, sessionId uuid.UUID, keep uuid.UUID, now time.Time) error
+
+var sessionRevokeHooks = map[string]SessionRevokeHook{}
+var sessionRevokeHooksMutex sync.RWMutex
+
+// RegisterSessionRevokeHook adds the hook called whenever sessions are revoked, a hook of the same name is replaced
+func RegisterSessionRevokeHook(name string, hook SessionRevokeHook) {
+	sessionRevokeHooksMutex.Lock()
+	defer sessionRevokeHooksMutex.Unlock()
+	sessionRevokeHooks[name] = hook
+}
+
+func runSessionRevokeHooks(tx *gorm.DB, userId uuid.UUID, sessionId uuid.UUID, keep uuid.UUID, now time.Time) error {
+	sessionRevokeHooksMutex.RLock()
+	defer sessionRevokeHooksMutex.RUnlock()
+
+	for _, hook := range sessionRevokeHooks {
+		if err := hook(tx, userId, sessionId, keep, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsSessionActive tells whether the session exists and is neither revoked nor past the idle or absolute timeout
+func IsSessionActive(sessionId uuid.UUID, now time.Time) (bool, error) {
+	session, err := GetSession(sessionId)
+	if err != nil || session == nil {
+		return false, err
+	}
+	return isSessionActive(session, LoadConfig(), now), nil
+}
+
+// isSessionActive tells whether the session is neither revoked nor past the idle or absolute timeout
+func isSessionActive(session *Session, config Config, now time.Time) bool {
+	return session.RevokedAt == nil &&
+		session.ExpiresAt.After(now) &&
+		session.LastSeenAt.Add(config.SessionIdleTimeout).After(now)
+}
+
+// touchSession moves the last seen time of the session, a failure only is logged
+func touchSession(c *gin.Context, sessionId uuid.UUID, now time.Time) {
+	err := TouchSession(sessionId, c.ClientIP(), sessionUserAgent(c), now, now.Add(-sessionTouchInterval))
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+	}
+}
+
+func sessionUserAgent(c *gin.Context) string {
+	userAgent := []rune(c.Request.UserAgent())
+	if len(userAgent) > sessionUserAgentLength {
+		userAgent = userAgent[:sessionUserAgentLength]
+	}
+	return string(userAgent)
+}
+
+func sessionDto(session Session, currentId uuid.UUID) SessionDto {
+	return SessionDto{
+		ID:         session.ID,
+		UserAgent:  session.UserAgent,
+		Ip:         session.Ip,
+		CreatedAt:  session.CreatedAt,
+		LastSeenAt: session.LastSeenAt,
+		ExpiresAt:  session.ExpiresAt,
+		Current:    session.ID == currentId,
+	}
+}
@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/apiboxgo/library-utils/dictionary"
+	"github.com/apiboxgo/library-utils/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"net/http"
+	"time"
+	"user-service/api/audit"
+	"user-service/api/job"
+	"user-service/api/lockout"
+	"user-service/api/user"
+	"user-service/mailer"
+)
+
+const MailTemplateAccountLocked = "account_locked"
+
+// JobTypeAccountLocked sends the lockout notice, the failed attempt does not wait for the mail
+const JobTypeAccountLocked = "auth.account_locked"
+
+type accountLockedMailData struct {
+	Email     string
+	Ip        string
+	LockedFor string
+}
+
+type accountLockedPayload struct {
+	Locale string                `json:"locale"`
+	Mail   accountLockedMailData `json:"mail"`
+}
+
+// loginThrottleKeys returns counters of the client address and, when email is known, of the account
+func loginThrottleKeys(c *gin.Context, email string) []lockout.Key {
+	keys := []lockout.Key{lockout.IpKey(c.ClientIP())}
+	if email != "" {
+		keys = append(keys, lockout.AccountKey(user.NormalizeEmail(email)))
+	}
+	return keys
+}
+
+// checkLoginThrottle answers 429 with Retry-After while the client or the account is blocked, the credentials are not
+// checked at all then
+func checkLoginThrottle(c *gin.Context, email string) bool {
+	retryAfter, err := lockout.RetryAfter(time.Now(), loginThrottleKeys(c, email)...)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return false
+	}
+
+	if retryAfter > 0 {
+		lockout.SetRetryAfter(c, retryAfter)
+		c.JSON(http.StatusTooManyRequests, &ErrorResponseDto{
+			Message: lockout.ErrorTooManyAttempts,
+		})
+		return false
+	}
+	return true
+}
+
+// recordLoginFailure counts the failed attempt, the owner of an existing account is notified when it gets locked.
+// Failures of the bookkeeping are only logged, the client gets the answer of the failed attempt anyway.
+func recordLoginFailure(c *gin.Context, email string, userId uuid.UUID) {
+	now := time.Now()
+	for _, key := range loginThrottleKeys(c, email) {
+		result, err := lockout.RecordFailure(key, now)
+		if err != nil {
+			utils.LogError(dictionary.SomethingWrong, err)
+			continue
+		}
+
+		if key.Scope == lockout.ScopeAccount && result.Locked && userId != uuid.Nil {
+			notifyAccountLocked(c, email, userId, result.BlockedUntil.Sub(now))
+		}
+	}
+}
+
+func notifyAccountLocked(c *gin.Context, email string, userId uuid.UUID, lockedFor time.Duration) {
+	if err := audit.Record(c, audit.EventAccountLocked, userId, nil); err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+	}
+
+	_, err := job.Enqueue(JobTypeAccountLocked, "", accountLockedPayload{
+		Locale: requestLocale(c),
+		Mail: accountLockedMailData{
+			Email:     email,
+			Ip:        c.ClientIP(),
+			LockedFor: formatTTL(lockedFor),
+		},
+	}, nil)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+	}
+}
+
+func runAccountLockedJob(ctx context.Context, noticeJob *job.Job, progress job.Progress) (any, error) {
+	var payload accountLockedPayload
+	if err := json.Unmarshal([]byte(noticeJob.Payload), &payload); err != nil {
+		return nil, job.Permanent(err)
+	}
+	return nil, mailer.SendTemplate(ctx, payload.Mail.Email, payload.Locale, MailTemplateAccountLocked, payload.Mail)
+}
+
+// resetLoginFailures forgets failures of the account after a successful sign-in, the counter of the client address
+// is kept because it may be shared by many users
+func resetLoginFailures(email string) {
+	if err := lockout.Reset(lockout.AccountKey(user.NormalizeEmail(email))); err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+	}
+}
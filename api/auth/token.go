@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"time"
+	"user-service/env"
+)
+
+const TokenTypeBearer = "Bearer"
+
+// ScopePasswordChange restricts the access token to the password change, it is issued when the password has expired
+const ScopePasswordChange = "password:change"
+
+type Config struct {
+	Secret          []byte
+	Issuer          string
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	// SessionIdleTimeout ends the session without refresh or requests, SessionAbsoluteTimeout ends it anyway
+	SessionIdleTimeout     time.Duration
+	SessionAbsoluteTimeout time.Duration
+	// MfaEncryptionKey encrypts TOTP secrets at rest, AES-256 key in base64
+	MfaEncryptionKey []byte
+}
+
+func LoadConfig() Config {
+	mfaEncryptionKey, _ := base64.StdEncoding.DecodeString(env.String("AUTH_MFA_ENCRYPTION_KEY", ""))
+
+	return Config{
+		Secret:                 []byte(env.String("AUTH_TOKEN_SECRET", "")),
+		Issuer:                 env.String("AUTH_TOKEN_ISSUER", "user-service"),
+		AccessTokenTTL:         env.Duration("AUTH_ACCESS_TOKEN_TTL", 15*time.Minute),
+		RefreshTokenTTL:        env.Duration("AUTH_REFRESH_TOKEN_TTL", 30*24*time.Hour),
+		SessionIdleTimeout:     env.Duration("AUTH_SESSION_IDLE_TIMEOUT", 7*24*time.Hour),
+		SessionAbsoluteTimeout: env.Duration("AUTH_SESSION_ABSOLUTE_TIMEOUT", 30*24*time.Hour),
+		MfaEncryptionKey:       mfaEncryptionKey,
+	}
+}
+
+// Validate fails when tokens can not be signed or MFA secrets encrypted safely, it is checked at the service start
+func (config Config) Validate() error {
+	if len(config.Secret) == 0 {
+		return errors.New(ErrorTokenSecretMissing)
+	}
+	if len(config.MfaEncryptionKey) != 32 {
+		return errors.New(ErrorMfaKeyInvalid)
+	}
+	return nil
+}
+
+// Claims of the access token, Subject is the user id. SessionID is empty in the token restricted to the password change.
+type Claims struct {
+	Roles     []string `json:"roles,omitempty"`
+	Scope     string   `json:"scope,omitempty"`
+	SessionID string   `json:"sid,omitempty"`
+	jwt.RegisteredClaims
+}
+
+func (claims *Claims) UserID() uuid.UUID {
+	id, _ := uuid.Parse(claims.Subject)
+	return id
+}
+
+// SessionId returns uuid.Nil for tokens without a session
+func (claims *Claims) SessionId() uuid.UUID {
+	id, _ := uuid.Parse(claims.SessionID)
+	return id
+}
+
+func IssueAccessToken(config Config, userId uuid.UUID, sessionId uuid.UUID, roles []string, scope string, now time.Time) (string, error) {
+	claims := Claims{
+		Roles: roles,
+		Scope: scope,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    config.Issuer,
+			Subject:   userId.String(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(config.AccessTokenTTL)),
+			ID:        uuid.NewString(),
+		},
+	}
+	if sessionId != uuid.Nil {
+		claims.SessionID = sessionId.String()
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(config.Secret)
+}
+
+func ParseAccessToken(config Config, raw string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		return config.Secret, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(config.Issuer),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.UserID() == uuid.Nil {
+		return nil, errors.New(ErrorInvalidToken)
+	}
+	return claims, nil
+}
+
+// NewOpaqueToken returns a random url-safe token and its hash for storing
+func NewOpaqueToken() (string, string, error) {
+	buffer := make([]byte, 32)
+	if _, err := rand.Read(buffer); err != nil {
+		return "", "", err
+	}
+
+	raw := base64.RawURLEncoding.EncodeToString(buffer)
+	return raw, HashToken(raw), nil
+}
+
+func HashToken(raw string) string {
+	hash := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(hash[:])
+}
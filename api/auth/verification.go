@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"slices"
+	"time"
+	"user-service/api/job"
+	"user-service/api/user"
+	"user-service/env"
+	"user-service/mailer"
+)
+
+const TokenPurposeVerifyEmail = "verify_email"
+const MailTemplateVerifyEmail = "verify_email"
+
+// JobTypeVerificationRequested and JobTypePasswordResetRequested send links requested by email, the answer does not
+// wait for the lookup and the mail, so its time does not tell whether the address is registered
+const JobTypeVerificationRequested = "auth.verification_requested"
+const JobTypePasswordResetRequested = "auth.password_reset_requested"
+
+type emailJobPayload struct {
+	Email  string `json:"email"`
+	Locale string `json:"locale"`
+}
+
+// Actions which can be restricted for users with unverified email by AUTH_UNVERIFIED_RESTRICTED_ACTIONS
+const ActionLogin = "login"
+const ActionAdmin = "admin"
+
+// InitAuthJobs registers handlers of auth jobs, it has to be called before workers are started
+func InitAuthJobs() {
+	job.RegisterHandler(user.JobTypeUserCreated, runUserCreatedJob)
+	job.RegisterHandler(JobTypeVerificationRequested, runVerificationRequestedJob)
+	job.RegisterHandler(JobTypePasswordResetRequested, runPasswordResetRequestedJob)
+	job.RegisterHandler(JobTypeMagicLinkRequested, runMagicLinkRequestedJob)
+	job.RegisterHandler(JobTypeNewDeviceLogin, runNewDeviceLoginJob)
+	job.RegisterHandler(JobTypeAccountLocked, runAccountLockedJob)
+}
+
+// IsRestrictedForUnverified tells whether the action requires a verified email
+func IsRestrictedForUnverified(action string) bool {
+	return slices.Contains(env.List("AUTH_UNVERIFIED_RESTRICTED_ACTIONS", []string{ActionAdmin}), action)
+}
+
+// sendVerification issues the verification token and sends the link to the user. It does nothing for verified
+// users and for users who requested the link too often.
+func sendVerification(ctx context.Context, userId uuid.UUID, locale string, isRetry bool) error {
+	config := loadActionTokenConfig("AUTH_VERIFICATION", 24*time.Hour)
+
+	account, err := user.GetOneById(user.RequestUserIdDTO{ID: userId.String()})
+	if err != nil {
+		return err
+	}
+
+	if account == nil || account.ID == uuid.Nil || !account.DeletedAt.IsZero() || account.EmailVerifiedAt != nil {
+		return nil
+	}
+
+	now := time.Now()
+	isLimited, err := isActionTokenLimited(account.ID, TokenPurposeVerifyEmail, config, isRetry, now)
+	if err != nil || isLimited {
+		return err
+	}
+
+	raw, err := issueActionToken(account.ID, TokenPurposeVerifyEmail, config.TokenTTL, now)
+	if err != nil {
+		return err
+	}
+
+	return mailer.SendTemplate(ctx, account.Email, locale, MailTemplateVerifyEmail, actionTokenMailData{
+		Email:     account.Email,
+		Link:      fmt.Sprintf(config.Url, raw),
+		ExpiresIn: formatTTL(config.TokenTTL),
+	})
+}
+
+func runUserCreatedJob(ctx context.Context, createdJob *job.Job, progress job.Progress) (any, error) {
+	var payload user.UserCreatedPayload
+	if err := json.Unmarshal([]byte(createdJob.Payload), &payload); err != nil {
+		return nil, job.Permanent(err)
+	}
+
+	return nil, sendVerification(ctx, payload.UserID, "", createdJob.Attempts > 1)
+}
+
+// runVerificationRequestedJob sends the link when the email belongs to a user, unknown emails are skipped silently
+func runVerificationRequestedJob(ctx context.Context, requestedJob *job.Job, progress job.Progress) (any, error) {
+	var payload emailJobPayload
+	if err := json.Unmarshal([]byte(requestedJob.Payload), &payload); err != nil {
+		return nil, job.Permanent(err)
+	}
+
+	account, err := user.GetOneByEmail(payload.Email)
+	if err != nil || account == nil || account.ID == uuid.Nil {
+		return nil, err
+	}
+
+	return nil, sendVerification(ctx, account.ID, payload.Locale, requestedJob.Attempts > 1)
+}
@@ -0,0 +1,427 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	"strings"
+	"time"
+	"user-service/api/user"
+	"user-service/env"
+)
+
+const WebauthnPurposeRegistration = "registration"
+const WebauthnPurposeLogin = "login"
+const WebauthnPurposeMfa = "mfa"
+
+const webauthnCredentialNameLength = 100
+
+var ErrWebauthnSessionInvalid = errors.New(ErrorWebauthnSessionInvalid)
+var ErrWebauthnResponseInvalid = errors.New(ErrorWebauthnResponseInvalid)
+var ErrWebauthnCredentialExists = errors.New(ErrorWebauthnCredentialExists)
+
+type webauthnConfig struct {
+	RPID    string
+	RPName  string
+	Origins []string
+	Timeout time.Duration
+}
+
+func loadWebauthnConfig() webauthnConfig {
+	return webauthnConfig{
+		RPID:    env.String("AUTH_WEBAUTHN_RP_ID", "localhost"),
+		RPName:  env.String("AUTH_WEBAUTHN_RP_NAME", "user-service"),
+		Origins: env.List("AUTH_WEBAUTHN_ORIGINS", []string{"http://localhost:8081"}),
+		Timeout: env.Duration("AUTH_WEBAUTHN_TIMEOUT", 5*time.Minute),
+	}
+}
+
+func newWebauthn(config webauthnConfig) (*webauthn.WebAuthn, error) {
+	timeout := webauthn.TimeoutConfig{
+		Enforce:    true,
+		Timeout:    config.Timeout,
+		TimeoutUVD: config.Timeout,
+	}
+
+	return webauthn.New(&webauthn.Config{
+		RPID:          config.RPID,
+		RPDisplayName: config.RPName,
+		RPOrigins:     config.Origins,
+		Timeouts: webauthn.TimeoutsConfig{
+			Login:        timeout,
+			Registration: timeout,
+		},
+	})
+}
+
+// webauthnUser is the user as the library sees it, the user handle is the user id, so a discoverable credential leads
+// to the account without the email
+type webauthnUser struct {
+	id          uuid.UUID
+	name        string
+	credentials []WebauthnCredential
+}
+
+func (account *webauthnUser) WebAuthnID() []byte {
+	return account.id[:]
+}
+
+func (account *webauthnUser) WebAuthnName() string {
+	return account.name
+}
+
+func (account *webauthnUser) WebAuthnDisplayName() string {
+	return account.name
+}
+
+func (account *webauthnUser) WebAuthnCredentials() []webauthn.Credential {
+	result := make([]webauthn.Credential, 0, len(account.credentials))
+	for _, credential := range account.credentials {
+		var transports []protocol.AuthenticatorTransport
+		for _, transport := range strings.Split(credential.Transports, ",") {
+			if transport != "" {
+				transports = append(transports, protocol.AuthenticatorTransport(transport))
+			}
+		}
+
+		result = append(result, webauthn.Credential{
+			ID:              credential.CredentialID,
+			PublicKey:       credential.PublicKey,
+			AttestationType: credential.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: credential.BackupEligible,
+				BackupState:    credential.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    credential.Aaguid,
+				SignCount: credential.SignCount,
+			},
+		})
+	}
+	return result
+}
+
+// credential returns the stored credential with the id sent by the authenticator
+func (account *webauthnUser) credential(credentialId []byte) *WebauthnCredential {
+	for i := range account.credentials {
+		if bytes.Equal(account.credentials[i].CredentialID, credentialId) {
+			return &account.credentials[i]
+		}
+	}
+	return nil
+}
+
+func loadWebauthnUser(userId uuid.UUID, name string) (*webauthnUser, error) {
+	credentials, err := GetWebauthnCredentials(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &webauthnUser{
+		id:          userId,
+		name:        name,
+		credentials: credentials,
+	}, nil
+}
+
+// beginWebauthnRegistration returns options for navigator.credentials.create(), already registered authenticators are
+// excluded, so one device is not added twice
+func beginWebauthnRegistration(account *user.UserItemResultDto, now time.Time) (*WebauthnCeremonyDto, error) {
+	config := loadWebauthnConfig()
+	relyingParty, err := newWebauthn(config)
+	if err != nil {
+		return nil, err
+	}
+
+	owner, err := loadWebauthnUser(account.ID, account.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	creation, sessionData, err := relyingParty.BeginRegistration(owner,
+		webauthn.WithExclusions(webauthn.Credentials(owner.WebAuthnCredentials()).CredentialDescriptors()),
+		webauthn.WithAuthenticatorSelection(protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementPreferred,
+			UserVerification: protocol.VerificationPreferred,
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := createWebauthnSession(&account.ID, WebauthnPurposeRegistration, "", sessionData, config.Timeout, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return &WebauthnCeremonyDto{
+		SessionToken: raw,
+		Options:      creation,
+	}, nil
+}
+
+// finishWebauthnRegistration checks the attestation against the started session and stores the new credential
+func finishWebauthnRegistration(account *user.UserItemResultDto, request RequestWebauthnRegisterDto, now time.Time) (*WebauthnCredential, error) {
+	config := loadWebauthnConfig()
+	relyingParty, err := newWebauthn(config)
+	if err != nil {
+		return nil, err
+	}
+
+	session, sessionData, err := useWebauthnSession(WebauthnPurposeRegistration, HashToken(request.SessionToken), now)
+	if err != nil {
+		return nil, err
+	}
+	if session.UserID == nil || *session.UserID != account.ID {
+		return nil, ErrWebauthnSessionInvalid
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(request.Credential)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrWebauthnResponseInvalid, err)
+	}
+
+	owner, err := loadWebauthnUser(account.ID, account.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := relyingParty.CreateCredential(owner, *sessionData, parsed)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrWebauthnResponseInvalid, err)
+	}
+
+	ownerId, err := GetWebauthnCredentialOwner(created.ID)
+	if err != nil {
+		return nil, err
+	}
+	if ownerId != uuid.Nil {
+		return nil, ErrWebauthnCredentialExists
+	}
+
+	transports := make([]string, 0, len(created.Transport))
+	for _, transport := range created.Transport {
+		transports = append(transports, string(transport))
+	}
+
+	name := []rune(strings.TrimSpace(request.Name))
+	if len(name) > webauthnCredentialNameLength {
+		name = name[:webauthnCredentialNameLength]
+	}
+
+	credential := &WebauthnCredential{
+		UserID:          account.ID,
+		CredentialID:    created.ID,
+		PublicKey:       created.PublicKey,
+		AttestationType: created.AttestationType,
+		Aaguid:          created.Authenticator.AAGUID,
+		SignCount:       created.Authenticator.SignCount,
+		Transports:      strings.Join(transports, ","),
+		BackupEligible:  created.Flags.BackupEligible,
+		BackupState:     created.Flags.BackupState,
+		Name:            string(name),
+		CreatedAt:       now,
+	}
+	return credential, CreateWebauthnCredential(credential)
+}
+
+// beginWebauthnLogin returns options for a passwordless login with a discoverable credential, the authenticator picks
+// the account. User verification is required, so the passkey replaces both the password and the second factor.
+func beginWebauthnLogin(now time.Time) (*WebauthnCeremonyDto, error) {
+	config := loadWebauthnConfig()
+	relyingParty, err := newWebauthn(config)
+	if err != nil {
+		return nil, err
+	}
+
+	assertion, sessionData, err := relyingParty.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := createWebauthnSession(nil, WebauthnPurposeLogin, "", sessionData, config.Timeout, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return &WebauthnCeremonyDto{
+		SessionToken: raw,
+		Options:      assertion,
+	}, nil
+}
+
+// finishWebauthnLogin checks the assertion of the passwordless login and returns the user of the credential
+func finishWebauthnLogin(request RequestWebauthnLoginDto, now time.Time) (uuid.UUID, error) {
+	relyingParty, err := newWebauthn(loadWebauthnConfig())
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	_, sessionData, err := useWebauthnSession(WebauthnPurposeLogin, HashToken(request.SessionToken), now)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(request.Credential)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("%w: %w", ErrWebauthnResponseInvalid, err)
+	}
+
+	var owner *webauthnUser
+	findOwner := func(credentialId []byte, userHandle []byte) (webauthn.User, error) {
+		ownerId, err := GetWebauthnCredentialOwner(credentialId)
+		if err != nil {
+			return nil, err
+		}
+		if ownerId == uuid.Nil || !bytes.Equal(ownerId[:], userHandle) {
+			return nil, errors.New(ErrorWebauthnCredentialUnknown)
+		}
+
+		owner, err = loadWebauthnUser(ownerId, "")
+		return owner, err
+	}
+
+	validated, err := relyingParty.ValidateDiscoverableLogin(findOwner, *sessionData, parsed)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("%w: %w", ErrWebauthnResponseInvalid, err)
+	}
+	return owner.id, storeWebauthnCredentialUse(owner, validated, now)
+}
+
+// beginWebauthnSecondFactor prepares the assertion for the login which is waiting for the second factor. The session
+// is bound to the MFA challenge token, so the client sends back only the challenge token and the assertion.
+func beginWebauthnSecondFactor(userId uuid.UUID, challengeHash string, now time.Time) (*protocol.CredentialAssertion, error) {
+	config := loadWebauthnConfig()
+	relyingParty, err := newWebauthn(config)
+	if err != nil {
+		return nil, err
+	}
+
+	owner, err := loadWebauthnUser(userId, "")
+	if err != nil {
+		return nil, err
+	}
+
+	assertion, sessionData, err := relyingParty.BeginLogin(owner, webauthn.WithUserVerification(protocol.VerificationPreferred))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = createWebauthnSession(&userId, WebauthnPurposeMfa, challengeHash, sessionData, config.Timeout, now)
+	return assertion, err
+}
+
+// verifyWebauthnSecondFactor checks the assertion sent with the MFA challenge, the session is used either way
+func verifyWebauthnSecondFactor(userId uuid.UUID, challengeHash string, credential json.RawMessage, now time.Time) (bool, error) {
+	relyingParty, err := newWebauthn(loadWebauthnConfig())
+	if err != nil {
+		return false, err
+	}
+
+	session, sessionData, err := useWebauthnSession(WebauthnPurposeMfa, challengeHash, now)
+	if errors.Is(err, ErrWebauthnSessionInvalid) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if session.UserID == nil || *session.UserID != userId {
+		return false, nil
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(credential)
+	if err != nil {
+		return false, nil
+	}
+
+	owner, err := loadWebauthnUser(userId, "")
+	if err != nil {
+		return false, err
+	}
+
+	validated, err := relyingParty.ValidateLogin(owner, *sessionData, parsed)
+	if err != nil {
+		return false, nil
+	}
+
+	err = storeWebauthnCredentialUse(owner, validated, now)
+	if errors.Is(err, ErrWebauthnResponseInvalid) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// storeWebauthnCredentialUse saves the signature counter, a counter which did not grow is rejected as a cloned key
+func storeWebauthnCredentialUse(owner *webauthnUser, validated *webauthn.Credential, now time.Time) error {
+	stored := owner.credential(validated.ID)
+	if stored == nil {
+		return ErrWebauthnResponseInvalid
+	}
+	if validated.Authenticator.CloneWarning {
+		return fmt.Errorf("%w: %s", ErrWebauthnResponseInvalid, ErrorWebauthnCredentialCloned)
+	}
+
+	isUsed, err := UseWebauthnCredential(stored.ID, validated.Authenticator.SignCount, validated.Flags.BackupState, now)
+	if err != nil {
+		return err
+	}
+	if !isUsed {
+		return fmt.Errorf("%w: %s", ErrWebauthnResponseInvalid, ErrorWebauthnCredentialCloned)
+	}
+	return nil
+}
+
+// createWebauthnSession stores the ceremony state. Without tokenHash a new token is issued and returned, otherwise the
+// session is bound to the given token.
+func createWebauthnSession(
+	userId *uuid.UUID,
+	purpose string,
+	tokenHash string,
+	sessionData *webauthn.SessionData,
+	ttl time.Duration,
+	now time.Time,
+) (string, error) {
+	data, err := json.Marshal(sessionData)
+	if err != nil {
+		return "", err
+	}
+
+	raw := ""
+	if tokenHash == "" {
+		raw, tokenHash, err = NewOpaqueToken()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return raw, CreateWebauthnSession(&WebauthnSession{
+		UserID:      userId,
+		Purpose:     purpose,
+		TokenHash:   tokenHash,
+		SessionData: string(data),
+		ExpiresAt:   now.Add(ttl),
+		CreatedAt:   now,
+	})
+}
+
+// useWebauthnSession consumes the session, an unknown or expired one is ErrWebauthnSessionInvalid
+func useWebauthnSession(purpose string, hash string, now time.Time) (*WebauthnSession, *webauthn.SessionData, error) {
+	session, err := UseWebauthnSession(purpose, hash, now)
+	if err != nil {
+		return nil, nil, err
+	}
+	if session == nil {
+		return nil, nil, ErrWebauthnSessionInvalid
+	}
+
+	var sessionData webauthn.SessionData
+	if err := json.Unmarshal([]byte(session.SessionData), &sessionData); err != nil {
+		return nil, nil, err
+	}
+	return session, &sessionData, nil
+}
@@ -0,0 +1,75 @@
+package job
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"time"
+)
+
+// ============================== Request DTO ==========================================================================
+
+type RequestJobIdDTO struct {
+	ID string `uri:"id" binding:"required,uuid" example:"987fbc97-4bed-5078-9f07-9141ba07c9f3"`
+}
+
+// ============================== Response DTO =========================================================================
+
+type ErrorResponseDto struct {
+	Message string `json:"message"`
+}
+
+type SuccessResponseDto struct {
+	Message string `json:"message"`
+}
+
+type JobCreatedDto struct {
+	ID       uuid.UUID `json:"id"`
+	Status   string    `json:"status"`
+	Location string    `json:"location"`
+}
+
+type JobResultDto struct {
+	ID            uuid.UUID       `json:"id"`
+	Type          string          `json:"type"`
+	Status        string          `json:"status"`
+	Attempts      int             `json:"attempts"`
+	MaxAttempts   int             `json:"max_attempts"`
+	ProgressDone  int             `json:"progress_done"`
+	ProgressTotal int             `json:"progress_total"`
+	Result        json.RawMessage `json:"result" swaggertype:"object"`
+	Error         string          `json:"error,omitempty"`
+	RunAt         time.Time       `json:"run_at"`
+	StartedAt     *time.Time      `json:"started_at"`
+	FinishedAt    *time.Time      `json:"finished_at"`
+	CreatedAt     time.Time       `json:"created_at"`
+}
+
+func NewJobResultDto(job *Job) JobResultDto {
+	resultDto := JobResultDto{
+		ID:            job.ID,
+		Type:          job.Type,
+		Status:        job.Status,
+		Attempts:      job.Attempts,
+		MaxAttempts:   job.MaxAttempts,
+		ProgressDone:  job.ProgressDone,
+		ProgressTotal: job.ProgressTotal,
+		Error:         job.Error,
+		RunAt:         job.RunAt,
+		StartedAt:     job.StartedAt,
+		FinishedAt:    job.FinishedAt,
+		CreatedAt:     job.CreatedAt,
+	}
+
+	if job.Result != nil {
+		resultDto.Result = json.RawMessage(*job.Result)
+	}
+	return resultDto
+}
+
+func NewJobCreatedDto(job *Job) JobCreatedDto {
+	return JobCreatedDto{
+		ID:       job.ID,
+		Status:   job.Status,
+		Location: UriJobs + "/" + job.ID.String(),
+	}
+}
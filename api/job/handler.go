@@ -0,0 +1,143 @@
+package job
+
+import (
+	"fmt"
+	"github.com/apiboxgo/library-utils/dictionary"
+	"github.com/apiboxgo/library-utils/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"net/http"
+	"time"
+	_ "user-service/docs"
+)
+
+// ================================== Get job by ID ====================================================================
+//	@title			Getting job by id
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// GetJobItemById godoc
+// @Summary      Getting job by id
+// @Description  Status, progress and result of a long-running job, only the user or the service which created it sees it
+// @Tags         Jobs
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Security     ServiceSignature
+// @Param        id path string true "Job id (UUID)"
+// @Success      200 {object}  JobResultDto
+// @Failure      404 {object}  ErrorResponseDto
+// @Failure      422 {object}  ErrorResponseDto
+// @Router       /jobs/{id} [get]
+func GetJobItemById(c *gin.Context) {
+	requestDto, id := parseDtoId(c)
+	if id == uuid.Nil {
+		return
+	}
+
+	job, ok := getOwnJob(c, requestDto, id)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, NewJobResultDto(job))
+}
+
+// ================================== Cancel job by ID =================================================================
+//	@title			Cancel job
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// CancelJobById godoc
+// @Summary      Cancel job
+// @Description  A queued job is cancelled at once, a running job is stopped by its worker on the next heartbeat
+// @Tags         Jobs
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Security     ServiceSignature
+// @Param        id path string true "Job id (UUID)"
+// @Success      202 {object}  SuccessResponseDto
+// @Failure      404 {object}  ErrorResponseDto
+// @Failure      409 {object}  ErrorResponseDto
+// @Router       /jobs/{id}/cancel [post]
+func CancelJobById(c *gin.Context) {
+	requestDto, id := parseDtoId(c)
+	if id == uuid.Nil {
+		return
+	}
+
+	if _, ok := getOwnJob(c, requestDto, id); !ok {
+		return
+	}
+
+	isCancelled, err := RequestJobCancel(id, time.Now())
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	if isCancelled {
+		c.JSON(http.StatusAccepted, &SuccessResponseDto{
+			Message: JobCancelRequested,
+		})
+		return
+	}
+
+	c.JSON(http.StatusConflict, &ErrorResponseDto{
+		Message: fmt.Sprintf(JobIsFinished, requestDto.ID),
+	})
+}
+
+// === Sys
+// getOwnJob returns the job of the client, jobs of others and internal jobs are not found
+func getOwnJob(c *gin.Context, requestDto RequestJobIdDTO, id uuid.UUID) (*Job, bool) {
+	job, err := GetJobById(id)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return nil, false
+	}
+
+	owner := c.GetString(ContextOwner)
+	if job == nil || job.Owner == "" || job.Owner != owner {
+		c.JSON(http.StatusNotFound, &ErrorResponseDto{
+			Message: fmt.Sprintf(JobNotFound, requestDto.ID),
+		})
+		return nil, false
+	}
+
+	return job, true
+}
+
+func parseDtoId(c *gin.Context) (RequestJobIdDTO, uuid.UUID) {
+	var requestJobIdDTO RequestJobIdDTO
+	if err := c.ShouldBindUri(&requestJobIdDTO); err != nil {
+		utils.LogError(dictionary.ErrorParsingRequestBody, err)
+		c.JSON(http.StatusUnprocessableEntity, &ErrorResponseDto{
+			Message: err.Error(),
+		})
+		return requestJobIdDTO, uuid.Nil
+	}
+
+	id, err := uuid.Parse(requestJobIdDTO.ID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, &ErrorResponseDto{
+			Message: fmt.Sprintf(JobNotFound, requestJobIdDTO.ID),
+		})
+		return requestJobIdDTO, uuid.Nil
+	}
+
+	return requestJobIdDTO, id
+}
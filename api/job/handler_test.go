@@ -0,0 +1,302 @@
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/apiboxgo/library-utils/api_init"
+	"github.com/apiboxgo/library-utils/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var db *gorm.DB
+
+const testJobEcho = "test.echo"
+const testJobFail = "test.fail"
+const testJobBroken = "test.broken"
+
+const testOwner = "service:test"
+const testHeaderOwner = "X-Test-Owner"
+
+func init() {
+	api_init.TestInit("../../")
+	db = api_init.InitGlobal.Dbh
+
+	RegisterHandler(testJobEcho, func(ctx context.Context, job *Job, progress Progress) (any, error) {
+		progress(1, 1)
+		return json.RawMessage(job.Payload), nil
+	})
+	RegisterHandler(testJobFail, func(ctx context.Context, job *Job, progress Progress) (any, error) {
+		return nil, errors.New("temporary failure")
+	})
+	RegisterHandler(testJobBroken, func(ctx context.Context, job *Job, progress Progress) (any, error) {
+		return nil, Permanent(errors.New("broken payload"))
+	})
+}
+
+func TestGetJobById_SuccessfulResult(t *testing.T) {
+	clearDbTableJobs(t)
+
+	job, err := Enqueue(testJobEcho, testOwner, map[string]string{"name": "test"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var result JobResultDto
+	w := sendRequest(t, fmt.Sprintf(UriJobs+UriJobGetByIdS, job.ID.String()), "GET", nil, &result)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, job.ID, result.ID)
+	assert.Equal(t, StatusQueued, result.Status)
+}
+
+func TestGetJobById_NotFoundResult(t *testing.T) {
+	clearDbTableJobs(t)
+
+	fakeId := "987fbc97-4bed-5078-9f07-9141ba07c9f3"
+	var result ErrorResponseDto
+	w := sendRequest(t, fmt.Sprintf(UriJobs+UriJobGetByIdS, fakeId), "GET", nil, &result)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, fmt.Sprintf(JobNotFound, fakeId), result.Message)
+}
+
+func TestGetJobById_OtherOwner(t *testing.T) {
+	clearDbTableJobs(t)
+
+	job, err := Enqueue(testJobEcho, testOwner, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	internalJob, err := Enqueue(testJobEcho, "", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var result ErrorResponseDto
+	w := sendOwnerRequest(t, "service:other", fmt.Sprintf(UriJobs+UriJobGetByIdS, job.ID.String()), "GET", nil, &result)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = sendOwnerRequest(t, "service:other", fmt.Sprintf(UriJobs+UriJobCancelS, job.ID.String()), "POST", nil, &result)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Internal jobs have no owner and are not shown to anybody
+	w = sendOwnerRequest(t, "", fmt.Sprintf(UriJobs+UriJobGetByIdS, internalJob.ID.String()), "GET", nil, &result)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	notCancelled, err := GetJobById(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, StatusQueued, notCancelled.Status)
+}
+
+func TestCancelJob_QueuedJob(t *testing.T) {
+	clearDbTableJobs(t)
+
+	job, err := Enqueue(testJobEcho, testOwner, nil, []byte("input"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var result SuccessResponseDto
+	w := sendRequest(t, fmt.Sprintf(UriJobs+UriJobCancelS, job.ID.String()), "POST", nil, &result)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	cancelledJob, err := GetJobById(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, StatusCancelled, cancelledJob.Status)
+	assert.Nil(t, cancelledJob.Input)
+
+	var errorResult ErrorResponseDto
+	w = sendRequest(t, fmt.Sprintf(UriJobs+UriJobCancelS, job.ID.String()), "POST", nil, &errorResult)
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestRunOnce_Succeeded(t *testing.T) {
+	clearDbTableJobs(t)
+
+	job, err := Enqueue(testJobEcho, testOwner, map[string]string{"name": "test"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	processed, err := NewPool(LoadConfig()).RunOnce(context.Background())
+	assert.Nil(t, err)
+	assert.True(t, processed)
+
+	doneJob, err := GetJobById(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, StatusSucceeded, doneJob.Status)
+	assert.Equal(t, 1, doneJob.Attempts)
+	assert.Equal(t, 1, doneJob.ProgressDone)
+	assert.JSONEq(t, `{"name": "test"}`, *doneJob.Result)
+}
+
+func TestRunOnce_RetryWithBackoff(t *testing.T) {
+	clearDbTableJobs(t)
+
+	job, err := Enqueue(testJobFail, testOwner, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := time.Now()
+	processed, err := NewPool(LoadConfig()).RunOnce(context.Background())
+	assert.Nil(t, err)
+	assert.True(t, processed)
+
+	failedJob, err := GetJobById(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, StatusQueued, failedJob.Status)
+	assert.Equal(t, 1, failedJob.Attempts)
+	assert.Equal(t, "temporary failure", failedJob.Error)
+	assert.True(t, failedJob.RunAt.After(before))
+
+	// The job is not due yet
+	processed, err = NewPool(LoadConfig()).RunOnce(context.Background())
+	assert.Nil(t, err)
+	assert.False(t, processed)
+}
+
+func TestRunOnce_PermanentFailure(t *testing.T) {
+	clearDbTableJobs(t)
+
+	job, err := Enqueue(testJobBroken, testOwner, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewPool(LoadConfig()).RunOnce(context.Background())
+	assert.Nil(t, err)
+
+	failedJob, err := GetJobById(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, StatusFailed, failedJob.Status)
+	assert.Equal(t, "broken payload", failedJob.Error)
+}
+
+func TestRequeueStaleJobs_NoAttemptsLeft(t *testing.T) {
+	clearDbTableJobs(t)
+
+	retried, err := Enqueue(testJobEcho, testOwner, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exhausted, err := Enqueue(testJobEcho, testOwner, nil, []byte("input"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	lockedAt := now.Add(-time.Hour)
+	db.Model(&Job{}).Where("id = ?", retried.ID).Updates(map[string]interface{}{"status": StatusRunning, "attempts": 1, "locked_by": "worker-1", "locked_at": lockedAt})
+	db.Model(&Job{}).Where("id = ?", exhausted.ID).Updates(map[string]interface{}{"status": StatusRunning, "attempts": exhausted.MaxAttempts, "locked_by": "worker-1", "locked_at": lockedAt})
+
+	count, err := RequeueStaleJobs(now.Add(-time.Minute), now)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), count)
+
+	retriedJob, _ := GetJobById(retried.ID)
+	assert.Equal(t, StatusQueued, retriedJob.Status)
+
+	exhaustedJob, _ := GetJobById(exhausted.ID)
+	assert.Equal(t, StatusFailed, exhaustedJob.Status)
+	assert.Equal(t, ErrorJobStale, exhaustedJob.Error)
+	assert.Nil(t, exhaustedJob.Input)
+}
+
+func TestCompleteJob_RequeuedToOtherWorker(t *testing.T) {
+	clearDbTableJobs(t)
+
+	job, err := Enqueue(testJobEcho, testOwner, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	if _, err := ClaimJob("worker-1", []string{testJobEcho}, now); err != nil {
+		t.Fatal(err)
+	}
+	db.Model(&Job{}).Where("id = ?", job.ID).Update("locked_at", now.Add(-time.Hour))
+	if _, err := RequeueStaleJobs(now.Add(-time.Minute), now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ClaimJob("worker-2", []string{testJobEcho}, now); err != nil {
+		t.Fatal(err)
+	}
+
+	// The stale worker finishes late, the job stays with the new one
+	assert.Nil(t, CompleteJob(job.ID, "worker-1", `{}`, now))
+
+	runningJob, _ := GetJobById(job.ID)
+	assert.Equal(t, StatusRunning, runningJob.Status)
+	assert.Equal(t, "worker-2", runningJob.LockedBy)
+}
+
+func TestRetryDelay(t *testing.T) {
+	config := Config{RetryBaseDelay: time.Second, RetryMaxDelay: 10 * time.Second}
+
+	assert.GreaterOrEqual(t, RetryDelay(config, 1), time.Second)
+	assert.GreaterOrEqual(t, RetryDelay(config, 3), 4*time.Second)
+	assert.Less(t, RetryDelay(config, 3), 5*time.Second)
+	assert.GreaterOrEqual(t, RetryDelay(config, 20), 10*time.Second)
+	assert.Less(t, RetryDelay(config, 20), 12*time.Second)
+}
+
+// === Sys
+func clearDbTableJobs(t *testing.T) {
+	if err := db.Exec("truncate table jobs restart identity cascade").Error; err != nil {
+		utils.Dump(err)
+		t.Fatal(err)
+	}
+}
+
+func sendRequest(
+	t *testing.T,
+	uri string,
+	method string,
+	body io.Reader,
+	result any,
+) *httptest.ResponseRecorder {
+	return sendOwnerRequest(t, testOwner, uri, method, body, result)
+}
+
+// sendOwnerRequest sends the request of the client owning jobs of owner
+func sendOwnerRequest(t *testing.T, owner string, uri string, method string, body io.Reader, result any) *httptest.ResponseRecorder {
+	router := gin.Default()
+	InitJobRoutes(router, func(c *gin.Context) string {
+		return c.GetHeader(testHeaderOwner)
+	})
+
+	req, err := http.NewRequest(method, uri, body)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(testHeaderOwner, owner)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	err = json.NewDecoder(w.Body).Decode(&result)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return w
+}
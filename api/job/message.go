@@ -0,0 +1,7 @@
+package job
+
+const JobNotFound = "Job %s not found"
+const JobIsFinished = "Job %s is already finished"
+const JobCancelRequested = "Job cancellation requested"
+const ErrorJobStale = "Job worker stopped responding and no attempts are left"
+const ErrorJobHandlerNotFound = "Handler of job type %s not found"
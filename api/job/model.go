@@ -0,0 +1,53 @@
+package job
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+)
+
+const StatusQueued = "queued"
+const StatusRunning = "running"
+const StatusSucceeded = "succeeded"
+const StatusFailed = "failed"
+const StatusCancelled = "cancelled"
+
+type Job struct {
+	ID              uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4()"`
+	Type            string     `gorm:"type:varchar(60);not null"`
+	Owner           string     `gorm:"type:varchar(150);null;default:null"`
+	Status          string     `gorm:"type:varchar(20);not null;default:queued"`
+	Payload         string     `gorm:"type:jsonb;not null;default:'{}'"`
+	Input           []byte     `gorm:"type:bytea;null;default:null"`
+	Result          *string    `gorm:"type:jsonb;null;default:null"`
+	Error           string     `gorm:"type:text;null;default:null"`
+	Attempts        int        `gorm:"not null;default:0"`
+	MaxAttempts     int        `gorm:"not null;default:5"`
+	ProgressDone    int        `gorm:"not null;default:0"`
+	ProgressTotal   int        `gorm:"not null;default:0"`
+	CancelRequested bool       `gorm:"not null;default:false"`
+	RunAt           time.Time  `gorm:"type:timestamp;not null"`
+	LockedBy        string     `gorm:"type:varchar(120);null;default:null"`
+	LockedAt        *time.Time `gorm:"type:timestamp;null;default:null"`
+	StartedAt       *time.Time `gorm:"type:timestamp;null;default:null"`
+	FinishedAt      *time.Time `gorm:"type:timestamp;null;default:null"`
+	CreatedAt       time.Time  `gorm:"type:timestamp;not null"`
+	UpdatedAt       time.Time  `gorm:"type:timestamp;null;default:null"`
+}
+
+func (p *Job) BeforeCreate(tx *gorm.DB) (err error) {
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	if p.Status == "" {
+		p.Status = StatusQueued
+	}
+	if p.RunAt.IsZero() {
+		p.RunAt = time.Now()
+	}
+	return
+}
+
+func (p *Job) IsFinished() bool {
+	return p.Status == StatusSucceeded || p.Status == StatusFailed || p.Status == StatusCancelled
+}
@@ -0,0 +1,161 @@
+package job
+
+import (
+	"github.com/apiboxgo/library-utils/api_init"
+	"github.com/google/uuid"
+	"time"
+)
+
+func CreateJob(job *Job) error {
+	return api_init.GetDbh().Create(job).Error
+}
+
+func GetJobById(id uuid.UUID) (*Job, error) {
+	var result Job
+	err := api_init.GetDbh().Raw("SELECT * FROM jobs WHERE id = $1 LIMIT 1", id).Scan(&result).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if result.ID == uuid.Nil {
+		return nil, nil
+	}
+	return &result, nil
+}
+
+// ClaimJob locks the oldest due job of the given types. SKIP LOCKED lets several workers
+// (and several service instances) poll the same table without waiting for each other.
+func ClaimJob(workerId string, types []string, now time.Time) (*Job, error) {
+	var result Job
+	err := api_init.GetDbh().Raw(`
+		UPDATE jobs
+		SET status = $1, locked_by = $2, locked_at = $3, started_at = COALESCE(started_at, $3),
+		    attempts = attempts + 1, updated_at = $3
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE status = $4 AND run_at <= $3 AND type = ANY($5)
+			ORDER BY run_at, created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`,
+		StatusRunning, workerId, now, StatusQueued, types,
+	).Scan(&result).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if result.ID == uuid.Nil {
+		return nil, nil
+	}
+	return &result, nil
+}
+
+// HeartbeatJob prolongs the lock of a running job and returns whether the cancellation was requested
+func HeartbeatJob(id uuid.UUID, workerId string, now time.Time) (bool, error) {
+	var cancelRequested []bool
+	err := api_init.GetDbh().Raw(
+		"UPDATE jobs SET locked_at = $1 WHERE id = $2 AND locked_by = $3 AND status = $4 RETURNING cancel_requested",
+		now, id, workerId, StatusRunning,
+	).Scan(&cancelRequested).Error
+	if err != nil {
+		return false, err
+	}
+
+	return len(cancelRequested) > 0 && cancelRequested[0], nil
+}
+
+// UpdateJobProgress changes only the running job locked by the worker, like finishJob
+func UpdateJobProgress(id uuid.UUID, workerId string, done int, total int) error {
+	return api_init.GetDbh().Model(&Job{}).
+		Where("id = ? AND locked_by = ? AND status = ?", id, workerId, StatusRunning).
+		Updates(map[string]interface{}{
+			"progress_done":  done,
+			"progress_total": total,
+			"updated_at":     time.Now(),
+		}).Error
+}
+
+// CompleteJob, FailJob, CancelRunningJob, RetryJob and ReleaseJob change only the job locked by the worker, a worker
+// whose job was requeued as stale does not overwrite the result of the new one
+func CompleteJob(id uuid.UUID, workerId string, result string, now time.Time) error {
+	return finishJob(id, workerId, map[string]interface{}{
+		"status": StatusSucceeded,
+		"result": result,
+		"error":  nil,
+	}, now)
+}
+
+func FailJob(id uuid.UUID, workerId string, message string, now time.Time) error {
+	return finishJob(id, workerId, map[string]interface{}{
+		"status": StatusFailed,
+		"error":  message,
+	}, now)
+}
+
+func CancelRunningJob(id uuid.UUID, workerId string, now time.Time) error {
+	return finishJob(id, workerId, map[string]interface{}{
+		"status": StatusCancelled,
+	}, now)
+}
+
+// RetryJob puts the failed job back to the queue to be run not earlier than runAt
+func RetryJob(id uuid.UUID, workerId string, message string, runAt time.Time, now time.Time) error {
+	return api_init.GetDbh().Model(&Job{}).Where("id = ? AND locked_by = ?", id, workerId).Updates(map[string]interface{}{
+		"status":     StatusQueued,
+		"error":      message,
+		"run_at":     runAt,
+		"locked_by":  nil,
+		"locked_at":  nil,
+		"updated_at": now,
+	}).Error
+}
+
+// ReleaseJob puts the interrupted job back to the queue without counting the attempt (example: service shutdown)
+func ReleaseJob(id uuid.UUID, workerId string, now time.Time) error {
+	return api_init.GetDbh().Exec(
+		"UPDATE jobs SET status = $1, attempts = GREATEST(attempts - 1, 0), locked_by = NULL, locked_at = NULL, run_at = $2, updated_at = $2 WHERE id = $3 AND status = $4 AND locked_by = $5",
+		StatusQueued, now, id, StatusRunning, workerId,
+	).Error
+}
+
+// RequeueStaleJobs returns running jobs whose worker stopped sending heartbeats (example: the process was killed). A job
+// which has used all attempts is failed, otherwise a job crashing the process would be run forever.
+func RequeueStaleJobs(staleBefore time.Time, now time.Time) (int64, error) {
+	tx := api_init.GetDbh().Exec(`
+		UPDATE jobs
+		SET status = CASE WHEN attempts >= max_attempts THEN $1 ELSE $2 END,
+		    error = CASE WHEN attempts >= max_attempts THEN $3 ELSE error END,
+		    finished_at = CASE WHEN attempts >= max_attempts THEN $4 ELSE finished_at END,
+		    input = CASE WHEN attempts >= max_attempts THEN NULL ELSE input END,
+		    locked_by = NULL, locked_at = NULL, run_at = $4, updated_at = $4
+		WHERE status = $5 AND locked_at < $6`,
+		StatusFailed, StatusQueued, ErrorJobStale, now, StatusRunning, staleBefore,
+	)
+	return tx.RowsAffected, tx.Error
+}
+
+// RequestJobCancel cancels a queued job at once, a running job is cancelled by its worker on the next heartbeat
+func RequestJobCancel(id uuid.UUID, now time.Time) (bool, error) {
+	tx := api_init.GetDbh().Exec(`
+		UPDATE jobs
+		SET cancel_requested = TRUE,
+		    status = CASE WHEN status = $1 THEN $2 ELSE status END,
+		    finished_at = CASE WHEN status = $1 THEN $3 ELSE finished_at END,
+		    input = CASE WHEN status = $1 THEN NULL ELSE input END,
+		    updated_at = $3
+		WHERE id = $4 AND status IN ($1, $5)`,
+		StatusQueued, StatusCancelled, now, id, StatusRunning,
+	)
+	return tx.RowsAffected > 0, tx.Error
+}
+
+// finishJob drops the input, it may be large and is not needed after the job is finished
+func finishJob(id uuid.UUID, workerId string, fields map[string]interface{}, now time.Time) error {
+	fields["input"] = nil
+	fields["finished_at"] = now
+	fields["updated_at"] = now
+	fields["locked_by"] = nil
+	fields["locked_at"] = nil
+	return api_init.GetDbh().Model(&Job{}).Where("id = ? AND locked_by = ?", id, workerId).Updates(fields).Error
+}
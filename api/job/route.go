@@ -0,0 +1,28 @@
+package job
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+const UriJobs = "/jobs"
+const UriJobGetById = "/:id"
+const UriJobCancel = "/:id/cancel"
+const UriJobGetByIdS = "/%s"
+const UriJobCancelS = "/%s/cancel"
+
+// ContextOwner is the gin context key of the owner of jobs the client may see
+const ContextOwner = "job_owner"
+
+// OwnerFunc returns the owner of jobs created by the request, empty when the client is not known
+type OwnerFunc func(c *gin.Context) string
+
+// InitJobRoutes registers job routes, the caller protects the router with authentication and owner tells whose jobs the
+// client sees
+func InitJobRoutes(route gin.IRouter, owner OwnerFunc) {
+	group := route.Group(UriJobs, func(c *gin.Context) {
+		c.Set(ContextOwner, owner(c))
+		c.Next()
+	})
+	group.GET(UriJobGetById, GetJobItemById)
+	group.POST(UriJobCancel, CancelJobById)
+}
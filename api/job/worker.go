@@ -0,0 +1,318 @@
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/apiboxgo/library-utils/utils"
+	"github.com/google/uuid"
+	"math/rand/v2"
+	"os"
+	"sync"
+	"time"
+	"user-service/env"
+)
+
+// Handler runs one job. The context is cancelled when the job is cancelled by a client
+// or the service is shutting down. The returned result is stored as job result JSON.
+type Handler func(ctx context.Context, job *Job, progress Progress) (any, error)
+
+// Progress stores how much of the job is done, total can be 0 when it is unknown
+type Progress func(done int, total int)
+
+type Config struct {
+	Workers           int
+	PollInterval      time.Duration
+	HeartbeatInterval time.Duration
+	StaleTimeout      time.Duration
+	MaxAttempts       int
+	RetryBaseDelay    time.Duration
+	RetryMaxDelay     time.Duration
+}
+
+func LoadConfig() Config {
+	return Config{
+		Workers:           env.Int("JOB_WORKERS", 2),
+		PollInterval:      env.Duration("JOB_POLL_INTERVAL", time.Second),
+		HeartbeatInterval: env.Duration("JOB_HEARTBEAT_INTERVAL", 10*time.Second),
+		StaleTimeout:      env.Duration("JOB_STALE_TIMEOUT", time.Minute),
+		MaxAttempts:       env.Int("JOB_MAX_ATTEMPTS", 5),
+		RetryBaseDelay:    env.Duration("JOB_RETRY_BASE_DELAY", 5*time.Second),
+		RetryMaxDelay:     env.Duration("JOB_RETRY_MAX_DELAY", 10*time.Minute),
+	}
+}
+
+var handlers = map[string]Handler{}
+var handlersMutex sync.RWMutex
+
+// RegisterHandler binds the job type with its handler, workers only claim jobs of registered types
+func RegisterHandler(jobType string, handler Handler) {
+	handlersMutex.Lock()
+	defer handlersMutex.Unlock()
+	handlers[jobType] = handler
+}
+
+func registeredTypes() []string {
+	handlersMutex.RLock()
+	defer handlersMutex.RUnlock()
+
+	types := make([]string, 0, len(handlers))
+	for jobType := range handlers {
+		types = append(types, jobType)
+	}
+	return types
+}
+
+func getHandler(jobType string) Handler {
+	handlersMutex.RLock()
+	defer handlersMutex.RUnlock()
+	return handlers[jobType]
+}
+
+// Enqueue stores a new job, input is an optional raw data of the job (example: uploaded file). Only the owner sees the
+// job by the API, a job without the owner is internal.
+func Enqueue(jobType string, owner string, payload any, input []byte) (*Job, error) {
+	payloadJson, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	job := &Job{
+		Type:        jobType,
+		Owner:       owner,
+		Payload:     string(payloadJson),
+		Input:       input,
+		MaxAttempts: LoadConfig().MaxAttempts,
+	}
+
+	if err := CreateJob(job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// ============================== Errors ===============================================================================
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks the error as not worth retrying (example: invalid payload)
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+var errCancelled = errors.New("job cancelled")
+
+// ============================== Pool =================================================================================
+
+// Pool runs jobs by its workers. Each worker claims jobs by its own id, the pool id and the worker index, so a worker
+// does not finish or heartbeat a job that was requeued as stale and claimed by another worker of the same process
+type Pool struct {
+	config   Config
+	workerId string
+	wg       sync.WaitGroup
+}
+
+func NewPool(config Config) *Pool {
+	hostname, _ := os.Hostname()
+	return &Pool{
+		config:   config,
+		workerId: fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), uuid.NewString()[:8]),
+	}
+}
+
+// StartWorkers runs the configured amount of workers until ctx is cancelled
+func StartWorkers(ctx context.Context) *Pool {
+	pool := NewPool(LoadConfig())
+	pool.Start(ctx)
+	return pool
+}
+
+func (p *Pool) Start(ctx context.Context) {
+	p.wg.Add(1)
+	go p.requeueStaleJobs(ctx)
+
+	for i := 0; i < p.config.Workers; i++ {
+		p.wg.Add(1)
+		go p.work(ctx, fmt.Sprintf("%s-%d", p.workerId, i+1))
+	}
+}
+
+// Wait blocks until all workers are stopped
+func (p *Pool) Wait() {
+	p.wg.Wait()
+}
+
+func (p *Pool) work(ctx context.Context, workerId string) {
+	defer p.wg.Done()
+
+	for {
+		processed, err := p.runOnce(ctx, workerId)
+		if err != nil {
+			utils.LogError("Job worker error", err)
+		}
+
+		if processed {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(p.config.PollInterval):
+		}
+	}
+}
+
+func (p *Pool) requeueStaleJobs(ctx context.Context) {
+	defer p.wg.Done()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(p.config.StaleTimeout):
+		}
+
+		now := time.Now()
+		count, err := RequeueStaleJobs(now.Add(-p.config.StaleTimeout), now)
+		if err != nil {
+			utils.LogError("Job requeue error", err)
+		} else if count > 0 {
+			utils.LogInfo(fmt.Sprintf("Requeued %d stale jobs", count))
+		}
+	}
+}
+
+// RunOnce claims and runs one due job as the pool itself, it returns false when there was nothing to do
+func (p *Pool) RunOnce(ctx context.Context) (bool, error) {
+	return p.runOnce(ctx, p.workerId)
+}
+
+func (p *Pool) runOnce(ctx context.Context, workerId string) (bool, error) {
+	if ctx.Err() != nil {
+		return false, nil
+	}
+
+	types := registeredTypes()
+	if len(types) == 0 {
+		return false, nil
+	}
+
+	job, err := ClaimJob(workerId, types, time.Now())
+	if err != nil || job == nil {
+		return false, err
+	}
+
+	return true, p.run(ctx, workerId, job)
+}
+
+func (p *Pool) run(ctx context.Context, workerId string, job *Job) error {
+	jobCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	heartbeatDone := make(chan struct{})
+	defer close(heartbeatDone)
+	go p.heartbeat(workerId, job, cancel, heartbeatDone)
+
+	progress := func(done int, total int) {
+		if err := UpdateJobProgress(job.ID, workerId, done, total); err != nil {
+			utils.LogError("Job progress error", err)
+		}
+	}
+
+	result, err := p.execute(jobCtx, job, progress)
+	now := time.Now()
+
+	// A job which is done is stored as done even when it was cancelled or the service is shutting down meanwhile
+	switch {
+	case err == nil:
+		resultJson, err := json.Marshal(result)
+		if err != nil {
+			return FailJob(job.ID, workerId, err.Error(), now)
+		}
+		return CompleteJob(job.ID, workerId, string(resultJson), now)
+
+	case errors.Is(context.Cause(jobCtx), errCancelled):
+		return CancelRunningJob(job.ID, workerId, now)
+
+	case ctx.Err() != nil:
+		// The service is shutting down, another worker will run the job again
+		return ReleaseJob(job.ID, workerId, now)
+	}
+
+	var permanent *permanentError
+	if errors.As(err, &permanent) || job.Attempts >= job.MaxAttempts {
+		return FailJob(job.ID, workerId, err.Error(), now)
+	}
+
+	return RetryJob(job.ID, workerId, err.Error(), now.Add(RetryDelay(p.config, job.Attempts)), now)
+}
+
+// execute turns a panic of the handler into the job error, so it does not kill the worker
+func (p *Pool) execute(ctx context.Context, job *Job, progress Progress) (result any, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("job panic: %v", recovered)
+		}
+	}()
+
+	handler := getHandler(job.Type)
+	if handler == nil {
+		return nil, Permanent(fmt.Errorf(ErrorJobHandlerNotFound, job.Type))
+	}
+
+	return handler(ctx, job, progress)
+}
+
+func (p *Pool) heartbeat(workerId string, job *Job, cancel context.CancelCauseFunc, done chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case <-time.After(p.config.HeartbeatInterval):
+		}
+
+		cancelRequested, err := HeartbeatJob(job.ID, workerId, time.Now())
+		if err != nil {
+			utils.LogError("Job heartbeat error", err)
+			continue
+		}
+
+		if cancelRequested {
+			cancel(errCancelled)
+			return
+		}
+	}
+}
+
+// RetryDelay is an exponential backoff with jitter: base * 2^(attempt-1) limited by the max delay
+func RetryDelay(config Config, attempt int) time.Duration {
+	delay := config.RetryBaseDelay
+	for i := 1; i < attempt && delay < config.RetryMaxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > config.RetryMaxDelay {
+		delay = config.RetryMaxDelay
+	}
+
+	// Up to 20% of jitter, so jobs failed together are not retried together
+	if jitter := int64(delay) / 5; jitter > 0 {
+		delay += time.Duration(rand.Int64N(jitter))
+	}
+	return delay
+}
@@ -0,0 +1,139 @@
+package lockout
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/gin-gonic/gin"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"user-service/env"
+)
+
+// Key identifies a counter, Subject is a hash of the account email or the client address
+type Key struct {
+	Scope   string
+	Subject string
+}
+
+// AccountKey returns the counter of the account, the email is expected normalised. Unknown emails are counted as well,
+// so the answers do not reveal registered addresses.
+func AccountKey(email string) Key {
+	return Key{Scope: ScopeAccount, Subject: hashSubject(email)}
+}
+
+func IpKey(ip string) Key {
+	return Key{Scope: ScopeIp, Subject: hashSubject(ip)}
+}
+
+func hashSubject(value string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(value)))
+	return hex.EncodeToString(sum[:])
+}
+
+type Config struct {
+	// FreeAttempts are failures allowed without a delay, every next failure doubles the delay from BackoffBase up to
+	// BackoffMax
+	FreeAttempts int
+	// Threshold of failures which locks the subject for Duration, 0 disables the lockout
+	Threshold   int
+	BackoffBase time.Duration
+	BackoffMax  time.Duration
+	Duration    time.Duration
+	// Window after the last failure when the counter starts again
+	Window time.Duration
+}
+
+// LoadConfig reads settings of the scope, for example LOCKOUT_ACCOUNT_THRESHOLD for ScopeAccount
+func LoadConfig(scope string) Config {
+	prefix := "LOCKOUT_" + strings.ToUpper(scope)
+	freeAttempts, threshold := 3, 10
+	if scope == ScopeIp {
+		freeAttempts, threshold = 20, 100
+	}
+
+	return Config{
+		FreeAttempts: env.Int(prefix+"_FREE_ATTEMPTS", freeAttempts),
+		Threshold:    env.Int(prefix+"_THRESHOLD", threshold),
+		BackoffBase:  env.Duration("LOCKOUT_BACKOFF_BASE", time.Second),
+		BackoffMax:   env.Duration("LOCKOUT_BACKOFF_MAX", time.Minute),
+		Duration:     env.Duration("LOCKOUT_DURATION", 15*time.Minute),
+		Window:       env.Duration("LOCKOUT_WINDOW", time.Hour),
+	}
+}
+
+// Result of a recorded failure, Locked is set only by the failure which reached the threshold
+type Result struct {
+	Failures     int
+	BlockedUntil *time.Time
+	Locked       bool
+}
+
+// RecordFailure counts the failure and blocks the key for the backoff delay or for the lockout duration
+func RecordFailure(key Key, now time.Time) (Result, error) {
+	config := LoadConfig(key.Scope)
+	windowStart := now.Add(-config.Window)
+
+	failure, err := incrementFailures(key, windowStart, now)
+	if err != nil || failure == nil {
+		return Result{}, err
+	}
+
+	result := Result{Failures: failure.Failures}
+	var lockedAt *time.Time
+	delay := backoff(failure.Failures, config)
+	if config.Threshold > 0 && failure.Failures >= config.Threshold {
+		delay = config.Duration
+		result.Locked = failure.Failures == config.Threshold
+		if result.Locked {
+			lockedAt = &now
+		}
+	}
+
+	if delay > 0 {
+		blockedUntil := now.Add(delay)
+		if err := blockFailure(key, blockedUntil, lockedAt); err != nil {
+			return Result{}, err
+		}
+		result.BlockedUntil = &blockedUntil
+	}
+
+	return result, deleteStaleFailures(windowStart, now)
+}
+
+// RetryAfter returns how long the most restricted of the keys stays blocked, 0 means the attempt is allowed
+func RetryAfter(now time.Time, keys ...Key) (time.Duration, error) {
+	blockedUntil, err := getBlockedUntil(keys, now)
+	if err != nil || blockedUntil == nil {
+		return 0, err
+	}
+	return blockedUntil.Sub(now), nil
+}
+
+// Reset forgets failures of the key after a successful sign-in or an unlock by staff
+func Reset(key Key) error {
+	return deleteFailures(key)
+}
+
+// SetRetryAfter sets the Retry-After header in whole seconds
+func SetRetryAfter(c *gin.Context, retryAfter time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+}
+
+// backoff returns the delay after the failure, it doubles with every failure over the free attempts
+func backoff(failures int, config Config) time.Duration {
+	over := failures - config.FreeAttempts
+	if over <= 0 || config.BackoffBase <= 0 {
+		return 0
+	}
+	if over > 30 {
+		return config.BackoffMax
+	}
+
+	delay := config.BackoffBase << (over - 1)
+	if delay > config.BackoffMax || delay <= 0 {
+		return config.BackoffMax
+	}
+	return delay
+}
@@ -0,0 +1,28 @@
+package lockout
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	config := Config{FreeAttempts: 3, BackoffBase: time.Second, BackoffMax: time.Minute}
+
+	assert.Equal(t, time.Duration(0), backoff(1, config))
+	assert.Equal(t, time.Duration(0), backoff(3, config))
+	assert.Equal(t, time.Second, backoff(4, config))
+	assert.Equal(t, 2*time.Second, backoff(5, config))
+	assert.Equal(t, 32*time.Second, backoff(9, config))
+	assert.Equal(t, time.Minute, backoff(10, config))
+	assert.Equal(t, time.Minute, backoff(1000, config))
+
+	config.BackoffBase = 0
+	assert.Equal(t, time.Duration(0), backoff(10, config))
+}
+
+func TestKeys(t *testing.T) {
+	assert.Equal(t, AccountKey("user@example.com"), AccountKey("User@Example.com"))
+	assert.NotEqual(t, AccountKey("127.0.0.1").Scope, IpKey("127.0.0.1").Scope)
+	assert.Len(t, IpKey("127.0.0.1").Subject, 64)
+}
@@ -0,0 +1,3 @@
+package lockout
+
+const ErrorTooManyAttempts = "Too many failed attempts, try again later"
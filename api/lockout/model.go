@@ -0,0 +1,23 @@
+package lockout
+
+import (
+	"time"
+)
+
+const ScopeAccount = "account"
+const ScopeIp = "ip"
+
+// Failure counts failed credential checks of one account or client address. BlockedUntil is the end of the backoff
+// or of the lockout, LockedAt is set when the threshold is reached.
+type Failure struct {
+	Scope        string     `gorm:"type:varchar(16);primaryKey"`
+	Subject      string     `gorm:"type:varchar(64);primaryKey"`
+	Failures     int        `gorm:"not null;default:0"`
+	LastFailedAt time.Time  `gorm:"type:timestamp;not null"`
+	BlockedUntil *time.Time `gorm:"type:timestamp;null;default:null"`
+	LockedAt     *time.Time `gorm:"type:timestamp;null;default:null"`
+}
+
+func (Failure) TableName() string {
+	return "login_failures"
+}
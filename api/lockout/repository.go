@@ -0,0 +1,72 @@
+package lockout
+
+import (
+	"github.com/apiboxgo/library-utils/api_init"
+	"time"
+)
+
+// incrementFailures counts the failure in one statement, so concurrent requests to different instances are all
+// counted. The counter starts again when the previous failure is older than windowStart.
+func incrementFailures(key Key, windowStart time.Time, now time.Time) (*Failure, error) {
+	var result []Failure
+	err := api_init.GetDbh().Raw(
+		`INSERT INTO login_failures (scope, subject, failures, last_failed_at) VALUES (?, ?, 1, ?)
+		ON CONFLICT (scope, subject) DO UPDATE SET
+			failures = CASE WHEN login_failures.last_failed_at < ? THEN 1 ELSE login_failures.failures + 1 END,
+			last_failed_at = EXCLUDED.last_failed_at
+		RETURNING *`,
+		key.Scope, key.Subject, now, windowStart,
+	).Scan(&result).Error
+	if err != nil || len(result) == 0 {
+		return nil, err
+	}
+	return &result[0], nil
+}
+
+func blockFailure(key Key, blockedUntil time.Time, lockedAt *time.Time) error {
+	updates := map[string]interface{}{
+		"blocked_until": blockedUntil,
+	}
+	if lockedAt != nil {
+		updates["locked_at"] = *lockedAt
+	}
+
+	return api_init.GetDbh().Model(&Failure{}).
+		Where("scope = ? AND subject = ?", key.Scope, key.Subject).
+		Updates(updates).Error
+}
+
+// getBlockedUntil returns the latest end of the block of the keys, nil means none of them is blocked
+func getBlockedUntil(keys []Key, now time.Time) (*time.Time, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	condition := api_init.GetDbh()
+	for _, key := range keys {
+		condition = condition.Or("scope = ? AND subject = ?", key.Scope, key.Subject)
+	}
+
+	var result []time.Time
+	err := api_init.GetDbh().Model(&Failure{}).
+		Where("blocked_until > ?", now).
+		Where(condition).
+		Order("blocked_until DESC").
+		Limit(1).
+		Pluck("blocked_until", &result).Error
+	if err != nil || len(result) == 0 {
+		return nil, err
+	}
+	return &result[0], nil
+}
+
+func deleteFailures(key Key) error {
+	return api_init.GetDbh().Where("scope = ? AND subject = ?", key.Scope, key.Subject).Delete(&Failure{}).Error
+}
+
+// deleteStaleFailures removes counters which are neither counted nor blocked anymore
+func deleteStaleFailures(windowStart time.Time, now time.Time) error {
+	return api_init.GetDbh().
+		Where("last_failed_at < ? AND (blocked_until IS NULL OR blocked_until < ?)", windowStart, now).
+		Delete(&Failure{}).Error
+}
@@ -0,0 +1,178 @@
+package oauth
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// ============================== Request DTO ==========================================================================
+
+// RequestClientDto registers a client, a confidential one gets the secret. Scope is space separated.
+type RequestClientDto struct {
+	Name                   string   `json:"name" binding:"required,max=100" example:"Billing"`
+	RedirectUris           []string `json:"redirect_uris" example:"https://billing.example.com/callback"`
+	PostLogoutRedirectUris []string `json:"post_logout_redirect_uris" example:"https://billing.example.com/"`
+	GrantTypes             []string `json:"grant_types" binding:"required,min=1" example:"authorization_code,refresh_token"`
+	Scope                  string   `json:"scope" example:"openid profile email"`
+	Confidential           bool     `json:"confidential" example:"true"`
+}
+
+type RequestClientIdDto struct {
+	ID string `uri:"id" binding:"required,uuid" example:"987fbc97-4bed-5078-9f07-9141ba07c9f3"`
+}
+
+// RequestAuthorizeDto has parameters of the authorization request, the consent screen sends them back unchanged
+type RequestAuthorizeDto struct {
+	ResponseType        string `form:"response_type" json:"response_type" example:"code"`
+	ClientID            string `form:"client_id" json:"client_id" example:"987fbc97-4bed-5078-9f07-9141ba07c9f3"`
+	RedirectUri         string `form:"redirect_uri" json:"redirect_uri" example:"https://billing.example.com/callback"`
+	Scope               string `form:"scope" json:"scope" example:"openid email"`
+	State               string `form:"state" json:"state" example:"af0ifjsldkj"`
+	Nonce               string `form:"nonce" json:"nonce" binding:"max=255" example:"n-0S6_WzA2Mj"`
+	CodeChallenge       string `form:"code_challenge" json:"code_challenge" example:"E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"`
+	CodeChallengeMethod string `form:"code_challenge_method" json:"code_challenge_method" example:"S256"`
+}
+
+// RequestConsentDto is the decision of the user on the consent screen
+type RequestConsentDto struct {
+	RequestAuthorizeDto
+	Approve bool `json:"approve" example:"true"`
+}
+
+// RequestTokenDto is the form of the token endpoint, the client authenticates with HTTP Basic or the form fields
+type RequestTokenDto struct {
+	GrantType    string `form:"grant_type"`
+	Code         string `form:"code"`
+	RedirectUri  string `form:"redirect_uri"`
+	CodeVerifier string `form:"code_verifier"`
+	RefreshToken string `form:"refresh_token"`
+	Scope        string `form:"scope"`
+	ClientID     string `form:"client_id"`
+	ClientSecret string `form:"client_secret"`
+}
+
+// RequestTokenLookupDto is the form of introspection and revocation
+type RequestTokenLookupDto struct {
+	Token         string `form:"token"`
+	TokenTypeHint string `form:"token_type_hint"`
+	ClientID      string `form:"client_id"`
+	ClientSecret  string `form:"client_secret"`
+}
+
+// RequestLogoutDto is RP-initiated logout of OpenID Connect, it comes as the query or the form
+type RequestLogoutDto struct {
+	IdTokenHint           string `form:"id_token_hint"`
+	ClientID              string `form:"client_id"`
+	PostLogoutRedirectUri string `form:"post_logout_redirect_uri"`
+	State                 string `form:"state"`
+}
+
+// ============================== Response DTO =========================================================================
+
+type ErrorResponseDto struct {
+	Message string `json:"message"`
+}
+
+type SuccessResponseDto struct {
+	Message string `json:"message"`
+}
+
+// OauthErrorDto is the error of RFC 6749, the token endpoint and the authorization request answer with it
+type OauthErrorDto struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+type ClientDto struct {
+	ID                     uuid.UUID `json:"id"`
+	Name                   string    `json:"name"`
+	RedirectUris           []string  `json:"redirect_uris"`
+	PostLogoutRedirectUris []string  `json:"post_logout_redirect_uris"`
+	GrantTypes             []string  `json:"grant_types"`
+	Scope                  string    `json:"scope"`
+	Confidential           bool      `json:"confidential"`
+	CreatedAt              time.Time `json:"created_at"`
+}
+
+// ClientCreatedDto has the secret of a confidential client, it is shown only once
+type ClientCreatedDto struct {
+	ClientDto
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+// ConsentDto describes the request for the consent screen, Granted means the user has allowed all scopes before
+type ConsentDto struct {
+	ClientID   uuid.UUID `json:"client_id"`
+	ClientName string    `json:"client_name"`
+	Scopes     []string  `json:"scopes"`
+	Granted    bool      `json:"granted"`
+}
+
+// AuthorizeRedirectDto is where the consent screen sends the browser, with the code or the error
+type AuthorizeRedirectDto struct {
+	RedirectUri string `json:"redirect_uri"`
+}
+
+// TokenResponseDto has IdToken when the openid scope is granted
+type TokenResponseDto struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IdToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope"`
+}
+
+// IntrospectionDto of RFC 7662, only Active is set for an inactive or unknown token
+type IntrospectionDto struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+	Iss       string `json:"iss,omitempty"`
+}
+
+// UserinfoDto has the claims of OpenID Connect about the user, email claims need the email scope
+type UserinfoDto struct {
+	Sub           string `json:"sub"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified *bool  `json:"email_verified,omitempty"`
+}
+
+// OpenidConfigurationDto is the discovery document of OpenID Connect Discovery 1.0
+type OpenidConfigurationDto struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JwksUri                           string   `json:"jwks_uri"`
+	EndSessionEndpoint                string   `json:"end_session_endpoint"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	RevocationEndpoint                string   `json:"revocation_endpoint"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IdTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+}
+
+// JwksDto is the JWK Set with public keys of ID tokens
+type JwksDto struct {
+	Keys []JwkDto `json:"keys"`
+}
+
+type JwkDto struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
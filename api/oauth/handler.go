@@ -0,0 +1,1303 @@
+package oauth
+
+import (
+	"fmt"
+	"github.com/apiboxgo/library-utils/dictionary"
+	"github.com/apiboxgo/library-utils/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+	"user-service/api/auth"
+	"user-service/api/user"
+	_ "user-service/docs"
+)
+
+// ================================== Create OAuth client ==============================================================
+//	@title			Create OAuth client
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// CreateClientItem godoc
+// @Summary      Create OAuth client
+// @Description  Register an application which signs users in through OAuth. A confidential client gets the secret,
+// @Description  it is shown only in this response. A public client (SPA, mobile app) has no secret and proves the
+// @Description  authorization with PKCE. Admin only.
+// @Tags         oauth
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request body RequestClientDto true "Client"
+// @Success      201 {object}  ClientCreatedDto
+// @Failure      403 {object}  ErrorResponseDto
+// @Failure      422 {object}  ErrorResponseDto
+// @Router       /oauth/clients [post]
+func CreateClientItem(c *gin.Context) {
+	var requestClientDto RequestClientDto
+	if err := c.ShouldBindJSON(&requestClientDto); err != nil {
+		utils.LogError(dictionary.ErrorParsingRequestBody, err)
+		c.JSON(http.StatusUnprocessableEntity, &ErrorResponseDto{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if message := validateClient(requestClientDto, LoadConfig()); message != "" {
+		c.JSON(http.StatusUnprocessableEntity, &ErrorResponseDto{
+			Message: message,
+		})
+		return
+	}
+
+	actorId := auth.GetClaims(c).UserID()
+	client := &Client{
+		ID:                     uuid.New(),
+		Name:                   requestClientDto.Name,
+		RedirectUris:           strings.Join(requestClientDto.RedirectUris, " "),
+		PostLogoutRedirectUris: strings.Join(requestClientDto.PostLogoutRedirectUris, " "),
+		GrantTypes:             strings.Join(requestClientDto.GrantTypes, " "),
+		Scope:                  JoinScope(ParseScope(requestClientDto.Scope)),
+		CreatedBy:              &actorId,
+		CreatedAt:              time.Now(),
+	}
+
+	var secret string
+	if requestClientDto.Confidential {
+		raw, hash, err := auth.NewOpaqueToken()
+		if err != nil {
+			utils.LogError(dictionary.SomethingWrong, err)
+			c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+				Message: dictionary.SomethingWrong,
+			})
+			return
+		}
+		secret = raw
+		client.SecretHash = hash
+	}
+
+	if err := CreateClient(client); err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, &ClientCreatedDto{
+		ClientDto:    clientDto(*client),
+		ClientSecret: secret,
+	})
+}
+
+// ================================== OAuth client list ================================================================
+//	@title			OAuth client list
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// GetClientList godoc
+// @Summary      OAuth client list
+// @Description  Registered OAuth clients without secrets. Admin only.
+// @Tags         oauth
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200 {array}   ClientDto
+// @Failure      403 {object}  ErrorResponseDto
+// @Router       /oauth/clients [get]
+func GetClientList(c *gin.Context) {
+	clients, err := GetClients()
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	result := make([]ClientDto, 0, len(clients))
+	for _, client := range clients {
+		result = append(result, clientDto(client))
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// ================================== Delete OAuth client ==============================================================
+//	@title			Delete OAuth client
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// DeleteClientById godoc
+// @Summary      Delete OAuth client
+// @Description  Delete the client, its access and refresh tokens stop working at once. Admin only.
+// @Tags         oauth
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path string true "Client id (UUID)"
+// @Success      200 {object}  SuccessResponseDto
+// @Failure      403 {object}  ErrorResponseDto
+// @Failure      404 {object}  ErrorResponseDto
+// @Failure      422 {object}  ErrorResponseDto
+// @Router       /oauth/clients/{id} [delete]
+func DeleteClientById(c *gin.Context) {
+	var requestClientIdDto RequestClientIdDto
+	if err := c.ShouldBindUri(&requestClientIdDto); err != nil {
+		utils.LogError(dictionary.ErrorParsingRequestBody, err)
+		c.JSON(http.StatusUnprocessableEntity, &ErrorResponseDto{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	isDeleted, err := DeleteClient(uuid.MustParse(requestClientIdDto.ID), time.Now())
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	if !isDeleted {
+		c.JSON(http.StatusNotFound, &ErrorResponseDto{
+			Message: fmt.Sprintf(ErrorClientNotFound, requestClientIdDto.ID),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, &SuccessResponseDto{
+		Message: ClientDeleted,
+	})
+}
+
+// ================================== Authorize ========================================================================
+//	@title			Authorize
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// Authorize godoc
+// @Summary      Authorize
+// @Description  Authorization request of the authorization code grant with PKCE (S256). A valid request is
+// @Description  redirected to the consent screen of the frontend (OAUTH_CONSENT_URL) with the same parameters, an
+// @Description  invalid one back to the client with error. Unknown client or redirect URI is answered with 400 and
+// @Description  never redirected.
+// @Tags         oauth
+// @Produce      json
+// @Param        response_type query string true "code"
+// @Param        client_id query string true "Client id"
+// @Param        redirect_uri query string true "Registered redirect URI"
+// @Param        scope query string false "Space separated scopes, all scopes of the client by default"
+// @Param        state query string false "Opaque value returned to the client"
+// @Param        code_challenge query string true "PKCE challenge"
+// @Param        code_challenge_method query string true "S256"
+// @Success      302
+// @Failure      400 {object}  OauthErrorDto
+// @Router       /oauth/authorize [get]
+func Authorize(c *gin.Context) {
+	var requestAuthorizeDto RequestAuthorizeDto
+	if err := c.ShouldBindQuery(&requestAuthorizeDto); err != nil {
+		utils.LogError(dictionary.ErrorParsingRequestBody, err)
+		abortOauthError(c, http.StatusBadRequest, ErrorCodeInvalidRequest, err.Error())
+		return
+	}
+
+	client, err := getRedirectClient(requestAuthorizeDto)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		abortOauthError(c, http.StatusInternalServerError, ErrorCodeServerError, dictionary.SomethingWrong)
+		return
+	}
+
+	if client == nil {
+		abortOauthError(c, http.StatusBadRequest, ErrorCodeInvalidRequest, ErrorRedirectUriNotRegistered)
+		return
+	}
+
+	// The redirect URI is trusted now, so other errors go back to the client as RFC 6749 requires
+	if _, code, description := checkAuthorizeRequest(client, requestAuthorizeDto, LoadConfig()); code != "" {
+		c.Redirect(http.StatusFound, redirectWithParams(requestAuthorizeDto.RedirectUri, url.Values{
+			"error":             {code},
+			"error_description": {description},
+			"state":             {requestAuthorizeDto.State},
+		}))
+		return
+	}
+
+	c.Redirect(http.StatusFound, redirectWithParams(LoadConfig().ConsentUrl, c.Request.URL.Query()))
+}
+
+// ================================== Consent request ==================================================================
+//	@title			Consent request
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// GetConsentRequest godoc
+// @Summary      Consent request
+// @Description  Client and scopes of the authorization request for the consent screen, the parameters are those of
+// @Description  GET /oauth/authorize. Granted is true when the user has allowed the scopes before, the screen can
+// @Description  then approve the request without asking.
+// @Tags         oauth
+// @Produce      json
+// @Security     BearerAuth
+// @Param        response_type query string true "code"
+// @Param        client_id query string true "Client id"
+// @Param        redirect_uri query string true "Registered redirect URI"
+// @Param        scope query string false "Space separated scopes"
+// @Param        state query string false "Opaque value returned to the client"
+// @Param        code_challenge query string true "PKCE challenge"
+// @Param        code_challenge_method query string true "S256"
+// @Success      200 {object}  ConsentDto
+// @Failure      400 {object}  OauthErrorDto
+// @Failure      401 {object}  ErrorResponseDto
+// @Router       /oauth/consent [get]
+func GetConsentRequest(c *gin.Context) {
+	var requestAuthorizeDto RequestAuthorizeDto
+	if err := c.ShouldBindQuery(&requestAuthorizeDto); err != nil {
+		utils.LogError(dictionary.ErrorParsingRequestBody, err)
+		abortOauthError(c, http.StatusBadRequest, ErrorCodeInvalidRequest, err.Error())
+		return
+	}
+
+	client, scopes, ok := bindAuthorizeRequest(c, requestAuthorizeDto)
+	if !ok {
+		return
+	}
+
+	consent, err := GetUserConsent(auth.GetClaims(c).UserID(), client.ID)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, &ConsentDto{
+		ClientID:   client.ID,
+		ClientName: client.Name,
+		Scopes:     scopes,
+		Granted:    consent != nil && HasScopes(consent.Scope, scopes),
+	})
+}
+
+// ================================== Consent decision =================================================================
+//	@title			Consent decision
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// PostConsent godoc
+// @Summary      Consent decision
+// @Description  Approve or deny the authorization request of the consent screen. Returns the redirect URI of the
+// @Description  client with the authorization code and state, or with error=access_denied. The approved scopes are
+// @Description  remembered for the client.
+// @Tags         oauth
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request body RequestConsentDto true "Authorization request and the decision"
+// @Success      200 {object}  AuthorizeRedirectDto
+// @Failure      400 {object}  OauthErrorDto
+// @Failure      401 {object}  ErrorResponseDto
+// @Router       /oauth/consent [post]
+func PostConsent(c *gin.Context) {
+	var requestConsentDto RequestConsentDto
+	if err := c.ShouldBindJSON(&requestConsentDto); err != nil {
+		utils.LogError(dictionary.ErrorParsingRequestBody, err)
+		abortOauthError(c, http.StatusBadRequest, ErrorCodeInvalidRequest, err.Error())
+		return
+	}
+
+	client, scopes, ok := bindAuthorizeRequest(c, requestConsentDto.RequestAuthorizeDto)
+	if !ok {
+		return
+	}
+
+	if !requestConsentDto.Approve {
+		c.JSON(http.StatusOK, &AuthorizeRedirectDto{
+			RedirectUri: redirectWithParams(requestConsentDto.RedirectUri, url.Values{
+				"error": {ErrorCodeAccessDenied},
+				"state": {requestConsentDto.State},
+			}),
+		})
+		return
+	}
+
+	claims := auth.GetClaims(c)
+	code, err := grantAuthorization(claims.UserID(), claims.SessionId(), client, scopes, requestConsentDto.RequestAuthorizeDto)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, &AuthorizeRedirectDto{
+		RedirectUri: redirectWithParams(requestConsentDto.RedirectUri, url.Values{
+			"code":  {code},
+			"state": {requestConsentDto.State},
+		}),
+	})
+}
+
+// ================================== Token ============================================================================
+//	@title			Token
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// IssueToken godoc
+// @Summary      Token
+// @Description  Token endpoint of RFC 6749: authorization_code with PKCE code_verifier, refresh_token (rotated, reuse
+// @Description  revokes all tokens of the authorization) and client_credentials for confidential clients. Clients
+// @Description  authenticate with HTTP Basic or client_id and client_secret fields, public clients send client_id only.
+// @Tags         oauth
+// @Accept       x-www-form-urlencoded
+// @Produce      json
+// @Param        grant_type formData string true "authorization_code, refresh_token or client_credentials"
+// @Param        code formData string false "Authorization code"
+// @Param        redirect_uri formData string false "Redirect URI of the authorization request"
+// @Param        code_verifier formData string false "PKCE verifier"
+// @Param        refresh_token formData string false "Refresh token"
+// @Param        scope formData string false "Space separated scopes, a subset of the granted ones"
+// @Param        client_id formData string false "Client id"
+// @Param        client_secret formData string false "Client secret"
+// @Success      200 {object}  TokenResponseDto
+// @Failure      400 {object}  OauthErrorDto
+// @Failure      401 {object}  OauthErrorDto
+// @Router       /oauth/token [post]
+func IssueToken(c *gin.Context) {
+	// Tokens must not be cached by the browser or proxies
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+
+	var requestTokenDto RequestTokenDto
+	if err := c.ShouldBind(&requestTokenDto); err != nil {
+		utils.LogError(dictionary.ErrorParsingRequestBody, err)
+		abortOauthError(c, http.StatusBadRequest, ErrorCodeInvalidRequest, err.Error())
+		return
+	}
+
+	client, isAuthenticated, ok := authenticateClient(c, requestTokenDto.ClientID, requestTokenDto.ClientSecret)
+	if !ok {
+		return
+	}
+
+	if !slices.Contains(GrantTypes, requestTokenDto.GrantType) {
+		abortOauthError(c, http.StatusBadRequest, ErrorCodeUnsupportedGrantType, fmt.Sprintf(ErrorUnknownGrantType, requestTokenDto.GrantType))
+		return
+	}
+
+	if !client.HasGrantType(requestTokenDto.GrantType) {
+		abortOauthError(c, http.StatusBadRequest, ErrorCodeUnauthorizedClient, fmt.Sprintf(ErrorGrantTypeNotAllowed, requestTokenDto.GrantType))
+		return
+	}
+
+	switch requestTokenDto.GrantType {
+	case GrantTypeAuthorizationCode:
+		exchangeAuthorizationCode(c, client, requestTokenDto)
+	case GrantTypeRefreshToken:
+		rotateRefreshToken(c, client, requestTokenDto)
+	case GrantTypeClientCredentials:
+		// A public client can not keep a secret, anyone could get its tokens
+		if !isAuthenticated {
+			abortOauthError(c, http.StatusBadRequest, ErrorCodeUnauthorizedClient, ErrorConfidentialClientRequired)
+			return
+		}
+		issueClientCredentials(c, client, requestTokenDto)
+	}
+}
+
+// ================================== Token introspection ==============================================================
+//	@title			Token introspection
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// Introspect godoc
+// @Summary      Token introspection
+// @Description  RFC 7662 introspection for resource servers. The caller is a confidential client, it can introspect
+// @Description  tokens of any client. A revoked or expired token, an ended sign-in session, a deleted client and
+// @Description  a blocked or deleted user make the token inactive.
+// @Tags         oauth
+// @Accept       x-www-form-urlencoded
+// @Produce      json
+// @Param        token formData string true "Access or refresh token"
+// @Param        token_type_hint formData string false "access_token or refresh_token"
+// @Param        client_id formData string false "Client id"
+// @Param        client_secret formData string false "Client secret"
+// @Success      200 {object}  IntrospectionDto
+// @Failure      400 {object}  OauthErrorDto
+// @Failure      401 {object}  OauthErrorDto
+// @Router       /oauth/introspect [post]
+func Introspect(c *gin.Context) {
+	var requestTokenLookupDto RequestTokenLookupDto
+	if err := c.ShouldBind(&requestTokenLookupDto); err != nil {
+		utils.LogError(dictionary.ErrorParsingRequestBody, err)
+		abortOauthError(c, http.StatusBadRequest, ErrorCodeInvalidRequest, err.Error())
+		return
+	}
+
+	_, isAuthenticated, ok := authenticateClient(c, requestTokenLookupDto.ClientID, requestTokenLookupDto.ClientSecret)
+	if !ok {
+		return
+	}
+
+	if !isAuthenticated {
+		c.Header("WWW-Authenticate", "Basic")
+		abortOauthError(c, http.StatusUnauthorized, ErrorCodeInvalidClient, ErrorClientAuthenticationFailed)
+		return
+	}
+
+	if requestTokenLookupDto.Token == "" {
+		abortOauthError(c, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Sprintf(ErrorParameterRequired, "token"))
+		return
+	}
+
+	result, err := introspectToken(requestTokenLookupDto.Token, time.Now())
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		abortOauthError(c, http.StatusInternalServerError, ErrorCodeServerError, dictionary.SomethingWrong)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// ================================== Token revocation =================================================================
+//	@title			Token revocation
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// Revoke godoc
+// @Summary      Token revocation
+// @Description  RFC 7009 revocation. A refresh token revokes all tokens of its authorization, an access token only
+// @Description  itself. Unknown tokens and tokens of other clients are answered with 200 as well.
+// @Tags         oauth
+// @Accept       x-www-form-urlencoded
+// @Produce      json
+// @Param        token formData string true "Access or refresh token"
+// @Param        token_type_hint formData string false "access_token or refresh_token"
+// @Param        client_id formData string false "Client id"
+// @Param        client_secret formData string false "Client secret"
+// @Success      200 {object}  SuccessResponseDto
+// @Failure      400 {object}  OauthErrorDto
+// @Failure      401 {object}  OauthErrorDto
+// @Router       /oauth/revoke [post]
+func Revoke(c *gin.Context) {
+	var requestTokenLookupDto RequestTokenLookupDto
+	if err := c.ShouldBind(&requestTokenLookupDto); err != nil {
+		utils.LogError(dictionary.ErrorParsingRequestBody, err)
+		abortOauthError(c, http.StatusBadRequest, ErrorCodeInvalidRequest, err.Error())
+		return
+	}
+
+	client, _, ok := authenticateClient(c, requestTokenLookupDto.ClientID, requestTokenLookupDto.ClientSecret)
+	if !ok {
+		return
+	}
+
+	if requestTokenLookupDto.Token == "" {
+		abortOauthError(c, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Sprintf(ErrorParameterRequired, "token"))
+		return
+	}
+
+	token, err := GetToken(auth.HashToken(requestTokenLookupDto.Token))
+	if err == nil && token != nil && token.ClientID == client.ID {
+		now := time.Now()
+		if token.Type == TokenTypeRefresh {
+			err = RevokeTokenFamily(token.FamilyID, now)
+		} else {
+			err = RevokeToken(token.ID, now)
+		}
+	}
+
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		abortOauthError(c, http.StatusInternalServerError, ErrorCodeServerError, dictionary.SomethingWrong)
+		return
+	}
+
+	c.JSON(http.StatusOK, &SuccessResponseDto{
+		Message: TokenRevoked,
+	})
+}
+
+// ================================== OpenID configuration =============================================================
+//	@title			OpenID configuration
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// GetOpenidConfiguration godoc
+// @Summary      OpenID configuration
+// @Description  Discovery document of OpenID Connect, endpoints are under OIDC_ISSUER.
+// @Tags         oauth
+// @Produce      json
+// @Success      200 {object}  OpenidConfigurationDto
+// @Router       /.well-known/openid-configuration [get]
+func GetOpenidConfiguration(c *gin.Context) {
+	c.JSON(http.StatusOK, openidConfiguration(LoadConfig()))
+}
+
+// ================================== JSON Web Key Set =================================================================
+//	@title			JSON Web Key Set
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// GetJwks godoc
+// @Summary      JSON Web Key Set
+// @Description  Public keys which verify signatures of ID tokens, kid of the token header selects the key.
+// @Tags         oauth
+// @Produce      json
+// @Success      200 {object}  JwksDto
+// @Router       /.well-known/jwks.json [get]
+func GetJwks(c *gin.Context) {
+	key, err := DefaultSigningKey()
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, jwks(key))
+}
+
+// ================================== Userinfo =========================================================================
+//	@title			Userinfo
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// GetUserinfo godoc
+// @Summary      Userinfo
+// @Description  Claims about the user of the OAuth access token with the openid scope, email and email_verified need
+// @Description  the email scope. The access token is the one of POST /oauth/token, not the token of /auth/login.
+// @Tags         oauth
+// @Produce      json
+// @Param        Authorization header string true "Bearer <access_token>"
+// @Success      200 {object}  UserinfoDto
+// @Failure      401 {object}  OauthErrorDto
+// @Failure      403 {object}  OauthErrorDto
+// @Router       /userinfo [get]
+// @Router       /userinfo [post]
+func GetUserinfo(c *gin.Context) {
+	now := time.Now()
+	var token *Token
+	var err error
+	if raw := auth.BearerToken(c); raw != "" {
+		token, err = GetToken(auth.HashToken(raw))
+	}
+
+	isSessionActive := false
+	if err == nil && token != nil && token.Type == TokenTypeAccess && token.UserID != nil && token.IsActive(now) {
+		isSessionActive, err = isTokenSessionActive(token, now)
+	}
+
+	var account *user.UserItemResultDto
+	if err == nil && isSessionActive {
+		account, err = getActiveUser(*token.UserID)
+	}
+
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		abortOauthError(c, http.StatusInternalServerError, ErrorCodeServerError, dictionary.SomethingWrong)
+		return
+	}
+
+	if account == nil {
+		c.Header("WWW-Authenticate", fmt.Sprintf(`Bearer error="%s"`, ErrorCodeInvalidToken))
+		abortOauthError(c, http.StatusUnauthorized, ErrorCodeInvalidToken, ErrorInvalidAccessToken)
+		return
+	}
+
+	if !HasScopes(token.Scope, []string{ScopeOpenid}) {
+		c.Header("WWW-Authenticate", fmt.Sprintf(`Bearer error="%s", scope="%s"`, ErrorCodeInsufficientScope, ScopeOpenid))
+		abortOauthError(c, http.StatusForbidden, ErrorCodeInsufficientScope, ErrorOpenidScopeRequired)
+		return
+	}
+
+	result := &UserinfoDto{Sub: account.ID.String()}
+	if HasScopes(token.Scope, []string{ScopeEmail}) {
+		isVerified := account.EmailVerifiedAt != nil
+		result.Email = account.Email
+		result.EmailVerified = &isVerified
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// ================================== Logout ===========================================================================
+//	@title			Logout
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// Logout godoc
+// @Summary      Logout
+// @Description  RP-initiated logout of OpenID Connect. The ID token of the client tells the user and the sign-in
+// @Description  session, an expired one is accepted. The session is ended with OAuth tokens issued in it. With
+// @Description  post_logout_redirect_uri registered for the client the browser is redirected there with state,
+// @Description  otherwise the result is answered with 200.
+// @Tags         oauth
+// @Produce      json
+// @Param        id_token_hint query string true "ID token issued to the client"
+// @Param        client_id query string false "Client id, it must be the audience of the ID token"
+// @Param        post_logout_redirect_uri query string false "Registered post logout redirect URI"
+// @Param        state query string false "Opaque value returned to the client"
+// @Success      200 {object}  SuccessResponseDto
+// @Success      302
+// @Failure      400 {object}  OauthErrorDto
+// @Router       /oauth/logout [get]
+// @Router       /oauth/logout [post]
+func Logout(c *gin.Context) {
+	var requestLogoutDto RequestLogoutDto
+	if err := c.ShouldBind(&requestLogoutDto); err != nil {
+		utils.LogError(dictionary.ErrorParsingRequestBody, err)
+		abortOauthError(c, http.StatusBadRequest, ErrorCodeInvalidRequest, err.Error())
+		return
+	}
+
+	if requestLogoutDto.IdTokenHint == "" {
+		abortOauthError(c, http.StatusBadRequest, ErrorCodeInvalidRequest, fmt.Sprintf(ErrorParameterRequired, "id_token_hint"))
+		return
+	}
+
+	key, err := DefaultSigningKey()
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		abortOauthError(c, http.StatusInternalServerError, ErrorCodeServerError, dictionary.SomethingWrong)
+		return
+	}
+
+	claims, err := ParseIdTokenHint(LoadConfig(), key, requestLogoutDto.IdTokenHint)
+	if err != nil {
+		utils.LogInfo(err.Error())
+		abortOauthError(c, http.StatusBadRequest, ErrorCodeInvalidRequest, ErrorInvalidIdTokenHint)
+		return
+	}
+
+	if requestLogoutDto.ClientID != "" && !slices.Contains(claims.Audience, requestLogoutDto.ClientID) {
+		abortOauthError(c, http.StatusBadRequest, ErrorCodeInvalidRequest, ErrorLogoutClientMismatch)
+		return
+	}
+
+	// The redirect URI is checked before the logout, an unregistered one must not receive the browser
+	if requestLogoutDto.PostLogoutRedirectUri != "" {
+		client, err := getLogoutClient(claims.Audience[0], requestLogoutDto.PostLogoutRedirectUri)
+		if err != nil {
+			utils.LogError(dictionary.SomethingWrong, err)
+			abortOauthError(c, http.StatusInternalServerError, ErrorCodeServerError, dictionary.SomethingWrong)
+			return
+		}
+
+		if client == nil {
+			abortOauthError(c, http.StatusBadRequest, ErrorCodeInvalidRequest, ErrorPostLogoutRedirectUriNotRegistered)
+			return
+		}
+	}
+
+	if err := endSession(claims, time.Now()); err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		abortOauthError(c, http.StatusInternalServerError, ErrorCodeServerError, dictionary.SomethingWrong)
+		return
+	}
+
+	if requestLogoutDto.PostLogoutRedirectUri != "" {
+		c.Redirect(http.StatusFound, redirectWithParams(requestLogoutDto.PostLogoutRedirectUri, url.Values{
+			"state": {requestLogoutDto.State},
+		}))
+		return
+	}
+
+	c.JSON(http.StatusOK, &SuccessResponseDto{
+		Message: LoggedOut,
+	})
+}
+
+// === Sys
+func validateClient(requestClientDto RequestClientDto, config Config) string {
+	for _, grantType := range requestClientDto.GrantTypes {
+		if !slices.Contains(GrantTypes, grantType) {
+			return fmt.Sprintf(ErrorUnknownGrantType, grantType)
+		}
+	}
+
+	for _, scope := range ParseScope(requestClientDto.Scope) {
+		if !slices.Contains(config.Scopes, scope) {
+			return fmt.Sprintf(ErrorUnknownScope, scope)
+		}
+	}
+
+	for _, redirectUri := range slices.Concat(requestClientDto.RedirectUris, requestClientDto.PostLogoutRedirectUris) {
+		if !IsValidRedirectUri(redirectUri) {
+			return fmt.Sprintf(ErrorInvalidRedirectUri, redirectUri)
+		}
+	}
+
+	isCodeGrant := slices.Contains(requestClientDto.GrantTypes, GrantTypeAuthorizationCode)
+	if isCodeGrant && len(requestClientDto.RedirectUris) == 0 {
+		return ErrorRedirectUriRequired
+	}
+
+	if !isCodeGrant && slices.Contains(requestClientDto.GrantTypes, GrantTypeRefreshToken) {
+		return ErrorRefreshTokenGrantAlone
+	}
+
+	if !requestClientDto.Confidential && slices.Contains(requestClientDto.GrantTypes, GrantTypeClientCredentials) {
+		return ErrorConfidentialClientRequired
+	}
+	return ""
+}
+
+// getRedirectClient returns the client when the redirect URI of the request is registered for it, nil otherwise
+func getRedirectClient(requestAuthorizeDto RequestAuthorizeDto) (*Client, error) {
+	clientId, err := uuid.Parse(requestAuthorizeDto.ClientID)
+	if err != nil {
+		return nil, nil
+	}
+
+	client, err := GetClient(clientId)
+	if err != nil || client == nil || !client.HasRedirectUri(requestAuthorizeDto.RedirectUri) {
+		return nil, err
+	}
+	return client, nil
+}
+
+// checkAuthorizeRequest returns the requested scopes, the client gets all its scopes when none are requested.
+// On failure it returns the error code and description.
+func checkAuthorizeRequest(client *Client, requestAuthorizeDto RequestAuthorizeDto, config Config) ([]string, string, string) {
+	if requestAuthorizeDto.ResponseType != ResponseTypeCode {
+		return nil, ErrorCodeUnsupportedResponse, ErrorResponseTypeNotSupported
+	}
+
+	if !client.HasGrantType(GrantTypeAuthorizationCode) {
+		return nil, ErrorCodeUnauthorizedClient, fmt.Sprintf(ErrorGrantTypeNotAllowed, GrantTypeAuthorizationCode)
+	}
+
+	if requestAuthorizeDto.CodeChallenge == "" || requestAuthorizeDto.CodeChallengeMethod != CodeChallengeMethodS256 {
+		return nil, ErrorCodeInvalidRequest, ErrorCodeChallengeRequired
+	}
+
+	scopes := ParseScope(requestAuthorizeDto.Scope)
+	if len(scopes) == 0 {
+		scopes = ParseScope(client.Scope)
+	}
+
+	for _, scope := range scopes {
+		if !HasScopes(client.Scope, []string{scope}) || !slices.Contains(config.Scopes, scope) {
+			return nil, ErrorCodeInvalidScope, fmt.Sprintf(ErrorScopeNotAllowed, scope)
+		}
+	}
+	return scopes, "", ""
+}
+
+// bindAuthorizeRequest checks the request sent back by the consent screen, errors are answered with 400
+func bindAuthorizeRequest(c *gin.Context, requestAuthorizeDto RequestAuthorizeDto) (*Client, []string, bool) {
+	client, err := getRedirectClient(requestAuthorizeDto)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		abortOauthError(c, http.StatusInternalServerError, ErrorCodeServerError, dictionary.SomethingWrong)
+		return nil, nil, false
+	}
+
+	if client == nil {
+		abortOauthError(c, http.StatusBadRequest, ErrorCodeInvalidRequest, ErrorRedirectUriNotRegistered)
+		return nil, nil, false
+	}
+
+	scopes, code, description := checkAuthorizeRequest(client, requestAuthorizeDto, LoadConfig())
+	if code != "" {
+		abortOauthError(c, http.StatusBadRequest, code, description)
+		return nil, nil, false
+	}
+	return client, scopes, true
+}
+
+// grantAuthorization remembers the consent and issues the authorization code, sessionId is uuid.Nil for tokens
+// without a session
+func grantAuthorization(userId uuid.UUID, sessionId uuid.UUID, client *Client, scopes []string, requestAuthorizeDto RequestAuthorizeDto) (string, error) {
+	now := time.Now()
+	consent, err := GetUserConsent(userId, client.ID)
+	if err != nil {
+		return "", err
+	}
+
+	consentScopes := scopes
+	if consent != nil {
+		consentScopes = ParseScope(consent.Scope + " " + JoinScope(scopes))
+	}
+
+	err = SaveConsent(&Consent{
+		UserID:    userId,
+		ClientID:  client.ID,
+		Scope:     JoinScope(consentScopes),
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	raw, hash, err := auth.NewOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	code := &AuthorizationCode{
+		ClientID:      client.ID,
+		UserID:        userId,
+		CodeHash:      hash,
+		RedirectUri:   requestAuthorizeDto.RedirectUri,
+		Scope:         JoinScope(scopes),
+		CodeChallenge: requestAuthorizeDto.CodeChallenge,
+		Nonce:         requestAuthorizeDto.Nonce,
+		ExpiresAt:     now.Add(LoadConfig().AuthorizationCodeTTL),
+		CreatedAt:     now,
+	}
+	if sessionId != uuid.Nil {
+		code.SessionID = &sessionId
+	}
+	return raw, CreateAuthorizationCode(code)
+}
+
+// authenticateClient finds the client of HTTP Basic or the form fields. isAuthenticated is true when the secret was
+// checked, a public client is identified by its id only. Failures are answered with 401 invalid_client.
+func authenticateClient(c *gin.Context, clientId string, clientSecret string) (*Client, bool, bool) {
+	if basicId, basicSecret, isBasic := c.Request.BasicAuth(); isBasic {
+		// RFC 6749 form-encodes the credentials before Basic encoding
+		clientId, _ = url.QueryUnescape(basicId)
+		clientSecret, _ = url.QueryUnescape(basicSecret)
+	}
+
+	var client *Client
+	if id, err := uuid.Parse(clientId); err == nil {
+		client, err = GetClient(id)
+		if err != nil {
+			utils.LogError(dictionary.SomethingWrong, err)
+			abortOauthError(c, http.StatusInternalServerError, ErrorCodeServerError, dictionary.SomethingWrong)
+			return nil, false, false
+		}
+	}
+
+	isAuthenticated := client != nil && VerifyClientSecret(client, clientSecret)
+	if client == nil || (client.IsConfidential() && !isAuthenticated) || (!client.IsConfidential() && clientSecret != "") {
+		c.Header("WWW-Authenticate", "Basic")
+		abortOauthError(c, http.StatusUnauthorized, ErrorCodeInvalidClient, ErrorClientAuthenticationFailed)
+		return nil, false, false
+	}
+	return client, isAuthenticated, true
+}
+
+func exchangeAuthorizationCode(c *gin.Context, client *Client, requestTokenDto RequestTokenDto) {
+	code, err := GetAuthorizationCode(auth.HashToken(requestTokenDto.Code))
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		abortOauthError(c, http.StatusInternalServerError, ErrorCodeServerError, dictionary.SomethingWrong)
+		return
+	}
+
+	now := time.Now()
+	if code == nil || code.ClientID != client.ID {
+		abortOauthError(c, http.StatusBadRequest, ErrorCodeInvalidGrant, ErrorInvalidAuthorizationCode)
+		return
+	}
+
+	// A replayed code may be stolen, tokens issued for it are revoked
+	if code.UsedAt != nil {
+		revokeTokenFamily(c, code.ID, now, ErrorInvalidAuthorizationCode)
+		return
+	}
+
+	if !code.ExpiresAt.After(now) {
+		abortOauthError(c, http.StatusBadRequest, ErrorCodeInvalidGrant, ErrorInvalidAuthorizationCode)
+		return
+	}
+
+	if code.RedirectUri != requestTokenDto.RedirectUri {
+		abortOauthError(c, http.StatusBadRequest, ErrorCodeInvalidGrant, ErrorRedirectUriMismatch)
+		return
+	}
+
+	if !VerifyCodeChallenge(code.CodeChallenge, requestTokenDto.CodeVerifier) {
+		abortOauthError(c, http.StatusBadRequest, ErrorCodeInvalidGrant, ErrorInvalidCodeVerifier)
+		return
+	}
+
+	account, ok := checkUser(c, code.UserID)
+	if !ok {
+		return
+	}
+
+	withRefresh := client.HasGrantType(GrantTypeRefreshToken)
+	response, tokens, err := newTokens(client, &code.UserID, code.SessionID, code.ID, code.Scope, withRefresh, now)
+	if err == nil {
+		err = addIdToken(response, client, account, code.Nonce, code.SessionID, now)
+	}
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		abortOauthError(c, http.StatusInternalServerError, ErrorCodeServerError, dictionary.SomethingWrong)
+		return
+	}
+
+	isExchanged, err := ExchangeAuthorizationCode(code, tokens, now)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		abortOauthError(c, http.StatusInternalServerError, ErrorCodeServerError, dictionary.SomethingWrong)
+		return
+	}
+
+	if !isExchanged {
+		revokeTokenFamily(c, code.ID, now, ErrorInvalidAuthorizationCode)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func rotateRefreshToken(c *gin.Context, client *Client, requestTokenDto RequestTokenDto) {
+	used, err := GetToken(auth.HashToken(requestTokenDto.RefreshToken))
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		abortOauthError(c, http.StatusInternalServerError, ErrorCodeServerError, dictionary.SomethingWrong)
+		return
+	}
+
+	now := time.Now()
+	if used == nil || used.Type != TokenTypeRefresh || used.ClientID != client.ID || used.UserID == nil ||
+		!used.ExpiresAt.After(now) {
+		abortOauthError(c, http.StatusBadRequest, ErrorCodeInvalidGrant, ErrorInvalidRefreshToken)
+		return
+	}
+
+	if used.RevokedAt != nil {
+		revokeTokenFamily(c, used.FamilyID, now, ErrorInvalidRefreshToken)
+		return
+	}
+
+	// The session may have ended by the idle or absolute timeout, which does not revoke the tokens
+	isSessionActive, err := isTokenSessionActive(used, now)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		abortOauthError(c, http.StatusInternalServerError, ErrorCodeServerError, dictionary.SomethingWrong)
+		return
+	}
+	if !isSessionActive {
+		revokeTokenFamily(c, used.FamilyID, now, ErrorInvalidRefreshToken)
+		return
+	}
+
+	// The scope can be narrowed but never widened
+	scope := used.Scope
+	if requested := ParseScope(requestTokenDto.Scope); len(requested) > 0 {
+		for _, item := range requested {
+			if !HasScopes(used.Scope, []string{item}) {
+				abortOauthError(c, http.StatusBadRequest, ErrorCodeInvalidScope, fmt.Sprintf(ErrorScopeNotGranted, item))
+				return
+			}
+		}
+		scope = JoinScope(requested)
+	}
+
+	account, ok := checkUser(c, *used.UserID)
+	if !ok {
+		return
+	}
+
+	// The refreshed ID token has no nonce, OpenID Connect Core 12.2 allows to omit it
+	response, tokens, err := newTokens(client, used.UserID, used.SessionID, used.FamilyID, scope, true, now)
+	if err == nil {
+		err = addIdToken(response, client, account, "", used.SessionID, now)
+	}
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		abortOauthError(c, http.StatusInternalServerError, ErrorCodeServerError, dictionary.SomethingWrong)
+		return
+	}
+
+	// Only the access token is narrowed, the new refresh token keeps the granted scope
+	tokens[len(tokens)-1].Scope = used.Scope
+
+	isRotated, err := RotateRefreshToken(used, tokens, now)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		abortOauthError(c, http.StatusInternalServerError, ErrorCodeServerError, dictionary.SomethingWrong)
+		return
+	}
+
+	if !isRotated {
+		revokeTokenFamily(c, used.FamilyID, now, ErrorInvalidRefreshToken)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func issueClientCredentials(c *gin.Context, client *Client, requestTokenDto RequestTokenDto) {
+	scopes := ParseScope(requestTokenDto.Scope)
+	if len(scopes) == 0 {
+		scopes = ParseScope(client.Scope)
+	}
+
+	for _, scope := range scopes {
+		if !HasScopes(client.Scope, []string{scope}) {
+			abortOauthError(c, http.StatusBadRequest, ErrorCodeInvalidScope, fmt.Sprintf(ErrorScopeNotAllowed, scope))
+			return
+		}
+	}
+
+	now := time.Now()
+	response, tokens, err := newTokens(client, nil, nil, uuid.New(), JoinScope(scopes), false, now)
+	if err == nil {
+		err = CreateTokens(tokens)
+	}
+
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		abortOauthError(c, http.StatusInternalServerError, ErrorCodeServerError, dictionary.SomethingWrong)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// newTokens returns the response and the tokens to store, the refresh token is the last one
+func newTokens(client *Client, userId *uuid.UUID, sessionId *uuid.UUID, familyId uuid.UUID, scope string, withRefresh bool, now time.Time) (*TokenResponseDto, []*Token, error) {
+	config := LoadConfig()
+	accessToken, accessHash, err := auth.NewOpaqueToken()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	response := &TokenResponseDto{
+		AccessToken: accessToken,
+		TokenType:   auth.TokenTypeBearer,
+		ExpiresIn:   int64(config.AccessTokenTTL.Seconds()),
+		Scope:       scope,
+	}
+	tokens := []*Token{{
+		FamilyID:  familyId,
+		ClientID:  client.ID,
+		UserID:    userId,
+		SessionID: sessionId,
+		Type:      TokenTypeAccess,
+		TokenHash: accessHash,
+		Scope:     scope,
+		ExpiresAt: now.Add(config.AccessTokenTTL),
+		CreatedAt: now,
+	}}
+
+	if withRefresh {
+		refreshToken, refreshHash, err := auth.NewOpaqueToken()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		response.RefreshToken = refreshToken
+		tokens = append(tokens, &Token{
+			FamilyID:  familyId,
+			ClientID:  client.ID,
+			UserID:    userId,
+			SessionID: sessionId,
+			Type:      TokenTypeRefresh,
+			TokenHash: refreshHash,
+			Scope:     scope,
+			ExpiresAt: now.Add(config.RefreshTokenTTL),
+			CreatedAt: now,
+		})
+	}
+	return response, tokens, nil
+}
+
+// addIdToken adds the ID token to the response when the openid scope is granted
+func addIdToken(response *TokenResponseDto, client *Client, account *user.UserItemResultDto, nonce string, sessionId *uuid.UUID, now time.Time) error {
+	if !HasScopes(response.Scope, []string{ScopeOpenid}) {
+		return nil
+	}
+
+	key, err := DefaultSigningKey()
+	if err != nil {
+		return err
+	}
+
+	response.IdToken, err = IssueIdToken(LoadConfig(), key, client.ID, account, response.Scope, nonce, sessionId, now)
+	return err
+}
+
+func revokeTokenFamily(c *gin.Context, familyId uuid.UUID, now time.Time, description string) {
+	if err := RevokeTokenFamily(familyId, now); err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		abortOauthError(c, http.StatusInternalServerError, ErrorCodeServerError, dictionary.SomethingWrong)
+		return
+	}
+	abortOauthError(c, http.StatusBadRequest, ErrorCodeInvalidGrant, description)
+}
+
+// checkUser rejects tokens of deleted, suspended and banned users with invalid_grant, it returns the active user
+func checkUser(c *gin.Context, userId uuid.UUID) (*user.UserItemResultDto, bool) {
+	account, err := getActiveUser(userId)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		abortOauthError(c, http.StatusInternalServerError, ErrorCodeServerError, dictionary.SomethingWrong)
+		return nil, false
+	}
+
+	if account == nil {
+		abortOauthError(c, http.StatusBadRequest, ErrorCodeInvalidGrant, ErrorUserNotAllowed)
+		return nil, false
+	}
+	return account, true
+}
+
+// getActiveUser returns nil for a deleted or blocked user
+func getActiveUser(userId uuid.UUID) (*user.UserItemResultDto, error) {
+	account, err := user.GetOneById(user.RequestUserIdDTO{ID: userId.String()})
+	if err != nil || account == nil || account.ID == uuid.Nil || !account.DeletedAt.IsZero() {
+		return nil, err
+	}
+
+	if user.CheckAccountStatus(account.ID, account.Status, account.SuspendedUntil, account.StatusReason) != nil {
+		return nil, nil
+	}
+	return account, nil
+}
+
+// getLogoutClient returns the client when the post logout redirect URI is registered for it, nil otherwise
+func getLogoutClient(clientId string, redirectUri string) (*Client, error) {
+	id, err := uuid.Parse(clientId)
+	if err != nil {
+		return nil, nil
+	}
+
+	client, err := GetClient(id)
+	if err != nil || client == nil || !client.HasPostLogoutRedirectUri(redirectUri) {
+		return nil, err
+	}
+	return client, nil
+}
+
+// isTokenSessionActive tells whether the sign-in session the token was issued in is active, a token issued without
+// a session has nothing to check
+func isTokenSessionActive(token *Token, now time.Time) (bool, error) {
+	if token.SessionID == nil {
+		return true, nil
+	}
+	return auth.IsSessionActive(*token.SessionID, now)
+}
+
+// endSession ends the sign-in session of the ID token, the session revoke hook revokes OAuth tokens issued in it.
+// A token without sid was issued without a session, there is nothing to end.
+func endSession(claims *IdTokenClaims, now time.Time) error {
+	userId, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return nil
+	}
+
+	sessionId, err := uuid.Parse(claims.SessionID)
+	if err != nil {
+		return nil
+	}
+
+	_, err = auth.RevokeSession(userId, sessionId, now)
+	return err
+}
+
+func introspectToken(raw string, now time.Time) (*IntrospectionDto, error) {
+	inactive := &IntrospectionDto{Active: false}
+	token, err := GetToken(auth.HashToken(raw))
+	if err != nil || token == nil || !token.IsActive(now) {
+		return inactive, err
+	}
+
+	isSessionActive, err := isTokenSessionActive(token, now)
+	if err != nil || !isSessionActive {
+		return inactive, err
+	}
+
+	client, err := GetClient(token.ClientID)
+	if err != nil || client == nil {
+		return inactive, err
+	}
+
+	result := &IntrospectionDto{
+		Active:    true,
+		Scope:     token.Scope,
+		ClientID:  client.ID.String(),
+		TokenType: auth.TokenTypeBearer,
+		Exp:       token.ExpiresAt.Unix(),
+		Iat:       token.CreatedAt.Unix(),
+		Sub:       client.ID.String(),
+		Iss:       auth.LoadConfig().Issuer,
+	}
+	if token.Type == TokenTypeRefresh {
+		result.TokenType = GrantTypeRefreshToken
+	}
+
+	if token.UserID != nil {
+		account, err := getActiveUser(*token.UserID)
+		if err != nil || account == nil {
+			return inactive, err
+		}
+		result.Sub = account.ID.String()
+		result.Username = account.Email
+	}
+	return result, nil
+}
+
+func abortOauthError(c *gin.Context, status int, code string, description string) {
+	c.AbortWithStatusJSON(status, &OauthErrorDto{
+		Error:            code,
+		ErrorDescription: description,
+	})
+}
+
+func clientDto(client Client) ClientDto {
+	return ClientDto{
+		ID:                     client.ID,
+		Name:                   client.Name,
+		RedirectUris:           strings.Fields(client.RedirectUris),
+		PostLogoutRedirectUris: strings.Fields(client.PostLogoutRedirectUris),
+		GrantTypes:             strings.Fields(client.GrantTypes),
+		Scope:                  client.Scope,
+		Confidential:           client.IsConfidential(),
+		CreatedAt:              client.CreatedAt,
+	}
+}
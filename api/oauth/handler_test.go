@@ -0,0 +1,711 @@
+package oauth
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/apiboxgo/library-utils/api_init"
+	"github.com/apiboxgo/library-utils/utils"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+	"user-service/api/auth"
+	"user-service/api/user"
+)
+
+var db *gorm.DB
+
+const testVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk-test-verifier"
+const testRedirectUri = "https://client.example.com/callback"
+
+func init() {
+	api_init.TestInit("../../")
+	db = api_init.InitGlobal.Dbh
+	InitOauthHooks()
+
+	if os.Getenv("AUTH_TOKEN_SECRET") == "" {
+		_ = os.Setenv("AUTH_TOKEN_SECRET", "test-secret")
+	}
+}
+
+func TestAuthorizationCode_FullFlow(t *testing.T) {
+	clearDbTables(t)
+	account, accessToken := createUser(t, "test_user_1@user.com")
+	_, adminToken := createAdmin(t)
+	client := createClient(t, adminToken, RequestClientDto{
+		Name:         "Billing",
+		RedirectUris: []string{testRedirectUri},
+		GrantTypes:   []string{GrantTypeAuthorizationCode, GrantTypeRefreshToken},
+		Scope:        "profile email",
+		Confidential: true,
+	})
+
+	query := authorizeQuery(client.ID, "profile")
+	w := sendRequest(t, UriOauth+UriOauthAuthorize+"?"+query.Encode(), "GET", nil, "", nil)
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.True(t, strings.HasPrefix(w.Header().Get("Location"), LoadConfig().ConsentUrl+"?"))
+
+	var consent ConsentDto
+	w = sendRequest(t, UriOauth+UriOauthConsent+"?"+query.Encode(), "GET", nil, accessToken, &consent)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "Billing", consent.ClientName)
+	assert.Equal(t, []string{"profile"}, consent.Scopes)
+	assert.False(t, consent.Granted)
+
+	code := approve(t, query, accessToken)
+	sendRequest(t, UriOauth+UriOauthConsent+"?"+query.Encode(), "GET", nil, accessToken, &consent)
+	assert.True(t, consent.Granted)
+
+	// The verifier of another authorization does not match
+	var oauthError OauthErrorDto
+	w = sendForm(t, UriOauthToken, url.Values{
+		"grant_type":    {GrantTypeAuthorizationCode},
+		"code":          {code},
+		"redirect_uri":  {testRedirectUri},
+		"code_verifier": {strings.Repeat("a", 43)},
+	}, client, &oauthError)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, ErrorCodeInvalidGrant, oauthError.Error)
+
+	var tokens TokenResponseDto
+	w = sendForm(t, UriOauthToken, url.Values{
+		"grant_type":    {GrantTypeAuthorizationCode},
+		"code":          {code},
+		"redirect_uri":  {testRedirectUri},
+		"code_verifier": {testVerifier},
+	}, client, &tokens)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, tokens.AccessToken)
+	assert.NotEmpty(t, tokens.RefreshToken)
+	assert.Equal(t, "profile", tokens.Scope)
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+
+	var introspection IntrospectionDto
+	w = sendForm(t, UriOauthIntrospect, url.Values{"token": {tokens.AccessToken}}, client, &introspection)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, introspection.Active)
+	assert.Equal(t, account.ID.String(), introspection.Sub)
+	assert.Equal(t, "test_user_1@user.com", introspection.Username)
+	assert.Equal(t, client.ID.String(), introspection.ClientID)
+
+	// Tokens are not accepted by the API of the service itself
+	var errorResult ErrorResponseDto
+	w = sendRequest(t, UriOauth+UriOauthConsent+"?"+query.Encode(), "GET", nil, tokens.AccessToken, &errorResult)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// A replayed code revokes the tokens issued for it
+	w = sendForm(t, UriOauthToken, url.Values{
+		"grant_type":    {GrantTypeAuthorizationCode},
+		"code":          {code},
+		"redirect_uri":  {testRedirectUri},
+		"code_verifier": {testVerifier},
+	}, client, &oauthError)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, ErrorCodeInvalidGrant, oauthError.Error)
+
+	sendForm(t, UriOauthIntrospect, url.Values{"token": {tokens.AccessToken}}, client, &introspection)
+	assert.False(t, introspection.Active)
+}
+
+func TestAuthorize_RejectsUnknownRedirectUri(t *testing.T) {
+	clearDbTables(t)
+	_, adminToken := createAdmin(t)
+	client := createClient(t, adminToken, RequestClientDto{
+		Name:         "Spa",
+		RedirectUris: []string{testRedirectUri},
+		GrantTypes:   []string{GrantTypeAuthorizationCode},
+	})
+
+	query := authorizeQuery(client.ID, "")
+	query.Set("redirect_uri", "https://evil.example.com/callback")
+	var oauthError OauthErrorDto
+	w := sendRequest(t, UriOauth+UriOauthAuthorize+"?"+query.Encode(), "GET", nil, "", &oauthError)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, ErrorCodeInvalidRequest, oauthError.Error)
+
+	// Errors of a request with the registered URI go back to the client
+	query = authorizeQuery(client.ID, "")
+	query.Del("code_challenge")
+	w = sendRequest(t, UriOauth+UriOauthAuthorize+"?"+query.Encode(), "GET", nil, "", nil)
+	assert.Equal(t, http.StatusFound, w.Code)
+	location, _ := url.Parse(w.Header().Get("Location"))
+	assert.Equal(t, ErrorCodeInvalidRequest, location.Query().Get("error"))
+	assert.Equal(t, "xyz", location.Query().Get("state"))
+}
+
+func TestIsValidRedirectUri(t *testing.T) {
+	assert.True(t, IsValidRedirectUri("https://client.example.com/callback"))
+	assert.True(t, IsValidRedirectUri("http://127.0.0.1:8080/callback"))
+	assert.True(t, IsValidRedirectUri("http://[::1]/callback"))
+	assert.True(t, IsValidRedirectUri("http://localhost/callback"))
+	assert.True(t, IsValidRedirectUri("com.example.app:/callback"))
+
+	assert.False(t, IsValidRedirectUri("http://client.example.com/callback"))
+	assert.False(t, IsValidRedirectUri("javascript:alert(document.cookie)"))
+	assert.False(t, IsValidRedirectUri("JavaScript://%0aalert(1)"))
+	assert.False(t, IsValidRedirectUri("data:text/html,<script>alert(1)</script>"))
+	assert.False(t, IsValidRedirectUri("vbscript:msgbox(1)"))
+	assert.False(t, IsValidRedirectUri("myapp:/callback"))
+	assert.False(t, IsValidRedirectUri("https://client.example.com/callback#token"))
+}
+
+func TestRefreshToken_RotationAndRevocation(t *testing.T) {
+	clearDbTables(t)
+	_, accessToken := createUser(t, "test_user_1@user.com")
+	_, adminToken := createAdmin(t)
+	client := createClient(t, adminToken, RequestClientDto{
+		Name:         "Mobile",
+		RedirectUris: []string{"com.example.app:/callback"},
+		GrantTypes:   []string{GrantTypeAuthorizationCode, GrantTypeRefreshToken},
+		Scope:        "profile email",
+	})
+
+	query := authorizeQuery(client.ID, "profile email")
+	query.Set("redirect_uri", "com.example.app:/callback")
+	code := approve(t, query, accessToken)
+
+	var tokens TokenResponseDto
+	w := sendForm(t, UriOauthToken, url.Values{
+		"grant_type":    {GrantTypeAuthorizationCode},
+		"code":          {code},
+		"redirect_uri":  {"com.example.app:/callback"},
+		"code_verifier": {testVerifier},
+	}, client, &tokens)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var rotated TokenResponseDto
+	w = sendForm(t, UriOauthToken, url.Values{
+		"grant_type":    {GrantTypeRefreshToken},
+		"refresh_token": {tokens.RefreshToken},
+		"scope":         {"email"},
+	}, client, &rotated)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "email", rotated.Scope)
+	assert.NotEqual(t, tokens.RefreshToken, rotated.RefreshToken)
+
+	// Reuse of the rotated token revokes the whole authorization
+	var oauthError OauthErrorDto
+	w = sendForm(t, UriOauthToken, url.Values{
+		"grant_type":    {GrantTypeRefreshToken},
+		"refresh_token": {tokens.RefreshToken},
+	}, client, &oauthError)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, ErrorCodeInvalidGrant, oauthError.Error)
+
+	w = sendForm(t, UriOauthToken, url.Values{
+		"grant_type":    {GrantTypeRefreshToken},
+		"refresh_token": {rotated.RefreshToken},
+	}, client, &oauthError)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Revocation of an unknown token succeeds as well
+	var result SuccessResponseDto
+	w = sendForm(t, UriOauthRevoke, url.Values{"token": {"unknown"}}, client, &result)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestClientCredentials_ConfidentialClientOnly(t *testing.T) {
+	clearDbTables(t)
+	_, adminToken := createAdmin(t)
+
+	body, _ := json.Marshal(RequestClientDto{Name: "Public", GrantTypes: []string{GrantTypeClientCredentials}})
+	var errorResult ErrorResponseDto
+	w := sendRequest(t, UriOauth+UriOauthClients, "POST", bytes.NewBuffer(body), adminToken, &errorResult)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, ErrorConfidentialClientRequired, errorResult.Message)
+
+	client := createClient(t, adminToken, RequestClientDto{
+		Name:         "Reports",
+		GrantTypes:   []string{GrantTypeClientCredentials},
+		Scope:        "profile",
+		Confidential: true,
+	})
+
+	var oauthError OauthErrorDto
+	w = sendForm(t, UriOauthToken, url.Values{"grant_type": {GrantTypeClientCredentials}}, &ClientCreatedDto{
+		ClientDto:    client.ClientDto,
+		ClientSecret: "wrong",
+	}, &oauthError)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, ErrorCodeInvalidClient, oauthError.Error)
+
+	var tokens TokenResponseDto
+	w = sendForm(t, UriOauthToken, url.Values{"grant_type": {GrantTypeClientCredentials}}, client, &tokens)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, tokens.RefreshToken)
+	assert.Equal(t, "profile", tokens.Scope)
+
+	var introspection IntrospectionDto
+	sendForm(t, UriOauthIntrospect, url.Values{"token": {tokens.AccessToken}}, client, &introspection)
+	assert.True(t, introspection.Active)
+	assert.Equal(t, client.ID.String(), introspection.Sub)
+
+	// Deleting the client revokes its tokens
+	var result SuccessResponseDto
+	w = sendRequest(t, fmt.Sprintf(UriOauth+UriOauthClientS, client.ID), "DELETE", nil, adminToken, &result)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	token, err := GetToken(auth.HashToken(tokens.AccessToken))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotNil(t, token.RevokedAt)
+}
+
+// TestOpenidConnect_Conformance runs the flow of a relying party with the go-oidc client: discovery, the code
+// exchange with PKCE, ID token verification with the JWKS, nonce and userinfo
+func TestOpenidConnect_Conformance(t *testing.T) {
+	clearDbTables(t)
+	account, accessToken := createUser(t, "test_user_1@user.com")
+	_, adminToken := createAdmin(t)
+	server := startIssuer(t)
+	client := createClient(t, adminToken, RequestClientDto{
+		Name:         "Portal",
+		RedirectUris: []string{testRedirectUri},
+		GrantTypes:   []string{GrantTypeAuthorizationCode, GrantTypeRefreshToken},
+		Scope:        "openid profile email",
+		Confidential: true,
+	})
+
+	ctx := oidc.ClientContext(context.Background(), server.Client())
+	provider, err := oidc.NewProvider(ctx, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var discovery OpenidConfigurationDto
+	sendRequest(t, UriOpenidConfiguration, "GET", nil, "", &discovery)
+	assert.Equal(t, server.URL+UriOauth+UriOauthLogout, discovery.EndSessionEndpoint)
+	assert.Equal(t, []string{CodeChallengeMethodS256}, discovery.CodeChallengeMethodsSupported)
+
+	config := oauth2.Config{
+		ClientID:     client.ID.String(),
+		ClientSecret: client.ClientSecret,
+		Endpoint:     provider.Endpoint(),
+		RedirectURL:  testRedirectUri,
+		Scopes:       []string{oidc.ScopeOpenID, "email"},
+	}
+	verifier := oauth2.GenerateVerifier()
+	authorizeUrl := config.AuthCodeURL("xyz", oidc.Nonce("n-0S6_WzA2Mj"), oauth2.S256ChallengeOption(verifier))
+	code := authorizeWithProvider(t, authorizeUrl, accessToken)
+
+	token, err := config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rawIdToken, _ := token.Extra("id_token").(string)
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: client.ID.String()}).Verify(ctx, rawIdToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, account.ID.String(), idToken.Subject)
+	assert.Equal(t, "n-0S6_WzA2Mj", idToken.Nonce)
+
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "test_user_1@user.com", claims.Email)
+	assert.True(t, claims.EmailVerified)
+
+	// The ID token of another client is rejected by the audience check
+	_, err = provider.Verifier(&oidc.Config{ClientID: uuid.NewString()}).Verify(ctx, rawIdToken)
+	assert.Error(t, err)
+
+	userinfo, err := provider.UserInfo(ctx, oauth2.StaticTokenSource(token))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, account.ID.String(), userinfo.Subject)
+	assert.Equal(t, "test_user_1@user.com", userinfo.Email)
+	assert.True(t, userinfo.EmailVerified)
+
+	// The refreshed ID token keeps the subject and has no nonce
+	refreshed, err := config.TokenSource(ctx, &oauth2.Token{RefreshToken: token.RefreshToken}).Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawIdToken, _ = refreshed.Extra("id_token").(string)
+	idToken, err = provider.Verifier(&oidc.Config{ClientID: client.ID.String()}).Verify(ctx, rawIdToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, account.ID.String(), idToken.Subject)
+	assert.Empty(t, idToken.Nonce)
+
+	// Userinfo needs an OAuth access token with the openid scope
+	var oauthError OauthErrorDto
+	w := sendRequest(t, UriUserinfo, "GET", nil, accessToken, &oauthError)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, ErrorCodeInvalidToken, oauthError.Error)
+	assert.Contains(t, w.Header().Get("WWW-Authenticate"), ErrorCodeInvalidToken)
+
+	code = approve(t, authorizeQuery(client.ID, "profile"), accessToken)
+	var tokens TokenResponseDto
+	sendForm(t, UriOauthToken, url.Values{
+		"grant_type":    {GrantTypeAuthorizationCode},
+		"code":          {code},
+		"redirect_uri":  {testRedirectUri},
+		"code_verifier": {testVerifier},
+	}, client, &tokens)
+	assert.Empty(t, tokens.IdToken)
+
+	w = sendRequest(t, UriUserinfo, "GET", nil, tokens.AccessToken, &oauthError)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, ErrorCodeInsufficientScope, oauthError.Error)
+}
+
+func TestOpenidConnect_Logout(t *testing.T) {
+	clearDbTables(t)
+	account, _ := createUser(t, "test_user_1@user.com")
+	accessToken := createSessionToken(t, account)
+	_, adminToken := createAdmin(t)
+	startIssuer(t)
+	client := createClient(t, adminToken, RequestClientDto{
+		Name:                   "Portal",
+		RedirectUris:           []string{testRedirectUri},
+		PostLogoutRedirectUris: []string{"https://client.example.com/"},
+		GrantTypes:             []string{GrantTypeAuthorizationCode},
+		Scope:                  "openid",
+	})
+
+	query := authorizeQuery(client.ID, "openid")
+	query.Set("nonce", "n-0S6_WzA2Mj")
+	code := approve(t, query, accessToken)
+
+	var tokens TokenResponseDto
+	w := sendForm(t, UriOauthToken, url.Values{
+		"grant_type":    {GrantTypeAuthorizationCode},
+		"code":          {code},
+		"redirect_uri":  {testRedirectUri},
+		"code_verifier": {testVerifier},
+	}, client, &tokens)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, tokens.IdToken)
+
+	// An unregistered URI is rejected before the session is touched
+	logout := url.Values{
+		"id_token_hint":            {tokens.IdToken},
+		"post_logout_redirect_uri": {"https://evil.example.com/"},
+		"state":                    {"abc"},
+	}
+	var oauthError OauthErrorDto
+	w = sendRequest(t, UriOauth+UriOauthLogout+"?"+logout.Encode(), "GET", nil, "", &oauthError)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, ErrorPostLogoutRedirectUriNotRegistered, oauthError.ErrorDescription)
+
+	var userinfo UserinfoDto
+	w = sendRequest(t, UriUserinfo, "GET", nil, tokens.AccessToken, &userinfo)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, account.ID.String(), userinfo.Sub)
+	assert.Empty(t, userinfo.Email)
+
+	logout.Set("post_logout_redirect_uri", "https://client.example.com/")
+	w = sendRequest(t, UriOauth+UriOauthLogout+"?"+logout.Encode(), "GET", nil, "", nil)
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "https://client.example.com/?state=abc", w.Header().Get("Location"))
+
+	// The session and OAuth tokens issued in it are ended
+	var errorResult ErrorResponseDto
+	w = sendRequest(t, UriOauth+UriOauthConsent+"?"+query.Encode(), "GET", nil, accessToken, &errorResult)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = sendRequest(t, UriUserinfo, "GET", nil, tokens.AccessToken, &oauthError)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestSessionRevocation_RevokesOauthTokens(t *testing.T) {
+	clearDbTables(t)
+	account, client, tokens := issueSessionTokens(t)
+
+	// Password change and reset, sign out everywhere end all sessions of the user
+	if _, err := auth.RevokeUserSessions(account.ID, uuid.Nil, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	var count int64
+	db.Model(&Token{}).Where("user_id = ? AND revoked_at IS NULL", account.ID).Count(&count)
+	assert.Equal(t, int64(0), count)
+
+	var oauthError OauthErrorDto
+	w := sendRequest(t, UriUserinfo, "GET", nil, tokens.AccessToken, &oauthError)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = sendForm(t, UriOauthToken, url.Values{
+		"grant_type":    {GrantTypeRefreshToken},
+		"refresh_token": {tokens.RefreshToken},
+	}, client, &oauthError)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, ErrorCodeInvalidGrant, oauthError.Error)
+}
+
+func TestIdleSession_OauthTokensInactive(t *testing.T) {
+	clearDbTables(t)
+	account, client, tokens := issueSessionTokens(t)
+
+	var introspection IntrospectionDto
+	w := sendForm(t, UriOauthIntrospect, url.Values{"token": {tokens.AccessToken}}, client, &introspection)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, introspection.Active)
+
+	// The idle timeout ends the session without revoking its tokens
+	idleSince := time.Now().Add(-auth.LoadConfig().SessionIdleTimeout - time.Minute)
+	db.Model(&auth.Session{}).Where("user_id = ?", account.ID).Update("last_seen_at", idleSince)
+
+	w = sendForm(t, UriOauthIntrospect, url.Values{"token": {tokens.AccessToken}}, client, &introspection)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.False(t, introspection.Active)
+
+	var oauthError OauthErrorDto
+	w = sendRequest(t, UriUserinfo, "GET", nil, tokens.AccessToken, &oauthError)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = sendForm(t, UriOauthToken, url.Values{
+		"grant_type":    {GrantTypeRefreshToken},
+		"refresh_token": {tokens.RefreshToken},
+	}, client, &oauthError)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, ErrorCodeInvalidGrant, oauthError.Error)
+}
+
+// === Sys
+func clearDbTables(t *testing.T) {
+	if err := db.Exec("truncate table oauth_tokens, oauth_authorization_codes, oauth_consents, oauth_clients, user_roles, Users restart identity cascade").Error; err != nil {
+		utils.Dump(err)
+		t.Fatal(err)
+	}
+}
+
+func createUser(t *testing.T, email string, roles ...string) (user.User, string) {
+	now := time.Now()
+	account := user.User{
+		Email:           email,
+		Password:        "",
+		Status:          user.StatusActive,
+		EmailVerifiedAt: &now,
+	}
+	if err := db.Create(&account).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	for _, role := range roles {
+		db.Create(&user.UserRole{UserID: account.ID, Role: role, CreatedAt: now})
+	}
+
+	accessToken, err := auth.IssueAccessToken(auth.LoadConfig(), account.ID, uuid.Nil, roles, "", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return account, accessToken
+}
+
+func createAdmin(t *testing.T) (user.User, string) {
+	return createUser(t, "admin@user.com", user.RoleAdmin)
+}
+
+func createClient(t *testing.T, adminToken string, requestClientDto RequestClientDto) *ClientCreatedDto {
+	body, _ := json.Marshal(requestClientDto)
+	var result ClientCreatedDto
+	w := sendRequest(t, UriOauth+UriOauthClients, "POST", bytes.NewBuffer(body), adminToken, &result)
+	if w.Code != http.StatusCreated {
+		t.Fatal(w.Body.String())
+	}
+	return &result
+}
+
+// createSessionToken signs the user in with a session, so the session can be ended by the logout
+func createSessionToken(t *testing.T, account user.User) string {
+	now := time.Now()
+	session := auth.Session{
+		UserID:     account.ID,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(time.Hour),
+	}
+	if err := db.Create(&session).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	accessToken, err := auth.IssueAccessToken(auth.LoadConfig(), account.ID, session.ID, nil, "", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return accessToken
+}
+
+// issueSessionTokens runs the authorization code flow of a confidential client in a sign-in session of a new user
+func issueSessionTokens(t *testing.T) (user.User, *ClientCreatedDto, TokenResponseDto) {
+	account, _ := createUser(t, "test_user_1@user.com")
+	accessToken := createSessionToken(t, account)
+	_, adminToken := createAdmin(t)
+	startIssuer(t)
+	client := createClient(t, adminToken, RequestClientDto{
+		Name:         "Portal",
+		RedirectUris: []string{testRedirectUri},
+		GrantTypes:   []string{GrantTypeAuthorizationCode, GrantTypeRefreshToken},
+		Scope:        "openid",
+		Confidential: true,
+	})
+
+	query := authorizeQuery(client.ID, "openid")
+	query.Set("nonce", "n-0S6_WzA2Mj")
+	code := approve(t, query, accessToken)
+
+	var tokens TokenResponseDto
+	w := sendForm(t, UriOauthToken, url.Values{
+		"grant_type":    {GrantTypeAuthorizationCode},
+		"code":          {code},
+		"redirect_uri":  {testRedirectUri},
+		"code_verifier": {testVerifier},
+	}, client, &tokens)
+	if w.Code != http.StatusOK {
+		t.Fatal(w.Body.String())
+	}
+	return account, client, tokens
+}
+
+// startIssuer serves the routes on a local address which becomes the issuer, as a relying party sees it
+func startIssuer(t *testing.T) *httptest.Server {
+	router := gin.Default()
+	InitOauthRoutes(router)
+
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	t.Setenv("OIDC_ISSUER", server.URL)
+	return server
+}
+
+// authorizeWithProvider follows the authorization URL of the relying party to the consent screen and approves it
+func authorizeWithProvider(t *testing.T, authorizeUrl string, accessToken string) string {
+	uri, err := url.Parse(authorizeUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := sendRequest(t, uri.RequestURI(), "GET", nil, "", nil)
+	if w.Code != http.StatusFound {
+		t.Fatal(w.Body.String())
+	}
+
+	consentUrl, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return approve(t, consentUrl.Query(), accessToken)
+}
+
+func authorizeQuery(clientId uuid.UUID, scope string) url.Values {
+	challenge := sha256.Sum256([]byte(testVerifier))
+	return url.Values{
+		"response_type":         {ResponseTypeCode},
+		"client_id":             {clientId.String()},
+		"redirect_uri":          {testRedirectUri},
+		"scope":                 {scope},
+		"state":                 {"xyz"},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {CodeChallengeMethodS256},
+	}
+}
+
+// approve sends the consent of the user and returns the authorization code from the redirect URI
+func approve(t *testing.T, query url.Values, accessToken string) string {
+	body, _ := json.Marshal(RequestConsentDto{
+		RequestAuthorizeDto: RequestAuthorizeDto{
+			ResponseType:        query.Get("response_type"),
+			ClientID:            query.Get("client_id"),
+			RedirectUri:         query.Get("redirect_uri"),
+			Scope:               query.Get("scope"),
+			State:               query.Get("state"),
+			Nonce:               query.Get("nonce"),
+			CodeChallenge:       query.Get("code_challenge"),
+			CodeChallengeMethod: query.Get("code_challenge_method"),
+		},
+		Approve: true,
+	})
+
+	var result AuthorizeRedirectDto
+	w := sendRequest(t, UriOauth+UriOauthConsent, "POST", bytes.NewBuffer(body), accessToken, &result)
+	if w.Code != http.StatusOK {
+		t.Fatal(w.Body.String())
+	}
+
+	location, err := url.Parse(result.RedirectUri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "xyz", location.Query().Get("state"))
+	return location.Query().Get("code")
+}
+
+// sendForm posts the form to the endpoint, a confidential client authenticates with HTTP Basic
+func sendForm(t *testing.T, uri string, form url.Values, client *ClientCreatedDto, result any) *httptest.ResponseRecorder {
+	if client.ClientSecret == "" {
+		form.Set("client_id", client.ID.String())
+	}
+
+	req, err := http.NewRequest("POST", UriOauth+uri, strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if client.ClientSecret != "" {
+		req.SetBasicAuth(client.ID.String(), client.ClientSecret)
+	}
+
+	return serve(t, req, result)
+}
+
+func sendRequest(
+	t *testing.T,
+	uri string,
+	method string,
+	body io.Reader,
+	accessToken string,
+	result any,
+) *httptest.ResponseRecorder {
+
+	// Creating test request
+	req, err := http.NewRequest(method, uri, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if accessToken != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("%s %s", auth.TokenTypeBearer, accessToken))
+	}
+
+	return serve(t, req, result)
+}
+
+func serve(t *testing.T, req *http.Request, result any) *httptest.ResponseRecorder {
+	router := gin.Default()
+	InitOauthRoutes(router)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if result != nil {
+		if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w
+}
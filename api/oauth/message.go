@@ -0,0 +1,34 @@
+package oauth
+
+// Service messages which are not present in library-utils dictionary
+
+const ErrorClientNotFound = "OAuth client %s not found"
+const ErrorUnknownGrantType = "Unknown grant type %s"
+const ErrorUnknownScope = "Unknown scope %s"
+const ErrorRedirectUriRequired = "Authorization code grant needs at least one redirect URI"
+const ErrorInvalidRedirectUri = "Invalid redirect URI %s, it must be absolute and have no fragment"
+const ErrorConfidentialClientRequired = "Client credentials grant needs a confidential client"
+const ErrorRefreshTokenGrantAlone = "Refresh token grant is used with the authorization code grant"
+const ErrorRedirectUriNotRegistered = "Unknown client or redirect URI is not registered for it"
+const ErrorResponseTypeNotSupported = "Only response_type=code is supported"
+const ErrorGrantTypeNotAllowed = "Grant type %s is not allowed for the client"
+const ErrorScopeNotAllowed = "Scope %s is not allowed for the client"
+const ErrorCodeChallengeRequired = "PKCE code_challenge with code_challenge_method=S256 is required"
+const ErrorClientAuthenticationFailed = "Client authentication failed"
+const ErrorInvalidAuthorizationCode = "Authorization code is invalid, expired or used"
+const ErrorRedirectUriMismatch = "Redirect URI differs from the authorization request"
+const ErrorInvalidCodeVerifier = "PKCE code_verifier does not match the code_challenge"
+const ErrorInvalidRefreshToken = "Refresh token is invalid, expired or revoked"
+const ErrorScopeNotGranted = "Scope %s was not granted"
+const ErrorUserNotAllowed = "User can not sign in"
+const ErrorParameterRequired = "Parameter %s is required"
+const ErrorInvalidSigningKey = "OIDC signing key %s is not a PEM RSA private key"
+const ErrorInvalidIdTokenHint = "ID token hint is invalid or issued by another issuer"
+const ErrorInvalidAccessToken = "Access token is invalid, expired or revoked"
+const ErrorOpenidScopeRequired = "Access token has no openid scope"
+const ErrorLogoutClientMismatch = "client_id differs from the audience of the ID token hint"
+const ErrorPostLogoutRedirectUriNotRegistered = "Post logout redirect URI is not registered for the client"
+const WarningSigningKeyGenerated = "OIDC_SIGNING_KEY_FILE is not set, ID tokens are signed with a generated key until the restart"
+const ClientDeleted = "OAuth client deleted, its tokens are revoked"
+const TokenRevoked = "Token revoked"
+const LoggedOut = "Logged out"
@@ -0,0 +1,110 @@
+package oauth
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+)
+
+const TokenTypeAccess = "access"
+const TokenTypeRefresh = "refresh"
+
+// Client is an application which signs users in through OAuth. SecretHash is empty for a public client, it proves
+// the authorization with PKCE only. RedirectUris, PostLogoutRedirectUris, GrantTypes and Scope are space separated like
+// the scope parameter.
+type Client struct {
+	ID                     uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4()"`
+	Name                   string     `gorm:"type:varchar(100);not null"`
+	SecretHash             string     `gorm:"type:varchar(64);not null;default:''"`
+	RedirectUris           string     `gorm:"type:text;not null;default:''"`
+	PostLogoutRedirectUris string     `gorm:"type:text;not null;default:''"`
+	GrantTypes             string     `gorm:"type:varchar(255);not null;default:''"`
+	Scope                  string     `gorm:"type:varchar(1024);not null;default:''"`
+	CreatedBy              *uuid.UUID `gorm:"type:uuid;null;default:null"`
+	CreatedAt              time.Time  `gorm:"type:timestamp;not null"`
+	DeletedAt              *time.Time `gorm:"type:timestamp;null;default:null"`
+}
+
+func (Client) TableName() string {
+	return "oauth_clients"
+}
+
+func (p *Client) BeforeCreate(tx *gorm.DB) (err error) {
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	return
+}
+
+// Consent keeps scopes the user has allowed to the client
+type Consent struct {
+	UserID    uuid.UUID `gorm:"type:uuid;primaryKey"`
+	ClientID  uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Scope     string    `gorm:"type:varchar(1024);not null;default:''"`
+	CreatedAt time.Time `gorm:"type:timestamp;not null"`
+	UpdatedAt time.Time `gorm:"type:timestamp;not null"`
+}
+
+func (Consent) TableName() string {
+	return "oauth_consents"
+}
+
+// AuthorizationCode is issued by the consent and exchanged for tokens once, CodeChallenge is the PKCE S256 challenge.
+// Its id is the family of the issued tokens. Nonce and SessionID go into the ID token of OpenID Connect.
+type AuthorizationCode struct {
+	ID            uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4()"`
+	ClientID      uuid.UUID  `gorm:"type:uuid;not null"`
+	UserID        uuid.UUID  `gorm:"type:uuid;not null"`
+	CodeHash      string     `gorm:"type:varchar(64);not null;unique"`
+	RedirectUri   string     `gorm:"type:text;not null"`
+	Scope         string     `gorm:"type:varchar(1024);not null;default:''"`
+	CodeChallenge string     `gorm:"type:varchar(128);not null"`
+	Nonce         string     `gorm:"type:varchar(255);not null;default:''"`
+	SessionID     *uuid.UUID `gorm:"type:uuid;null;default:null"`
+	ExpiresAt     time.Time  `gorm:"type:timestamp;not null"`
+	UsedAt        *time.Time `gorm:"type:timestamp;null;default:null"`
+	CreatedAt     time.Time  `gorm:"type:timestamp;not null"`
+}
+
+func (AuthorizationCode) TableName() string {
+	return "oauth_authorization_codes"
+}
+
+func (p *AuthorizationCode) BeforeCreate(tx *gorm.DB) (err error) {
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	return
+}
+
+// Token is an opaque access or refresh token, only its hash is stored. UserID is nil for the client credentials grant.
+// SessionID is the sign-in session of the user which approved the authorization, logout revokes the tokens with it.
+type Token struct {
+	ID        uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4()"`
+	FamilyID  uuid.UUID  `gorm:"type:uuid;not null"`
+	ClientID  uuid.UUID  `gorm:"type:uuid;not null"`
+	UserID    *uuid.UUID `gorm:"type:uuid;null;default:null"`
+	SessionID *uuid.UUID `gorm:"type:uuid;null;default:null"`
+	Type      string     `gorm:"type:varchar(16);not null"`
+	TokenHash string     `gorm:"type:varchar(64);not null;unique"`
+	Scope     string     `gorm:"type:varchar(1024);not null;default:''"`
+	ExpiresAt time.Time  `gorm:"type:timestamp;not null"`
+	RevokedAt *time.Time `gorm:"type:timestamp;null;default:null"`
+	CreatedAt time.Time  `gorm:"type:timestamp;not null"`
+}
+
+func (Token) TableName() string {
+	return "oauth_tokens"
+}
+
+func (p *Token) BeforeCreate(tx *gorm.DB) (err error) {
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	return
+}
+
+// IsActive tells whether the token is neither revoked nor expired
+func (p *Token) IsActive(now time.Time) bool {
+	return p.RevokedAt == nil && p.ExpiresAt.After(now)
+}
@@ -0,0 +1,185 @@
+package oauth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+	"user-service/api/auth"
+	"user-service/env"
+)
+
+const GrantTypeAuthorizationCode = "authorization_code"
+const GrantTypeRefreshToken = "refresh_token"
+const GrantTypeClientCredentials = "client_credentials"
+
+var GrantTypes = []string{GrantTypeAuthorizationCode, GrantTypeRefreshToken, GrantTypeClientCredentials}
+
+const ResponseTypeCode = "code"
+
+// CodeChallengeMethodS256 is the only PKCE method accepted, plain would send the verifier through the browser
+const CodeChallengeMethodS256 = "S256"
+
+// Error codes of RFC 6749
+const (
+	ErrorCodeInvalidRequest       = "invalid_request"
+	ErrorCodeInvalidClient        = "invalid_client"
+	ErrorCodeInvalidGrant         = "invalid_grant"
+	ErrorCodeInvalidScope         = "invalid_scope"
+	ErrorCodeUnauthorizedClient   = "unauthorized_client"
+	ErrorCodeUnsupportedGrantType = "unsupported_grant_type"
+	ErrorCodeUnsupportedResponse  = "unsupported_response_type"
+	ErrorCodeAccessDenied         = "access_denied"
+	ErrorCodeServerError          = "server_error"
+)
+
+// Error codes of RFC 6750, the userinfo endpoint answers with them
+const (
+	ErrorCodeInvalidToken      = "invalid_token"
+	ErrorCodeInsufficientScope = "insufficient_scope"
+)
+
+type Config struct {
+	// Scopes which clients can be registered with
+	Scopes               []string
+	AuthorizationCodeTTL time.Duration
+	AccessTokenTTL       time.Duration
+	RefreshTokenTTL      time.Duration
+	// ConsentUrl is the page of the frontend which signs the user in and shows the consent screen, GET /oauth/authorize
+	// redirects there with the request parameters
+	ConsentUrl string
+	// Issuer is the public base URL of the service, it is the iss claim of ID tokens and the prefix of the endpoints
+	// in the discovery document
+	Issuer     string
+	IdTokenTTL time.Duration
+	// SigningKeyFile is the PEM RSA key which signs ID tokens, a key is generated at the start when it is empty
+	SigningKeyFile string
+}
+
+func LoadConfig() Config {
+	return Config{
+		Scopes:               env.List("OAUTH_SCOPES", []string{ScopeOpenid, "profile", ScopeEmail}),
+		AuthorizationCodeTTL: env.Duration("OAUTH_AUTHORIZATION_CODE_TTL", time.Minute),
+		AccessTokenTTL:       env.Duration("OAUTH_ACCESS_TOKEN_TTL", time.Hour),
+		RefreshTokenTTL:      env.Duration("OAUTH_REFRESH_TOKEN_TTL", 30*24*time.Hour),
+		ConsentUrl:           env.String("OAUTH_CONSENT_URL", "http://127.0.0.1:8081/oauth/consent"),
+		Issuer:               env.String("OIDC_ISSUER", "http://127.0.0.1:8081"),
+		IdTokenTTL:           env.Duration("OIDC_ID_TOKEN_TTL", time.Hour),
+		SigningKeyFile:       env.String("OIDC_SIGNING_KEY_FILE", ""),
+	}
+}
+
+// ParseScope splits the scope parameter, duplicates are dropped
+func ParseScope(scope string) []string {
+	var result []string
+	for _, item := range strings.Fields(scope) {
+		if !slices.Contains(result, item) {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+func JoinScope(scopes []string) string {
+	return strings.Join(scopes, " ")
+}
+
+// HasScopes tells whether all requested scopes are in the granted ones
+func HasScopes(granted string, requested []string) bool {
+	grantedScopes := ParseScope(granted)
+	for _, scope := range requested {
+		if !slices.Contains(grantedScopes, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// VerifyCodeChallenge checks the PKCE verifier against the S256 challenge stored with the code
+func VerifyCodeChallenge(challenge string, verifier string) bool {
+	// RFC 7636 verifier is 43-128 characters, shorter ones are too weak
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+
+	hash := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(hash[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+// IsValidRedirectUri accepts absolute URIs without a fragment: https, http of the loopback interface and private-use
+// schemes of native apps in reverse domain notation (RFC 8252), for example com.example.app:/callback
+func IsValidRedirectUri(raw string) bool {
+	uri, err := url.Parse(raw)
+	if err != nil || !uri.IsAbs() || uri.Fragment != "" || strings.ContainsAny(raw, " #") {
+		return false
+	}
+
+	switch scheme := strings.ToLower(uri.Scheme); scheme {
+	case "https":
+		return uri.Host != ""
+	case "http":
+		return isLoopbackHost(uri.Hostname())
+	case "javascript", "data", "vbscript", "file":
+		return false
+	default:
+		return strings.Contains(scheme, ".")
+	}
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// VerifyClientSecret compares hashes, so the comparison takes the same time for any secret
+func VerifyClientSecret(client *Client, secret string) bool {
+	if client.SecretHash == "" || secret == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(client.SecretHash), []byte(auth.HashToken(secret))) == 1
+}
+
+func (client *Client) IsConfidential() bool {
+	return client.SecretHash != ""
+}
+
+func (client *Client) HasGrantType(grantType string) bool {
+	return slices.Contains(strings.Fields(client.GrantTypes), grantType)
+}
+
+// HasPostLogoutRedirectUri matches the URIs registered for RP-initiated logout exactly
+func (client *Client) HasPostLogoutRedirectUri(redirectUri string) bool {
+	return slices.Contains(strings.Fields(client.PostLogoutRedirectUris), redirectUri)
+}
+
+// HasRedirectUri matches the registered URIs exactly, as RFC 6749 recommends
+func (client *Client) HasRedirectUri(redirectUri string) bool {
+	return slices.Contains(strings.Fields(client.RedirectUris), redirectUri)
+}
+
+// redirectWithParams adds the parameters to the query of the redirect URI
+func redirectWithParams(redirectUri string, params url.Values) string {
+	uri, err := url.Parse(redirectUri)
+	if err != nil {
+		return redirectUri
+	}
+
+	query := uri.Query()
+	for key, values := range params {
+		for _, value := range values {
+			if value != "" {
+				query.Add(key, value)
+			}
+		}
+	}
+	uri.RawQuery = query.Encode()
+	return uri.String()
+}
@@ -0,0 +1,201 @@
+package oauth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/apiboxgo/library-utils/utils"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"user-service/api/user"
+)
+
+// ScopeOpenid makes the authorization an OpenID Connect one, the token response has the ID token then
+const ScopeOpenid = "openid"
+
+// ScopeEmail adds email and email_verified claims to the ID token and userinfo
+const ScopeEmail = "email"
+
+// SigningAlgorithm of ID tokens, clients verify them with the public key of the JWKS
+const SigningAlgorithm = "RS256"
+
+const signingKeyBits = 2048
+
+// SigningKey signs ID tokens, ID is the RFC 7638 thumbprint of the public key published as kid
+type SigningKey struct {
+	ID  string
+	Key *rsa.PrivateKey
+}
+
+// IdTokenClaims of OpenID Connect Core, Subject is the user id and Audience is the client id
+type IdTokenClaims struct {
+	Nonce         string `json:"nonce,omitempty"`
+	SessionID     string `json:"sid,omitempty"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified *bool  `json:"email_verified,omitempty"`
+	jwt.RegisteredClaims
+}
+
+var defaultSigningKey *SigningKey
+var defaultSigningKeyMutex sync.Mutex
+
+// DefaultSigningKey reads the PEM key of OIDC_SIGNING_KEY_FILE once. Without the file a key is generated, it lives
+// until the restart and is not shared by instances, so ID tokens of other instances can not be verified.
+func DefaultSigningKey() (*SigningKey, error) {
+	defaultSigningKeyMutex.Lock()
+	defer defaultSigningKeyMutex.Unlock()
+	if defaultSigningKey != nil {
+		return defaultSigningKey, nil
+	}
+
+	path := LoadConfig().SigningKeyFile
+	var key *rsa.PrivateKey
+	var err error
+	if path == "" {
+		utils.LogInfo(WarningSigningKeyGenerated)
+		key, err = rsa.GenerateKey(rand.Reader, signingKeyBits)
+	} else {
+		key, err = readSigningKey(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	defaultSigningKey = &SigningKey{ID: keyThumbprint(&key.PublicKey), Key: key}
+	return defaultSigningKey, nil
+}
+
+// IssueIdToken signs the ID token of the user for the client, email claims are added with the email scope
+func IssueIdToken(config Config, key *SigningKey, clientId uuid.UUID, account *user.UserItemResultDto, scope string, nonce string, sessionId *uuid.UUID, now time.Time) (string, error) {
+	claims := IdTokenClaims{
+		Nonce: nonce,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    config.Issuer,
+			Subject:   account.ID.String(),
+			Audience:  jwt.ClaimStrings{clientId.String()},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(config.IdTokenTTL)),
+		},
+	}
+	if sessionId != nil {
+		claims.SessionID = sessionId.String()
+	}
+	if HasScopes(scope, []string{ScopeEmail}) {
+		isVerified := account.EmailVerifiedAt != nil
+		claims.Email = account.Email
+		claims.EmailVerified = &isVerified
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Key)
+}
+
+// ParseIdTokenHint checks the signature and the issuer of the ID token sent to the logout. An expired token is
+// accepted, RPs usually log out long after the sign-in.
+func ParseIdTokenHint(config Config, key *SigningKey, raw string) (*IdTokenClaims, error) {
+	claims := &IdTokenClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		return &key.Key.PublicKey, nil
+	},
+		jwt.WithValidMethods([]string{SigningAlgorithm}),
+		jwt.WithoutClaimsValidation(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Issuer != config.Issuer || len(claims.Audience) == 0 {
+		return nil, errors.New(ErrorInvalidIdTokenHint)
+	}
+	return claims, nil
+}
+
+// jwks publishes the public key in the JWK Set format of RFC 7517
+func jwks(key *SigningKey) JwksDto {
+	return JwksDto{Keys: []JwkDto{{
+		Kty: "RSA",
+		Use: "sig",
+		Alg: SigningAlgorithm,
+		Kid: key.ID,
+		N:   base64.RawURLEncoding.EncodeToString(key.Key.PublicKey.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.Key.PublicKey.E)).Bytes()),
+	}}}
+}
+
+// openidConfiguration is the discovery document, endpoints are under the issuer URL
+func openidConfiguration(config Config) OpenidConfigurationDto {
+	issuer := strings.TrimSuffix(config.Issuer, "/")
+	return OpenidConfigurationDto{
+		Issuer:                            config.Issuer,
+		AuthorizationEndpoint:             issuer + UriOauth + UriOauthAuthorize,
+		TokenEndpoint:                     issuer + UriOauth + UriOauthToken,
+		UserinfoEndpoint:                  issuer + UriUserinfo,
+		JwksUri:                           issuer + UriJwks,
+		EndSessionEndpoint:                issuer + UriOauth + UriOauthLogout,
+		IntrospectionEndpoint:             issuer + UriOauth + UriOauthIntrospect,
+		RevocationEndpoint:                issuer + UriOauth + UriOauthRevoke,
+		ScopesSupported:                   config.Scopes,
+		ResponseTypesSupported:            []string{ResponseTypeCode},
+		GrantTypesSupported:               GrantTypes,
+		SubjectTypesSupported:             []string{"public"},
+		IdTokenSigningAlgValuesSupported:  []string{SigningAlgorithm},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		ClaimsSupported:                   []string{"iss", "sub", "aud", "exp", "iat", "nonce", "sid", "email", "email_verified"},
+		CodeChallengeMethodsSupported:     []string{CodeChallengeMethodS256},
+	}
+}
+
+func readSigningKey(path string) (*rsa.PrivateKey, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, fmt.Errorf(ErrorInvalidSigningKey, path)
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	key, isRsa := parsed.(*rsa.PrivateKey)
+	if !isRsa {
+		return nil, fmt.Errorf(ErrorInvalidSigningKey, path)
+	}
+	return key, nil
+}
+
+// keyThumbprint is the RFC 7638 thumbprint, members are in the lexicographic order the RFC requires
+func keyThumbprint(key *rsa.PublicKey) string {
+	members, _ := json.Marshal(struct {
+		E   string `json:"e"`
+		Kty string `json:"kty"`
+		N   string `json:"n"`
+	}{
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		Kty: "RSA",
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+	})
+
+	hash := sha256.Sum256(members)
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
@@ -0,0 +1,176 @@
+package oauth
+
+import (
+	"github.com/apiboxgo/library-utils/api_init"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+)
+
+func CreateClient(client *Client) error {
+	return api_init.GetDbh().Create(client).Error
+}
+
+// GetClient returns nil for an unknown or deleted client
+func GetClient(id uuid.UUID) (*Client, error) {
+	var result Client
+	err := api_init.GetDbh().
+		Where("id = ? AND deleted_at IS NULL", id).
+		Limit(1).
+		Find(&result).Error
+	if err != nil || result.ID == uuid.Nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func GetClients() ([]Client, error) {
+	var result []Client
+	err := api_init.GetDbh().
+		Where("deleted_at IS NULL").
+		Order("created_at").
+		Find(&result).Error
+	return result, err
+}
+
+// DeleteClient marks the client deleted and revokes its tokens in one transaction, false means it is not found
+func DeleteClient(id uuid.UUID, now time.Time) (bool, error) {
+	isDeleted := false
+	err := api_init.GetDbh().Transaction(func(tx *gorm.DB) error {
+		update := tx.Model(&Client{}).
+			Where("id = ? AND deleted_at IS NULL", id).
+			Update("deleted_at", now)
+		if update.Error != nil {
+			return update.Error
+		}
+
+		isDeleted = update.RowsAffected > 0
+		return tx.Model(&Token{}).
+			Where("client_id = ? AND revoked_at IS NULL", id).
+			Update("revoked_at", now).Error
+	})
+	return isDeleted, err
+}
+
+// GetUserConsent returns nil when the user has not allowed anything to the client
+func GetUserConsent(userId uuid.UUID, clientId uuid.UUID) (*Consent, error) {
+	var result Consent
+	err := api_init.GetDbh().
+		Where("user_id = ? AND client_id = ?", userId, clientId).
+		Limit(1).
+		Find(&result).Error
+	if err != nil || result.UserID == uuid.Nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// SaveConsent replaces scopes allowed by the user to the client
+func SaveConsent(consent *Consent) error {
+	return api_init.GetDbh().Exec(
+		`INSERT INTO oauth_consents (user_id, client_id, scope, created_at, updated_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (user_id, client_id) DO UPDATE SET scope = EXCLUDED.scope, updated_at = EXCLUDED.updated_at`,
+		consent.UserID, consent.ClientID, consent.Scope, consent.CreatedAt, consent.UpdatedAt,
+	).Error
+}
+
+func CreateAuthorizationCode(code *AuthorizationCode) error {
+	return api_init.GetDbh().Create(code).Error
+}
+
+// GetAuthorizationCode returns the code whether it is used or not, so a replayed code can be detected
+func GetAuthorizationCode(hash string) (*AuthorizationCode, error) {
+	var result AuthorizationCode
+	err := api_init.GetDbh().
+		Where("code_hash = ?", hash).
+		Limit(1).
+		Find(&result).Error
+	if err != nil || result.ID == uuid.Nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ExchangeAuthorizationCode marks the code used and stores the issued tokens in one transaction.
+// It returns false when the code was used concurrently.
+func ExchangeAuthorizationCode(code *AuthorizationCode, tokens []*Token, now time.Time) (bool, error) {
+	isExchanged := false
+	err := api_init.GetDbh().Transaction(func(tx *gorm.DB) error {
+		update := tx.Model(&AuthorizationCode{}).
+			Where("id = ? AND used_at IS NULL", code.ID).
+			Update("used_at", now)
+		if update.Error != nil {
+			return update.Error
+		}
+
+		isExchanged = update.RowsAffected > 0
+		if !isExchanged {
+			return nil
+		}
+		return tx.Create(tokens).Error
+	})
+	return isExchanged, err
+}
+
+func CreateTokens(tokens []*Token) error {
+	return api_init.GetDbh().Create(tokens).Error
+}
+
+// GetToken returns the token whether it is active or not, nil means it is unknown
+func GetToken(hash string) (*Token, error) {
+	var result Token
+	err := api_init.GetDbh().
+		Where("token_hash = ?", hash).
+		Limit(1).
+		Find(&result).Error
+	if err != nil || result.ID == uuid.Nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// RotateRefreshToken revokes the used refresh token and stores the tokens issued for it in one transaction.
+// It returns false when the used token was revoked concurrently.
+func RotateRefreshToken(used *Token, tokens []*Token, now time.Time) (bool, error) {
+	isRotated := false
+	err := api_init.GetDbh().Transaction(func(tx *gorm.DB) error {
+		update := tx.Model(&Token{}).
+			Where("id = ? AND revoked_at IS NULL", used.ID).
+			Update("revoked_at", now)
+		if update.Error != nil {
+			return update.Error
+		}
+
+		isRotated = update.RowsAffected > 0
+		if !isRotated {
+			return nil
+		}
+		return tx.Create(tokens).Error
+	})
+	return isRotated, err
+}
+
+func RevokeToken(id uuid.UUID, now time.Time) error {
+	return api_init.GetDbh().Model(&Token{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", now).Error
+}
+
+// RevokeTokenFamily revokes all tokens of one authorization
+func RevokeTokenFamily(familyId uuid.UUID, now time.Time) error {
+	return api_init.GetDbh().Model(&Token{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyId).
+		Update("revoked_at", now).Error
+}
+
+// revokeSessionTokens is the session revoke hook, it revokes tokens issued to clients in the ended sessions of the
+// user. When all sessions of the user end, tokens issued without a session are revoked as well.
+func revokeSessionTokens(tx *gorm.DB, userId uuid.UUID, sessionId uuid.UUID, keep uuid.UUID, now time.Time) error {
+	query := tx.Model(&Token{}).Where("user_id = ? AND revoked_at IS NULL", userId)
+	if sessionId != uuid.Nil {
+		query = query.Where("session_id = ?", sessionId)
+	} else {
+		query = query.Where("session_id IS NULL OR session_id <> ?", keep)
+	}
+	return query.Update("revoked_at", now).Error
+}
@@ -0,0 +1,51 @@
+package oauth
+
+import (
+	"github.com/gin-gonic/gin"
+	"user-service/api/auth"
+	"user-service/api/user"
+)
+
+const UriOauth = "/oauth"
+const UriOauthAuthorize = "/authorize"
+const UriOauthConsent = "/consent"
+const UriOauthToken = "/token"
+const UriOauthIntrospect = "/introspect"
+const UriOauthRevoke = "/revoke"
+const UriOauthLogout = "/logout"
+const UriOauthClients = "/clients"
+const UriOauthClient = "/clients/:id"
+const UriOauthClientS = "/clients/%s"
+
+// OpenID Connect endpoints are at the root of the issuer URL, as discovery requires
+const UriOpenidConfiguration = "/.well-known/openid-configuration"
+const UriJwks = "/.well-known/jwks.json"
+const UriUserinfo = "/userinfo"
+
+// InitOauthHooks makes auth revoke OAuth tokens together with sessions, it has to be called before requests are served
+func InitOauthHooks() {
+	auth.RegisterSessionRevokeHook("oauth", revokeSessionTokens)
+}
+
+func InitOauthRoutes(route *gin.Engine) {
+	group := route.Group(UriOauth)
+	group.GET(UriOauthAuthorize, Authorize)
+	group.GET(UriOauthConsent, auth.RequireInteractiveAuth(), GetConsentRequest)
+	group.POST(UriOauthConsent, auth.RequireInteractiveAuth(), PostConsent)
+	group.POST(UriOauthToken, IssueToken)
+	group.POST(UriOauthIntrospect, Introspect)
+	group.POST(UriOauthRevoke, Revoke)
+	group.GET(UriOauthLogout, Logout)
+	group.POST(UriOauthLogout, Logout)
+
+	route.GET(UriOpenidConfiguration, GetOpenidConfiguration)
+	route.GET(UriJwks, GetJwks)
+	route.GET(UriUserinfo, GetUserinfo)
+	route.POST(UriUserinfo, GetUserinfo)
+
+	// Clients are registered by admins only, support has no access to them
+	admin := group.Group("", auth.RequireAuth(), auth.RequireVerifiedEmail(auth.ActionAdmin), auth.RequireRole(user.RoleAdmin))
+	admin.POST(UriOauthClients, CreateClientItem)
+	admin.GET(UriOauthClients, GetClientList)
+	admin.DELETE(UriOauthClient, DeleteClientById)
+}
@@ -0,0 +1,5 @@
+package service
+
+type ErrorResponseDto struct {
+	Message string `json:"message"`
+}
@@ -0,0 +1,8 @@
+package service
+
+const ErrorInvalidSignature = "Invalid service signature"
+const ErrorServiceRequired = "Service authentication is required"
+const ErrorServiceScope = "Service scopes do not allow the request"
+const ErrorSignedBodyTooLarge = "Body of the signed request is larger than %d bytes"
+const ErrorClientCaInvalid = "No certificates found in the client CA file %s"
+const ErrorTlsKeyPair = "Both SERVER_TLS_CERT_FILE and SERVER_TLS_KEY_FILE must be set"
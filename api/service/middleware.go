@@ -0,0 +1,86 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"github.com/apiboxgo/library-utils/dictionary"
+	"github.com/apiboxgo/library-utils/utils"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+)
+
+// Authenticate recognises the service by the verified client certificate or by the signed request and checks its
+// scopes. A request without a service identity is let through unless SERVICE_AUTH_REQUIRED is set, a wrong signature
+// is rejected.
+func Authenticate() gin.HandlerFunc {
+	config := LoadConfig()
+	return authenticateWith(config, config.Required)
+}
+
+// Identify is Authenticate which always lets through requests without a service identity, for routes shared by
+// services and signed-in users
+func Identify() gin.HandlerFunc {
+	return authenticateWith(LoadConfig(), false)
+}
+
+func authenticateWith(config Config, required bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		caller, err := authenticate(c, config, time.Now())
+		if errors.Is(err, ErrInvalidSignature) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, &ErrorResponseDto{
+				Message: ErrorInvalidSignature,
+			})
+			return
+		}
+
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, &ErrorResponseDto{
+				Message: fmt.Sprintf(ErrorSignedBodyTooLarge, config.MaxBodySize),
+			})
+			return
+		}
+
+		if err != nil {
+			utils.LogError(dictionary.SomethingWrong, err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, &ErrorResponseDto{
+				Message: dictionary.SomethingWrong,
+			})
+			return
+		}
+
+		if caller == nil {
+			if required {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, &ErrorResponseDto{
+					Message: ErrorServiceRequired,
+				})
+				return
+			}
+
+			c.Next()
+			return
+		}
+
+		if !caller.Allows(c.Request.Method) {
+			c.AbortWithStatusJSON(http.StatusForbidden, &ErrorResponseDto{
+				Message: ErrorServiceScope,
+			})
+			return
+		}
+
+		c.Set(ContextCaller, caller)
+		c.Next()
+	}
+}
+
+// authenticate prefers the client certificate, the signature is checked when the certificate belongs to no service
+func authenticate(c *gin.Context, config Config, now time.Time) (*Caller, error) {
+	if state := c.Request.TLS; state != nil && len(state.VerifiedChains) > 0 && len(state.VerifiedChains[0]) > 0 {
+		if caller := FindByCertificate(state.VerifiedChains[0][0]); caller != nil {
+			return caller, nil
+		}
+	}
+
+	return verifySignature(c.Writer, c.Request, config, now)
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/apiboxgo/library-utils/api_init"
+	"github.com/apiboxgo/library-utils/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var db *gorm.DB
+
+func init() {
+	api_init.TestInit("../../")
+	db = api_init.InitGlobal.Dbh
+}
+
+func TestAuthenticate_SignedRequest(t *testing.T) {
+	clearDbTables(t)
+	setBillingCaller(t)
+	router := newEchoRouter()
+
+	nonce := uuid.NewString()
+	var result map[string]string
+	w := sendSigned(t, router, `{"status":"active"}`, nonce, &result)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "billing", result["caller"])
+	assert.Equal(t, `{"status":"active"}`, result["body"], "the handler reads the body after the signature check")
+
+	var count int64
+	db.Model(&Nonce{}).Where("service = ? AND nonce = ?", "billing", nonce).Count(&count)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestAuthenticate_ReplayedNonce(t *testing.T) {
+	clearDbTables(t)
+	setBillingCaller(t)
+	router := newEchoRouter()
+
+	nonce := uuid.NewString()
+	var result ErrorResponseDto
+	w := sendSigned(t, router, `{"status":"active"}`, nonce, &result)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = sendSigned(t, router, `{"status":"active"}`, nonce, &result)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, ErrorInvalidSignature, result.Message)
+}
+
+func TestAuthenticate_BodyTooLarge(t *testing.T) {
+	clearDbTables(t)
+	setBillingCaller(t)
+	t.Setenv("SERVICE_SIGNATURE_MAX_BODY_BYTES", "16")
+	router := newEchoRouter()
+
+	var result ErrorResponseDto
+	w := sendSigned(t, router, `{"status":"suspended"}`, uuid.NewString(), &result)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	var count int64
+	db.Model(&Nonce{}).Count(&count)
+	assert.Equal(t, int64(0), count, "the nonce is not used up by a rejected request")
+}
+
+// === Sys
+func clearDbTables(t *testing.T) {
+	if err := db.Exec("truncate table service_nonces").Error; err != nil {
+		utils.Dump(err)
+		t.Fatal(err)
+	}
+}
+
+func setBillingCaller(t *testing.T) {
+	t.Setenv("SERVICE_AUTH_REQUIRED", "true")
+	t.Setenv("SERVICE_CALLERS", "billing")
+	t.Setenv("SERVICE_BILLING_HMAC_SECRET", "secret")
+	t.Setenv("SERVICE_BILLING_SCOPES", "read,write")
+}
+
+// newEchoRouter answers authenticated requests with the service name and the body the handler has read
+func newEchoRouter() *gin.Engine {
+	router := gin.New()
+	router.POST("/echo", Authenticate(), func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		c.JSON(http.StatusOK, map[string]string{"caller": GetCaller(c).Name, "body": string(body)})
+	})
+	return router
+}
+
+func sendSigned(t *testing.T, router *gin.Engine, body string, nonce string, result any) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(http.MethodPost, "/echo", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Sign(req, "billing", "secret", nonce, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
@@ -0,0 +1,17 @@
+package service
+
+import (
+	"time"
+)
+
+// Nonce is a used nonce of a signed request, it is kept until the timestamp of the request is out of the window, so
+// the same request can not be sent again
+type Nonce struct {
+	Service   string    `gorm:"type:varchar(100);primaryKey"`
+	Nonce     string    `gorm:"type:varchar(64);primaryKey"`
+	ExpiresAt time.Time `gorm:"type:timestamp;not null"`
+}
+
+func (Nonce) TableName() string {
+	return "service_nonces"
+}
@@ -0,0 +1,24 @@
+package service
+
+import (
+	"github.com/apiboxgo/library-utils/api_init"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+// useNonce stores the nonce of the service and returns false when it has been used, expired nonces are dropped in the
+// same transaction
+func useNonce(nonce *Nonce, now time.Time) (bool, error) {
+	isNew := false
+	err := api_init.GetDbh().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("expires_at <= ?", now).Delete(&Nonce{}).Error; err != nil {
+			return err
+		}
+
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(nonce)
+		isNew = result.RowsAffected > 0
+		return result.Error
+	})
+	return isNew, err
+}
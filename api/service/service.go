@@ -0,0 +1,106 @@
+package service
+
+import (
+	"crypto/x509"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"user-service/env"
+)
+
+// ContextCaller is the gin context key of the service the request is authenticated as
+const ContextCaller = "service_caller"
+
+// ScopeRead allows safe methods only, ScopeWrite allows any method
+const ScopeRead = "read"
+const ScopeWrite = "write"
+
+// Caller is an internal service. It is recognised by the client certificate matching any of CertSubjects, or by the
+// request signed with HmacSecret.
+type Caller struct {
+	Name   string
+	Scopes []string
+	// CertSubjects are the common name, DNS or URI names (example: spiffe://cluster/ns/billing) of the certificate
+	CertSubjects []string
+	HmacSecret   string
+}
+
+type Config struct {
+	// Required rejects requests to the protected routes without a service identity
+	Required bool
+	// SignatureWindow is how far the timestamp of a signed request may be from the server time
+	SignatureWindow time.Duration
+	// MaxBodySize limits the body of a signed request, the whole body is held in memory until the signature is
+	// checked, so the handler reads only verified data. Callers with a client certificate are not limited.
+	MaxBodySize int64
+}
+
+func LoadConfig() Config {
+	return Config{
+		Required:        env.Bool("SERVICE_AUTH_REQUIRED", false),
+		SignatureWindow: env.Duration("SERVICE_SIGNATURE_WINDOW", 5*time.Minute),
+		MaxBodySize:     int64(env.Int("SERVICE_SIGNATURE_MAX_BODY_BYTES", 10*1024*1024)),
+	}
+}
+
+// Callers returns names of the services listed in SERVICE_CALLERS
+func Callers() []string {
+	return env.List("SERVICE_CALLERS", nil)
+}
+
+// LoadCaller reads SERVICE_<NAME>_* variables of the service, nil means it is not listed in SERVICE_CALLERS
+func LoadCaller(name string) *Caller {
+	if !slices.Contains(Callers(), name) {
+		return nil
+	}
+
+	prefix := "SERVICE_" + strings.ToUpper(name) + "_"
+	return &Caller{
+		Name:         name,
+		Scopes:       env.List(prefix+"SCOPES", []string{ScopeRead}),
+		CertSubjects: env.List(prefix+"CERT_SUBJECTS", nil),
+		HmacSecret:   env.String(prefix+"HMAC_SECRET", ""),
+	}
+}
+
+// FindByCertificate returns the service of the verified client certificate or nil
+func FindByCertificate(certificate *x509.Certificate) *Caller {
+	names := []string{certificate.Subject.CommonName}
+	names = append(names, certificate.DNSNames...)
+	for _, uri := range certificate.URIs {
+		names = append(names, uri.String())
+	}
+
+	for _, name := range Callers() {
+		caller := LoadCaller(name)
+		for _, subject := range caller.CertSubjects {
+			if slices.Contains(names, subject) {
+				return caller
+			}
+		}
+	}
+	return nil
+}
+
+// Allows tells whether the scopes of the service allow the request method
+func (caller *Caller) Allows(method string) bool {
+	if slices.Contains(caller.Scopes, ScopeWrite) {
+		return true
+	}
+
+	isSafe := method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+	return isSafe && slices.Contains(caller.Scopes, ScopeRead)
+}
+
+// GetCaller returns the service of the authenticated request or nil
+func GetCaller(c *gin.Context) *Caller {
+	value, exists := c.Get(ContextCaller)
+	if !exists {
+		return nil
+	}
+
+	caller, _ := value.(*Caller)
+	return caller
+}
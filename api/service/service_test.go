@@ -0,0 +1,183 @@
+package service
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCaller_Allows(t *testing.T) {
+	reader := &Caller{Scopes: []string{ScopeRead}}
+	assert.True(t, reader.Allows(http.MethodGet))
+	assert.False(t, reader.Allows(http.MethodPost))
+	assert.False(t, reader.Allows(http.MethodDelete))
+
+	writer := &Caller{Scopes: []string{ScopeWrite}}
+	assert.True(t, writer.Allows(http.MethodPatch))
+	assert.True(t, writer.Allows(http.MethodGet))
+
+	assert.False(t, (&Caller{}).Allows(http.MethodGet))
+}
+
+func TestFindByCertificate(t *testing.T) {
+	t.Setenv("SERVICE_CALLERS", "billing,reports")
+	t.Setenv("SERVICE_BILLING_CERT_SUBJECTS", "billing.internal,spiffe://cluster/ns/billing")
+	t.Setenv("SERVICE_BILLING_SCOPES", "read,write")
+	t.Setenv("SERVICE_REPORTS_CERT_SUBJECTS", "reports")
+
+	spiffe, _ := url.Parse("spiffe://cluster/ns/billing")
+	caller := FindByCertificate(&x509.Certificate{URIs: []*url.URL{spiffe}})
+	assert.NotNil(t, caller)
+	assert.Equal(t, "billing", caller.Name)
+	assert.Equal(t, []string{ScopeRead, ScopeWrite}, caller.Scopes)
+
+	caller = FindByCertificate(&x509.Certificate{DNSNames: []string{"billing.internal"}})
+	assert.Equal(t, "billing", caller.Name)
+
+	caller = FindByCertificate(&x509.Certificate{Subject: pkix.Name{CommonName: "reports"}})
+	assert.Equal(t, "reports", caller.Name)
+	assert.Equal(t, []string{ScopeRead}, caller.Scopes)
+
+	assert.Nil(t, FindByCertificate(&x509.Certificate{Subject: pkix.Name{CommonName: "unknown"}}))
+}
+
+func TestSign(t *testing.T) {
+	t.Setenv("SERVICE_CALLERS", "billing")
+	t.Setenv("SERVICE_BILLING_HMAC_SECRET", "secret")
+	config := Config{SignatureWindow: 5 * time.Minute}
+	now := time.Now()
+
+	newRequest := func(body string) *http.Request {
+		request, _ := http.NewRequest(http.MethodPatch, "/user/1?fields=email", bytes.NewBufferString(body))
+		if err := Sign(request, "billing", "secret", "0123456789abcdef", now); err != nil {
+			t.Fatal(err)
+		}
+		return request
+	}
+
+	request := newRequest(`{"status":"active"}`)
+	assert.Equal(t, strconv.FormatInt(now.Unix(), 10), request.Header.Get(HeaderTimestamp))
+	body, _ := io.ReadAll(request.Body)
+	assert.Equal(t, `{"status":"active"}`, string(body), "the body is put back after signing")
+
+	// The signature covers the body, the path and the method
+	request = newRequest(`{"status":"active"}`)
+	request.Body = io.NopCloser(bytes.NewBufferString(`{"status":"banned"}`))
+	_, err := verifySignature(nil, request, config, now)
+	assert.True(t, errors.Is(err, ErrInvalidSignature))
+
+	request = newRequest("")
+	request.URL.RawQuery = "fields=password"
+	_, err = verifySignature(nil, request, config, now)
+	assert.True(t, errors.Is(err, ErrInvalidSignature))
+
+	request = newRequest("")
+	request.Method = http.MethodDelete
+	_, err = verifySignature(nil, request, config, now)
+	assert.True(t, errors.Is(err, ErrInvalidSignature))
+
+	// Stale timestamp, short nonce and unknown service
+	request = newRequest("")
+	_, err = verifySignature(nil, request, config, now.Add(6*time.Minute))
+	assert.True(t, errors.Is(err, ErrInvalidSignature))
+
+	request = newRequest("")
+	request.Header.Set(HeaderNonce, "short")
+	_, err = verifySignature(nil, request, config, now)
+	assert.True(t, errors.Is(err, ErrInvalidSignature))
+
+	request = newRequest("")
+	request.Header.Set(HeaderName, "reports")
+	_, err = verifySignature(nil, request, config, now)
+	assert.True(t, errors.Is(err, ErrInvalidSignature))
+
+	// Without the headers the request is not a service one
+	request, _ = http.NewRequest(http.MethodGet, "/user", nil)
+	caller, err := verifySignature(nil, request, config, now)
+	assert.Nil(t, caller)
+	assert.Nil(t, err)
+}
+
+func TestLoadTLSConfig(t *testing.T) {
+	config, err := LoadTLSConfig()
+	assert.Nil(t, config)
+	assert.Nil(t, err)
+
+	dir := t.TempDir()
+	certFile, keyFile := writeCertificate(t, dir)
+	t.Setenv("SERVER_TLS_CERT_FILE", certFile)
+	_, err = LoadTLSConfig()
+	assert.EqualError(t, err, ErrorTlsKeyPair)
+
+	t.Setenv("SERVER_TLS_KEY_FILE", keyFile)
+	config, err = LoadTLSConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, tls.NoClientCert, config.ClientAuth)
+
+	t.Setenv("SERVER_TLS_CLIENT_CA_FILE", certFile)
+	config, err = LoadTLSConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, tls.VerifyClientCertIfGiven, config.ClientAuth)
+
+	t.Setenv("SERVER_TLS_CLIENT_CERT_REQUIRED", "true")
+	config, err = LoadTLSConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, tls.RequireAndVerifyClientCert, config.ClientAuth)
+
+	t.Setenv("SERVER_TLS_CLIENT_CA_FILE", keyFile)
+	_, err = LoadTLSConfig()
+	assert.NotNil(t, err)
+}
+
+// writeCertificate writes a self-signed certificate and its key
+func writeCertificate(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "user-service"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
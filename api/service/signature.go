@@ -0,0 +1,130 @@
+package service
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const HeaderName = "X-Service-Name"
+const HeaderTimestamp = "X-Service-Timestamp"
+const HeaderNonce = "X-Service-Nonce"
+const HeaderSignature = "X-Service-Signature"
+
+const nonceMinLength = 16
+const nonceMaxLength = 64
+
+var ErrInvalidSignature = errors.New(ErrorInvalidSignature)
+
+// Sign adds the signature headers to the request of the service, the body is read and put back. The nonce must be
+// unique within the signature window, 16 to 64 characters.
+func Sign(request *http.Request, name string, secret string, nonce string, now time.Time) error {
+	bodyHash, err := hashBody(nil, request, 0)
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	request.Header.Set(HeaderName, name)
+	request.Header.Set(HeaderTimestamp, timestamp)
+	request.Header.Set(HeaderNonce, nonce)
+	request.Header.Set(HeaderSignature, signature(secret, stringToSign(request, name, timestamp, nonce, bodyHash)))
+	return nil
+}
+
+// verifySignature returns the service of the signed request, nil without the signature headers. The nonce is stored,
+// so a captured request can not be sent again. A body over the limit of the config fails with *http.MaxBytesError.
+func verifySignature(writer http.ResponseWriter, request *http.Request, config Config, now time.Time) (*Caller, error) {
+	name := request.Header.Get(HeaderName)
+	if name == "" {
+		return nil, nil
+	}
+
+	caller := LoadCaller(name)
+	if caller == nil || caller.HmacSecret == "" {
+		return nil, ErrInvalidSignature
+	}
+
+	unix, err := strconv.ParseInt(request.Header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+	timestamp := time.Unix(unix, 0)
+	if timestamp.Before(now.Add(-config.SignatureWindow)) || timestamp.After(now.Add(config.SignatureWindow)) {
+		return nil, ErrInvalidSignature
+	}
+
+	nonce := request.Header.Get(HeaderNonce)
+	if len(nonce) < nonceMinLength || len(nonce) > nonceMaxLength {
+		return nil, ErrInvalidSignature
+	}
+
+	bodyHash, err := hashBody(writer, request, config.MaxBodySize)
+	if err != nil {
+		return nil, err
+	}
+
+	expected := signature(caller.HmacSecret, stringToSign(request, name, request.Header.Get(HeaderTimestamp), nonce, bodyHash))
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(request.Header.Get(HeaderSignature)))) {
+		return nil, ErrInvalidSignature
+	}
+
+	isNew, err := useNonce(&Nonce{Service: name, Nonce: nonce, ExpiresAt: timestamp.Add(config.SignatureWindow)}, now)
+	if err != nil {
+		return nil, err
+	}
+	if !isNew {
+		return nil, ErrInvalidSignature
+	}
+	return caller, nil
+}
+
+// stringToSign has the service, method, path with query, timestamp, nonce and hex SHA-256 of the body, one per line
+func stringToSign(request *http.Request, name string, timestamp string, nonce string, bodyHash string) string {
+	return strings.Join([]string{
+		name,
+		request.Method,
+		request.URL.RequestURI(),
+		timestamp,
+		nonce,
+		bodyHash,
+	}, "\n")
+}
+
+// signature is hex of HMAC-SHA256
+func signature(secret string, value string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// hashBody returns hex SHA-256 of the body and puts the body back, so the handler can read it again. The body is
+// buffered in memory, reading stops with *http.MaxBytesError after maxBytes, 0 means no limit.
+func hashBody(writer http.ResponseWriter, request *http.Request, maxBytes int64) (string, error) {
+	hash := sha256.New()
+	if request.Body == nil || request.Body == http.NoBody {
+		return hex.EncodeToString(hash.Sum(nil)), nil
+	}
+
+	body := request.Body
+	if maxBytes > 0 {
+		body = http.MaxBytesReader(writer, request.Body, maxBytes)
+	}
+
+	var buffer bytes.Buffer
+	_, err := io.Copy(io.MultiWriter(&buffer, hash), body)
+	body.Close()
+	if err != nil {
+		return "", err
+	}
+
+	request.Body = io.NopCloser(&buffer)
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
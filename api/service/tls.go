@@ -0,0 +1,55 @@
+package service
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"user-service/env"
+)
+
+// LoadTLSConfig returns the TLS settings of the server, nil when SERVER_TLS_CERT_FILE is not set and the server speaks
+// plain HTTP. With SERVER_TLS_CLIENT_CA_FILE client certificates signed by the CA are verified, a client without the
+// certificate is let through unless SERVER_TLS_CLIENT_CERT_REQUIRED is set, so users and services share the port.
+func LoadTLSConfig() (*tls.Config, error) {
+	certFile := env.String("SERVER_TLS_CERT_FILE", "")
+	keyFile := env.String("SERVER_TLS_KEY_FILE", "")
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New(ErrorTlsKeyPair)
+	}
+
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	caFile := env.String("SERVER_TLS_CLIENT_CA_FILE", "")
+	if caFile == "" {
+		return config, nil
+	}
+
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config.ClientCAs = x509.NewCertPool()
+	if !config.ClientCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf(ErrorClientCaInvalid, caFile)
+	}
+
+	config.ClientAuth = tls.VerifyClientCertIfGiven
+	if env.Bool("SERVER_TLS_CLIENT_CERT_REQUIRED", false) {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
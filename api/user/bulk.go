@@ -0,0 +1,143 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"slices"
+	"time"
+	"user-service/env"
+)
+
+const BulkOperationSoftDelete = "soft_delete"
+const BulkOperationRestore = "restore"
+const BulkOperationSetStatus = "set_status"
+const BulkOperationAddRole = "add_role"
+
+const JobTypeUserBulk = "user.bulk"
+
+type bulkConfig struct {
+	ConfirmThreshold int64
+	SampleSize       int
+	BatchSize        int
+}
+
+func loadBulkConfig() bulkConfig {
+	config := bulkConfig{
+		ConfirmThreshold: int64(env.Int("USER_BULK_CONFIRM_THRESHOLD", 1000)),
+		SampleSize:       env.Int("USER_BULK_SAMPLE_SIZE", 20),
+		BatchSize:        env.Int("USER_BULK_BATCH_SIZE", 1000),
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 1000
+	}
+	return config
+}
+
+// Roles returns roles which can be granted to users
+func Roles() []string {
+	return env.List("USER_ROLES", []string{RoleAdmin, RoleSupport})
+}
+
+// validateBulkRequest checks arguments required by the chosen operation
+func validateBulkRequest(requestBulkUserDto *RequestBulkUserDto) error {
+	switch requestBulkUserDto.Operation {
+	case BulkOperationSetStatus:
+		if !slices.Contains(Statuses, requestBulkUserDto.Status) {
+			return fmt.Errorf(ErrorBulkInvalidArgument, "status", requestBulkUserDto.Status)
+		}
+	case BulkOperationAddRole:
+		if !slices.Contains(Roles(), requestBulkUserDto.Role) {
+			return fmt.Errorf(ErrorBulkInvalidArgument, "role", requestBulkUserDto.Role)
+		}
+	}
+
+	if lastTimestamp := requestBulkUserDto.Filter.LastTimestamp; lastTimestamp != "" {
+		if _, err := time.Parse(time.RFC3339Nano, lastTimestamp); err != nil {
+			return fmt.Errorf(ErrorBulkInvalidArgument, "lastTimestamp", lastTimestamp)
+		}
+	}
+
+	// A forgotten filter must not turn into an operation on every user
+	if isEmptyFilter(&requestBulkUserDto.Filter) && !requestBulkUserDto.All {
+		return errors.New(ErrorBulkEmptyFilter)
+	}
+
+	return nil
+}
+
+// isEmptyFilter tells whether the filter matches every user, orders and a cursor without timestamp do not narrow it
+func isEmptyFilter(filterDto *RequestFilterUserDto) bool {
+	return len(filterDto.Emails) == 0 && (filterDto.Cursor == "" || filterDto.LastTimestamp == "")
+}
+
+// runBulkOperation applies the operation batch by batch, every batch is a separate short update.
+// progress can be nil.
+func runBulkOperation(ctx context.Context, requestBulkUserDto *RequestBulkUserDto, progress func(done int, total int)) (*BulkResultDto, error) {
+	config := loadBulkConfig()
+
+	total, err := CountBulkTargets(requestBulkUserDto)
+	if err != nil {
+		return nil, err
+	}
+
+	resultDto := &BulkResultDto{
+		Operation: requestBulkUserDto.Operation,
+		Count:     total,
+		SampleIds: []uuid.UUID{},
+	}
+
+	afterId := uuid.Nil
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		ids, err := GetBulkTargetIds(requestBulkUserDto, afterId, config.BatchSize)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(ids) == 0 {
+			break
+		}
+
+		affected, err := applyBulkOperation(requestBulkUserDto, ids, time.Now())
+		if err != nil {
+			return nil, err
+		}
+
+		resultDto.Affected += affected
+		if len(resultDto.SampleIds) < config.SampleSize {
+			resultDto.SampleIds = append(resultDto.SampleIds, ids[:min(len(ids), config.SampleSize-len(resultDto.SampleIds))]...)
+		}
+
+		if progress != nil {
+			progress(int(resultDto.Affected), int(max(total, resultDto.Affected)))
+		}
+
+		afterId = ids[len(ids)-1]
+	}
+
+	return resultDto, nil
+}
+
+func applyBulkOperation(requestBulkUserDto *RequestBulkUserDto, ids []uuid.UUID, now time.Time) (int64, error) {
+	var actorId *uuid.UUID
+	if id, err := uuid.Parse(requestBulkUserDto.ActorId); err == nil {
+		actorId = &id
+	}
+
+	switch requestBulkUserDto.Operation {
+	case BulkOperationSoftDelete:
+		return SoftDeleteUserItems(ids, now)
+	case BulkOperationRestore:
+		return RestoreUserItems(ids, now)
+	case BulkOperationSetStatus:
+		return SetUserItemsStatus(ids, requestBulkUserDto.Status, requestBulkUserDto.Reason, actorId, now)
+	case BulkOperationAddRole:
+		return AddUserItemsRole(ids, requestBulkUserDto.Role, now)
+	}
+	return 0, fmt.Errorf(ErrorBulkInvalidArgument, "operation", requestBulkUserDto.Operation)
+}
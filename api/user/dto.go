@@ -17,7 +17,7 @@ type RequestFilterUserDto struct {
 
 type RequestUserDTO struct {
 	Email     string `form:"email" binding:"required,email" example:"Some user email"`
-	Password  string `form:"password" binding:"required" example:"Some user password"`
+	Password  string `form:"password" example:"Some user password, empty for an account without a password"`
 	CreatedAt string `form:"created_at" example:"2022-01-01T00:00:00Z"`
 	UpdatedAt string `form:"updated_at" example:"2022-01-01T00:00:00Z"`
 	DeletedAt string `form:"deleted_at" example:"2022-01-01T00:00:00Z"`
@@ -31,31 +31,100 @@ type RequestUserByEmailDto struct {
 	Email string `form:"email" binding:"required,email"  example:"Some user email"`
 }
 
+type RequestImportUserDto struct {
+	DryRun bool   `form:"dry_run" example:"true"`
+	Async  bool   `form:"async" example:"true"`
+	Format string `form:"format" example:"csv"`
+}
+
+type RequestExportUserDto struct {
+	Format  string `form:"format" example:"csv"`
+	Columns string `form:"columns" example:"id,email,created_at"`
+}
+
+type RequestUserStatusDto struct {
+	Reason string `json:"reason" binding:"required,max=500" example:"Spam"`
+	Until  string `json:"until" example:"2026-01-01T00:00:00Z"`
+}
+
+type RequestBulkUserDto struct {
+	Operation    string               `json:"operation" binding:"required,oneof=soft_delete restore set_status add_role" example:"set_status"`
+	Filter       RequestFilterUserDto `json:"filter"`
+	All          bool                 `json:"all" example:"false"`
+	Status       string               `json:"status" example:"suspended"`
+	Role         string               `json:"role" example:"support"`
+	Reason       string               `json:"reason" example:"Cleanup of test accounts"`
+	DryRun       bool                 `json:"dry_run" example:"true"`
+	ConfirmCount int64                `json:"confirm_count" example:"1500"`
+	Async        bool                 `json:"async" example:"false"`
+	ActorId      string               `json:"actor_id" swaggerignore:"true"`
+	ExcludeStaff bool                 `json:"exclude_staff" swaggerignore:"true"`
+}
+
+type RequestImportUserRowDto struct {
+	Email    string `json:"email" binding:"required,email" example:"Some user email"`
+	Password string `json:"password" binding:"required" example:"Some user password"`
+}
+
 // ============================== Response DTO =========================================================================
 
 type ErrorResponseDto struct {
 	Message string `json:"message"`
 }
 
+type AccountStatusErrorDto struct {
+	Message        string     `json:"message"`
+	Status         string     `json:"status"`
+	Reason         string     `json:"reason"`
+	SuspendedUntil *time.Time `json:"suspended_until"`
+}
+
 type SuccessResponseDto struct {
 	Message string `json:"message"`
 }
 
+type ValidationErrorDto struct {
+	Field   string `json:"field" example:"password"`
+	Rule    string `json:"rule" example:"min_length"`
+	Message string `json:"message" example:"Password must contain at least 8 characters"`
+}
+
+type ValidationErrorResponseDto struct {
+	Message string               `json:"message"`
+	Errors  []ValidationErrorDto `json:"errors"`
+}
+
 type UserItemFullResultDto struct {
-	ID        uuid.UUID `json:"id"`
-	Email     string    `json:"email"`
-	Password  string    `json:"password"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	DeletedAt time.Time `json:"deleted_at"`
+	ID                 uuid.UUID  `json:"id"`
+	Email              string     `json:"email"`
+	Password           string     `json:"password"`
+	Status             string     `json:"status"`
+	StatusReason       string     `json:"status_reason"`
+	StatusChangedAt    *time.Time `json:"status_changed_at"`
+	SuspendedUntil     *time.Time `json:"suspended_until"`
+	EmailVerifiedAt    *time.Time `json:"email_verified_at"`
+	PasswordChangedAt  *time.Time `json:"password_changed_at"`
+	MustChangePassword bool       `json:"must_change_password"`
+	PendingEmail       string     `json:"pending_email"`
+	CreatedAt          time.Time  `json:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at"`
+	DeletedAt          time.Time  `json:"deleted_at"`
 }
 
 type UserItemResultDto struct {
-	ID        uuid.UUID `json:"id"`
-	Email     string    `json:"email"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	DeletedAt time.Time `json:"deleted_at"`
+	ID                 uuid.UUID  `json:"id"`
+	Email              string     `json:"email"`
+	Status             string     `json:"status"`
+	StatusReason       string     `json:"status_reason"`
+	StatusChangedAt    *time.Time `json:"status_changed_at"`
+	SuspendedUntil     *time.Time `json:"suspended_until"`
+	EmailVerifiedAt    *time.Time `json:"email_verified_at"`
+	PasswordChangedAt  *time.Time `json:"password_changed_at"`
+	MustChangePassword bool       `json:"must_change_password"`
+	PendingEmail       string     `json:"pending_email"`
+	CreatedAt          time.Time  `json:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at"`
+	DeletedAt          time.Time  `json:"deleted_at"`
 }
 
 type ResultListDTO struct {
@@ -64,3 +133,27 @@ type ResultListDTO struct {
 	LastTimestamp time.Time           `json:"lastTimestamp"`
 	Total         int64               `json:"total"`
 }
+
+type ImportRowErrorDto struct {
+	Row     int    `json:"row"`
+	Email   string `json:"email"`
+	Message string `json:"message"`
+}
+
+type ImportResultDto struct {
+	DryRun          bool                `json:"dry_run"`
+	Total           int                 `json:"total"`
+	Valid           int                 `json:"valid"`
+	Imported        int                 `json:"imported"`
+	Failed          int                 `json:"failed"`
+	Errors          []ImportRowErrorDto `json:"errors"`
+	ErrorsTruncated bool                `json:"errors_truncated"`
+}
+
+type BulkResultDto struct {
+	Operation string      `json:"operation"`
+	DryRun    bool        `json:"dry_run"`
+	Count     int64       `json:"count"`
+	Affected  int64       `json:"affected"`
+	SampleIds []uuid.UUID `json:"sample_ids"`
+}
@@ -0,0 +1,82 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/net/idna"
+	"slices"
+	"strings"
+	"time"
+	"user-service/api/job"
+	"user-service/env"
+)
+
+// JobTypeEmailsNormalize recomputes normalised emails of stored users after NormalizeEmail rules have changed
+const JobTypeEmailsNormalize = "user.emails_normalize"
+
+// emailNormalizationVersion has to be raised when NormalizeEmail changes, so stored emails are normalised again
+const emailNormalizationVersion = 1
+
+var ErrEmailTaken = errors.New("email is already used")
+
+// NormalizeEmail returns the identity of the email: lower-cased, with punycode domain. For domains listed in
+// USER_EMAIL_PLUS_DOMAINS the +tag is dropped, for USER_EMAIL_DOT_DOMAINS dots of the local part are dropped,
+// as these providers deliver such addresses to the same mailbox.
+func NormalizeEmail(email string) string {
+	email = strings.TrimSpace(email)
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return strings.ToLower(email)
+	}
+
+	local := strings.ToLower(email[:at])
+	domain := strings.ToLower(strings.TrimSuffix(email[at+1:], "."))
+	if ascii, err := idna.Lookup.ToASCII(domain); err == nil {
+		domain = ascii
+	}
+
+	if slices.Contains(env.List("USER_EMAIL_PLUS_DOMAINS", nil), domain) {
+		local, _, _ = strings.Cut(local, "+")
+	}
+	if slices.Contains(env.List("USER_EMAIL_DOT_DOMAINS", nil), domain) {
+		local = strings.ReplaceAll(local, ".", "")
+	}
+
+	return local + "@" + domain
+}
+
+// emailNormalizationRules describes the current rules of NormalizeEmail
+func emailNormalizationRules() string {
+	return fmt.Sprintf("v%d;plus=%s;dot=%s", emailNormalizationVersion,
+		strings.Join(env.List("USER_EMAIL_PLUS_DOMAINS", nil), ","),
+		strings.Join(env.List("USER_EMAIL_DOT_DOMAINS", nil), ","),
+	)
+}
+
+// EnqueueEmailNormalization queues normalisation of stored emails when they were normalised by other rules,
+// for example after USER_EMAIL_PLUS_DOMAINS or USER_EMAIL_DOT_DOMAINS have changed
+func EnqueueEmailNormalization() error {
+	rules, err := GetEmailNormalizationRules()
+	if err != nil || rules == emailNormalizationRules() {
+		return err
+	}
+
+	_, err = job.Enqueue(JobTypeEmailsNormalize, "", nil, nil)
+	return err
+}
+
+// runEmailsNormalizeJob normalises stored emails, a job queued by another instance with the same rules does nothing
+func runEmailsNormalizeJob(ctx context.Context, normalizeJob *job.Job, progress job.Progress) (any, error) {
+	rules := emailNormalizationRules()
+	stored, err := GetEmailNormalizationRules()
+	if err != nil || stored == rules {
+		return nil, err
+	}
+
+	changed, err := NormalizeStoredEmails(rules, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return map[string]int{"changed": changed}, nil
+}
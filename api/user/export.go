@@ -0,0 +1,279 @@
+package user
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"user-service/env"
+)
+
+const ExportMimeCsv = "text/csv"
+const ExportMimeNdjson = "application/x-ndjson"
+const ExportMimeJson = "application/json"
+
+// ExportColumns are the columns which can be exported, password hash is never exported
+var ExportColumns = []string{"id", "email", "status", "created_at", "updated_at", "deleted_at"}
+
+// ExportMimeTypes are offered for Accept negotiation, the first one is the default
+var ExportMimeTypes = []string{ExportMimeJson, ExportMimeNdjson, ExportMimeCsv}
+
+func exportMimeType(format string) string {
+	switch strings.ToLower(format) {
+	case "csv":
+		return ExportMimeCsv
+	case "ndjson", "jsonl":
+		return ExportMimeNdjson
+	case "json":
+		return ExportMimeJson
+	}
+	return ""
+}
+
+func exportFileName(mimeType string) string {
+	switch mimeType {
+	case ExportMimeCsv:
+		return "users.csv"
+	case ExportMimeNdjson:
+		return "users.ndjson"
+	}
+	return "users.json"
+}
+
+// parseExportColumns returns all columns for empty value, otherwise the selected ones in the requested order
+func parseExportColumns(columns string) ([]string, error) {
+	if strings.TrimSpace(columns) == "" {
+		return ExportColumns, nil
+	}
+
+	allowed := map[string]bool{}
+	for _, column := range ExportColumns {
+		allowed[column] = true
+	}
+
+	var result []string
+	for _, column := range strings.Split(columns, ",") {
+		column = strings.ToLower(strings.TrimSpace(column))
+		if column == "" {
+			continue
+		}
+		if !allowed[column] {
+			return nil, fmt.Errorf(ErrorExportUnknownColumn, column)
+		}
+		result = append(result, column)
+	}
+
+	if len(result) == 0 {
+		return ExportColumns, nil
+	}
+	return result, nil
+}
+
+// exportColumnValue returns nil for empty timestamps
+func exportColumnValue(item UserItemResultDto, column string) any {
+	var value time.Time
+
+	switch column {
+	case "id":
+		return item.ID.String()
+	case "email":
+		return item.Email
+	case "status":
+		return item.Status
+	case "created_at":
+		value = item.CreatedAt
+	case "updated_at":
+		value = item.UpdatedAt
+	case "deleted_at":
+		value = item.DeletedAt
+	}
+
+	if value.IsZero() {
+		return nil
+	}
+	return value.Format(time.RFC3339Nano)
+}
+
+// ============================== Writers ==============================================================================
+
+type exportWriter interface {
+	WriteHeader() error
+	WriteItem(item UserItemResultDto) error
+	Flush() error
+	Close() error
+}
+
+func newExportWriter(mimeType string, writer io.Writer, columns []string) exportWriter {
+	switch mimeType {
+	case ExportMimeCsv:
+		return &csvExportWriter{writer: csv.NewWriter(writer), columns: columns}
+	case ExportMimeNdjson:
+		return &ndjsonExportWriter{writer: writer, columns: columns}
+	}
+	return &jsonExportWriter{writer: writer, columns: columns}
+}
+
+// encodeExportObject keeps the order of selected columns, json.Marshal of a map would sort them
+func encodeExportObject(item UserItemResultDto, columns []string) ([]byte, error) {
+	var buffer bytes.Buffer
+	buffer.WriteByte('{')
+
+	for i, column := range columns {
+		if i > 0 {
+			buffer.WriteByte(',')
+		}
+
+		key, _ := json.Marshal(column)
+		value, err := json.Marshal(exportColumnValue(item, column))
+		if err != nil {
+			return nil, err
+		}
+
+		buffer.Write(key)
+		buffer.WriteByte(':')
+		buffer.Write(value)
+	}
+
+	buffer.WriteByte('}')
+	return buffer.Bytes(), nil
+}
+
+type csvExportWriter struct {
+	writer  *csv.Writer
+	columns []string
+}
+
+func (w *csvExportWriter) WriteHeader() error {
+	return w.writer.Write(w.columns)
+}
+
+func (w *csvExportWriter) WriteItem(item UserItemResultDto) error {
+	record := make([]string, len(w.columns))
+	for i, column := range w.columns {
+		if value, ok := exportColumnValue(item, column).(string); ok {
+			record[i] = value
+		}
+	}
+	return w.writer.Write(record)
+}
+
+func (w *csvExportWriter) Flush() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+func (w *csvExportWriter) Close() error {
+	return w.Flush()
+}
+
+type ndjsonExportWriter struct {
+	writer  io.Writer
+	columns []string
+}
+
+func (w *ndjsonExportWriter) WriteHeader() error {
+	return nil
+}
+
+func (w *ndjsonExportWriter) WriteItem(item UserItemResultDto) error {
+	line, err := encodeExportObject(item, w.columns)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.writer.Write(append(line, '\n'))
+	return err
+}
+
+func (w *ndjsonExportWriter) Flush() error {
+	return nil
+}
+
+func (w *ndjsonExportWriter) Close() error {
+	return nil
+}
+
+type jsonExportWriter struct {
+	writer  io.Writer
+	columns []string
+	count   int
+}
+
+func (w *jsonExportWriter) WriteHeader() error {
+	_, err := io.WriteString(w.writer, "[")
+	return err
+}
+
+func (w *jsonExportWriter) WriteItem(item UserItemResultDto) error {
+	object, err := encodeExportObject(item, w.columns)
+	if err != nil {
+		return err
+	}
+
+	if w.count > 0 {
+		object = append([]byte{','}, object...)
+	}
+	w.count++
+
+	_, err = w.writer.Write(object)
+	return err
+}
+
+func (w *jsonExportWriter) Flush() error {
+	return nil
+}
+
+func (w *jsonExportWriter) Close() error {
+	_, err := io.WriteString(w.writer, "]")
+	return err
+}
+
+// ============================== Export ===============================================================================
+
+// exportUsers walks through all users matching the filter page by page using the keyset cursor.
+// Every page is a separate short query, so a large export neither keeps all users in memory
+// nor holds one long transaction.
+func exportUsers(filterDto *RequestFilterUserDto, writer exportWriter, flush func()) error {
+	pageSize := env.Int("USER_EXPORT_PAGE_SIZE", 1000)
+	if pageSize <= 0 {
+		pageSize = 1000
+	}
+
+	filter := *filterDto
+	filter.Limit = pageSize
+
+	if err := writer.WriteHeader(); err != nil {
+		return err
+	}
+
+	for {
+		items, err := GetItemsPage(&filter)
+		if err != nil {
+			return err
+		}
+
+		for _, item := range items {
+			if err := writer.WriteItem(item); err != nil {
+				return err
+			}
+		}
+
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+		flush()
+
+		if len(items) < pageSize {
+			break
+		}
+
+		lastItem := items[len(items)-1]
+		filter.Cursor = lastItem.ID.String()
+		filter.LastTimestamp = lastItem.CreatedAt.Format(time.RFC3339Nano)
+	}
+
+	return writer.Close()
+}
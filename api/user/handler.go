@@ -1,16 +1,25 @@
 package user
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"github.com/apiboxgo/library-utils/dictionary"
 	"github.com/apiboxgo/library-utils/utils"
 	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
+	"io"
 	"net/http"
+	"reflect"
+	"slices"
 	"strings"
 	"time"
+	"user-service/api/audit"
+	"user-service/api/job"
+	"user-service/api/lockout"
 	_ "user-service/docs"
+	"user-service/env"
 )
 
 // ================================== Get user by Email and Password ===================================================
@@ -23,12 +32,15 @@ import (
 //	@license.url	https://opensource.org/license/mit
 
 // GetUserByEmail @Summary Getting user by Email and Password
-// @Description Getting user by Email and Password
+// @Description Getting user by Email and Password, a suspended or banned user is not returned like on login
 // @Tags user
 // @Accept json
 // @Produce json
 // @Param        request body RequestUserByEmailDto true "Sent data"
 // @Success 200 {array} RequestUserDTO
+// @Failure 403 {object} AccountStatusErrorDto
+// @Failure 429 {object} ErrorResponseDto
+// @Security ServiceSignature
 // @Router /user/get-by-email [post]
 func GetUserByEmail(c *gin.Context) {
 
@@ -39,6 +51,29 @@ func GetUserByEmail(c *gin.Context) {
 		c.JSON(http.StatusUnprocessableEntity, &ErrorResponseDto{
 			Message: err.Error(),
 		})
+		return
+	}
+
+	// Lookups of unknown emails are counted like failed logins of the client, so addresses can not be enumerated.
+	// The caller checks the password itself, so every lookup of a known email is counted as an attempt on the account.
+	ipKey := lockout.IpKey(c.ClientIP())
+	accountKey := lockout.AccountKey(NormalizeEmail(requestUserByEmailDto.Email))
+	now := time.Now()
+	retryAfter, err := lockout.RetryAfter(now, ipKey, accountKey)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	if retryAfter > 0 {
+		lockout.SetRetryAfter(c, retryAfter)
+		c.JSON(http.StatusTooManyRequests, &ErrorResponseDto{
+			Message: lockout.ErrorTooManyAttempts,
+		})
+		return
 	}
 
 	resultDto, err := GetOneByEmail(requestUserByEmailDto.Email)
@@ -52,6 +87,12 @@ func GetUserByEmail(c *gin.Context) {
 	}
 
 	if resultDto == nil || resultDto.ID == uuid.Nil {
+		for _, key := range []lockout.Key{ipKey, accountKey} {
+			if _, err := lockout.RecordFailure(key, now); err != nil {
+				utils.LogError(dictionary.SomethingWrong, err)
+			}
+		}
+
 		message := fmt.Sprintf(dictionary.UserNotFound, requestUserByEmailDto.Email)
 		c.JSON(http.StatusNotFound, &ErrorResponseDto{
 			Message: message,
@@ -59,6 +100,31 @@ func GetUserByEmail(c *gin.Context) {
 		return
 	}
 
+	if _, err := lockout.RecordFailure(accountKey, now); err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+	}
+
+	// Callers check the password themselves, so a blocked account is rejected here as it is by the login
+	err = CheckAccountStatus(resultDto.ID, resultDto.Status, resultDto.SuspendedUntil, resultDto.StatusReason)
+	var statusError *AccountStatusError
+	if errors.As(err, &statusError) {
+		c.JSON(http.StatusForbidden, &AccountStatusErrorDto{
+			Message:        statusError.Error(),
+			Status:         statusError.Status,
+			Reason:         statusError.Reason,
+			SuspendedUntil: statusError.SuspendedUntil,
+		})
+		return
+	}
+
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, resultDto)
 }
 
@@ -78,6 +144,7 @@ func GetUserByEmail(c *gin.Context) {
 // @Produce json
 // @Param id path string true "User id (UUID)"
 // @Success 200 {array} RequestUserDTO
+// @Security ServiceSignature
 // @Router /user/{id} [get]
 func GetUserById(c *gin.Context) {
 
@@ -128,43 +195,11 @@ func GetUserById(c *gin.Context) {
 // @Param lastTimestamp query string false "lastTimestamp"
 // @Param orders[created_at] query []string false "Filter created_at Like min-max (example: 2025-06-11T08:28:51.400404Z)"
 // @Success 200 {array} RequestUserDTO
+// @Security ServiceSignature
 // @Router /user [get]
 func GetUsersListByFilter(c *gin.Context) {
-	emails := c.QueryArray("emails")
-	lastTimestamp := c.Query("lastTimestamp")
-	ordersCreatedAt := c.Query("orders[created_at]")
-	var orders map[string]string
-
-	if ordersCreatedAt != "" {
-		orders = map[string]string{
-			"created_at": strings.ToUpper(ordersCreatedAt),
-		}
-	} else {
-		orders = map[string]string{
-			"created_at": "DESC",
-		}
-	}
-
-	if lastTimestamp != "" {
-		_, err := time.Parse(time.RFC3339Nano, lastTimestamp)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf(dictionary.ErrorParsingFilter, "lastTimestamp", lastTimestamp)})
-			return
-		}
-	}
-
-	if len(emails) > 0 {
-		emails = strings.Split(emails[0], ",")
-	}
-
-	requestFilterUserDto := &RequestFilterUserDto{
-		Emails:        emails,
-		LastTimestamp: lastTimestamp,
-		Orders:        orders,
-	}
-
-	if err := c.ShouldBindQuery(&requestFilterUserDto); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	requestFilterUserDto := parseFilterQuery(c)
+	if requestFilterUserDto == nil {
 		return
 	}
 
@@ -206,6 +241,7 @@ func GetUsersListByFilter(c *gin.Context) {
 // @Produce json
 // @Param id path string true "User id (UUID)"
 // @Success 200 {array} RequestUserDTO
+// @Security ServiceSignature
 // @Router /user/{id} [delete]
 func DeleteUserById(c *gin.Context) {
 	_, id := parseDtoId(c)
@@ -250,14 +286,30 @@ func DeleteUserById(c *gin.Context) {
 // @Param        request body RequestUserDTO true "Updated data"
 // @Success      200 {object}  UserItemResultDto
 // @Failure      400 {object}  map[string]interface{}
+// @Failure      409 {object}  ErrorResponseDto
+// @Failure      422 {object}  ValidationErrorResponseDto
 // @Failure      500 {object}  map[string]interface{}
+// @Security     ServiceSignature
 // @Router       /user/{id} [patch]
 func PatchUserById(c *gin.Context) {
 	_, id := parseDtoId(c)
-	User, _ := parseRequestBody(c)
+	User, _, isValid := parseRequestBody(c, id)
+	if !isValid {
+		return
+	}
 	User.ID = id
 
+	if !checkEmailUnchanged(c, id, User.Email) {
+		return
+	}
+
+	// The password goes through UpdateUserPassword, which keeps the history
+	hash := User.Password
+	User.Password = ""
 	isUpdated, err := PatchUserItem(User)
+	if err == nil && isUpdated && hash != "" {
+		err = UpdateUserPassword(id, hash, time.Now())
+	}
 
 	if err != nil || !isUpdated {
 		utils.LogError(dictionary.SomethingWrong, err)
@@ -290,14 +342,28 @@ func PatchUserById(c *gin.Context) {
 // @Param        request body  RequestUserDTO true "Updated data"
 // @Success      200 {object}  SuccessResponseDto
 // @Failure      400 {object}  map[string]interface{}
+// @Failure      409 {object}  ErrorResponseDto
+// @Failure      422 {object}  ValidationErrorResponseDto
 // @Failure      500 {object}  map[string]interface{}
+// @Security     ServiceSignature
 // @Router       /user/{id} [put]
 func PutUserItemById(c *gin.Context) {
-	requestIdDto, _ := parseDtoId(c)
-	_, requestUserPostDTO := parseRequestBody(c)
+	requestIdDto, id := parseDtoId(c)
+	_, requestUserPostDTO, isValid := parseRequestBody(c, id)
+	if !isValid {
+		return
+	}
+
+	if !checkEmailUnchanged(c, id, requestUserPostDTO.Email) {
+		return
+	}
+
 	UserMap := convertRequestUserDTOToMap(c, requestUserPostDTO)
 	UserMap["updated_at"] = time.Now()
 	isUpdated, err := PutUserItem(requestIdDto, UserMap)
+	if err == nil && isUpdated && requestUserPostDTO.Password != "" {
+		err = UpdateUserPassword(id, requestUserPostDTO.Password, time.Now())
+	}
 
 	if err != nil || !isUpdated {
 		utils.LogError(dictionary.SomethingWrong, err)
@@ -330,11 +396,27 @@ func PutUserItemById(c *gin.Context) {
 // @Param        request body RequestUserDTO true "Sent data"
 // @Success      200 {object}  SuccessResponseDto
 // @Failure      400 {object}  map[string]interface{}
+// @Failure      409 {object}  ErrorResponseDto
+// @Failure      422 {object}  ValidationErrorResponseDto
 // @Failure      500 {object}  map[string]interface{}
+// @Security     ServiceSignature
 // @Router       /user [post]
 func CreateUser(c *gin.Context) {
 
-	User, _ := parseRequestBody(c)
+	User, _, isValid := parseRequestBody(c, uuid.Nil)
+	if !isValid {
+		return
+	}
+	User.ID = uuid.New()
+
+	existing, err := GetOneByEmail(User.Email)
+	if err == nil && existing != nil && existing.ID != uuid.Nil {
+		c.JSON(http.StatusConflict, &ErrorResponseDto{
+			Message: fmt.Sprintf(ErrorEmailTaken, User.Email),
+		})
+		return
+	}
+
 	isCreated, err := CreateUserItem(User)
 
 	if err != nil || !isCreated {
@@ -346,12 +428,643 @@ func CreateUser(c *gin.Context) {
 		return
 	}
 
+	// The verification email is sent by the job, so a mail failure does not fail the registration
+	if _, err := job.Enqueue(JobTypeUserCreated, "", UserCreatedPayload{UserID: User.ID}, nil); err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+	}
+
 	c.JSON(http.StatusCreated, &SuccessResponseDto{
 		Message: dictionary.SaveSuccessfulMessage,
 	})
 }
 
+// ================================== Import users =====================================================================
+//	@title			Import users
+//	@version		1.0
+//	@description	Import users from CSV or NDJSON stream
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// ImportUsers godoc
+// @Summary      Import users
+// @Description  Import users from CSV (header: email,password) or NDJSON ({"email": "...", "password": "..."} per line).
+// @Description  Rows are validated one by one, valid rows are inserted in batches. With dry_run nothing is written.
+// @Tags         Users
+// @Accept       text/csv
+// @Accept       application/x-ndjson
+// @Produce      json
+// @Param        dry_run query bool false "Validate only, nothing is written"
+// @Param        async query bool false "Run the import as a background job, the result is available by GET /jobs/{id}"
+// @Param        format query string false "csv or ndjson, by default is taken from Content-Type"
+// @Param        request body string true "CSV or NDJSON rows"
+// @Success      200 {object}  ImportResultDto
+// @Success      202 {object}  job.JobCreatedDto
+// @Failure      401 {object}  ErrorResponseDto
+// @Failure      413 {object}  ErrorResponseDto
+// @Failure      415 {object}  ErrorResponseDto
+// @Failure      422 {object}  ErrorResponseDto
+// @Failure      500 {object}  ErrorResponseDto
+// @Security     ServiceSignature
+// @Router       /user/import [post]
+func ImportUsers(c *gin.Context) {
+	var requestImportUserDto RequestImportUserDto
+	if err := c.ShouldBindQuery(&requestImportUserDto); err != nil {
+		utils.LogError(dictionary.ErrorParsingRequestBody, err)
+		c.JSON(http.StatusUnprocessableEntity, &ErrorResponseDto{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	format := importFormat(requestImportUserDto.Format, c.ContentType())
+	if requestImportUserDto.Async {
+		importUsersAsync(c, format, requestImportUserDto.DryRun)
+		return
+	}
+
+	reader, ok := parseImportBody(c, format, c.Request.Body)
+	if !ok {
+		return
+	}
+
+	resultDto, err := importUsers(c.Request.Context(), reader, requestImportUserDto.DryRun, nil)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, resultDto)
+}
+
+// ================================== Export users =====================================================================
+//	@title			Export users
+//	@version		1.0
+//	@description	Stream users matching the filter
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// ExportUsers godoc
+// @Summary      Export users
+// @Description  Stream all users matching the same filter as GET /user. Format is negotiated by Accept header
+// @Description  (application/json, application/x-ndjson, text/csv) or set by format parameter. Password hash is never exported.
+// @Tags         Users
+// @Produce      json
+// @Produce      application/x-ndjson
+// @Produce      text/csv
+// @Param        emails query []string false "Emails prefixes"
+// @Param        orders[created_at] query string false "Order by created_at: ASC or DESC"
+// @Param        format query string false "json, ndjson or csv"
+// @Param        columns query string false "Comma separated columns: id, email, status, created_at, updated_at, deleted_at"
+// @Success      200 {array}   UserItemResultDto
+// @Failure      406 {object}  ErrorResponseDto
+// @Failure      422 {object}  ErrorResponseDto
+// @Security     ServiceSignature
+// @Router       /user/export [get]
+func ExportUsers(c *gin.Context) {
+	requestFilterUserDto := parseFilterQuery(c)
+	if requestFilterUserDto == nil {
+		return
+	}
+
+	var requestExportUserDto RequestExportUserDto
+	if err := c.ShouldBindQuery(&requestExportUserDto); err != nil {
+		utils.LogError(dictionary.ErrorParsingRequestBody, err)
+		c.JSON(http.StatusUnprocessableEntity, &ErrorResponseDto{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	mimeType := exportMimeType(requestExportUserDto.Format)
+	if mimeType == "" {
+		mimeType = c.NegotiateFormat(ExportMimeTypes...)
+	}
+
+	if mimeType == "" {
+		c.JSON(http.StatusNotAcceptable, &ErrorResponseDto{
+			Message: fmt.Sprintf(ErrorExportNotAcceptable, strings.Join(ExportMimeTypes, ", ")),
+		})
+		return
+	}
+
+	columns, err := parseExportColumns(requestExportUserDto.Columns)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, &ErrorResponseDto{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.Header("Content-Type", mimeType+"; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", exportFileName(mimeType)))
+	c.Status(http.StatusOK)
+
+	writer := newExportWriter(mimeType, c.Writer, columns)
+	if err := exportUsers(requestFilterUserDto, writer, c.Writer.Flush); err != nil {
+		// Headers are already sent, the client gets a truncated stream
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.Abort()
+	}
+}
+
+// ================================== Bulk update users ================================================================
+//	@title			Bulk update users
+//	@version		1.0
+//	@description	Bulk update or delete users by filter
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// BulkUsers godoc
+// @Summary      Bulk update users
+// @Description  Apply soft_delete, restore, set_status or add_role to all users matching the filter of GET /user.
+// @Description  dry_run returns the amount and a sample of affected ids. Above the limit the operation needs confirm_count
+// @Description  equal to the amount of affected users. With async the operation runs as a background job. An empty
+// @Description  filter is rejected unless all is true. Only admins can add roles, operations of support users skip
+// @Description  users which have a role.
+// @Tags         Users
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request body RequestBulkUserDto true "Operation and filter"
+// @Success      200 {object}  BulkResultDto
+// @Success      202 {object}  job.JobCreatedDto
+// @Failure      401 {object}  ErrorResponseDto
+// @Failure      403 {object}  ErrorResponseDto
+// @Failure      409 {object}  ErrorResponseDto
+// @Failure      422 {object}  ErrorResponseDto
+// @Failure      500 {object}  ErrorResponseDto
+// @Router       /user/bulk [post]
+func BulkUsers(c *gin.Context) {
+	var requestBulkUserDto RequestBulkUserDto
+	if err := c.ShouldBindJSON(&requestBulkUserDto); err != nil {
+		utils.LogError(dictionary.ErrorParsingRequestBody, err)
+		c.JSON(http.StatusUnprocessableEntity, &ErrorResponseDto{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if err := validateBulkRequest(&requestBulkUserDto); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, &ErrorResponseDto{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	// Only admins grant roles and change staff users, for others staff is not a target
+	isAdmin := isActorAdmin(c)
+	if requestBulkUserDto.Operation == BulkOperationAddRole && !isAdmin {
+		c.JSON(http.StatusForbidden, &ErrorResponseDto{
+			Message: ErrorRoleGrantDenied,
+		})
+		return
+	}
+	requestBulkUserDto.ExcludeStaff = !isAdmin
+	requestBulkUserDto.ActorId = c.GetString(ContextActorId)
+
+	config := loadBulkConfig()
+	count, err := CountBulkTargets(&requestBulkUserDto)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	if requestBulkUserDto.DryRun {
+		sampleIds, err := GetBulkTargetIds(&requestBulkUserDto, uuid.Nil, config.SampleSize)
+		if err != nil {
+			utils.LogError(dictionary.SomethingWrong, err)
+			c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+				Message: dictionary.SomethingWrong,
+			})
+			return
+		}
+
+		if sampleIds == nil {
+			sampleIds = []uuid.UUID{}
+		}
+
+		c.JSON(http.StatusOK, &BulkResultDto{
+			Operation: requestBulkUserDto.Operation,
+			DryRun:    true,
+			Count:     count,
+			SampleIds: sampleIds,
+		})
+		return
+	}
+
+	if count > config.ConfirmThreshold && requestBulkUserDto.ConfirmCount != count {
+		c.JSON(http.StatusConflict, &ErrorResponseDto{
+			Message: fmt.Sprintf(ErrorBulkConfirmationRequired, count, config.ConfirmThreshold, count),
+		})
+		return
+	}
+
+	if requestBulkUserDto.Async {
+		owner, ok := requireJobOwner(c)
+		if !ok {
+			return
+		}
+
+		bulkJob, err := job.Enqueue(JobTypeUserBulk, owner, requestBulkUserDto, nil)
+		if err != nil {
+			utils.LogError(dictionary.SomethingWrong, err)
+			c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+				Message: dictionary.SomethingWrong,
+			})
+			return
+		}
+
+		c.JSON(http.StatusAccepted, job.NewJobCreatedDto(bulkJob))
+		return
+	}
+
+	resultDto, err := runBulkOperation(c.Request.Context(), &requestBulkUserDto, nil)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, resultDto)
+}
+
+// ================================== Suspend user =====================================================================
+//	@title			Suspend user
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// SuspendUserById godoc
+// @Summary      Suspend user
+// @Description  Suspend user until the given time or indefinitely. Suspended user can not log in or use issued tokens.
+// @Tags         user
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path string true "User id (UUID)"
+// @Param        request body RequestUserStatusDto true "Reason and optional end of suspension"
+// @Success      200 {object}  UserItemResultDto
+// @Failure      403 {object}  ErrorResponseDto
+// @Failure      404 {object}  ErrorResponseDto
+// @Failure      409 {object}  ErrorResponseDto
+// @Failure      422 {object}  ErrorResponseDto
+// @Router       /user/{id}/suspend [post]
+func SuspendUserById(c *gin.Context) {
+	changeUserStatus(c, StatusSuspended)
+}
+
+// ================================== Reinstate user ===================================================================
+//	@title			Reinstate user
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// ReinstateUserById godoc
+// @Summary      Reinstate user
+// @Description  Activate suspended or banned user
+// @Tags         user
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path string true "User id (UUID)"
+// @Param        request body RequestUserStatusDto true "Reason"
+// @Success      200 {object}  UserItemResultDto
+// @Failure      403 {object}  ErrorResponseDto
+// @Failure      404 {object}  ErrorResponseDto
+// @Failure      409 {object}  ErrorResponseDto
+// @Failure      422 {object}  ErrorResponseDto
+// @Router       /user/{id}/reinstate [post]
+func ReinstateUserById(c *gin.Context) {
+	changeUserStatus(c, StatusActive)
+}
+
+// ================================== Ban user =========================================================================
+//	@title			Ban user
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// BanUserById godoc
+// @Summary      Ban user
+// @Description  Ban user permanently, only reinstate can lift it
+// @Tags         user
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path string true "User id (UUID)"
+// @Param        request body RequestUserStatusDto true "Reason"
+// @Success      200 {object}  UserItemResultDto
+// @Failure      403 {object}  ErrorResponseDto
+// @Failure      404 {object}  ErrorResponseDto
+// @Failure      409 {object}  ErrorResponseDto
+// @Failure      422 {object}  ErrorResponseDto
+// @Router       /user/{id}/ban [post]
+func BanUserById(c *gin.Context) {
+	changeUserStatus(c, StatusBanned)
+}
+
+// ================================== Unlock user ======================================================================
+//	@title			Unlock user
+//	@version		1.0
+//	@contact.name	API Support
+//	@contact.email	kostiaGm@gmail.com
+//	@license.name	MIT
+//	@license.url	https://opensource.org/license/mit
+
+// UnlockUserById godoc
+// @Summary      Unlock user
+// @Description  Lift the temporary sign-in lockout after failed attempts and reset the counter of the account,
+// @Description  it is audited. Counters of client addresses are not changed.
+// @Tags         user
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id path string true "User id (UUID)"
+// @Success      200 {object}  SuccessResponseDto
+// @Failure      404 {object}  ErrorResponseDto
+// @Router       /user/{id}/unlock [post]
+func UnlockUserById(c *gin.Context) {
+	requestIdDto, id := parseDtoId(c)
+	if id == uuid.Nil {
+		return
+	}
+
+	resultDto, err := GetOneById(requestIdDto)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	if resultDto == nil || resultDto.ID == uuid.Nil {
+		c.JSON(http.StatusNotFound, &ErrorResponseDto{
+			Message: fmt.Sprintf(dictionary.UserByIdNotFound, requestIdDto.ID),
+		})
+		return
+	}
+
+	if err := lockout.Reset(lockout.AccountKey(NormalizeEmail(resultDto.Email))); err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	if err := audit.Record(c, audit.EventAccountUnlocked, resultDto.ID, contextActorId(c)); err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+	}
+
+	c.JSON(http.StatusOK, &SuccessResponseDto{
+		Message: fmt.Sprintf(UserUnlocked, resultDto.ID),
+	})
+}
+
 // === Sys
+func changeUserStatus(c *gin.Context, to string) {
+	requestIdDto, id := parseDtoId(c)
+	if id == uuid.Nil {
+		return
+	}
+
+	var requestUserStatusDto RequestUserStatusDto
+	if err := c.ShouldBindJSON(&requestUserStatusDto); err != nil {
+		utils.LogError(dictionary.ErrorParsingRequestBody, err)
+		c.JSON(http.StatusUnprocessableEntity, &ErrorResponseDto{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	until, err := parseStatusUntil(requestUserStatusDto.Until)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, &ErrorResponseDto{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if !isActorAdmin(c) {
+		roles, err := GetUserRoles(id)
+		if err != nil {
+			utils.LogError(dictionary.SomethingWrong, err)
+			c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+				Message: dictionary.SomethingWrong,
+			})
+			return
+		}
+
+		if len(roles) > 0 {
+			c.JSON(http.StatusForbidden, &ErrorResponseDto{
+				Message: ErrorStaffStatusDenied,
+			})
+			return
+		}
+	}
+
+	resultDto, err := ChangeStatus(id, to, requestUserStatusDto.Reason, until, contextActorId(c))
+	if errors.Is(err, ErrUserNotFound) {
+		c.JSON(http.StatusNotFound, &ErrorResponseDto{
+			Message: fmt.Sprintf(dictionary.UserByIdNotFound, requestIdDto.ID),
+		})
+		return
+	}
+
+	if errors.Is(err, ErrStatusTransition) {
+		c.JSON(http.StatusConflict, &ErrorResponseDto{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, resultDto)
+}
+
+// checkEmailUnchanged rejects a direct email change, the new email has to be confirmed by the email change flow
+func checkEmailUnchanged(c *gin.Context, id uuid.UUID, email string) bool {
+	if id == uuid.Nil || email == "" {
+		return true
+	}
+
+	current, err := GetOneById(RequestUserIdDTO{ID: id.String()})
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return false
+	}
+
+	if current.ID != uuid.Nil && NormalizeEmail(current.Email) != NormalizeEmail(email) {
+		c.JSON(http.StatusConflict, &ErrorResponseDto{
+			Message: ErrorEmailChangeRequiresConfirmation,
+		})
+		return false
+	}
+	return true
+}
+
+// contextActorId returns id of the authenticated user or nil for anonymous calls
+// isActorAdmin tells whether the authenticated user is an admin, support users are not
+func isActorAdmin(c *gin.Context) bool {
+	value, _ := c.Get(ContextActorRoles)
+	roles, _ := value.([]string)
+	return slices.Contains(roles, RoleAdmin)
+}
+
+func contextActorId(c *gin.Context) *uuid.UUID {
+	id, err := uuid.Parse(c.GetString(ContextActorId))
+	if err != nil {
+		return nil
+	}
+	return &id
+}
+
+// parseFilterQuery reads the filter of users list, on error it writes the response and returns nil
+func parseFilterQuery(c *gin.Context) *RequestFilterUserDto {
+	emails := c.QueryArray("emails")
+	lastTimestamp := c.Query("lastTimestamp")
+	ordersCreatedAt := c.Query("orders[created_at]")
+	var orders map[string]string
+
+	if ordersCreatedAt != "" {
+		orders = map[string]string{
+			"created_at": strings.ToUpper(ordersCreatedAt),
+		}
+	} else {
+		orders = map[string]string{
+			"created_at": "DESC",
+		}
+	}
+
+	if lastTimestamp != "" {
+		_, err := time.Parse(time.RFC3339Nano, lastTimestamp)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf(dictionary.ErrorParsingFilter, "lastTimestamp", lastTimestamp)})
+			return nil
+		}
+	}
+
+	if len(emails) > 0 {
+		emails = strings.Split(emails[0], ",")
+	}
+
+	requestFilterUserDto := &RequestFilterUserDto{
+		Emails:        emails,
+		LastTimestamp: lastTimestamp,
+		Orders:        orders,
+	}
+
+	if err := c.ShouldBindQuery(&requestFilterUserDto); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil
+	}
+
+	return requestFilterUserDto
+}
+
+// parseImportBody checks the format and the header of the import file, on error it writes the response
+func parseImportBody(c *gin.Context, format string, body io.Reader) (importRowReader, bool) {
+	reader, err := newImportRowReader(format, body)
+	if errors.Is(err, errUnsupportedImportFormat) {
+		c.JSON(http.StatusUnsupportedMediaType, &ErrorResponseDto{
+			Message: fmt.Sprintf(ErrorUnsupportedImportFormat, format),
+		})
+		return nil, false
+	}
+
+	if err != nil {
+		utils.LogError(dictionary.ErrorParsingRequestBody, err)
+		c.JSON(http.StatusUnprocessableEntity, &ErrorResponseDto{
+			Message: err.Error(),
+		})
+		return nil, false
+	}
+
+	return reader, true
+}
+
+// importUsersAsync checks the uploaded file and stores it as input of a background job, passwords are hashed before,
+// so the job keeps no plaintext passwords
+func importUsersAsync(c *gin.Context, format string, dryRun bool) {
+	owner, ok := requireJobOwner(c)
+	if !ok {
+		return
+	}
+
+	maxBytes := int64(env.Int("USER_IMPORT_MAX_ASYNC_BYTES", 50*1024*1024))
+	data, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes))
+
+	var maxBytesError *http.MaxBytesError
+	if errors.As(err, &maxBytesError) {
+		c.JSON(http.StatusRequestEntityTooLarge, &ErrorResponseDto{
+			Message: fmt.Sprintf(ErrorImportTooLarge, maxBytes),
+		})
+		return
+	}
+
+	if err != nil {
+		utils.LogError(dictionary.ErrorParsingRequestBody, err)
+		c.JSON(http.StatusUnprocessableEntity, &ErrorResponseDto{
+			Message: err.Error(),
+		})
+		return
+	}
+
+	reader, ok := parseImportBody(c, format, bytes.NewReader(data))
+	if !ok {
+		return
+	}
+
+	prepared, err := prepareImport(c.Request.Context(), reader, dryRun)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	importJob, err := job.Enqueue(JobTypeUserImport, owner, importJobPayload{Format: importFormatPrepared, DryRun: dryRun}, prepared)
+	if err != nil {
+		utils.LogError(dictionary.SomethingWrong, err)
+		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+			Message: dictionary.SomethingWrong,
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, job.NewJobCreatedDto(importJob))
+}
 
 func parseDtoId(c *gin.Context) (RequestUserIdDTO, uuid.UUID) {
 	var requestUserIdDTO RequestUserIdDTO
@@ -374,24 +1087,33 @@ func parseDtoId(c *gin.Context) (RequestUserIdDTO, uuid.UUID) {
 	return requestUserIdDTO, id
 }
 
-func parseRequestBody(c *gin.Context) (User, RequestUserDTO) {
+// parseRequestBody binds the user and hashes its password, false means the body is invalid or the password
+// is rejected by the policy and the response is already written. id is the updated user, uuid.Nil for a new one.
+func parseRequestBody(c *gin.Context, id uuid.UUID) (User, RequestUserDTO, bool) {
 	var requestUserPostDTO RequestUserDTO
 	if err := c.ShouldBindJSON(&requestUserPostDTO); err != nil {
 		utils.LogError(dictionary.ErrorParsingRequestBody, err)
-		c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
-			Message: dictionary.SomethingWrong,
+		c.JSON(http.StatusUnprocessableEntity, &ValidationErrorResponseDto{
+			Message: ErrorInvalidRequestBody,
+			Errors:  bindingViolations(requestUserPostDTO, err),
 		})
+		return User{}, requestUserPostDTO, false
 	}
 
 	if requestUserPostDTO.Password != "" {
-		hash, err := bcrypt.GenerateFromPassword([]byte(requestUserPostDTO.Password), bcrypt.DefaultCost)
+		if !checkPasswordPolicy(c, id, requestUserPostDTO) {
+			return User{}, requestUserPostDTO, false
+		}
+
+		hash, err := HashPassword(requestUserPostDTO.Password)
 		if err != nil {
 			utils.LogError(dictionary.ErrorParsingRequestBody, err)
 			c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
 				Message: dictionary.SomethingWrong,
 			})
+			return User{}, requestUserPostDTO, false
 		}
-		requestUserPostDTO.Password = string(hash)
+		requestUserPostDTO.Password = hash
 
 	}
 
@@ -399,5 +1121,70 @@ func parseRequestBody(c *gin.Context) (User, RequestUserDTO) {
 			Email:    requestUserPostDTO.Email,
 			Password: requestUserPostDTO.Password,
 		},
-		requestUserPostDTO
+		requestUserPostDTO,
+		true
+}
+
+// bindingViolations converts binding errors of the dto to validation errors named by the form tags,
+// a malformed body has no field errors
+func bindingViolations(dto any, err error) []ValidationErrorDto {
+	violations := []ValidationErrorDto{}
+	var fieldErrors validator.ValidationErrors
+	if !errors.As(err, &fieldErrors) {
+		return violations
+	}
+
+	dtoType := reflect.TypeOf(dto)
+	for _, fieldError := range fieldErrors {
+		field := fieldError.Field()
+		if structField, isFound := dtoType.FieldByName(fieldError.StructField()); isFound && structField.Tag.Get("form") != "" {
+			field = structField.Tag.Get("form")
+		}
+
+		message := fmt.Sprintf(ErrorFieldInvalid, field, fieldError.Tag())
+		if fieldError.Tag() == "required" {
+			message = fmt.Sprintf(ErrorFieldRequired, field)
+		}
+		violations = append(violations, ValidationErrorDto{Field: field, Rule: fieldError.Tag(), Message: message})
+	}
+	return violations
+}
+
+// checkPasswordPolicy writes 422 with the violated rules, the history is checked only for existing users when the
+// password passes the policy. The stored email is used when the request has none.
+func checkPasswordPolicy(c *gin.Context, id uuid.UUID, requestUserPostDTO RequestUserDTO) bool {
+	email := requestUserPostDTO.Email
+	if email == "" && id != uuid.Nil {
+		current, err := GetOneById(RequestUserIdDTO{ID: id.String()})
+		if err != nil {
+			utils.LogError(dictionary.SomethingWrong, err)
+			c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+				Message: dictionary.SomethingWrong,
+			})
+			return false
+		}
+		email = current.Email
+	}
+
+	violations := CheckPasswordPolicy(requestUserPostDTO.Password, email)
+	if len(violations) == 0 {
+		reused, err := CheckPasswordHistory(id, requestUserPostDTO.Password)
+		if err != nil {
+			utils.LogError(dictionary.SomethingWrong, err)
+			c.JSON(http.StatusInternalServerError, &ErrorResponseDto{
+				Message: dictionary.SomethingWrong,
+			})
+			return false
+		}
+		violations = reused
+	}
+
+	if len(violations) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, &ValidationErrorResponseDto{
+			Message: ErrorPasswordPolicy,
+			Errors:  violations,
+		})
+		return false
+	}
+	return true
 }
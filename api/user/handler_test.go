@@ -2,6 +2,9 @@ package user
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"github.com/apiboxgo/library-utils/api_init"
@@ -15,9 +18,15 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
+	"user-service/api/job"
+	"user-service/api/lockout"
+	"user-service/api/service"
 )
 
 var db *gorm.DB
@@ -51,6 +60,17 @@ func TestGetUsersList(t *testing.T) {
 	assert.Equal(t, "test_user_14@user.com", result.List[0].Email)
 }
 
+func TestGetUsersList_EmptyPage(t *testing.T) {
+	clearDbTableUser(t)
+
+	var result ResultListDTO
+	w := sendRequest(t, UriUser, "GET", nil, &result)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, result.List)
+	assert.Equal(t, int64(0), result.Total)
+	assert.Equal(t, uuid.Nil, result.Cursor)
+}
+
 func TestGetUsersListWithFilterEmail(t *testing.T) {
 	clearDbTableUser(t)
 
@@ -168,13 +188,73 @@ func TestGetUserByEmail_SuccessfulResult(t *testing.T) {
 	assert.Equal(t, User.ID, result.ID)
 }
 
+func TestGetUserByEmail_BannedUser(t *testing.T) {
+	clearDbTableUser(t)
+	User := User{
+		Email:    "test_user_1@user.com",
+		Password: "123123",
+		Status:   StatusBanned,
+	}
+	if err := db.Create(&User).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	jsonData, _ := json.Marshal(map[string]string{"email": "test_user_1@user.com"})
+	var result AccountStatusErrorDto
+	w := sendRequest(t, UriUser+UriUserGetByEmail, "POST", bytes.NewBuffer(jsonData), &result)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, StatusBanned, result.Status)
+}
+
+func TestGetUserByEmail_ThrottlesUnknownEmails(t *testing.T) {
+	clearDbTableUser(t)
+	t.Setenv("LOCKOUT_IP_FREE_ATTEMPTS", "1")
+	t.Setenv("LOCKOUT_BACKOFF_BASE", "1m")
+
+	jsonData, _ := json.Marshal(map[string]string{"email": "unknown@user.com"})
+	var result ErrorResponseDto
+	w := sendRequest(t, UriUser+UriUserGetByEmail, "POST", bytes.NewBuffer(jsonData), &result)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = sendRequest(t, UriUser+UriUserGetByEmail, "POST", bytes.NewBuffer(jsonData), &result)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = sendRequest(t, UriUser+UriUserGetByEmail, "POST", bytes.NewBuffer(jsonData), &result)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, lockout.ErrorTooManyAttempts, result.Message)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+}
+
+func TestGetUserByEmail_ThrottlesAccount(t *testing.T) {
+	clearDbTableUser(t)
+	if _, err := createUsers(1); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("LOCKOUT_ACCOUNT_FREE_ATTEMPTS", "1")
+	t.Setenv("LOCKOUT_BACKOFF_BASE", "1m")
+
+	jsonData, _ := json.Marshal(map[string]string{"email": "test_user_1@user.com"})
+	var result UserItemResultDto
+	w := sendRequest(t, UriUser+UriUserGetByEmail, "POST", bytes.NewBuffer(jsonData), &result)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = sendRequest(t, UriUser+UriUserGetByEmail, "POST", bytes.NewBuffer(jsonData), &result)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Only the account counter blocks the lookup, known emails do not add failures of the client address
+	var errorResult ErrorResponseDto
+	w = sendRequest(t, UriUser+UriUserGetByEmail, "POST", bytes.NewBuffer(jsonData), &errorResult)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, lockout.ErrorTooManyAttempts, errorResult.Message)
+}
+
 func TestCreateUser_SuccessfulResult(t *testing.T) {
 	clearDbTableUser(t)
 
 	var result SuccessResponseDto
 	post := map[string]string{
 		"email":    "test_user_1@user.com",
-		"password": "123123",
+		"password": "Violet-Kettle-93-Harbor",
 	}
 
 	jsonData, err := json.Marshal(post)
@@ -186,6 +266,28 @@ func TestCreateUser_SuccessfulResult(t *testing.T) {
 	assert.Equal(t, http.StatusCreated, w.Code)
 }
 
+func TestCreateUser_WithoutPassword(t *testing.T) {
+	clearDbTableUser(t)
+
+	jsonData, _ := json.Marshal(map[string]string{"email": "test_user_1@user.com"})
+	var result SuccessResponseDto
+	w := sendRequest(t, UriUser, "POST", bytes.NewBuffer(jsonData), &result)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	resultDto, err := GetOneByEmail("test_user_1@user.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, resultDto.Password)
+	assert.False(t, VerifyPassword(resultDto.Password, ""))
+
+	hashes, err := GetPasswordHistory(resultDto.ID, passwordHistorySize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, hashes)
+}
+
 func TestPutUserItem_SuccessfulResult(t *testing.T) {
 	clearDbTableUser(t)
 
@@ -197,6 +299,7 @@ func TestPutUserItem_SuccessfulResult(t *testing.T) {
 	}
 
 	User.DeletedAt = now
+	User.Password = "Violet-Kettle-93-Harbor"
 	jsonData, err := json.Marshal(User)
 	if err != nil {
 		panic(err)
@@ -227,7 +330,7 @@ func TestPatchUserItem_SuccessfulResult(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	User.Email = "test_user_2@user.com"
+	User.Password = "Violet-Kettle-93-Harbor"
 
 	jsonData, err := json.Marshal(User)
 	if err != nil {
@@ -248,6 +351,51 @@ func TestPatchUserItem_SuccessfulResult(t *testing.T) {
 	assert.Equal(t, User.Email, updatedUser.Email)
 }
 
+func TestPatchUserItem_EmailChangeRejected(t *testing.T) {
+	clearDbTableUser(t)
+
+	User := User{
+		Email:    "test_user_1@user.com",
+		Password: "123123",
+	}
+
+	if err := db.Create(&User).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	jsonData, _ := json.Marshal(map[string]any{"email": "test_user_2@user.com", "password": "Violet-Kettle-93-Harbor"})
+
+	var result ErrorResponseDto
+	w := sendRequest(t, fmt.Sprintf(UriUser+UriUserGetByIdS, User.ID.String()), "PATCH", bytes.NewBuffer(jsonData), &result)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, ErrorEmailChangeRequiresConfirmation, result.Message)
+
+	updatedUser, err := GetOneById(RequestUserIdDTO{ID: User.ID.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "test_user_1@user.com", updatedUser.Email)
+}
+
+func TestPatchUserItem_EmailCaseChange(t *testing.T) {
+	clearDbTableUser(t)
+
+	User := User{
+		Email:    "test_user_1@user.com",
+		Password: "123123",
+	}
+
+	if err := db.Create(&User).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	jsonData, _ := json.Marshal(map[string]any{"email": "Test_User_1@User.com"})
+
+	var result SuccessResponseDto
+	w := sendRequest(t, fmt.Sprintf(UriUser+UriUserGetByIdS, User.ID.String()), "PATCH", bytes.NewBuffer(jsonData), &result)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
 func TestDeleteUserItem_SuccessfulResult(t *testing.T) {
 	clearDbTableUser(t)
 
@@ -271,9 +419,682 @@ func TestDeleteUserItem_SuccessfulResult(t *testing.T) {
 	assert.Equal(t, uuid.Nil, deletedUser.ID)
 }
 
+func TestImportUsers_DryRunReport(t *testing.T) {
+	clearDbTableUser(t)
+
+	if _, err := createUsers(1); err != nil {
+		t.Fatal(err)
+	}
+
+	body := strings.Join([]string{
+		`{"email": "test_user_2@user.com", "password": "Violet-Kettle-93-Harbor"}`,
+		`{"email": "test_user_1@user.com", "password": "Violet-Kettle-93-Harbor"}`,
+		`{"email": "wrong-email", "password": "Violet-Kettle-93-Harbor"}`,
+		`{"email": "test_user_3@user.com", "password": "123"}`,
+		`{"email": "test_user_2@user.com", "password": "Violet-Kettle-93-Harbor"}`,
+		`not a json`,
+	}, "\n")
+
+	var result ImportResultDto
+	w := sendRequest(t, UriUser+UriUserImport+"?dry_run=true&format=ndjson", "POST", strings.NewReader(body), &result)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, result.DryRun)
+	assert.Equal(t, 6, result.Total)
+	assert.Equal(t, 1, result.Valid)
+	assert.Equal(t, 0, result.Imported)
+	assert.Equal(t, 5, result.Failed)
+	assert.Equal(t, 5, len(result.Errors))
+	assert.Equal(t, 3, result.Errors[0].Row)
+	assert.Equal(t, fmt.Sprintf(ErrorImportEmailExists, "test_user_1@user.com"), result.Errors[4].Message)
+
+	var total int64
+	db.Model(&User{}).Count(&total)
+	assert.Equal(t, int64(1), total)
+}
+
+func TestImportUsers_CsvSuccessfulResult(t *testing.T) {
+	clearDbTableUser(t)
+
+	body := "email,password\n" +
+		"test_user_1@user.com,Violet-Kettle-93-Harbor\n" +
+		"test_user_2@user.com,Violet-Kettle-93-Harbor\n" +
+		"test_user_3@user.com,Violet-Kettle-93-Harbor\n"
+
+	var result ImportResultDto
+	w := sendRequest(t, UriUser+UriUserImport+"?format=csv", "POST", strings.NewReader(body), &result)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 3, result.Imported)
+	assert.Equal(t, 0, result.Failed)
+
+	importedUser, err := GetOneByEmail("test_user_2@user.com")
+	if err != nil {
+		panic(err)
+	}
+
+	assert.NotEqual(t, uuid.Nil, importedUser.ID)
+	assert.NotEqual(t, "Violet-Kettle-93-Harbor", importedUser.Password)
+}
+
+func TestImportUsers_UnsupportedFormat(t *testing.T) {
+	var result ErrorResponseDto
+	w := sendRequest(t, UriUser+UriUserImport+"?format=xml", "POST", strings.NewReader("<users/>"), &result)
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+}
+
+func TestImportUsers_AsyncJob(t *testing.T) {
+	clearDbTableUser(t)
+	if err := db.Exec("truncate table jobs").Error; err != nil {
+		t.Fatal(err)
+	}
+	InitUserJobs()
+
+	body := "email,password\n" +
+		"test_user_1@user.com,Violet-Kettle-93-Harbor\n" +
+		"test_user_2@user.com,Violet-Kettle-93-Harbor\n"
+
+	var result job.JobCreatedDto
+	w := sendRequestAs(t, uuid.NewString(), nil, UriUser+UriUserImport+"?format=csv&async=true", "POST", strings.NewReader(body), &result)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, job.StatusQueued, result.Status)
+
+	// Passwords are hashed before the file is stored in the job
+	queuedJob, err := job.GetJobById(result.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotContains(t, string(queuedJob.Input), "Violet-Kettle-93-Harbor")
+
+	processed, err := job.NewPool(job.LoadConfig()).RunOnce(context.Background())
+	assert.Nil(t, err)
+	assert.True(t, processed)
+
+	importJob, err := job.GetJobById(result.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, job.StatusSucceeded, importJob.Status)
+	assert.Equal(t, 2, importJob.ProgressDone)
+	assert.Nil(t, importJob.Input)
+
+	var importResult ImportResultDto
+	if err := json.Unmarshal([]byte(*importJob.Result), &importResult); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, importResult.Imported)
+}
+
+func TestImportUsers_AsyncJobAnonymous(t *testing.T) {
+	var result ErrorResponseDto
+	body := "email,password\ntest_user_1@user.com,Violet-Kettle-93-Harbor\n"
+	w := sendRequest(t, UriUser+UriUserImport+"?format=csv&async=true", "POST", strings.NewReader(body), &result)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, ErrorJobOwnerRequired, result.Message)
+}
+
+func TestExportUsers_JsonSelectedColumns(t *testing.T) {
+	clearDbTableUser(t)
+	t.Setenv("USER_EXPORT_PAGE_SIZE", "2")
+
+	if _, err := createUsers(5); err != nil {
+		t.Fatal(err)
+	}
+
+	var result []map[string]any
+	w := sendRequest(t, UriUser+UriUserExport+"?format=json&columns=email,created_at", "GET", nil, &result)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 5, len(result))
+	assert.Equal(t, "test_user_5@user.com", result[0]["email"])
+	assert.Equal(t, "test_user_1@user.com", result[4]["email"])
+	assert.NotContains(t, result[0], "id")
+	assert.NotContains(t, result[0], "password")
+}
+
+func TestExportUsers_UnknownColumn(t *testing.T) {
+	var result ErrorResponseDto
+	w := sendRequest(t, UriUser+UriUserExport+"?format=csv&columns=email,password", "GET", nil, &result)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, fmt.Sprintf(ErrorExportUnknownColumn, "password"), result.Message)
+}
+
+func TestBulkUsers_DryRun(t *testing.T) {
+	clearDbTableUser(t)
+
+	if _, err := createUsers(12); err != nil {
+		t.Fatal(err)
+	}
+
+	jsonData, err := json.Marshal(map[string]any{
+		"operation": BulkOperationSoftDelete,
+		"filter":    map[string]any{"emails": []string{"test_user_1"}},
+		"dry_run":   true,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	var result BulkResultDto
+	w := sendRequest(t, UriUser+UriUserBulk, "POST", bytes.NewBuffer(jsonData), &result)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, result.DryRun)
+	assert.Equal(t, int64(4), result.Count)
+	assert.Equal(t, 4, len(result.SampleIds))
+
+	var deleted int64
+	db.Model(&User{}).Where("deleted_at IS NOT NULL").Count(&deleted)
+	assert.Equal(t, int64(0), deleted)
+}
+
+func TestBulkUsers_ConfirmationRequired(t *testing.T) {
+	clearDbTableUser(t)
+	t.Setenv("USER_BULK_CONFIRM_THRESHOLD", "2")
+
+	users, err := createUsers(12)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := map[string]any{
+		"operation": BulkOperationSetStatus,
+		"status":    StatusSuspended,
+		"filter":    map[string]any{"emails": []string{"test_user_1"}},
+	}
+
+	jsonData, _ := json.Marshal(request)
+	var errorResult ErrorResponseDto
+	w := sendRequest(t, UriUser+UriUserBulk, "POST", bytes.NewBuffer(jsonData), &errorResult)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, fmt.Sprintf(ErrorBulkConfirmationRequired, 4, 2, 4), errorResult.Message)
+
+	request["confirm_count"] = 4
+	jsonData, _ = json.Marshal(request)
+	var result BulkResultDto
+	w = sendRequest(t, UriUser+UriUserBulk, "POST", bytes.NewBuffer(jsonData), &result)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, int64(4), result.Affected)
+
+	suspendedUser, err := GetOneById(RequestUserIdDTO{ID: users[9].ID.String()})
+	if err != nil {
+		panic(err)
+	}
+	assert.Equal(t, StatusSuspended, suspendedUser.Status)
+}
+
+func TestBulkUsers_EmptyFilter(t *testing.T) {
+	clearDbTableUser(t)
+
+	if _, err := createUsers(3); err != nil {
+		t.Fatal(err)
+	}
+
+	jsonData, _ := json.Marshal(map[string]any{
+		"operation": BulkOperationSoftDelete,
+		"filter":    map[string]any{"orders": map[string]string{"created_at": "ASC"}},
+	})
+
+	var errorResult ErrorResponseDto
+	w := sendRequest(t, UriUser+UriUserBulk, "POST", bytes.NewBuffer(jsonData), &errorResult)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, ErrorBulkEmptyFilter, errorResult.Message)
+
+	var deleted int64
+	db.Model(&User{}).Where("deleted_at IS NOT NULL").Count(&deleted)
+	assert.Equal(t, int64(0), deleted)
+}
+
+func TestBulkUsers_AddRoleTwice(t *testing.T) {
+	clearDbTableUser(t)
+
+	if _, err := createUsers(3); err != nil {
+		t.Fatal(err)
+	}
+
+	jsonData, _ := json.Marshal(map[string]any{
+		"operation": BulkOperationAddRole,
+		"role":      RoleSupport,
+		"all":       true,
+	})
+
+	adminId := uuid.NewString()
+	var result BulkResultDto
+	w := sendRequestAs(t, adminId, []string{RoleAdmin}, UriUser+UriUserBulk, "POST", bytes.NewBuffer(jsonData), &result)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, int64(3), result.Affected)
+
+	w = sendRequestAs(t, adminId, []string{RoleAdmin}, UriUser+UriUserBulk, "POST", bytes.NewBuffer(jsonData), &result)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, int64(0), result.Affected)
+}
+
+func TestBulkUsers_SupportCanNotGrantRoles(t *testing.T) {
+	clearDbTableUser(t)
+
+	if _, err := createUsers(2); err != nil {
+		t.Fatal(err)
+	}
+
+	jsonData, _ := json.Marshal(map[string]any{
+		"operation": BulkOperationAddRole,
+		"role":      RoleAdmin,
+		"all":       true,
+	})
+
+	var result ErrorResponseDto
+	w := sendRequestAs(t, uuid.NewString(), []string{RoleSupport}, UriUser+UriUserBulk, "POST", bytes.NewBuffer(jsonData), &result)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, ErrorRoleGrantDenied, result.Message)
+
+	var granted int64
+	db.Table("user_roles").Count(&granted)
+	assert.Equal(t, int64(0), granted)
+}
+
+func TestBulkUsers_SupportSkipsStaff(t *testing.T) {
+	clearDbTableUser(t)
+
+	users, err := createUsers(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AddUserItemsRole([]uuid.UUID{users[0].ID}, RoleAdmin, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	jsonData, _ := json.Marshal(map[string]any{
+		"operation": BulkOperationSetStatus,
+		"status":    StatusBanned,
+		"all":       true,
+	})
+
+	var result BulkResultDto
+	w := sendRequestAs(t, uuid.NewString(), []string{RoleSupport}, UriUser+UriUserBulk, "POST", bytes.NewBuffer(jsonData), &result)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, int64(2), result.Affected)
+
+	admin, err := GetOneById(RequestUserIdDTO{ID: users[0].ID.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotEqual(t, StatusBanned, admin.Status)
+}
+
+func TestBanUser_SupportCanNotBanAdmin(t *testing.T) {
+	clearDbTableUser(t)
+
+	users, err := createUsers(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AddUserItemsRole([]uuid.UUID{users[0].ID}, RoleAdmin, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	jsonData, _ := json.Marshal(map[string]any{"reason": "Spam"})
+	uri := fmt.Sprintf(UriUser+UriUserBanS, users[0].ID.String())
+
+	var errorResult ErrorResponseDto
+	w := sendRequestAs(t, uuid.NewString(), []string{RoleSupport}, uri, "POST", bytes.NewBuffer(jsonData), &errorResult)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, ErrorStaffStatusDenied, errorResult.Message)
+
+	var result UserItemResultDto
+	w = sendRequestAs(t, uuid.NewString(), []string{RoleAdmin}, uri, "POST", bytes.NewBuffer(jsonData), &result)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, StatusBanned, result.Status)
+}
+
+func TestBulkUsers_InvalidStatus(t *testing.T) {
+	jsonData, _ := json.Marshal(map[string]any{
+		"operation": BulkOperationSetStatus,
+		"status":    "unknown",
+	})
+
+	var result ErrorResponseDto
+	w := sendRequest(t, UriUser+UriUserBulk, "POST", bytes.NewBuffer(jsonData), &result)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, fmt.Sprintf(ErrorBulkInvalidArgument, "status", "unknown"), result.Message)
+}
+
+func TestSuspendUser_UntilAndReinstate(t *testing.T) {
+	clearDbTableUser(t)
+
+	users, err := createUsers(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	until := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	jsonData, _ := json.Marshal(map[string]any{
+		"reason": "Spam",
+		"until":  until.Format(time.RFC3339),
+	})
+
+	var result UserItemResultDto
+	w := sendRequest(t, fmt.Sprintf(UriUser+UriUserSuspendS, users[0].ID.String()), "POST", bytes.NewBuffer(jsonData), &result)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, StatusSuspended, result.Status)
+	assert.Equal(t, "Spam", result.StatusReason)
+	assert.True(t, until.Equal(result.SuspendedUntil.UTC()))
+
+	var historyCount int64
+	db.Model(&UserStatusHistory{}).Where("user_id = ?", users[0].ID).Count(&historyCount)
+	assert.Equal(t, int64(1), historyCount)
+
+	jsonData, _ = json.Marshal(map[string]any{"reason": "Appeal accepted"})
+	w = sendRequest(t, fmt.Sprintf(UriUser+UriUserReinstateS, users[0].ID.String()), "POST", bytes.NewBuffer(jsonData), &result)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, StatusActive, result.Status)
+	assert.Nil(t, result.SuspendedUntil)
+}
+
+func TestSuspendUser_UntilInPast(t *testing.T) {
+	clearDbTableUser(t)
+
+	users, err := createUsers(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	until := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	jsonData, _ := json.Marshal(map[string]any{
+		"reason": "Spam",
+		"until":  until,
+	})
+
+	var result ErrorResponseDto
+	w := sendRequest(t, fmt.Sprintf(UriUser+UriUserSuspendS, users[0].ID.String()), "POST", bytes.NewBuffer(jsonData), &result)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, fmt.Sprintf(ErrorStatusUntilInPast, until), result.Message)
+}
+
+func TestBanUser_InvalidTransition(t *testing.T) {
+	clearDbTableUser(t)
+
+	users, err := createUsers(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jsonData, _ := json.Marshal(map[string]any{"reason": "Fraud"})
+
+	var result UserItemResultDto
+	w := sendRequest(t, fmt.Sprintf(UriUser+UriUserBanS, users[0].ID.String()), "POST", bytes.NewBuffer(jsonData), &result)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, StatusBanned, result.Status)
+
+	var errorResult ErrorResponseDto
+	w = sendRequest(t, fmt.Sprintf(UriUser+UriUserSuspendS, users[0].ID.String()), "POST", bytes.NewBuffer(jsonData), &errorResult)
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestCheckAccountStatus_ExpiredSuspension(t *testing.T) {
+	clearDbTableUser(t)
+
+	users, err := createUsers(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	until := time.Now().Add(-time.Minute)
+	db.Model(&User{}).Where("id = ?", users[0].ID).Updates(map[string]any{
+		"status":          StatusSuspended,
+		"suspended_until": until,
+	})
+
+	assert.NoError(t, CheckAccountStatus(users[0].ID, StatusSuspended, &until, ""))
+
+	resultDto, err := GetOneById(RequestUserIdDTO{ID: users[0].ID.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, StatusActive, resultDto.Status)
+
+	var statusError *AccountStatusError
+	assert.ErrorAs(t, CheckAccountStatus(users[0].ID, StatusBanned, nil, "Fraud"), &statusError)
+}
+
+func TestCreateUser_CaseInsensitiveDuplicate(t *testing.T) {
+	clearDbTableUser(t)
+
+	if err := db.Create(&User{Email: "Test_User_1@User.com", Password: "123123"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	jsonData, _ := json.Marshal(map[string]any{"email": "test_user_1@user.com", "password": "Violet-Kettle-93-Harbor"})
+	var result ErrorResponseDto
+	w := sendRequest(t, UriUser, "POST", bytes.NewBuffer(jsonData), &result)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, fmt.Sprintf(ErrorEmailTaken, "test_user_1@user.com"), result.Message)
+
+	resultDto, err := GetOneByEmail("TEST_USER_1@user.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "Test_User_1@User.com", resultDto.Email)
+}
+
+func TestNormalizeEmail(t *testing.T) {
+	t.Setenv("USER_EMAIL_PLUS_DOMAINS", "gmail.com")
+	t.Setenv("USER_EMAIL_DOT_DOMAINS", "gmail.com")
+
+	assert.Equal(t, "foo@x.com", NormalizeEmail(" Foo@X.com "))
+	assert.Equal(t, "foo@xn--e1afmkfd.com", NormalizeEmail("foo@пример.com"))
+	assert.Equal(t, "johndoe@gmail.com", NormalizeEmail("John.Doe+news@Gmail.com"))
+	assert.Equal(t, "john.doe+news@user.com", NormalizeEmail("John.Doe+news@user.com"))
+}
+
+func TestNormalizeStoredEmails(t *testing.T) {
+	clearDbTableUser(t)
+	db.Exec("DELETE FROM email_normalization")
+
+	now := time.Now()
+	first := User{Email: "John.Doe+news@gmail.com", Password: "123123", CreatedAt: now.Add(-time.Hour)}
+	second := User{Email: "johndoe@gmail.com", Password: "123123", CreatedAt: now}
+	for _, account := range []*User{&first, &second} {
+		if err := db.Create(account).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Setenv("USER_EMAIL_PLUS_DOMAINS", "gmail.com")
+	t.Setenv("USER_EMAIL_DOT_DOMAINS", "gmail.com")
+	changed, err := NormalizeStoredEmails(emailNormalizationRules(), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, changed)
+
+	var normalized []*string
+	db.Raw("SELECT email_normalized FROM users ORDER BY created_at").Scan(&normalized)
+	assert.Equal(t, "johndoe@gmail.com", *normalized[0])
+	assert.Nil(t, normalized[1])
+
+	var keptUserIds []uuid.UUID
+	db.Raw("SELECT kept_user_id FROM user_email_collisions WHERE user_id = ?", second.ID).Scan(&keptUserIds)
+	assert.Equal(t, []uuid.UUID{first.ID}, keptUserIds)
+
+	// The exact email wins over the normalised one of another user
+	resultDto, err := GetOneByEmail("johndoe@gmail.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, second.ID, resultDto.ID)
+
+	rules, err := GetEmailNormalizationRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, emailNormalizationRules(), rules)
+}
+
+func TestCreateUser_PasswordPolicyViolations(t *testing.T) {
+	clearDbTableUser(t)
+
+	jsonData, _ := json.Marshal(map[string]any{"email": "test_user_1@user.com", "password": "test_user_1"})
+	var result ValidationErrorResponseDto
+	w := sendRequest(t, UriUser, "POST", bytes.NewBuffer(jsonData), &result)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, ErrorPasswordPolicy, result.Message)
+	assert.Equal(t, PasswordRuleBannedSubstring, result.Errors[0].Rule)
+	assert.Equal(t, "password", result.Errors[0].Field)
+
+	var total int64
+	db.Model(&User{}).Count(&total)
+	assert.Equal(t, int64(0), total)
+}
+
+func TestCreateUser_InvalidBody(t *testing.T) {
+	clearDbTableUser(t)
+
+	jsonData, _ := json.Marshal(map[string]any{"password": "Violet-Kettle-93-Harbor"})
+	var result ValidationErrorResponseDto
+	w := sendRequest(t, UriUser, "POST", bytes.NewBuffer(jsonData), &result)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, ErrorInvalidRequestBody, result.Message)
+	assert.Equal(t, "email", result.Errors[0].Field)
+	assert.Equal(t, "required", result.Errors[0].Rule)
+
+	jsonData, _ = json.Marshal(map[string]any{"email": "not an email", "password": "Violet-Kettle-93-Harbor"})
+	w = sendRequest(t, UriUser, "POST", bytes.NewBuffer(jsonData), &result)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, "email", result.Errors[0].Rule)
+
+	w = sendRequest(t, UriUser, "POST", bytes.NewBufferString("{"), &result)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Empty(t, result.Errors)
+
+	var total int64
+	db.Model(&User{}).Count(&total)
+	assert.Equal(t, int64(0), total)
+}
+
+func TestCheckPasswordPolicy(t *testing.T) {
+	breached := sha1.Sum([]byte("Violet-Kettle-93-Harbor"))
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	list := "0000000000000000000000000000000000000000:3\n" + strings.ToUpper(hex.EncodeToString(breached[:])) + ":12\n"
+	if err := os.WriteFile(path, []byte(list), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("USER_PASSWORD_BREACHED_FILE", path)
+	t.Setenv("USER_PASSWORD_BANNED_WORDS", "apibox")
+
+	rules := func(violations []ValidationErrorDto) []string {
+		var result []string
+		for _, violation := range violations {
+			result = append(result, violation.Rule)
+		}
+		return result
+	}
+
+	assert.Empty(t, CheckPasswordPolicy("Amber-Lantern-41-Quiet", "test_user_1@user.com"))
+	assert.Equal(t, []string{PasswordRuleMinLength, PasswordRuleStrength}, rules(CheckPasswordPolicy("123", "")))
+	assert.Equal(t, []string{PasswordRuleStrength}, rules(CheckPasswordPolicy("123123123", "")))
+	assert.Contains(t, rules(CheckPasswordPolicy("Amber-Test_User_1-Quiet", "test_user_1@user.com")), PasswordRuleBannedSubstring)
+	assert.Contains(t, rules(CheckPasswordPolicy("Amber-ApiBox-41-Quiet", "")), PasswordRuleBannedSubstring)
+	assert.Equal(t, []string{PasswordRuleBreached}, rules(CheckPasswordPolicy("Violet-Kettle-93-Harbor", "")))
+}
+
+func TestPasswordHashers(t *testing.T) {
+	t.Setenv("USER_PASSWORD_BCRYPT_COST", "4")
+	t.Setenv("USER_PASSWORD_HASHER", PasswordHasherBcrypt)
+	bcryptHash, err := HashPassword("Violet-Kettle-93-Harbor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, VerifyPassword(bcryptHash, "Violet-Kettle-93-Harbor"))
+	assert.False(t, PasswordNeedsRehash(bcryptHash))
+
+	t.Setenv("USER_PASSWORD_HASHER", PasswordHasherArgon2id)
+	t.Setenv("USER_PASSWORD_PEPPERS", "first-pepper")
+	assert.True(t, PasswordNeedsRehash(bcryptHash))
+
+	argon2idHash, err := HashPassword("Violet-Kettle-93-Harbor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, strings.HasPrefix(argon2idHash, "$argon2id$v=19$m=19456,t=2,p=1,keyid="))
+	assert.True(t, VerifyPassword(argon2idHash, "Violet-Kettle-93-Harbor"))
+	assert.False(t, VerifyPassword(argon2idHash, "Amber-Lantern-41-Quiet"))
+	assert.False(t, PasswordNeedsRehash(argon2idHash))
+
+	// The old pepper still verifies, but the hash is upgraded to the new one
+	t.Setenv("USER_PASSWORD_PEPPERS", "second-pepper,first-pepper")
+	assert.True(t, VerifyPassword(argon2idHash, "Violet-Kettle-93-Harbor"))
+	assert.True(t, PasswordNeedsRehash(argon2idHash))
+
+	t.Setenv("USER_PASSWORD_PEPPERS", "")
+	assert.False(t, VerifyPassword(argon2idHash, "Violet-Kettle-93-Harbor"))
+	assert.False(t, VerifyPassword("$argon2id$v=19$m=19456,t=0,p=0$c2FsdA$aGFzaA", "Violet-Kettle-93-Harbor"))
+}
+
+func TestPatchUserItem_ReusedPassword(t *testing.T) {
+	clearDbTableUser(t)
+
+	hash, err := HashPassword("Violet-Kettle-93-Harbor")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	User := User{Email: "test_user_1@user.com", Password: hash}
+	if err := db.Create(&User).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	jsonData, _ := json.Marshal(map[string]any{"email": "test_user_1@user.com", "password": "Amber-Lantern-41-Quiet"})
+	var result SuccessResponseDto
+	w := sendRequest(t, fmt.Sprintf(UriUser+UriUserGetByIdS, User.ID.String()), "PATCH", bytes.NewBuffer(jsonData), &result)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Both the first and the current password are in the history now
+	for _, password := range []string{"Violet-Kettle-93-Harbor", "Amber-Lantern-41-Quiet"} {
+		jsonData, _ = json.Marshal(map[string]any{"email": "test_user_1@user.com", "password": password})
+		var errorResult ValidationErrorResponseDto
+		w = sendRequest(t, fmt.Sprintf(UriUser+UriUserGetByIdS, User.ID.String()), "PATCH", bytes.NewBuffer(jsonData), &errorResult)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Equal(t, PasswordRuleReused, errorResult.Errors[0].Rule)
+	}
+
+	var count int64
+	db.Model(&PasswordHistory{}).Where("user_id = ?", User.ID).Count(&count)
+	assert.Equal(t, int64(2), count)
+}
+
+func TestUserRoutes_SignedServiceRequest(t *testing.T) {
+	clearDbTableUser(t)
+	users, _ := createUsers(1)
+	t.Setenv("SERVICE_AUTH_REQUIRED", "true")
+	t.Setenv("SERVICE_CALLERS", "billing")
+	t.Setenv("SERVICE_BILLING_HMAC_SECRET", "secret")
+
+	router := gin.Default()
+	InitUserRoutes(router.Group("", service.Authenticate()))
+	send := func(method string, uri string, body string, isSigned bool, nonce string) int {
+		req, _ := http.NewRequest(method, uri, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		if isSigned {
+			if err := service.Sign(req, "billing", "secret", nonce, time.Now()); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	uri := fmt.Sprintf(UriUser+UriUserGetByIdS, users[0].ID)
+	assert.Equal(t, http.StatusUnauthorized, send("GET", uri, "", false, ""))
+
+	nonce := uuid.NewString()
+	assert.Equal(t, http.StatusOK, send("GET", uri, "", true, nonce))
+	assert.Equal(t, http.StatusUnauthorized, send("GET", uri, "", true, nonce), "the nonce is used once")
+
+	// The default scope is read
+	assert.Equal(t, http.StatusForbidden, send("PATCH", uri, `{"email":"other@user.com"}`, true, uuid.NewString()))
+}
+
 // === Sys
 func clearDbTableUser(t *testing.T) {
-	if err := db.Exec("truncate table Users restart identity cascade").Error; err != nil {
+	if err := db.Exec("truncate table login_failures, Users restart identity cascade").Error; err != nil {
 		utils.Dump(err)
 		t.Fatal(err)
 	}
@@ -286,11 +1107,31 @@ func sendRequest(
 	body io.Reader,
 	result any,
 ) *httptest.ResponseRecorder {
+	return sendRequestAs(t, "", nil, uri, method, body, result)
+}
+
+// sendRequestAs sends the request on behalf of the user the auth middleware would authenticate
+func sendRequestAs(
+	t *testing.T,
+	actorId string,
+	roles []string,
+	uri string,
+	method string,
+	body io.Reader,
+	result any,
+) *httptest.ResponseRecorder {
 
 	//Init
 
 	router := gin.Default()
+	if actorId != "" {
+		router.Use(func(c *gin.Context) {
+			c.Set(ContextActorId, actorId)
+			c.Set(ContextActorRoles, roles)
+		})
+	}
 	InitUserRoutes(router)
+	InitUserAdminRoutes(router)
 
 	// Creating test request
 	req, err := http.NewRequest(method, uri, body)
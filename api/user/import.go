@@ -0,0 +1,537 @@
+package user
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin/binding"
+	"io"
+	"runtime"
+	"strings"
+	"sync"
+	"user-service/env"
+)
+
+const ImportFormatCsv = "csv"
+const ImportFormatNdjson = "ndjson"
+
+// importFormatPrepared is the file of the async import as it is stored in the job, rows are checked and passwords are
+// hashed before the job is queued, so plaintext passwords are not written to the database. It is not accepted from
+// clients, otherwise they could import hashes of their choice.
+const importFormatPrepared = "prepared"
+
+const importMaxLineSize = 1024 * 1024
+
+var errUnsupportedImportFormat = errors.New("unsupported import format")
+
+type importConfig struct {
+	BatchSize         int
+	Workers           int
+	MaxReportedErrors int
+}
+
+func loadImportConfig() importConfig {
+	config := importConfig{
+		BatchSize:         env.Int("USER_IMPORT_BATCH_SIZE", 500),
+		Workers:           env.Int("USER_IMPORT_HASH_WORKERS", runtime.NumCPU()),
+		MaxReportedErrors: env.Int("USER_IMPORT_MAX_REPORTED_ERRORS", 1000),
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 500
+	}
+	if config.Workers <= 0 {
+		config.Workers = 1
+	}
+	return config
+}
+
+// ============================== Row readers ==========================================================================
+
+type importRow struct {
+	Row          int
+	Email        string
+	Password     string
+	PasswordHash string
+	// Checked rows have passed the validation and the password policy before the job was queued
+	Checked bool
+}
+
+// preparedImportRow is one line of importFormatPrepared, Error is set for a rejected row
+type preparedImportRow struct {
+	Row          int    `json:"row"`
+	Email        string `json:"email"`
+	PasswordHash string `json:"password_hash,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// importRowError is a broken row, the import goes on with the next one
+type importRowError struct {
+	message string
+}
+
+func (e *importRowError) Error() string {
+	return e.message
+}
+
+// importRowReader returns io.EOF when the stream is over
+type importRowReader interface {
+	Next() (importRow, error)
+}
+
+// importFormat takes the explicit format first and falls back to the request Content-Type
+func importFormat(format string, contentType string) string {
+	if format != "" {
+		return strings.ToLower(format)
+	}
+
+	switch contentType {
+	case "text/csv", "application/csv":
+		return ImportFormatCsv
+	case "application/x-ndjson", "application/ndjson", "application/jsonl":
+		return ImportFormatNdjson
+	}
+	return contentType
+}
+
+func newImportRowReader(format string, reader io.Reader) (importRowReader, error) {
+	switch format {
+	case ImportFormatCsv:
+		return newCsvImportReader(reader)
+	case ImportFormatNdjson:
+		scanner := bufio.NewScanner(reader)
+		scanner.Buffer(make([]byte, 0, 64*1024), importMaxLineSize)
+		return &ndjsonImportReader{scanner: scanner}, nil
+	}
+	return nil, errUnsupportedImportFormat
+}
+
+type csvImportReader struct {
+	reader        *csv.Reader
+	emailIndex    int
+	passwordIndex int
+	row           int
+}
+
+func newCsvImportReader(reader io.Reader) (*csvImportReader, error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = -1
+	csvReader.TrimLeadingSpace = true
+
+	header, err := csvReader.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &csvImportReader{reader: csvReader, emailIndex: -1, passwordIndex: -1}
+	for i, column := range header {
+		switch strings.ToLower(strings.TrimSpace(column)) {
+		case "email":
+			result.emailIndex = i
+		case "password":
+			result.passwordIndex = i
+		}
+	}
+
+	if result.emailIndex < 0 {
+		return nil, fmt.Errorf(ErrorImportMissingColumn, "email")
+	}
+	if result.passwordIndex < 0 {
+		return nil, fmt.Errorf(ErrorImportMissingColumn, "password")
+	}
+
+	return result, nil
+}
+
+func (r *csvImportReader) Next() (importRow, error) {
+	record, err := r.reader.Read()
+	if err == io.EOF {
+		return importRow{}, err
+	}
+
+	r.row++
+	row := importRow{Row: r.row}
+
+	var parseError *csv.ParseError
+	if errors.As(err, &parseError) {
+		return row, &importRowError{message: fmt.Sprintf(ErrorImportInvalidRow, parseError.Err)}
+	}
+	if err != nil {
+		return row, err
+	}
+
+	if len(record) <= r.emailIndex || len(record) <= r.passwordIndex {
+		return row, &importRowError{message: fmt.Sprintf(ErrorImportInvalidRow, "not enough columns")}
+	}
+
+	row.Email = strings.TrimSpace(record[r.emailIndex])
+	row.Password = record[r.passwordIndex]
+	return row, nil
+}
+
+type ndjsonImportReader struct {
+	scanner *bufio.Scanner
+	row     int
+}
+
+func (r *ndjsonImportReader) Next() (importRow, error) {
+	for r.scanner.Scan() {
+		line := strings.TrimSpace(r.scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		r.row++
+		row := importRow{Row: r.row}
+
+		var requestImportRowDto RequestImportUserRowDto
+		if err := json.Unmarshal([]byte(line), &requestImportRowDto); err != nil {
+			return row, &importRowError{message: fmt.Sprintf(ErrorImportInvalidRow, err.Error())}
+		}
+
+		row.Email = strings.TrimSpace(requestImportRowDto.Email)
+		row.Password = requestImportRowDto.Password
+		return row, nil
+	}
+
+	if err := r.scanner.Err(); err != nil {
+		return importRow{}, err
+	}
+	return importRow{}, io.EOF
+}
+
+type preparedImportReader struct {
+	scanner *bufio.Scanner
+}
+
+func newPreparedImportReader(reader io.Reader) *preparedImportReader {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), importMaxLineSize)
+	return &preparedImportReader{scanner: scanner}
+}
+
+func (r *preparedImportReader) Next() (importRow, error) {
+	if !r.scanner.Scan() {
+		if err := r.scanner.Err(); err != nil {
+			return importRow{}, err
+		}
+		return importRow{}, io.EOF
+	}
+
+	var prepared preparedImportRow
+	if err := json.Unmarshal(r.scanner.Bytes(), &prepared); err != nil {
+		return importRow{}, err
+	}
+
+	row := importRow{Row: prepared.Row, Email: prepared.Email, PasswordHash: prepared.PasswordHash, Checked: true}
+	if prepared.Error != "" {
+		return row, &importRowError{message: prepared.Error}
+	}
+	return row, nil
+}
+
+// prepareImport checks rows of the uploaded file and hashes passwords of valid ones, the result is importFormatPrepared.
+// Duplicates and existing emails are left to the job, they depend on the state of the database when it runs.
+func prepareImport(ctx context.Context, reader importRowReader, dryRun bool) ([]byte, error) {
+	config := loadImportConfig()
+	var output bytes.Buffer
+	encoder := json.NewEncoder(&output)
+
+	var batch []importRow
+	write := func() error {
+		var hashes []string
+		var hashErrors []error
+		if !dryRun {
+			hashes, hashErrors = hashImportPasswords(batch, config.Workers)
+		}
+
+		for i, row := range batch {
+			prepared := preparedImportRow{Row: row.Row, Email: row.Email}
+			if !dryRun && hashErrors[i] != nil {
+				prepared.Error = hashErrors[i].Error()
+			} else if !dryRun {
+				prepared.PasswordHash = hashes[i]
+			}
+			if err := encoder.Encode(prepared); err != nil {
+				return err
+			}
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		row, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+
+		var rowError *importRowError
+		if errors.As(err, &rowError) {
+			if err := encoder.Encode(preparedImportRow{Row: row.Row, Email: row.Email, Error: rowError.Error()}); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if message := checkImportRow(row); message != "" {
+			if err := encoder.Encode(preparedImportRow{Row: row.Row, Email: row.Email, Error: message}); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		batch = append(batch, row)
+		if len(batch) >= config.BatchSize {
+			if err := write(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := write(); err != nil {
+		return nil, err
+	}
+	return output.Bytes(), nil
+}
+
+// checkImportRow returns why the row is rejected, empty when it is valid
+func checkImportRow(row importRow) string {
+	requestImportRowDto := RequestImportUserRowDto{
+		Email:    row.Email,
+		Password: row.Password,
+	}
+	if err := binding.Validator.ValidateStruct(&requestImportRowDto); err != nil {
+		return err.Error()
+	}
+
+	if violations := CheckPasswordPolicy(row.Password, row.Email); len(violations) > 0 {
+		return PasswordViolationMessages(violations)
+	}
+	return ""
+}
+
+// ============================== Importer =============================================================================
+
+// userImporter validates rows as they are read and writes them in batches, so memory usage
+// is bounded by the batch size and not by the size of the uploaded file
+type userImporter struct {
+	ctx      context.Context
+	config   importConfig
+	dryRun   bool
+	progress func(processed int)
+	seen     map[string]int
+	batch    []importRow
+	result   ImportResultDto
+}
+
+// importUsers stops between rows when ctx is cancelled, progress is called after every written batch and can be nil
+func importUsers(ctx context.Context, reader importRowReader, dryRun bool, progress func(processed int)) (*ImportResultDto, error) {
+	importer := &userImporter{
+		ctx:      ctx,
+		config:   loadImportConfig(),
+		dryRun:   dryRun,
+		progress: progress,
+		seen:     map[string]int{},
+		result: ImportResultDto{
+			DryRun: dryRun,
+			Errors: []ImportRowErrorDto{},
+		},
+	}
+
+	if err := importer.run(reader); err != nil {
+		return nil, err
+	}
+
+	return &importer.result, nil
+}
+
+func (importer *userImporter) run(reader importRowReader) error {
+	for {
+		if err := importer.ctx.Err(); err != nil {
+			return err
+		}
+
+		row, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+
+		var rowError *importRowError
+		if errors.As(err, &rowError) {
+			importer.result.Total++
+			importer.addError(row, rowError.Error())
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		importer.result.Total++
+		if !importer.validate(row) {
+			continue
+		}
+
+		importer.batch = append(importer.batch, row)
+		if len(importer.batch) >= importer.config.BatchSize {
+			if err := importer.flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return importer.flush()
+}
+
+func (importer *userImporter) validate(row importRow) bool {
+	if !row.Checked {
+		if message := checkImportRow(row); message != "" {
+			importer.addError(row, message)
+			return false
+		}
+	}
+
+	normalized := NormalizeEmail(row.Email)
+	if firstRow, exists := importer.seen[normalized]; exists {
+		importer.addError(row, fmt.Sprintf(ErrorImportDuplicateInFile, row.Email, firstRow))
+		return false
+	}
+	importer.seen[normalized] = row.Row
+
+	return true
+}
+
+func (importer *userImporter) flush() error {
+	if len(importer.batch) == 0 {
+		return nil
+	}
+	defer func() {
+		importer.batch = importer.batch[:0]
+		if importer.progress != nil {
+			importer.progress(importer.result.Total)
+		}
+	}()
+
+	emails := make([]string, 0, len(importer.batch))
+	for _, row := range importer.batch {
+		emails = append(emails, row.Email)
+	}
+
+	existing, err := GetExistingEmails(emails)
+	if err != nil {
+		return err
+	}
+
+	var rows []importRow
+	for _, row := range importer.batch {
+		if existing[NormalizeEmail(row.Email)] {
+			importer.addError(row, fmt.Sprintf(ErrorImportEmailExists, row.Email))
+			continue
+		}
+		rows = append(rows, row)
+	}
+
+	importer.result.Valid += len(rows)
+	if importer.dryRun || len(rows) == 0 {
+		return nil
+	}
+
+	rows, users := importer.hashPasswords(rows)
+	if len(users) == 0 {
+		return nil
+	}
+
+	if err := CreateUserItems(users); err == nil {
+		importer.result.Imported += len(users)
+		return nil
+	}
+
+	// The batch is inserted by one statement, so nothing is written when a row is rejected
+	// (example: the same email created concurrently). Retrying row by row to find it.
+	for i, user := range users {
+		if _, err := CreateUserItem(user); err != nil {
+			importer.result.Valid--
+			importer.addError(rows[i], err.Error())
+			continue
+		}
+		importer.result.Imported++
+	}
+
+	return nil
+}
+
+// hashPasswords hashes passwords of the rows, rows of the prepared file come with the hash already
+func (importer *userImporter) hashPasswords(rows []importRow) ([]importRow, []User) {
+	hashes, hashErrors := hashImportPasswords(rows, importer.config.Workers)
+
+	hashedRows := make([]importRow, 0, len(rows))
+	users := make([]User, 0, len(rows))
+	for i, row := range rows {
+		if hashErrors[i] != nil {
+			importer.result.Valid--
+			importer.addError(row, hashErrors[i].Error())
+			continue
+		}
+		hashedRows = append(hashedRows, row)
+		users = append(users, User{
+			Email:    row.Email,
+			Password: hashes[i],
+		})
+	}
+
+	return hashedRows, users
+}
+
+// hashImportPasswords hashes passwords with a bounded amount of workers, hashing is the slowest part of the import
+func hashImportPasswords(rows []importRow, workers int) ([]string, []error) {
+	hashes := make([]string, len(rows))
+	hashErrors := make([]error, len(rows))
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if rows[i].PasswordHash != "" {
+					hashes[i] = rows[i].PasswordHash
+					continue
+				}
+				hashes[i], hashErrors[i] = HashPassword(rows[i].Password)
+			}
+		}()
+	}
+
+	for i := range rows {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return hashes, hashErrors
+}
+
+func (importer *userImporter) addError(row importRow, message string) {
+	importer.result.Failed++
+	if len(importer.result.Errors) >= importer.config.MaxReportedErrors {
+		importer.result.ErrorsTruncated = true
+		return
+	}
+
+	importer.result.Errors = append(importer.result.Errors, ImportRowErrorDto{
+		Row:     row.Row,
+		Email:   row.Email,
+		Message: message,
+	})
+}
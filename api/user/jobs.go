@@ -0,0 +1,100 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"net/http"
+	"user-service/api/job"
+	"user-service/api/service"
+)
+
+const JobTypeUserImport = "user.import"
+
+// JobTypeUserCreated is enqueued after a user is created, its handler is registered by the auth package
+const JobTypeUserCreated = "user.created"
+
+type UserCreatedPayload struct {
+	UserID uuid.UUID `json:"user_id"`
+}
+
+type importJobPayload struct {
+	Format string `json:"format"`
+	DryRun bool   `json:"dry_run"`
+}
+
+// JobOwner is the owner of jobs created by the request: the internal service, else the signed-in user. Anonymous
+// callers have no owner, they can not create jobs because nobody else may read them
+func JobOwner(c *gin.Context) string {
+	if caller := service.GetCaller(c); caller != nil {
+		return "service:" + caller.Name
+	}
+	if actorId := c.GetString(ContextActorId); actorId != "" {
+		return "user:" + actorId
+	}
+	return ""
+}
+
+// requireJobOwner returns the owner of the job the request creates, it responds 401 when the caller is anonymous
+func requireJobOwner(c *gin.Context) (string, bool) {
+	owner := JobOwner(c)
+	if owner == "" {
+		c.JSON(http.StatusUnauthorized, &ErrorResponseDto{
+			Message: ErrorJobOwnerRequired,
+		})
+		return "", false
+	}
+	return owner, true
+}
+
+// InitUserJobs registers handlers of user jobs, it has to be called before workers are started
+func InitUserJobs() {
+	job.RegisterHandler(JobTypeUserImport, runImportJob)
+	job.RegisterHandler(JobTypeUserBulk, runBulkJob)
+	job.RegisterHandler(JobTypeEmailsNormalize, runEmailsNormalizeJob)
+}
+
+// runImportJob imports the prepared file stored as job input. When the job is retried after a failure,
+// rows written by the previous attempt are reported as already existing emails.
+func runImportJob(ctx context.Context, importJob *job.Job, progress job.Progress) (any, error) {
+	var payload importJobPayload
+	if err := json.Unmarshal([]byte(importJob.Payload), &payload); err != nil {
+		return nil, job.Permanent(err)
+	}
+
+	var reader importRowReader = newPreparedImportReader(bytes.NewReader(importJob.Input))
+	if payload.Format != importFormatPrepared {
+		var err error
+		reader, err = newImportRowReader(payload.Format, bytes.NewReader(importJob.Input))
+		if err != nil {
+			return nil, job.Permanent(err)
+		}
+	}
+
+	// Approximate amount of rows, good enough for a progress bar
+	total := bytes.Count(importJob.Input, []byte("\n"))
+	if payload.Format == ImportFormatCsv && total > 0 {
+		total--
+	}
+
+	return importUsers(ctx, reader, payload.DryRun, func(processed int) {
+		progress(processed, max(total, processed))
+	})
+}
+
+// runBulkJob applies the bulk operation stored as job payload. A retry continues with users
+// which are not updated yet, because targets of the operation exclude already updated ones.
+func runBulkJob(ctx context.Context, bulkJob *job.Job, progress job.Progress) (any, error) {
+	var requestBulkUserDto RequestBulkUserDto
+	if err := json.Unmarshal([]byte(bulkJob.Payload), &requestBulkUserDto); err != nil {
+		return nil, job.Permanent(err)
+	}
+
+	if err := validateBulkRequest(&requestBulkUserDto); err != nil {
+		return nil, job.Permanent(err)
+	}
+
+	return runBulkOperation(ctx, &requestBulkUserDto, progress)
+}
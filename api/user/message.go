@@ -0,0 +1,38 @@
+package user
+
+// Service messages which are not present in library-utils dictionary
+
+const ErrorUnsupportedImportFormat = "Unsupported import format %s, expected csv or ndjson"
+const ErrorImportMissingColumn = "Import file has no %s column"
+const ErrorImportInvalidRow = "Invalid row: %s"
+const ErrorImportDuplicateInFile = "Email %s is duplicated in row %d"
+const ErrorImportEmailExists = "Email %s already exists"
+const ErrorPasswordTooShort = "Password must contain at least %d characters"
+const ErrorExportUnknownColumn = "Unknown export column %s"
+const ErrorExportNotAcceptable = "Export format is not acceptable, supported: %s"
+const ErrorImportTooLarge = "Import file is larger than %d bytes"
+const ErrorBulkInvalidArgument = "Invalid bulk operation %s: %s"
+const ErrorBulkEmptyFilter = "Filter is empty, set all=true to apply the operation to every user"
+const ErrorBulkConfirmationRequired = "Operation affects %d users which is above the limit of %d, repeat it with confirm_count=%d"
+const ErrorAccountStatus = "Account is %s"
+const ErrorAccountSuspendedUntil = "Account is suspended until %s"
+const ErrorStatusUntilInPast = "Suspension end %s is in the past"
+const ErrorStatusTransition = "User can not be moved from %s to %s"
+const SuspensionExpiredReason = "Suspension expired"
+const ErrorEmailChangeRequiresConfirmation = "Email can not be changed directly, use POST /user/{id}/email-change"
+const ErrorEmailTaken = "Email %s is already used"
+const ErrorPasswordPolicy = "Password does not meet the password policy"
+const ErrorRoleGrantDenied = "Only an admin can grant roles"
+const ErrorStaffStatusDenied = "Only an admin can change the status of a staff user"
+const ErrorJobOwnerRequired = "Background jobs can be created only by an authenticated user or service"
+const ErrorInvalidRequestBody = "Request body is invalid"
+const ErrorFieldRequired = "Field %s is required"
+const ErrorFieldInvalid = "Field %s does not pass the %s check"
+const ErrorPasswordTooWeak = "Password is too easy to guess, strength %d of required %d"
+const ErrorPasswordBannedSubstring = "Password must not contain %s"
+const ErrorPasswordBreached = "Password was found in a data breach, choose another one"
+const ErrorUnknownPasswordHasher = "Unknown password hasher %s"
+const ErrorInvalidPasswordHash = "Invalid password hash"
+const ErrorUnknownPasswordPepper = "Unknown password pepper %s"
+const ErrorPasswordReused = "Password must differ from the last %d passwords"
+const UserUnlocked = "Sign-in of user %s is unlocked"
@@ -6,18 +6,96 @@ import (
 	"time"
 )
 
+const StatusPending = "pending"
+const StatusActive = "active"
+const StatusSuspended = "suspended"
+const StatusBanned = "banned"
+
+var Statuses = []string{StatusPending, StatusActive, StatusSuspended, StatusBanned}
+
+const RoleAdmin = "admin"
+const RoleSupport = "support"
+
 type User struct {
+	ID                      uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4()"`
+	Email                   string     `gorm:"type:varchar(120);not null;unique"`
+	EmailNormalized         string     `gorm:"type:varchar(255);null;default:null"`
+	Password                string     `gorm:"type:varchar(255);not null"`
+	Status                  string     `gorm:"type:varchar(20);not null;default:active"`
+	StatusReason            string     `gorm:"type:text;null;default:null"`
+	StatusChangedAt         *time.Time `gorm:"type:timestamp;null;default:null"`
+	SuspendedUntil          *time.Time `gorm:"type:timestamp;null;default:null"`
+	EmailVerifiedAt         *time.Time `gorm:"type:timestamp;null;default:null"`
+	PasswordChangedAt       *time.Time `gorm:"type:timestamp;null;default:null"`
+	MustChangePassword      bool       `gorm:"not null;default:false"`
+	PendingEmail            string     `gorm:"type:varchar(120);null;default:null"`
+	PendingEmailRequestedAt *time.Time `gorm:"type:timestamp;null;default:null"`
+	CreatedAt               time.Time  `gorm:"type:timestamp;not null"`
+	UpdatedAt               time.Time  `gorm:"type:timestamp;null;default:null"`
+	DeletedAt               time.Time  `gorm:"type:timestamp;null;default:null"`
+}
+
+type UserRole struct {
+	UserID    uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Role      string    `gorm:"type:varchar(60);primaryKey"`
+	CreatedAt time.Time `gorm:"type:timestamp;not null"`
+}
+
+type UserStatusHistory struct {
+	ID             uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4()"`
+	UserID         uuid.UUID  `gorm:"type:uuid;not null"`
+	FromStatus     string     `gorm:"type:varchar(20);not null"`
+	ToStatus       string     `gorm:"type:varchar(20);not null"`
+	Reason         string     `gorm:"type:text;null;default:null"`
+	SuspendedUntil *time.Time `gorm:"type:timestamp;null;default:null"`
+	ActorID        *uuid.UUID `gorm:"type:uuid;null;default:null"`
+	CreatedAt      time.Time  `gorm:"type:timestamp;not null"`
+}
+
+// PasswordHistory keeps hashes of the last passwords of the user, so they can not be used again
+type PasswordHistory struct {
 	ID        uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4()"`
-	Email     string    `gorm:"type:varchar(120);not null;unique"`
-	Password  string    `gorm:"type:varchar(120);not null"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null"`
+	Password  string    `gorm:"type:varchar(255);not null"`
 	CreatedAt time.Time `gorm:"type:timestamp;not null"`
-	UpdatedAt time.Time `gorm:"type:timestamp;null;default:null"`
-	DeletedAt time.Time `gorm:"type:timestamp;null;default:null"`
+}
+
+func (PasswordHistory) TableName() string {
+	return "password_history"
+}
+
+func (p *PasswordHistory) BeforeCreate(tx *gorm.DB) (err error) {
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	return
+}
+
+func (UserStatusHistory) TableName() string {
+	return "user_status_history"
+}
+
+func (p *UserStatusHistory) BeforeCreate(tx *gorm.DB) (err error) {
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	return
 }
 
 func (p *User) BeforeCreate(tx *gorm.DB) (err error) {
 	if p.ID == uuid.Nil {
 		p.ID = uuid.New()
 	}
+	if p.EmailNormalized == "" {
+		p.EmailNormalized = NormalizeEmail(p.Email)
+	}
 	return
 }
+
+// AfterCreate starts the password history of the new user, imported users included
+func (p *User) AfterCreate(tx *gorm.DB) (err error) {
+	if p.Password == "" {
+		return
+	}
+	return recordPasswordHistory(tx, p.ID, p.Password, p.CreatedAt)
+}
@@ -0,0 +1,15 @@
+package user
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// ChangePassword stores the new password, tokens issued before the change stop working
+func ChangePassword(id uuid.UUID, password string, now time.Time) error {
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	return UpdateUserPassword(id, hash, now)
+}
@@ -0,0 +1,276 @@
+package user
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"strconv"
+	"strings"
+	"user-service/env"
+)
+
+const PasswordHasherBcrypt = "bcrypt"
+const PasswordHasherArgon2id = "argon2id"
+
+const argon2idSaltLength = 16
+const argon2idKeyLength = 32
+
+// PasswordHasher creates and checks password hashes of one algorithm. Hashes are PHC strings:
+// $<id>$<params>$<salt>$<hash>, bcrypt keeps its own $2b$<cost>$ form.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(hash string, password string) bool
+	// Outdated reports that the hash was made with other parameters than the configured ones
+	Outdated(hash string) bool
+}
+
+// passwordHashers maps the PHC id of the hash to the hasher, USER_PASSWORD_HASHER picks the one for new hashes
+var passwordHashers = map[string]PasswordHasher{
+	PasswordHasherBcrypt:   bcryptHasher{},
+	PasswordHasherArgon2id: argon2idHasher{},
+}
+
+// passwordHashAliases are PHC ids written by other implementations of the same algorithm
+var passwordHashAliases = map[string]string{
+	"2a": PasswordHasherBcrypt,
+	"2b": PasswordHasherBcrypt,
+	"2y": PasswordHasherBcrypt,
+}
+
+func RegisterPasswordHasher(id string, hasher PasswordHasher) {
+	passwordHashers[id] = hasher
+}
+
+// HashPassword hashes the password with the configured hasher
+func HashPassword(password string) (string, error) {
+	id := env.String("USER_PASSWORD_HASHER", PasswordHasherArgon2id)
+	hasher, exists := passwordHashers[id]
+	if !exists {
+		return "", fmt.Errorf(ErrorUnknownPasswordHasher, id)
+	}
+	return hasher.Hash(password)
+}
+
+// VerifyPassword compares the password with the stored hash of any registered algorithm
+func VerifyPassword(hash string, password string) bool {
+	hasher, exists := passwordHashers[passwordHashId(hash)]
+	if !exists {
+		return false
+	}
+	return hasher.Verify(hash, password)
+}
+
+// PasswordNeedsRehash reports that the hash is made by another algorithm or with outdated parameters
+func PasswordNeedsRehash(hash string) bool {
+	id := passwordHashId(hash)
+	if id != env.String("USER_PASSWORD_HASHER", PasswordHasherArgon2id) {
+		return true
+	}
+	return passwordHashers[id].Outdated(hash)
+}
+
+// RehashPassword upgrades the stored hash after a successful login, the password itself and issued tokens stay valid
+func RehashPassword(id uuid.UUID, currentHash string, password string) error {
+	if !PasswordNeedsRehash(currentHash) {
+		return nil
+	}
+
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	return UpdateUserPasswordHash(id, currentHash, hash)
+}
+
+// passwordHashId returns the algorithm id of the PHC string
+func passwordHashId(hash string) string {
+	parts := strings.SplitN(hash, "$", 3)
+	if len(parts) < 3 || parts[0] != "" {
+		return ""
+	}
+
+	if alias, exists := passwordHashAliases[parts[1]]; exists {
+		return alias
+	}
+	return parts[1]
+}
+
+// ============================== bcrypt ===============================================================================
+
+type bcryptHasher struct{}
+
+func (bcryptHasher) cost() int {
+	return env.Int("USER_PASSWORD_BCRYPT_COST", bcrypt.DefaultCost)
+}
+
+func (hasher bcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), hasher.cost())
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (bcryptHasher) Verify(hash string, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+func (hasher bcryptHasher) Outdated(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != hasher.cost()
+}
+
+// ============================== argon2id =============================================================================
+
+// argon2idHasher writes $argon2id$v=19$m=<KiB>,t=<iterations>,p=<threads>[,keyid=<pepper id>]$<salt>$<hash>.
+// With USER_PASSWORD_PEPPERS set the password is mixed with the first pepper before hashing and the pepper id is
+// kept in keyid, the rest of the peppers are only used to verify older hashes.
+type argon2idHasher struct{}
+
+type argon2idParams struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	KeyId       string
+}
+
+func (argon2idHasher) config() argon2idParams {
+	params := argon2idParams{
+		Memory:      uint32(env.Int("USER_PASSWORD_ARGON2_MEMORY", 19456)),
+		Iterations:  uint32(env.Int("USER_PASSWORD_ARGON2_ITERATIONS", 2)),
+		Parallelism: uint8(env.Int("USER_PASSWORD_ARGON2_PARALLELISM", 1)),
+	}
+	if peppers := env.List("USER_PASSWORD_PEPPERS", nil); len(peppers) > 0 {
+		params.KeyId = pepperId(peppers[0])
+	}
+	return params
+}
+
+func (hasher argon2idHasher) Hash(password string) (string, error) {
+	params := hasher.config()
+
+	salt := make([]byte, argon2idSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	input, err := pepperPassword(password, params.KeyId)
+	if err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey(input, salt, params.Iterations, params.Memory, params.Parallelism, argon2idKeyLength)
+	return fmt.Sprintf("$%s$v=%d$%s$%s$%s",
+		PasswordHasherArgon2id,
+		argon2.Version,
+		params.encode(),
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (argon2idHasher) Verify(hash string, password string) bool {
+	params, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return false
+	}
+
+	input, err := pepperPassword(password, params.KeyId)
+	if err != nil {
+		return false
+	}
+
+	actual := argon2.IDKey(input, salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(actual, key) == 1
+}
+
+func (hasher argon2idHasher) Outdated(hash string) bool {
+	params, _, key, err := decodeArgon2id(hash)
+	return err != nil || params != hasher.config() || len(key) != argon2idKeyLength
+}
+
+func (params argon2idParams) encode() string {
+	encoded := fmt.Sprintf("m=%d,t=%d,p=%d", params.Memory, params.Iterations, params.Parallelism)
+	if params.KeyId != "" {
+		encoded += ",keyid=" + params.KeyId
+	}
+	return encoded
+}
+
+func decodeArgon2id(hash string) (argon2idParams, []byte, []byte, error) {
+	var params argon2idParams
+
+	// "", "argon2id", "v=19", params, salt, hash
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != PasswordHasherArgon2id || parts[2] != fmt.Sprintf("v=%d", argon2.Version) {
+		return params, nil, nil, errors.New(ErrorInvalidPasswordHash)
+	}
+
+	for _, param := range strings.Split(parts[3], ",") {
+		name, value, _ := strings.Cut(param, "=")
+		if name == "keyid" {
+			params.KeyId = value
+			continue
+		}
+
+		number, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return params, nil, nil, errors.New(ErrorInvalidPasswordHash)
+		}
+		switch name {
+		case "m":
+			params.Memory = uint32(number)
+		case "t":
+			params.Iterations = uint32(number)
+		case "p":
+			params.Parallelism = uint8(number)
+		}
+	}
+
+	// argon2 panics on zero time or threads
+	if params.Iterations == 0 || params.Parallelism == 0 {
+		return params, nil, nil, errors.New(ErrorInvalidPasswordHash)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, err
+	}
+	return params, salt, key, nil
+}
+
+// ============================== Pepper ===============================================================================
+
+// pepperId identifies the pepper in hashes without revealing it
+func pepperId(pepper string) string {
+	sum := sha256.Sum256([]byte(pepper))
+	return hex.EncodeToString(sum[:4])
+}
+
+// pepperPassword mixes the password with the pepper of the key id, empty key id means no pepper
+func pepperPassword(password string, keyId string) ([]byte, error) {
+	if keyId == "" {
+		return []byte(password), nil
+	}
+
+	for _, pepper := range env.List("USER_PASSWORD_PEPPERS", nil) {
+		if pepperId(pepper) == keyId {
+			mac := hmac.New(sha256.New, []byte(pepper))
+			mac.Write([]byte(password))
+			return mac.Sum(nil), nil
+		}
+	}
+	return nil, fmt.Errorf(ErrorUnknownPasswordPepper, keyId)
+}
@@ -0,0 +1,59 @@
+package user
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"time"
+	"user-service/env"
+)
+
+const PasswordRuleReused = "reused"
+
+// passwordHistorySize is the amount of previous passwords which can not be used again, 0 disables the history
+func passwordHistorySize() int {
+	return env.Int("USER_PASSWORD_HISTORY_SIZE", 5)
+}
+
+// CheckPasswordHistory returns a violation when the password matches the current or a previous password of the user
+func CheckPasswordHistory(id uuid.UUID, password string) ([]ValidationErrorDto, error) {
+	size := passwordHistorySize()
+	if size <= 0 || id == uuid.Nil {
+		return nil, nil
+	}
+
+	hashes, err := GetPasswordHistory(id, size)
+	if err != nil {
+		return nil, err
+	}
+
+	checked := map[string]bool{}
+	for _, hash := range hashes {
+		if checked[hash] {
+			continue
+		}
+		checked[hash] = true
+
+		if VerifyPassword(hash, password) {
+			return []ValidationErrorDto{passwordViolation(PasswordRuleReused, fmt.Sprintf(ErrorPasswordReused, size))}, nil
+		}
+	}
+	return nil, nil
+}
+
+// PasswordExpired reports that the user has to change the password before using the account: it is flagged or older
+// than USER_PASSWORD_MAX_AGE. Passwords which were never changed are counted from the registration.
+func PasswordExpired(mustChange bool, changedAt *time.Time, createdAt time.Time, now time.Time) bool {
+	if mustChange {
+		return true
+	}
+
+	maxAge := env.Duration("USER_PASSWORD_MAX_AGE", 0)
+	if maxAge <= 0 {
+		return false
+	}
+
+	if changedAt == nil {
+		changedAt = &createdAt
+	}
+	return changedAt.Add(maxAge).Before(now)
+}
@@ -0,0 +1,178 @@
+package user
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"github.com/apiboxgo/library-utils/utils"
+	"github.com/nbutton23/zxcvbn-go"
+	"os"
+	"strings"
+	"sync"
+	"user-service/env"
+)
+
+const DefaultPasswordMinLength = 8
+const DefaultPasswordMinScore = 2
+
+const PasswordRuleMinLength = "min_length"
+const PasswordRuleStrength = "strength"
+const PasswordRuleBannedSubstring = "banned_substring"
+const PasswordRuleBreached = "breached"
+
+// bannedSubstringMinLength skips too short email names, otherwise a@example.com would ban every password with "a"
+const bannedSubstringMinLength = 3
+
+// breachedRangeLength is the length of the hash prefix used to pick a range, the same split as in the k-anonymity API
+const breachedRangeLength = 5
+
+type passwordPolicyConfig struct {
+	MinLength    int
+	MinScore     int
+	BannedWords  []string
+	BreachedFile string
+}
+
+func loadPasswordPolicyConfig() passwordPolicyConfig {
+	return passwordPolicyConfig{
+		MinLength:    env.Int("USER_PASSWORD_MIN_LENGTH", DefaultPasswordMinLength),
+		MinScore:     env.Int("USER_PASSWORD_MIN_SCORE", DefaultPasswordMinScore),
+		BannedWords:  env.List("USER_PASSWORD_BANNED_WORDS", nil),
+		BreachedFile: env.String("USER_PASSWORD_BREACHED_FILE", ""),
+	}
+}
+
+// CheckPasswordPolicy returns the list of violated password rules, empty list means the password is acceptable.
+// email is used to ban passwords built from the account name, it can be empty.
+func CheckPasswordPolicy(password string, email string) []ValidationErrorDto {
+	config := loadPasswordPolicyConfig()
+	var violations []ValidationErrorDto
+
+	if len([]rune(password)) < config.MinLength {
+		violations = append(violations, passwordViolation(PasswordRuleMinLength, fmt.Sprintf(ErrorPasswordTooShort, config.MinLength)))
+	}
+
+	banned := bannedPasswordSubstrings(email, config.BannedWords)
+	lowerPassword := strings.ToLower(password)
+	for _, word := range banned {
+		if strings.Contains(lowerPassword, word) {
+			violations = append(violations, passwordViolation(PasswordRuleBannedSubstring, fmt.Sprintf(ErrorPasswordBannedSubstring, word)))
+			break
+		}
+	}
+
+	if config.MinScore > 0 {
+		if score := zxcvbn.PasswordStrength(password, banned).Score; score < config.MinScore {
+			violations = append(violations, passwordViolation(PasswordRuleStrength, fmt.Sprintf(ErrorPasswordTooWeak, score, config.MinScore)))
+		}
+	}
+
+	if config.BreachedFile != "" {
+		list, err := loadBreachedPasswords(config.BreachedFile)
+		if err != nil {
+			// A broken list must not block every registration, the other rules still apply
+			utils.LogError(fmt.Sprintf("Breached password list %s is not loaded", config.BreachedFile), err)
+		} else if list.contains(password) {
+			violations = append(violations, passwordViolation(PasswordRuleBreached, ErrorPasswordBreached))
+		}
+	}
+
+	return violations
+}
+
+// PasswordViolationMessages joins violation messages for places where a plain text error is expected
+func PasswordViolationMessages(violations []ValidationErrorDto) string {
+	messages := make([]string, 0, len(violations))
+	for _, violation := range violations {
+		messages = append(messages, violation.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+func passwordViolation(rule string, message string) ValidationErrorDto {
+	return ValidationErrorDto{
+		Field:   "password",
+		Rule:    rule,
+		Message: message,
+	}
+}
+
+// bannedPasswordSubstrings returns lower case words which the password must not contain: the email name and
+// configured words
+func bannedPasswordSubstrings(email string, words []string) []string {
+	var banned []string
+	if name, _, found := strings.Cut(strings.ToLower(strings.TrimSpace(email)), "@"); found {
+		if len([]rune(name)) >= bannedSubstringMinLength {
+			banned = append(banned, name)
+		}
+	}
+
+	for _, word := range words {
+		if word = strings.ToLower(word); len([]rune(word)) >= bannedSubstringMinLength {
+			banned = append(banned, word)
+		}
+	}
+	return banned
+}
+
+// breachedPasswords keeps SHA-1 hashes of leaked passwords grouped by the hash prefix. A password is looked up the
+// same way as with the k-anonymity range API: the prefix selects a range and only suffixes are compared.
+type breachedPasswords struct {
+	path   string
+	ranges map[string]map[string]struct{}
+}
+
+var breachedPasswordsMutex sync.Mutex
+var breachedPasswordsList *breachedPasswords
+
+// loadBreachedPasswords reads the list once and keeps it in memory, the file is read again only when the path changes.
+// Every line is an upper or lower case SHA-1 hex hash, optionally followed by ":count" as in the published dumps.
+func loadBreachedPasswords(path string) (*breachedPasswords, error) {
+	breachedPasswordsMutex.Lock()
+	defer breachedPasswordsMutex.Unlock()
+
+	if breachedPasswordsList != nil && breachedPasswordsList.path == path {
+		return breachedPasswordsList, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	list := &breachedPasswords{
+		path:   path,
+		ranges: map[string]map[string]struct{}{},
+	}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		hash, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if len(hash) != sha1.Size*2 {
+			continue
+		}
+
+		hash = strings.ToUpper(hash)
+		prefix, suffix := hash[:breachedRangeLength], hash[breachedRangeLength:]
+		if list.ranges[prefix] == nil {
+			list.ranges[prefix] = map[string]struct{}{}
+		}
+		list.ranges[prefix][suffix] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	breachedPasswordsList = list
+	return list, nil
+}
+
+func (list *breachedPasswords) contains(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	_, found := list.ranges[hash[:breachedRangeLength]][hash[breachedRangeLength:]]
+	return found
+}
@@ -1,26 +1,90 @@
 package user
 
 import (
+	"errors"
 	"fmt"
 	"github.com/apiboxgo/library-utils/api_init"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"slices"
 	"strings"
+	"time"
 )
 
 func GetItems(filterDto *RequestFilterUserDto) (*ResultListDTO, error) {
 
+	query := filterItemsQuery(filterDto)
+
+	if filterDto.Limit <= 0 {
+		filterDto.Limit = 10
+	}
+
+	var total int64
+	result := []UserItemResultDto{}
+	err := query.Count(&total).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	query.Limit(filterDto.Limit)
+	if err := query.Find(&result).Error; err != nil {
+		return nil, err
+	}
+
+	resultDto := ResultListDTO{
+		List:  result,
+		Total: total,
+	}
+
+	// An empty page has no cursor
+	if len(result) > 0 {
+		lastItem := result[len(result)-1]
+		resultDto.Cursor = lastItem.ID
+		resultDto.LastTimestamp = lastItem.CreatedAt
+	}
+
+	return &resultDto, nil
+}
+
+// GetItemsPage returns the next page of users after the cursor without counting the total
+func GetItemsPage(filterDto *RequestFilterUserDto) ([]UserItemResultDto, error) {
+	var result []UserItemResultDto
+	err := filterItemsQuery(filterDto).
+		Select("id, email, status, created_at, updated_at, deleted_at").
+		Limit(filterDto.Limit).
+		Find(&result).Error
+	return result, err
+}
+
+// filterItemsQuery applies emails filter, order and keyset cursor of the users list
+func filterItemsQuery(filterDto *RequestFilterUserDto) *gorm.DB {
+
 	query := api_init.GetDbh().Model(&User{})
 
-	where := "(created_at, id) %s (?, ?)"
 	orderCreatedAt, orderCreatedExists := filterDto.Orders["created_at"]
 
 	if orderCreatedExists && strings.ToUpper(orderCreatedAt) != "DESC" {
 		query.Order("created_at ASC")
 		query.Order("id ASC")
-		where = fmt.Sprintf(where, ">")
 	} else {
 		query.Order("created_at DESC")
 		query.Order("id DESC")
+	}
+
+	return filterItemsWhere(query, filterDto)
+}
+
+// filterItemsWhere applies only conditions of the users list filter, without order and limit
+func filterItemsWhere(query *gorm.DB, filterDto *RequestFilterUserDto) *gorm.DB {
+
+	where := "(created_at, id) %s (?, ?)"
+	orderCreatedAt, orderCreatedExists := filterDto.Orders["created_at"]
+
+	if orderCreatedExists && strings.ToUpper(orderCreatedAt) != "DESC" {
+		where = fmt.Sprintf(where, ">")
+	} else {
 		where = fmt.Sprintf(where, "<")
 	}
 
@@ -33,36 +97,13 @@ func GetItems(filterDto *RequestFilterUserDto) (*ResultListDTO, error) {
 		var likeConditions []string
 		var likeArgs []interface{}
 		for _, email := range filterDto.Emails {
-			likeConditions = append(likeConditions, "email LIKE ?")
-			likeArgs = append(likeArgs, email+"%")
+			likeConditions = append(likeConditions, "lower(email) LIKE ?")
+			likeArgs = append(likeArgs, strings.ToLower(email)+"%")
 		}
 		query = query.Where("("+strings.Join(likeConditions, " OR ")+")", likeArgs...)
 	}
 
-	if filterDto.Limit <= 0 {
-		filterDto.Limit = 10
-	}
-
-	var total int64
-	var result []UserItemResultDto
-	err := query.Count(&total).Error
-
-	if err != nil {
-		return nil, err
-	}
-
-	query.Limit(filterDto.Limit)
-	query.Find(&result)
-
-	lastItem := result[len(result)-1]
-	resultDto := ResultListDTO{
-		List:          result,
-		Cursor:        lastItem.ID,
-		LastTimestamp: lastItem.CreatedAt,
-		Total:         total,
-	}
-
-	return &resultDto, nil
+	return query
 }
 
 func GetOneByEmail(email string) (*UserItemFullResultDto, error) {
@@ -72,7 +113,11 @@ func GetOneByEmail(email string) (*UserItemFullResultDto, error) {
 	}
 
 	var result UserItemFullResultDto
-	err := api_init.GetDbh().Raw("SELECT * FROM users WHERE email = $1 LIMIT 1", email).Scan(&result).Error
+	// Users left without normalised email by a collision are found by the exact email, which wins over the normalised one
+	err := api_init.GetDbh().Raw(
+		"SELECT * FROM users WHERE email_normalized = $1 OR (email_normalized IS NULL AND email = $2) ORDER BY (email = $2) DESC LIMIT 1",
+		NormalizeEmail(email), email,
+	).Scan(&result).Error
 
 	if err != nil {
 		return nil, err
@@ -81,6 +126,16 @@ func GetOneByEmail(email string) (*UserItemFullResultDto, error) {
 	return &result, nil
 }
 
+// GetOneFullById returns the user with the password hash, it is meant for authentication only
+func GetOneFullById(id uuid.UUID) (*UserItemFullResultDto, error) {
+	var result UserItemFullResultDto
+	err := api_init.GetDbh().Raw("SELECT * FROM users WHERE id = $1 LIMIT 1", id).Scan(&result).Error
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
 func GetOneById(requestUserIdDTO RequestUserIdDTO) (*UserItemResultDto, error) {
 
 	if requestUserIdDTO.ID == "" {
@@ -97,6 +152,35 @@ func CreateUserItem(User User) (bool, error) {
 	return result(err)
 }
 
+// CreateUserItems inserts all users by one statement
+func CreateUserItems(users []User) error {
+	return api_init.GetDbh().Create(&users).Error
+}
+
+// GetExistingEmails returns normalised forms of the emails which are already registered
+func GetExistingEmails(emails []string) (map[string]bool, error) {
+	existing := map[string]bool{}
+	if len(emails) == 0 {
+		return existing, nil
+	}
+
+	normalized := make([]string, 0, len(emails))
+	for _, email := range emails {
+		normalized = append(normalized, NormalizeEmail(email))
+	}
+
+	var found []string
+	err := api_init.GetDbh().Model(&User{}).Where("email_normalized IN ?", normalized).Pluck("email_normalized", &found).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, email := range found {
+		existing[email] = true
+	}
+	return existing, nil
+}
+
 func PutUserItem(requestUserIdDTO RequestUserIdDTO, user map[string]interface{}) (bool, error) {
 	err := api_init.GetDbh().Model(&User{}).Where("id = ?", requestUserIdDTO.ID).Updates(user).Error
 	return result(err)
@@ -112,6 +196,377 @@ func DeleteUserItemById(id uuid.UUID) (bool, error) {
 	return result(err)
 }
 
+// bulkTargetsQuery selects users matched by the filter which are not in the state the operation leads to yet,
+// so a repeated operation affects nobody
+func bulkTargetsQuery(requestBulkUserDto *RequestBulkUserDto) *gorm.DB {
+	query := filterItemsWhere(api_init.GetDbh().Model(&User{}), &requestBulkUserDto.Filter)
+	if requestBulkUserDto.ExcludeStaff {
+		query = query.Where("NOT EXISTS (SELECT 1 FROM user_roles WHERE user_roles.user_id = users.id)")
+	}
+
+	switch requestBulkUserDto.Operation {
+	case BulkOperationSoftDelete:
+		query = query.Where("deleted_at IS NULL")
+	case BulkOperationRestore:
+		query = query.Where("deleted_at IS NOT NULL")
+	case BulkOperationSetStatus:
+		query = query.Where("status IN ?", statusSources(requestBulkUserDto.Status))
+	case BulkOperationAddRole:
+		query = query.Where(
+			"NOT EXISTS (SELECT 1 FROM user_roles WHERE user_roles.user_id = users.id AND user_roles.role = ?)",
+			requestBulkUserDto.Role,
+		)
+	}
+
+	return query
+}
+
+func CountBulkTargets(requestBulkUserDto *RequestBulkUserDto) (int64, error) {
+	var total int64
+	err := bulkTargetsQuery(requestBulkUserDto).Count(&total).Error
+	return total, err
+}
+
+// GetBulkTargetIds returns the next ids of bulk operation targets ordered by id
+func GetBulkTargetIds(requestBulkUserDto *RequestBulkUserDto, afterId uuid.UUID, limit int) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	err := bulkTargetsQuery(requestBulkUserDto).
+		Where("id > ?", afterId).
+		Order("id ASC").
+		Limit(limit).
+		Pluck("id", &ids).Error
+	return ids, err
+}
+
+func SoftDeleteUserItems(ids []uuid.UUID, now time.Time) (int64, error) {
+	tx := api_init.GetDbh().Model(&User{}).Where("id IN ? AND deleted_at IS NULL", ids).Updates(map[string]interface{}{
+		"deleted_at": now,
+		"updated_at": now,
+	})
+	return tx.RowsAffected, tx.Error
+}
+
+func RestoreUserItems(ids []uuid.UUID, now time.Time) (int64, error) {
+	tx := api_init.GetDbh().Model(&User{}).Where("id IN ? AND deleted_at IS NOT NULL", ids).Updates(map[string]interface{}{
+		"deleted_at": nil,
+		"updated_at": now,
+	})
+	return tx.RowsAffected, tx.Error
+}
+
+// SetUserItemsStatus changes status of users which are allowed to move to it and writes the history records
+func SetUserItemsStatus(ids []uuid.UUID, status string, reason string, actorId *uuid.UUID, now time.Time) (int64, error) {
+	tx := api_init.GetDbh().Exec(`
+		WITH changed AS (
+			UPDATE users
+			SET status = ?, status_reason = ?, status_changed_at = ?, suspended_until = NULL, updated_at = ?
+			FROM (SELECT id, status FROM users WHERE id IN ? AND status IN ? FOR UPDATE) AS previous
+			WHERE users.id = previous.id
+			RETURNING users.id, previous.status AS from_status
+		)
+		INSERT INTO user_status_history (id, user_id, from_status, to_status, reason, actor_id, created_at)
+		SELECT uuid_generate_v4(), id, from_status, ?, ?, ?, ? FROM changed`,
+		status, reason, now, now, ids, statusSources(status),
+		status, reason, actorId, now,
+	)
+	return tx.RowsAffected, tx.Error
+}
+
+// ChangeUserItemStatus checks the transition against the locked row, so concurrent changes can not skip the state machine
+func ChangeUserItemStatus(id uuid.UUID, to string, reason string, until *time.Time, actorId *uuid.UUID, now time.Time) error {
+	return api_init.GetDbh().Transaction(func(tx *gorm.DB) error {
+		var current User
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id, status").Where("id = ?", id).Take(&current).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrUserNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		if !CanTransition(current.Status, to) {
+			return fmt.Errorf("%w: %s -> %s", ErrStatusTransition, current.Status, to)
+		}
+
+		err = tx.Model(&User{}).Where("id = ?", id).Updates(map[string]interface{}{
+			"status":            to,
+			"status_reason":     reason,
+			"status_changed_at": now,
+			"suspended_until":   until,
+			"updated_at":        now,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Create(&UserStatusHistory{
+			UserID:         id,
+			FromStatus:     current.Status,
+			ToStatus:       to,
+			Reason:         reason,
+			SuspendedUntil: until,
+			ActorID:        actorId,
+			CreatedAt:      now,
+		}).Error
+	})
+}
+
+// ReactivateExpiredSuspensions activates users whose suspension is over, id limits it to one user and can be nil
+func ReactivateExpiredSuspensions(id *uuid.UUID, now time.Time) (int64, error) {
+	tx := api_init.GetDbh().Exec(`
+		WITH reactivated AS (
+			UPDATE users
+			SET status = ?, status_reason = NULL, status_changed_at = ?, suspended_until = NULL, updated_at = ?
+			WHERE status = ? AND suspended_until IS NOT NULL AND suspended_until <= ? AND (CAST(? AS uuid) IS NULL OR id = ?)
+			RETURNING id
+		)
+		INSERT INTO user_status_history (id, user_id, from_status, to_status, reason, created_at)
+		SELECT uuid_generate_v4(), id, ?, ?, ?, ? FROM reactivated`,
+		StatusActive, now, now, StatusSuspended, now, id, id,
+		StatusSuspended, StatusActive, SuspensionExpiredReason, now,
+	)
+	return tx.RowsAffected, tx.Error
+}
+
+func GetUserRoles(id uuid.UUID) ([]string, error) {
+	var roles []string
+	err := api_init.GetDbh().Model(&UserRole{}).Where("user_id = ?", id).Order("role").Pluck("role", &roles).Error
+	return roles, err
+}
+
+func AddUserItemsRole(ids []uuid.UUID, role string, now time.Time) (int64, error) {
+	userRoles := make([]UserRole, 0, len(ids))
+	for _, id := range ids {
+		userRoles = append(userRoles, UserRole{UserID: id, Role: role, CreatedAt: now})
+	}
+
+	tx := api_init.GetDbh().Clauses(clause.OnConflict{DoNothing: true}).Create(&userRoles)
+	return tx.RowsAffected, tx.Error
+}
+
+func MarkEmailVerified(id uuid.UUID, now time.Time) error {
+	return api_init.GetDbh().Model(&User{}).
+		Where("id = ? AND email_verified_at IS NULL", id).
+		Updates(map[string]interface{}{
+			"email_verified_at": now,
+			"updated_at":        now,
+		}).Error
+}
+
+func UpdateUserPassword(id uuid.UUID, hash string, now time.Time) error {
+	return api_init.GetDbh().Transaction(func(tx *gorm.DB) error {
+		return UpdateUserPasswordTx(tx, id, hash, now)
+	})
+}
+
+// UpdateUserPasswordTx changes the password in the transaction of the caller, for example together with the use of
+// the reset token
+func UpdateUserPasswordTx(tx *gorm.DB, id uuid.UUID, hash string, now time.Time) error {
+	err := tx.Model(&User{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"password":             hash,
+			"password_changed_at":  now,
+			"must_change_password": false,
+			"updated_at":           now,
+		}).Error
+	if err != nil {
+		return err
+	}
+	return recordPasswordHistory(tx, id, hash, now)
+}
+
+// UpdateUserPasswordHash replaces the hash of the same password, unlike UpdateUserPassword issued tokens stay valid.
+// Nothing is changed when the password was changed in the meantime.
+func UpdateUserPasswordHash(id uuid.UUID, currentHash string, hash string) error {
+	return api_init.GetDbh().Model(&User{}).
+		Where("id = ? AND password = ?", id, currentHash).
+		Update("password", hash).Error
+}
+
+func SetPasswordMustChange(id uuid.UUID, now time.Time) error {
+	return api_init.GetDbh().Model(&User{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"must_change_password": true,
+			"updated_at":           now,
+		}).Error
+}
+
+// GetPasswordHistory returns the current password hash and up to limit previous ones, the newest first
+func GetPasswordHistory(id uuid.UUID, limit int) ([]string, error) {
+	var result []string
+	err := api_init.GetDbh().Raw(
+		"SELECT password FROM users WHERE id = ? UNION ALL "+
+			"(SELECT password FROM password_history WHERE user_id = ? ORDER BY created_at DESC LIMIT ?)",
+		id, id, limit,
+	).Scan(&result).Error
+	return result, err
+}
+
+// recordPasswordHistory adds the hash and removes entries above USER_PASSWORD_HISTORY_SIZE
+func recordPasswordHistory(tx *gorm.DB, id uuid.UUID, hash string, now time.Time) error {
+	size := passwordHistorySize()
+	if size <= 0 {
+		return nil
+	}
+
+	if err := tx.Create(&PasswordHistory{UserID: id, Password: hash, CreatedAt: now}).Error; err != nil {
+		return err
+	}
+
+	return tx.Exec(
+		"DELETE FROM password_history WHERE user_id = ? AND id NOT IN "+
+			"(SELECT id FROM password_history WHERE user_id = ? ORDER BY created_at DESC, id LIMIT ?)",
+		id, id, size,
+	).Error
+}
+
+func SetPendingEmail(id uuid.UUID, email string, now time.Time) error {
+	return api_init.GetDbh().Model(&User{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"pending_email":              email,
+			"pending_email_requested_at": now,
+			"updated_at":                 now,
+		}).Error
+}
+
+// ApplyPendingEmail swaps the email with the confirmed pending one and returns the new email,
+// an empty result means there is no pending email
+func ApplyPendingEmail(id uuid.UUID, now time.Time) (string, error) {
+	email := ""
+	err := api_init.GetDbh().Transaction(func(tx *gorm.DB) error {
+		var current User
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id, pending_email").Where("id = ?", id).Take(&current).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrUserNotFound
+		}
+		if err != nil || current.PendingEmail == "" {
+			return err
+		}
+
+		email = current.PendingEmail
+		return updateEmail(tx, id, email, now)
+	})
+	return email, err
+}
+
+// ChangeEmail sets the email without confirmation, it is used by staff
+func ChangeEmail(id uuid.UUID, email string, now time.Time) error {
+	return api_init.GetDbh().Transaction(func(tx *gorm.DB) error {
+		return updateEmail(tx, id, email, now)
+	})
+}
+
+// updateEmail checks uniqueness right before the update, the unique index still protects from a concurrent insert
+func updateEmail(tx *gorm.DB, id uuid.UUID, email string, now time.Time) error {
+	var count int64
+	normalized := NormalizeEmail(email)
+	if err := tx.Model(&User{}).Where("email_normalized = ? AND id <> ?", normalized, id).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("%w: %s", ErrEmailTaken, email)
+	}
+
+	return tx.Model(&User{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"email":                      email,
+		"email_normalized":           normalized,
+		"pending_email":              nil,
+		"pending_email_requested_at": nil,
+		"email_verified_at":          now,
+		"updated_at":                 now,
+	}).Error
+}
+
+// GetEmailNormalizationRules returns the rules stored emails were normalised with, empty before the first run
+func GetEmailNormalizationRules() (string, error) {
+	var rules []string
+	err := api_init.GetDbh().Raw("SELECT rules FROM email_normalization WHERE id = 1").Scan(&rules).Error
+	if err != nil || len(rules) == 0 {
+		return "", err
+	}
+	return rules[0], nil
+}
+
+type storedEmail struct {
+	ID              uuid.UUID
+	Email           string
+	EmailNormalized *string
+}
+
+// NormalizeStoredEmails recomputes users.email_normalized by NormalizeEmail and saves the rules. As the migration did,
+// only the oldest user of emails colliding after normalisation keeps the normalised email, the rest are listed
+// in user_email_collisions. It returns the number of changed users.
+func NormalizeStoredEmails(rules string, now time.Time) (int, error) {
+	changed := 0
+	err := api_init.GetDbh().Transaction(func(tx *gorm.DB) error {
+		// Users created meanwhile wait, so they can not take a normalised email of a stored user
+		if err := tx.Exec("LOCK TABLE users IN SHARE ROW EXCLUSIVE MODE").Error; err != nil {
+			return err
+		}
+
+		var users []storedEmail
+		if err := tx.Raw("SELECT id, email, email_normalized FROM users ORDER BY created_at, id").Scan(&users).Error; err != nil {
+			return err
+		}
+
+		keptUserIds := map[string]uuid.UUID{}
+		updates := map[uuid.UUID]*string{}
+		if err := tx.Exec("DELETE FROM user_email_collisions").Error; err != nil {
+			return err
+		}
+		for _, stored := range users {
+			normalized := NormalizeEmail(stored.Email)
+			value := &normalized
+			if keptUserId, isTaken := keptUserIds[normalized]; isTaken {
+				value = nil
+				err := tx.Exec(
+					"INSERT INTO user_email_collisions (user_id, email, email_normalized, kept_user_id, created_at) VALUES (?, ?, ?, ?, ?)",
+					stored.ID, stored.Email, normalized, keptUserId, now,
+				).Error
+				if err != nil {
+					return err
+				}
+			} else {
+				keptUserIds[normalized] = stored.ID
+			}
+
+			if (value == nil) != (stored.EmailNormalized == nil) || (value != nil && *value != *stored.EmailNormalized) {
+				updates[stored.ID] = value
+			}
+		}
+
+		// Old values are cleared first, otherwise the unique index fails when two users swap their normalised emails
+		ids := make([]uuid.UUID, 0, len(updates))
+		for id := range updates {
+			ids = append(ids, id)
+		}
+		for chunk := range slices.Chunk(ids, 1000) {
+			if err := tx.Exec("UPDATE users SET email_normalized = NULL WHERE id IN ?", chunk).Error; err != nil {
+				return err
+			}
+		}
+		for id, value := range updates {
+			if value == nil {
+				continue
+			}
+			if err := tx.Exec("UPDATE users SET email_normalized = ? WHERE id = ?", *value, id).Error; err != nil {
+				return err
+			}
+		}
+		changed = len(updates)
+
+		return tx.Exec(
+			"INSERT INTO email_normalization (id, rules, normalized_at) VALUES (1, ?, ?) "+
+				"ON CONFLICT (id) DO UPDATE SET rules = excluded.rules, normalized_at = excluded.normalized_at",
+			rules, now,
+		).Error
+	})
+	return changed, err
+}
+
 func result(err error) (bool, error) {
 	if err != nil {
 		return false, err
@@ -6,16 +6,72 @@ import (
 
 const UriUser = "/user"
 const UriUserGetByEmail = "/get-by-email"
+const UriUserImport = "/import"
+const UriUserExport = "/export"
+const UriUserBulk = "/bulk"
+const UriUserSuspend = "/:id/suspend"
+const UriUserReinstate = "/:id/reinstate"
+const UriUserBan = "/:id/ban"
+const UriUserSuspendS = "/%s/suspend"
+const UriUserReinstateS = "/%s/reinstate"
+const UriUserBanS = "/%s/ban"
+const UriUserUnlock = "/:id/unlock"
+const UriUserUnlockS = "/%s/unlock"
+const UriUserEmailChange = "/:id/email-change"
+const UriUserEmailChangeS = "/%s/email-change"
+const UriUserMfaTotp = "/:id/mfa/totp"
+const UriUserMfaTotpS = "/%s/mfa/totp"
+const UriUserMfaTotpVerify = "/:id/mfa/totp/verify"
+const UriUserMfaTotpVerifyS = "/%s/mfa/totp/verify"
+const UriUserWebauthnRegisterBegin = "/:id/webauthn/register/begin"
+const UriUserWebauthnRegisterBeginS = "/%s/webauthn/register/begin"
+const UriUserWebauthnRegisterFinish = "/:id/webauthn/register/finish"
+const UriUserWebauthnRegisterFinishS = "/%s/webauthn/register/finish"
+const UriUserWebauthnCredentials = "/:id/webauthn/credentials"
+const UriUserWebauthnCredentialsS = "/%s/webauthn/credentials"
+const UriUserWebauthnCredential = "/:id/webauthn/credentials/:credentialId"
+const UriUserWebauthnCredentialS = "/%s/webauthn/credentials/%s"
+const UriUserSessions = "/:id/sessions"
+const UriUserSessionsS = "/%s/sessions"
+const UriUserSession = "/:id/sessions/:sessionId"
+const UriUserSessionS = "/%s/sessions/%s"
+const UriUserLogins = "/:id/logins"
+const UriUserLoginsS = "/%s/logins"
+const UriUserIdentities = "/:id/identities"
+const UriUserIdentitiesS = "/%s/identities"
+const UriUserIdentityLinkBegin = "/:id/identities/:provider/begin"
+const UriUserIdentityLinkBeginS = "/%s/identities/%s/begin"
+const UriUserIdentityLinkFinish = "/:id/identities/:provider/finish"
+const UriUserIdentityLinkFinishS = "/%s/identities/%s/finish"
+const UriUserIdentity = "/:id/identities/:identityId"
+const UriUserIdentityS = "/%s/identities/%s"
+const UriUserApiKeys = "/:id/api-keys"
+const UriUserApiKeysS = "/%s/api-keys"
+const UriUserApiKey = "/:id/api-keys/:keyId"
+const UriUserApiKeyS = "/%s/api-keys/%s"
 const UriUserGetById = "/:id"
 const UriUserGetByIdS = "/%s"
 
-func InitUserRoutes(route *gin.Engine) {
+// InitUserRoutes registers routes of internal callers, the caller protects the router with service authentication
+func InitUserRoutes(route gin.IRouter) {
 	group := route.Group(UriUser)
 	route.GET(UriUser, GetUsersListByFilter)
+	group.GET(UriUserExport, ExportUsers)
 	group.GET(UriUserGetById, GetUserById)
 	route.POST(UriUser, CreateUser)
 	group.POST(UriUserGetByEmail, GetUserByEmail)
+	group.POST(UriUserImport, ImportUsers)
 	group.PUT(UriUserGetById, PutUserItemById)
 	group.PATCH(UriUserGetById, PatchUserById)
 	group.DELETE(UriUserGetById, DeleteUserById)
 }
+
+// InitUserAdminRoutes registers staff only routes, the caller protects the router with auth middleware
+func InitUserAdminRoutes(route gin.IRouter) {
+	group := route.Group(UriUser)
+	group.POST(UriUserBulk, BulkUsers)
+	group.POST(UriUserSuspend, SuspendUserById)
+	group.POST(UriUserReinstate, ReinstateUserById)
+	group.POST(UriUserBan, BanUserById)
+	group.POST(UriUserUnlock, UnlockUserById)
+}
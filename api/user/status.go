@@ -0,0 +1,133 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/apiboxgo/library-utils/utils"
+	"github.com/google/uuid"
+	"slices"
+	"time"
+	"user-service/env"
+)
+
+// ContextActorId is the gin context key of the authenticated user id, it is set by the auth middleware
+const ContextActorId = "actor_id"
+
+// ContextActorRoles is the gin context key of roles of the authenticated user, it is set by the auth middleware
+const ContextActorRoles = "actor_roles"
+
+// statusTransitions lists statuses which can follow the current one
+var statusTransitions = map[string][]string{
+	StatusPending:   {StatusActive, StatusSuspended, StatusBanned},
+	StatusActive:    {StatusSuspended, StatusBanned},
+	StatusSuspended: {StatusActive, StatusBanned},
+	StatusBanned:    {StatusActive},
+}
+
+var ErrStatusTransition = errors.New("status transition is not allowed")
+var ErrUserNotFound = errors.New("user not found")
+
+// AccountStatusError explains why the account can not be used right now
+type AccountStatusError struct {
+	Status         string
+	Reason         string
+	SuspendedUntil *time.Time
+}
+
+func (e *AccountStatusError) Error() string {
+	if e.SuspendedUntil != nil {
+		return fmt.Sprintf(ErrorAccountSuspendedUntil, e.SuspendedUntil.Format(time.RFC3339))
+	}
+	return fmt.Sprintf(ErrorAccountStatus, e.Status)
+}
+
+func CanTransition(from string, to string) bool {
+	return slices.Contains(statusTransitions[from], to)
+}
+
+// statusSources returns statuses which can be changed to the given one
+func statusSources(to string) []string {
+	var sources []string
+	for from, targets := range statusTransitions {
+		if slices.Contains(targets, to) {
+			sources = append(sources, from)
+		}
+	}
+	slices.Sort(sources)
+	return sources
+}
+
+// ChangeStatus moves the user to another status and writes the history record. until is used only for suspension,
+// nil means an indefinite suspension.
+func ChangeStatus(id uuid.UUID, to string, reason string, until *time.Time, actorId *uuid.UUID) (*UserItemResultDto, error) {
+	if to != StatusSuspended {
+		until = nil
+	}
+
+	if err := ChangeUserItemStatus(id, to, reason, until, actorId, time.Now()); err != nil {
+		return nil, err
+	}
+
+	return GetOneById(RequestUserIdDTO{ID: id.String()})
+}
+
+// CheckAccountStatus returns AccountStatusError when the account may not log in or use its tokens.
+// An expired suspension is lifted right here, so the user does not wait for the sweeper.
+func CheckAccountStatus(id uuid.UUID, status string, suspendedUntil *time.Time, reason string) error {
+	switch status {
+	case StatusActive, StatusPending:
+		return nil
+	case StatusSuspended:
+		if suspendedUntil != nil && !suspendedUntil.After(time.Now()) {
+			if _, err := ReactivateExpiredSuspensions(&id, time.Now()); err != nil {
+				return err
+			}
+			return nil
+		}
+	}
+
+	return &AccountStatusError{
+		Status:         status,
+		Reason:         reason,
+		SuspendedUntil: suspendedUntil,
+	}
+}
+
+// StartSuspensionSweeper periodically reactivates users whose suspension is over
+func StartSuspensionSweeper(ctx context.Context) {
+	interval := env.Duration("USER_SUSPENSION_SWEEP_INTERVAL", time.Minute)
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+
+			count, err := ReactivateExpiredSuspensions(nil, time.Now())
+			if err != nil {
+				utils.LogError("Suspension sweeper error", err)
+			} else if count > 0 {
+				utils.LogInfo(fmt.Sprintf("Reactivated %d users with expired suspension", count))
+			}
+		}
+	}()
+}
+
+func parseStatusUntil(until string) (*time.Time, error) {
+	if until == "" {
+		return nil, nil
+	}
+
+	value, err := time.Parse(time.RFC3339, until)
+	if err != nil {
+		return nil, err
+	}
+
+	if !value.After(time.Now()) {
+		return nil, fmt.Errorf(ErrorStatusUntilInPast, until)
+	}
+	return &value, nil
+}
@@ -0,0 +1,46 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users ADD COLUMN status_reason TEXT NULL DEFAULT NULL;
+ALTER TABLE users ADD COLUMN status_changed_at TIMESTAMP NULL DEFAULT NULL;
+ALTER TABLE users ADD COLUMN suspended_until TIMESTAMP NULL DEFAULT NULL;
+
+CREATE INDEX users_suspended_until_idx ON users (suspended_until) WHERE status = 'suspended';
+
+CREATE TABLE user_status_history
+(
+    id   uuid DEFAULT uuid_generate_v4() NOT NULL PRIMARY KEY,
+    user_id uuid NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    from_status VARCHAR(20) NOT NULL,
+    to_status VARCHAR(20) NOT NULL,
+    reason TEXT NULL DEFAULT NULL,
+    suspended_until TIMESTAMP NULL DEFAULT NULL,
+    actor_id uuid NULL DEFAULT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX user_status_history_user_id_idx ON user_status_history (user_id, created_at);
+
+CREATE TABLE refresh_tokens
+(
+    id   uuid DEFAULT uuid_generate_v4() NOT NULL PRIMARY KEY,
+    user_id uuid NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    family_id uuid NOT NULL,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP NULL DEFAULT NULL,
+    replaced_by uuid NULL DEFAULT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX refresh_tokens_user_id_idx ON refresh_tokens (user_id);
+CREATE INDEX refresh_tokens_family_id_idx ON refresh_tokens (family_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS refresh_tokens;
+DROP TABLE IF EXISTS user_status_history;
+ALTER TABLE users DROP COLUMN IF EXISTS suspended_until;
+ALTER TABLE users DROP COLUMN IF EXISTS status_changed_at;
+ALTER TABLE users DROP COLUMN IF EXISTS status_reason
+-- +goose StatementEnd
@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users ADD COLUMN password_changed_at TIMESTAMP NULL DEFAULT NULL;
+
+CREATE TABLE audit_events
+(
+    id   uuid DEFAULT uuid_generate_v4() NOT NULL PRIMARY KEY,
+    user_id uuid NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    actor_id uuid NULL DEFAULT NULL,
+    event VARCHAR(60) NOT NULL,
+    ip VARCHAR(45) NULL DEFAULT NULL,
+    user_agent TEXT NULL DEFAULT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX audit_events_user_id_idx ON audit_events (user_id, created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS audit_events;
+ALTER TABLE users DROP COLUMN IF EXISTS password_changed_at
+-- +goose StatementEnd
@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE mail_send_log
+(
+    id   uuid DEFAULT uuid_generate_v4() NOT NULL PRIMARY KEY,
+    recipient VARCHAR(120) NOT NULL,
+    template VARCHAR(60) NOT NULL,
+    locale VARCHAR(20) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    error TEXT NULL DEFAULT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX mail_send_log_recipient_idx ON mail_send_log (recipient, created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS mail_send_log
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users ADD COLUMN pending_email VARCHAR(120) NULL DEFAULT NULL;
+ALTER TABLE users ADD COLUMN pending_email_requested_at TIMESTAMP NULL DEFAULT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users DROP COLUMN IF EXISTS pending_email_requested_at;
+ALTER TABLE users DROP COLUMN IF EXISTS pending_email
+-- +goose StatementEnd
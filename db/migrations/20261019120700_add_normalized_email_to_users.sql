@@ -0,0 +1,46 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users ADD COLUMN email_normalized VARCHAR(255) NULL DEFAULT NULL;
+
+-- Users whose emails become equal after normalisation, only the oldest of them gets the normalised email.
+-- The rest keep working by the exact email until the collision is resolved manually.
+CREATE TABLE user_email_collisions
+(
+    id   uuid DEFAULT uuid_generate_v4() NOT NULL PRIMARY KEY,
+    user_id uuid NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    email VARCHAR(120) NOT NULL,
+    email_normalized VARCHAR(255) NOT NULL,
+    kept_user_id uuid NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+WITH ranked AS (
+    SELECT id, email, lower(trim(email)) AS normalized,
+           first_value(id) OVER (PARTITION BY lower(trim(email)) ORDER BY created_at, id) AS kept_user_id
+    FROM users
+)
+INSERT INTO user_email_collisions (user_id, email, email_normalized, kept_user_id)
+SELECT id, email, normalized, kept_user_id FROM ranked WHERE id <> kept_user_id;
+
+UPDATE users SET email_normalized = lower(trim(email))
+WHERE id NOT IN (SELECT user_id FROM user_email_collisions);
+
+CREATE UNIQUE INDEX users_email_normalized_idx ON users (email_normalized);
+
+DO $$
+DECLARE
+    collisions INT;
+BEGIN
+    SELECT count(*) INTO collisions FROM user_email_collisions;
+    IF collisions > 0 THEN
+        RAISE WARNING '% users have emails colliding after normalisation, see table user_email_collisions', collisions;
+    END IF;
+END $$;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS user_email_collisions;
+DROP INDEX IF EXISTS users_email_normalized_idx;
+ALTER TABLE users DROP COLUMN IF EXISTS email_normalized
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+-- PHC strings of argon2id with a pepper id are longer than bcrypt hashes
+ALTER TABLE users ALTER COLUMN password TYPE VARCHAR(255);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+-- Fails while argon2id hashes are stored, switch USER_PASSWORD_HASHER back to bcrypt and let users log in first
+ALTER TABLE users ALTER COLUMN password TYPE VARCHAR(60)
+-- +goose StatementEnd
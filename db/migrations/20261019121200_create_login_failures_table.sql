@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE login_failures
+(
+    scope VARCHAR(16) NOT NULL,
+    subject VARCHAR(64) NOT NULL,
+    failures INT NOT NULL DEFAULT 0,
+    last_failed_at TIMESTAMP NOT NULL,
+    blocked_until TIMESTAMP NULL DEFAULT NULL,
+    locked_at TIMESTAMP NULL DEFAULT NULL,
+    PRIMARY KEY (scope, subject)
+);
+
+CREATE INDEX login_failures_last_failed_at_idx ON login_failures (last_failed_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS login_failures
+-- +goose StatementEnd
@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Every sign-in attempt, user_id is empty for unknown emails. device_hash is the hash of the user agent.
+CREATE TABLE login_events
+(
+    id   uuid DEFAULT uuid_generate_v4() NOT NULL PRIMARY KEY,
+    user_id uuid NULL DEFAULT NULL REFERENCES users (id) ON DELETE CASCADE,
+    method VARCHAR(16) NOT NULL,
+    success BOOLEAN NOT NULL,
+    failure_reason VARCHAR(32) NOT NULL DEFAULT '',
+    ip VARCHAR(45) NOT NULL DEFAULT '',
+    user_agent VARCHAR(512) NOT NULL DEFAULT '',
+    device_hash VARCHAR(64) NOT NULL DEFAULT '',
+    country VARCHAR(2) NOT NULL DEFAULT '',
+    city VARCHAR(100) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX login_events_user_id_idx ON login_events (user_id, created_at);
+CREATE INDEX login_events_ip_idx ON login_events (ip, created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS login_events
+-- +goose StatementEnd
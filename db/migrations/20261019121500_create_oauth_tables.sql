@@ -0,0 +1,68 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Applications signing users in through OAuth, secret_hash is empty for public clients. Lists are space separated.
+CREATE TABLE oauth_clients
+(
+    id   uuid DEFAULT uuid_generate_v4() NOT NULL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    secret_hash VARCHAR(64) NOT NULL DEFAULT '',
+    redirect_uris TEXT NOT NULL DEFAULT '',
+    grant_types VARCHAR(255) NOT NULL DEFAULT '',
+    scope VARCHAR(1024) NOT NULL DEFAULT '',
+    created_by uuid NULL DEFAULT NULL REFERENCES users (id) ON DELETE SET NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP NULL DEFAULT NULL
+);
+
+-- Scopes the user has allowed to the client, the consent screen is skipped when they cover the request
+CREATE TABLE oauth_consents
+(
+    user_id uuid NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    client_id uuid NOT NULL REFERENCES oauth_clients (id) ON DELETE CASCADE,
+    scope VARCHAR(1024) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, client_id)
+);
+
+CREATE TABLE oauth_authorization_codes
+(
+    id   uuid DEFAULT uuid_generate_v4() NOT NULL PRIMARY KEY,
+    client_id uuid NOT NULL REFERENCES oauth_clients (id) ON DELETE CASCADE,
+    user_id uuid NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    code_hash VARCHAR(64) NOT NULL UNIQUE,
+    redirect_uri TEXT NOT NULL,
+    scope VARCHAR(1024) NOT NULL DEFAULT '',
+    code_challenge VARCHAR(128) NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP NULL DEFAULT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Opaque access and refresh tokens, user_id is empty for the client credentials grant. Tokens issued for one
+-- authorization share the family, so a replayed code or refresh token revokes all of them.
+CREATE TABLE oauth_tokens
+(
+    id   uuid DEFAULT uuid_generate_v4() NOT NULL PRIMARY KEY,
+    family_id uuid NOT NULL,
+    client_id uuid NOT NULL REFERENCES oauth_clients (id) ON DELETE CASCADE,
+    user_id uuid NULL DEFAULT NULL REFERENCES users (id) ON DELETE CASCADE,
+    type VARCHAR(16) NOT NULL,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    scope VARCHAR(1024) NOT NULL DEFAULT '',
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP NULL DEFAULT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX oauth_tokens_family_id_idx ON oauth_tokens (family_id);
+CREATE INDEX oauth_tokens_client_id_idx ON oauth_tokens (client_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS oauth_tokens;
+DROP TABLE IF EXISTS oauth_authorization_codes;
+DROP TABLE IF EXISTS oauth_consents;
+DROP TABLE IF EXISTS oauth_clients
+-- +goose StatementEnd
@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+-- OpenID Connect: nonce of the authentication request and the sign-in session go into ID tokens, logout redirects
+-- only to registered URIs
+ALTER TABLE oauth_clients ADD COLUMN post_logout_redirect_uris TEXT NOT NULL DEFAULT '';
+ALTER TABLE oauth_authorization_codes ADD COLUMN nonce VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE oauth_authorization_codes ADD COLUMN session_id uuid NULL DEFAULT NULL;
+ALTER TABLE oauth_tokens ADD COLUMN session_id uuid NULL DEFAULT NULL;
+
+CREATE INDEX oauth_tokens_session_id_idx ON oauth_tokens (session_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS oauth_tokens_session_id_idx;
+ALTER TABLE oauth_tokens DROP COLUMN IF EXISTS session_id;
+ALTER TABLE oauth_authorization_codes DROP COLUMN IF EXISTS session_id;
+ALTER TABLE oauth_authorization_codes DROP COLUMN IF EXISTS nonce;
+ALTER TABLE oauth_clients DROP COLUMN IF EXISTS post_logout_redirect_uris
+-- +goose StatementEnd
@@ -0,0 +1,36 @@
+-- +goose Up
+-- +goose StatementBegin
+-- An identity links the account of an external provider to the user, subject is the account id at the provider
+CREATE TABLE user_identities
+(
+    id   uuid DEFAULT uuid_generate_v4() NOT NULL PRIMARY KEY,
+    user_id uuid NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    provider VARCHAR(32) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP NULL DEFAULT NULL,
+    UNIQUE (provider, subject),
+    UNIQUE (user_id, provider)
+);
+
+-- The state of a sign-in started at the provider, user_id is set when an identity is being linked
+CREATE TABLE external_login_states
+(
+    id   uuid DEFAULT uuid_generate_v4() NOT NULL PRIMARY KEY,
+    user_id uuid NULL DEFAULT NULL REFERENCES users (id) ON DELETE CASCADE,
+    provider VARCHAR(32) NOT NULL,
+    purpose VARCHAR(32) NOT NULL,
+    state_hash VARCHAR(64) NOT NULL UNIQUE,
+    nonce VARCHAR(64) NOT NULL DEFAULT '',
+    code_verifier VARCHAR(128) NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS external_login_states;
+DROP TABLE IF EXISTS user_identities
+-- +goose StatementEnd
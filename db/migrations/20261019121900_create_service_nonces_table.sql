@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE service_nonces
+(
+    service VARCHAR(100) NOT NULL,
+    nonce VARCHAR(64) NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (service, nonce)
+);
+
+CREATE INDEX service_nonces_expires_at_idx ON service_nonces (expires_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS service_nonces
+-- +goose StatementEnd
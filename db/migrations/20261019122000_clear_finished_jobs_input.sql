@@ -0,0 +1,9 @@
+-- +goose Up
+-- +goose StatementBegin
+UPDATE jobs SET input = NULL WHERE status IN ('succeeded', 'failed', 'cancelled') AND input IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 1
+-- +goose StatementEnd
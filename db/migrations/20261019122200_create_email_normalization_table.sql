@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Rules of NormalizeEmail which users.email_normalized was computed with. The table starts empty, so the first start
+-- of the service normalises stored emails by the Go function: the SQL backfill knew neither punycode nor the
+-- USER_EMAIL_PLUS_DOMAINS / USER_EMAIL_DOT_DOMAINS rules.
+CREATE TABLE email_normalization
+(
+    id INT NOT NULL PRIMARY KEY DEFAULT 1 CHECK (id = 1),
+    rules TEXT NOT NULL,
+    normalized_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS email_normalization
+-- +goose StatementEnd
@@ -0,0 +1,79 @@
+package geoip
+
+import (
+	"fmt"
+	"github.com/apiboxgo/library-utils/dictionary"
+	"github.com/apiboxgo/library-utils/utils"
+	"net"
+	"sync"
+	"user-service/env"
+)
+
+// Location is the coarse place of an address, fields are empty when the database does not know it
+type Location struct {
+	Country string
+	City    string
+}
+
+// Locator finds the location of an address
+type Locator interface {
+	Lookup(ip net.IP) (Location, error)
+}
+
+// New returns the locator of the database file (MaxMind DB, for example GeoLite2-City.mmdb), an empty path gives
+// the locator which knows nothing
+func New(path string) (Locator, error) {
+	if path == "" {
+		return NewStaticLocator(nil), nil
+	}
+	return OpenMaxmindLocator(path)
+}
+
+var defaultLocator Locator
+var defaultLocatorMutex sync.RWMutex
+
+// Default returns the locator set by SetDefault, when it is not set the database of GEOIP_DATABASE_FILE is opened
+func Default() (Locator, error) {
+	defaultLocatorMutex.RLock()
+	locator := defaultLocator
+	defaultLocatorMutex.RUnlock()
+	if locator != nil {
+		return locator, nil
+	}
+
+	locator, err := New(env.String("GEOIP_DATABASE_FILE", ""))
+	if err != nil {
+		return nil, err
+	}
+
+	SetDefault(locator)
+	return locator, nil
+}
+
+// SetDefault replaces the locator used by Lookup, tests use it with StaticLocator
+func SetDefault(locator Locator) {
+	defaultLocatorMutex.Lock()
+	defaultLocator = locator
+	defaultLocatorMutex.Unlock()
+}
+
+// Lookup returns the location of the address by the default locator. The location is informational, so errors are
+// only logged and give an empty location.
+func Lookup(ip string) Location {
+	address := net.ParseIP(ip)
+	if address == nil {
+		utils.LogError(dictionary.SomethingWrong, fmt.Errorf(ErrorInvalidIp, ip))
+		return Location{}
+	}
+
+	locator, err := Default()
+	if err == nil {
+		var location Location
+		if location, err = locator.Lookup(address); err == nil {
+			return location
+		}
+	}
+
+	utils.LogError(dictionary.SomethingWrong, err)
+	return Location{}
+}
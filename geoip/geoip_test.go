@@ -0,0 +1,26 @@
+package geoip
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestLookup_StaticLocator(t *testing.T) {
+	SetDefault(NewStaticLocator(map[string]Location{
+		"203.0.113.7": {Country: "DE", City: "Berlin"},
+	}))
+	defer SetDefault(nil)
+
+	assert.Equal(t, Location{Country: "DE", City: "Berlin"}, Lookup("203.0.113.7"))
+	assert.Equal(t, Location{}, Lookup("198.51.100.1"))
+	assert.Equal(t, Location{}, Lookup("not-an-ip"))
+}
+
+func TestNew(t *testing.T) {
+	locator, err := New("")
+	assert.Nil(t, err)
+	assert.IsType(t, &StaticLocator{}, locator)
+
+	_, err = New("missing.mmdb")
+	assert.NotNil(t, err)
+}
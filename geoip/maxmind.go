@@ -0,0 +1,41 @@
+package geoip
+
+import (
+	"github.com/oschwald/maxminddb-golang"
+	"net"
+)
+
+// maxmindRecord reads both country and city databases, the city is empty in the country one
+type maxmindRecord struct {
+	Country struct {
+		IsoCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+}
+
+// MaxmindLocator reads the MaxMind DB file, the file is memory mapped and shared by requests
+type MaxmindLocator struct {
+	reader *maxminddb.Reader
+}
+
+func OpenMaxmindLocator(path string) (*MaxmindLocator, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &MaxmindLocator{reader: reader}, nil
+}
+
+func (locator *MaxmindLocator) Lookup(ip net.IP) (Location, error) {
+	var record maxmindRecord
+	if err := locator.reader.Lookup(ip, &record); err != nil {
+		return Location{}, err
+	}
+
+	return Location{
+		Country: record.Country.IsoCode,
+		City:    record.City.Names["en"],
+	}, nil
+}
@@ -0,0 +1,3 @@
+package geoip
+
+const ErrorInvalidIp = "Invalid IP address %s"
@@ -0,0 +1,267 @@
+package idp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"user-service/env"
+)
+
+var ErrInvalidIdentity = errors.New(ErrorInvalidIdentity)
+
+// Provider is an external identity provider. With Issuer it is an OpenID provider (Google, Microsoft, Keycloak), the
+// endpoints come from discovery and the identity from the verified ID token. Without Issuer it is a plain OAuth 2.0
+// provider (GitHub), the identity comes from UserinfoUrl.
+type Provider struct {
+	Name         string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// RedirectUrl is the callback page of the frontend, it sends the code and state to the service
+	RedirectUrl string
+	Issuer      string
+	AuthUrl     string
+	TokenUrl    string
+	UserinfoUrl string
+	// TrustEmail treats the email of the provider as verified when it has no email_verified claim
+	TrustEmail bool
+}
+
+// Identity is the external account, Subject is unique within the provider
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+// Providers returns names of the providers listed in IDP_PROVIDERS
+func Providers() []string {
+	return env.List("IDP_PROVIDERS", nil)
+}
+
+// LoadProvider reads IDP_<NAME>_* variables of the provider, nil means it is not listed in IDP_PROVIDERS
+func LoadProvider(name string) *Provider {
+	if !slices.Contains(Providers(), name) {
+		return nil
+	}
+
+	prefix := "IDP_" + strings.ToUpper(name) + "_"
+	issuer := env.String(prefix+"ISSUER", "")
+	var scopes []string
+	if issuer != "" {
+		scopes = []string{oidc.ScopeOpenID, "email"}
+	}
+
+	return &Provider{
+		Name:         name,
+		ClientID:     env.String(prefix+"CLIENT_ID", ""),
+		ClientSecret: env.String(prefix+"CLIENT_SECRET", ""),
+		Scopes:       env.List(prefix+"SCOPES", scopes),
+		RedirectUrl:  fmt.Sprintf(env.String("IDP_REDIRECT_URL", "http://127.0.0.1:8081/auth/external/%s/callback"), name),
+		Issuer:       issuer,
+		AuthUrl:      env.String(prefix+"AUTH_URL", ""),
+		TokenUrl:     env.String(prefix+"TOKEN_URL", ""),
+		UserinfoUrl:  env.String(prefix+"USERINFO_URL", ""),
+		TrustEmail:   env.Bool(prefix+"TRUST_EMAIL", false),
+	}
+}
+
+// AuthCodeUrl is where the browser signs in at the provider. The nonce goes into the ID token, the PKCE verifier
+// proves the code exchange is made by the same flow.
+func (provider *Provider) AuthCodeUrl(state string, nonce string, verifier string) (string, error) {
+	config, _, err := provider.oauth2Config()
+	if err != nil {
+		return "", err
+	}
+
+	options := []oauth2.AuthCodeOption{oauth2.S256ChallengeOption(verifier)}
+	if provider.Issuer != "" {
+		options = append(options, oidc.Nonce(nonce))
+	}
+	return config.AuthCodeURL(state, options...), nil
+}
+
+// Exchange trades the code for tokens and returns the identity. ErrInvalidIdentity means the code, the ID token or
+// its nonce is rejected, other errors are failures of the provider.
+func (provider *Provider) Exchange(ctx context.Context, code string, verifier string, nonce string) (*Identity, error) {
+	ctx = clientContext(ctx)
+	config, discovered, err := provider.oauth2Config()
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	var retrieveError *oauth2.RetrieveError
+	if errors.As(err, &retrieveError) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidIdentity, retrieveError.ErrorCode)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if discovered == nil {
+		return provider.userinfoIdentity(ctx, token)
+	}
+	return provider.idTokenIdentity(ctx, discovered, token, nonce)
+}
+
+// idTokenIdentity verifies the ID token, the email missing there is taken from userinfo
+func (provider *Provider) idTokenIdentity(ctx context.Context, discovered *oidc.Provider, token *oauth2.Token, nonce string) (*Identity, error) {
+	raw, _ := token.Extra("id_token").(string)
+	idToken, err := discovered.Verifier(&oidc.Config{ClientID: provider.ClientID}).Verify(ctx, raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidIdentity, err)
+	}
+
+	if idToken.Nonce != nonce {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidIdentity, ErrorNonceMismatch)
+	}
+
+	claims := map[string]any{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+
+	identity := provider.identity(claims)
+	if identity.Email == "" && discovered.UserInfoEndpoint() != "" {
+		userinfo, err := discovered.UserInfo(ctx, oauth2.StaticTokenSource(token))
+		if err != nil {
+			return nil, err
+		}
+
+		// Userinfo of another subject would be a token mix-up, OpenID Connect Core 5.3.2
+		if userinfo.Subject == identity.Subject {
+			identity.Email = userinfo.Email
+			identity.EmailVerified = userinfo.EmailVerified || (provider.TrustEmail && userinfo.Email != "")
+		}
+	}
+	return identity, nil
+}
+
+// userinfoIdentity reads the account of a plain OAuth 2.0 provider, GitHub names the subject id and sends it as a number
+func (provider *Provider) userinfoIdentity(ctx context.Context, token *oauth2.Token) (*Identity, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, provider.UserinfoUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", "application/json")
+
+	response, err := oauth2.NewClient(ctx, oauth2.StaticTokenSource(token)).Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf(ErrorUserinfoStatus, response.StatusCode)
+	}
+
+	claims := map[string]any{}
+	decoder := json.NewDecoder(response.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&claims); err != nil {
+		return nil, err
+	}
+
+	identity := provider.identity(claims)
+	if identity.Subject == "" {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidIdentity, ErrorSubjectMissing)
+	}
+	return identity, nil
+}
+
+// identity reads the standard claims, email_verified sent as a string by some providers is accepted as well
+func (provider *Provider) identity(claims map[string]any) *Identity {
+	identity := &Identity{
+		Subject: claimString(claims["sub"]),
+	}
+	if identity.Subject == "" {
+		identity.Subject = claimString(claims["id"])
+	}
+
+	identity.Email, _ = claims["email"].(string)
+	verified, isSet := claims["email_verified"]
+	if isSet {
+		identity.EmailVerified, _ = strconv.ParseBool(claimString(verified))
+	} else {
+		identity.EmailVerified = provider.TrustEmail
+	}
+
+	if identity.Email == "" {
+		identity.EmailVerified = false
+	}
+	return identity
+}
+
+func (provider *Provider) oauth2Config() (*oauth2.Config, *oidc.Provider, error) {
+	config := &oauth2.Config{
+		ClientID:     provider.ClientID,
+		ClientSecret: provider.ClientSecret,
+		RedirectURL:  provider.RedirectUrl,
+		Scopes:       provider.Scopes,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  provider.AuthUrl,
+			TokenURL: provider.TokenUrl,
+		},
+	}
+	if provider.Issuer == "" {
+		return config, nil, nil
+	}
+
+	discovered, err := discover(provider.Issuer)
+	if err != nil {
+		return nil, nil, err
+	}
+	config.Endpoint = discovered.Endpoint()
+	return config, discovered, nil
+}
+
+// discoveries keeps the discovery documents by issuer, the keys of the provider are refreshed by go-oidc itself
+var discoveries = map[string]*oidc.Provider{}
+var discoveriesMutex sync.Mutex
+
+func discover(issuer string) (*oidc.Provider, error) {
+	discoveriesMutex.Lock()
+	defer discoveriesMutex.Unlock()
+	if provider, isFound := discoveries[issuer]; isFound {
+		return provider, nil
+	}
+
+	// The provider keeps the context for fetching keys later, so it must outlive the request
+	provider, err := oidc.NewProvider(clientContext(context.Background()), issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	discoveries[issuer] = provider
+	return provider, nil
+}
+
+// clientContext makes the libraries use the client with the timeout, the default one waits forever
+func clientContext(ctx context.Context) context.Context {
+	client := &http.Client{Timeout: env.Duration("IDP_HTTP_TIMEOUT", 10*time.Second)}
+	return oidc.ClientContext(ctx, client)
+}
+
+func claimString(value any) string {
+	switch typed := value.(type) {
+	case string:
+		return typed
+	case json.Number:
+		return typed.String()
+	case float64:
+		return strconv.FormatFloat(typed, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(typed)
+	}
+	return ""
+}
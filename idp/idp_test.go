@@ -0,0 +1,100 @@
+package idp
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+	"testing"
+)
+
+func TestExchange_OpenidProvider(t *testing.T) {
+	mock := startMock(t)
+	t.Setenv("IDP_PROVIDERS", "mock")
+	t.Setenv("IDP_MOCK_ISSUER", mock.URL())
+	t.Setenv("IDP_MOCK_CLIENT_ID", mock.ClientID)
+	t.Setenv("IDP_MOCK_CLIENT_SECRET", "secret")
+	mock.SetIdentity(Identity{Subject: "248289761001", Email: "jane@example.com", EmailVerified: true})
+
+	provider := LoadProvider("mock")
+	assert.Equal(t, []string{"openid", "email"}, provider.Scopes)
+	assert.Nil(t, LoadProvider("unknown"))
+
+	verifier := oauth2.GenerateVerifier()
+	authorizationUrl, err := provider.AuthCodeUrl("xyz", "n-0S6_WzA2Mj", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, state, err := mock.SignIn(authorizationUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "xyz", state)
+
+	identity, err := provider.Exchange(context.Background(), code, verifier, "n-0S6_WzA2Mj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, &Identity{Subject: "248289761001", Email: "jane@example.com", EmailVerified: true}, identity)
+
+	// The code is single use
+	_, err = provider.Exchange(context.Background(), code, verifier, "n-0S6_WzA2Mj")
+	assert.ErrorIs(t, err, ErrInvalidIdentity)
+
+	// The ID token of another flow has another nonce
+	authorizationUrl, _ = provider.AuthCodeUrl("xyz", "other", verifier)
+	code, _, _ = mock.SignIn(authorizationUrl)
+	_, err = provider.Exchange(context.Background(), code, verifier, "n-0S6_WzA2Mj")
+	assert.ErrorIs(t, err, ErrInvalidIdentity)
+}
+
+func TestExchange_OAuth2Provider(t *testing.T) {
+	mock := startMock(t)
+	t.Setenv("IDP_PROVIDERS", "github")
+	t.Setenv("IDP_GITHUB_AUTH_URL", mock.URL()+"/authorize")
+	t.Setenv("IDP_GITHUB_TOKEN_URL", mock.URL()+"/token")
+	t.Setenv("IDP_GITHUB_USERINFO_URL", mock.URL()+"/userinfo")
+	t.Setenv("IDP_GITHUB_CLIENT_ID", mock.ClientID)
+	mock.SetIdentity(Identity{Subject: "583231", Email: "octocat@example.com"})
+
+	provider := LoadProvider("github")
+	verifier := oauth2.GenerateVerifier()
+	authorizationUrl, _ := provider.AuthCodeUrl("xyz", "", verifier)
+	code, _, err := mock.SignIn(authorizationUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identity, err := provider.Exchange(context.Background(), code, verifier, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, &Identity{Subject: "583231", Email: "octocat@example.com", EmailVerified: false}, identity)
+}
+
+func TestIdentity_Claims(t *testing.T) {
+	var claims map[string]any
+	_ = json.Unmarshal([]byte(`{"id": 583231, "email": "octocat@example.com"}`), &claims)
+	assert.Equal(t, &Identity{Subject: "583231", Email: "octocat@example.com"}, (&Provider{}).identity(claims))
+	assert.True(t, (&Provider{TrustEmail: true}).identity(claims).EmailVerified)
+
+	claims = nil
+	_ = json.Unmarshal([]byte(`{"sub": "a1", "email": "a@example.com", "email_verified": "true"}`), &claims)
+	assert.Equal(t, &Identity{Subject: "a1", Email: "a@example.com", EmailVerified: true}, (&Provider{}).identity(claims))
+
+	// A verified claim without email verifies nothing
+	claims = nil
+	_ = json.Unmarshal([]byte(`{"sub": "a2", "email_verified": true}`), &claims)
+	assert.False(t, (&Provider{}).identity(claims).EmailVerified)
+}
+
+// === Sys
+func startMock(t *testing.T) *MockProvider {
+	mock, err := NewMockProvider("client-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(mock.Close)
+	return mock
+}
@@ -0,0 +1,6 @@
+package idp
+
+const ErrorInvalidIdentity = "External identity is not valid"
+const ErrorNonceMismatch = "ID token nonce does not match the request"
+const ErrorSubjectMissing = "Userinfo has no subject"
+const ErrorUserinfoStatus = "Userinfo answered with status %d"
@@ -0,0 +1,214 @@
+package idp
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v5"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const mockKeyId = "mock"
+
+// MockProvider is a local OpenID provider for tests and development. Its authorization endpoint signs in the
+// identity set by SetIdentity at once and redirects back with the code, there is no login page.
+type MockProvider struct {
+	Server   *httptest.Server
+	ClientID string
+	key      *rsa.PrivateKey
+	mutex    sync.Mutex
+	identity Identity
+	codes    map[string]mockGrant
+	tokens   map[string]Identity
+}
+
+type mockGrant struct {
+	identity  Identity
+	nonce     string
+	challenge string
+}
+
+func NewMockProvider(clientId string) (*MockProvider, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := &MockProvider{
+		ClientID: clientId,
+		key:      key,
+		codes:    map[string]mockGrant{},
+		tokens:   map[string]Identity{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", provider.discovery)
+	mux.HandleFunc("/jwks", provider.jwks)
+	mux.HandleFunc("/authorize", provider.authorize)
+	mux.HandleFunc("/token", provider.token)
+	mux.HandleFunc("/userinfo", provider.userinfo)
+	provider.Server = httptest.NewServer(mux)
+	return provider, nil
+}
+
+func (provider *MockProvider) URL() string {
+	return provider.Server.URL
+}
+
+func (provider *MockProvider) Close() {
+	provider.Server.Close()
+}
+
+// SetIdentity is the account signed in by the next authorization
+func (provider *MockProvider) SetIdentity(identity Identity) {
+	provider.mutex.Lock()
+	provider.identity = identity
+	provider.mutex.Unlock()
+}
+
+// SignIn opens the authorization URL as the browser would and returns the code and state of the redirect
+func (provider *MockProvider) SignIn(authorizationUrl string) (string, string, error) {
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	response, err := client.Get(authorizationUrl)
+	if err != nil {
+		return "", "", err
+	}
+	defer response.Body.Close()
+
+	location, err := url.Parse(response.Header.Get("Location"))
+	if err != nil {
+		return "", "", err
+	}
+	return location.Query().Get("code"), location.Query().Get("state"), nil
+}
+
+func (provider *MockProvider) discovery(w http.ResponseWriter, _ *http.Request) {
+	writeJson(w, http.StatusOK, map[string]any{
+		"issuer":                                provider.URL(),
+		"authorization_endpoint":                provider.URL() + "/authorize",
+		"token_endpoint":                        provider.URL() + "/token",
+		"userinfo_endpoint":                     provider.URL() + "/userinfo",
+		"jwks_uri":                              provider.URL() + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (provider *MockProvider) jwks(w http.ResponseWriter, _ *http.Request) {
+	writeJson(w, http.StatusOK, map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"use": "sig",
+		"alg": "RS256",
+		"kid": mockKeyId,
+		"n":   base64.RawURLEncoding.EncodeToString(provider.key.PublicKey.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(provider.key.PublicKey.E)).Bytes()),
+	}}})
+}
+
+func (provider *MockProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	redirectUri, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || query.Get("client_id") != provider.ClientID {
+		writeJson(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	code := rand.Text()
+	provider.mutex.Lock()
+	provider.codes[code] = mockGrant{
+		identity:  provider.identity,
+		nonce:     query.Get("nonce"),
+		challenge: query.Get("code_challenge"),
+	}
+	provider.mutex.Unlock()
+
+	result := redirectUri.Query()
+	result.Set("code", code)
+	result.Set("state", query.Get("state"))
+	redirectUri.RawQuery = result.Encode()
+	http.Redirect(w, r, redirectUri.String(), http.StatusFound)
+}
+
+func (provider *MockProvider) token(w http.ResponseWriter, r *http.Request) {
+	clientId, _, isBasic := r.BasicAuth()
+	if !isBasic {
+		clientId = r.FormValue("client_id")
+	}
+
+	provider.mutex.Lock()
+	grant, isFound := provider.codes[r.FormValue("code")]
+	delete(provider.codes, r.FormValue("code"))
+	provider.mutex.Unlock()
+
+	hash := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if !isFound || clientId != provider.ClientID || base64.RawURLEncoding.EncodeToString(hash[:]) != grant.challenge {
+		writeJson(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            provider.URL(),
+		"sub":            grant.identity.Subject,
+		"aud":            provider.ClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+		"nonce":          grant.nonce,
+		"email":          grant.identity.Email,
+		"email_verified": grant.identity.EmailVerified,
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = mockKeyId
+	idToken, err := token.SignedString(provider.key)
+	if err != nil {
+		writeJson(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	accessToken := rand.Text()
+	provider.mutex.Lock()
+	provider.tokens[accessToken] = grant.identity
+	provider.mutex.Unlock()
+
+	writeJson(w, http.StatusOK, map[string]any{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+func (provider *MockProvider) userinfo(w http.ResponseWriter, r *http.Request) {
+	provider.mutex.Lock()
+	identity, isFound := provider.tokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+	provider.mutex.Unlock()
+
+	if !isFound {
+		writeJson(w, http.StatusUnauthorized, map[string]string{"error": "invalid_token"})
+		return
+	}
+
+	writeJson(w, http.StatusOK, map[string]any{
+		"sub":            identity.Subject,
+		"email":          identity.Email,
+		"email_verified": identity.EmailVerified,
+	})
+}
+
+func writeJson(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,44 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileMailer drops messages into the maildir, so they can be read by a mail client or by tests in dev
+type FileMailer struct {
+	dir string
+}
+
+func NewFileMailer(dir string) *FileMailer {
+	return &FileMailer{dir: dir}
+}
+
+// Send writes the message to tmp and moves it to new, readers never see a partially written file
+func (mailer *FileMailer) Send(ctx context.Context, message Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	data, err := message.Bytes()
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(mailer.dir, dir), 0o750); err != nil {
+			return err
+		}
+	}
+
+	name := fmt.Sprintf("%d.%s.eml", time.Now().UnixNano(), randomHex(8))
+	tmpPath := filepath.Join(mailer.dir, "tmp", name)
+	if err := os.WriteFile(tmpPath, data, 0o640); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, filepath.Join(mailer.dir, "new", name))
+}
@@ -0,0 +1,174 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+	"user-service/env"
+)
+
+const DriverSmtp = "smtp"
+const DriverFile = "file"
+const DriverMemory = "memory"
+
+// Message is a rendered email, Html is optional
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	Text    string
+	Html    string
+}
+
+// Mailer delivers a rendered message
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
+
+type Config struct {
+	Driver       string
+	From         string
+	SmtpHost     string
+	SmtpPort     int
+	SmtpUsername string
+	SmtpPassword string
+	FileDir      string
+	Attempts     int
+	RetryDelay   time.Duration
+	Locale       string
+}
+
+func LoadConfig() Config {
+	return Config{
+		Driver:       env.String("MAIL_DRIVER", DriverFile),
+		From:         env.String("MAIL_FROM", "no-reply@localhost"),
+		SmtpHost:     env.String("MAIL_SMTP_HOST", "localhost"),
+		SmtpPort:     env.Int("MAIL_SMTP_PORT", 25),
+		SmtpUsername: env.String("MAIL_SMTP_USERNAME", ""),
+		SmtpPassword: env.String("MAIL_SMTP_PASSWORD", ""),
+		FileDir:      env.String("MAIL_FILE_DIR", "var/mail"),
+		Attempts:     env.Int("MAIL_SEND_ATTEMPTS", 3),
+		RetryDelay:   env.Duration("MAIL_RETRY_DELAY", time.Second),
+		Locale:       env.String("MAIL_DEFAULT_LOCALE", "en"),
+	}
+}
+
+// New returns the mailer of the configured driver
+func New(config Config) (Mailer, error) {
+	switch config.Driver {
+	case DriverSmtp:
+		return NewSmtpMailer(config.SmtpHost, config.SmtpPort, config.SmtpUsername, config.SmtpPassword), nil
+	case DriverFile:
+		return NewFileMailer(config.FileDir), nil
+	case DriverMemory:
+		return NewMemoryMailer(), nil
+	}
+	return nil, fmt.Errorf(ErrorUnknownDriver, config.Driver)
+}
+
+var defaultMailer Mailer
+var defaultMailerMutex sync.RWMutex
+
+// Default returns the mailer set by SetDefault, when it is not set the mailer is created from the environment
+func Default() (Mailer, error) {
+	defaultMailerMutex.RLock()
+	mailer := defaultMailer
+	defaultMailerMutex.RUnlock()
+	if mailer != nil {
+		return mailer, nil
+	}
+
+	mailer, err := New(LoadConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	SetDefault(mailer)
+	return mailer, nil
+}
+
+// SetDefault replaces the mailer used by SendTemplate, tests use it to capture messages
+func SetDefault(mailer Mailer) {
+	defaultMailerMutex.Lock()
+	defaultMailer = mailer
+	defaultMailerMutex.Unlock()
+}
+
+// Bytes encodes the message as multipart/alternative RFC 5322 message
+func (message Message) Bytes() ([]byte, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	parts := []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=utf-8", message.Text},
+		{"text/html; charset=utf-8", message.Html},
+	}
+	for _, part := range parts {
+		if part.content == "" {
+			continue
+		}
+
+		partWriter, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		encoder := quotedprintable.NewWriter(partWriter)
+		if _, err := encoder.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+		if err := encoder.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	var result bytes.Buffer
+	headers := [][2]string{
+		{"From", message.From},
+		{"To", message.To},
+		{"Subject", mime.QEncoding.Encode("utf-8", message.Subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", messageId(message.From)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "multipart/alternative; boundary=" + writer.Boundary()},
+	}
+	for _, header := range headers {
+		result.WriteString(header[0] + ": " + header[1] + "\r\n")
+	}
+	result.WriteString("\r\n")
+	result.Write(body.Bytes())
+
+	return result.Bytes(), nil
+}
+
+func messageId(from string) string {
+	domain := "localhost"
+	if at := strings.LastIndex(from, "@"); at >= 0 {
+		domain = strings.Trim(from[at+1:], "> ")
+	}
+	return "<" + randomHex(16) + "@" + domain + ">"
+}
+
+func randomHex(size int) string {
+	buffer := make([]byte, size)
+	_, _ = rand.Read(buffer)
+	return hex.EncodeToString(buffer)
+}
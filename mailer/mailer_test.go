@@ -0,0 +1,157 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"github.com/emersion/go-smtp"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type templateData struct {
+	Email     string
+	Link      string
+	ExpiresIn string
+}
+
+func TestSmtpMailer_Send(t *testing.T) {
+	backend := &smtpBackend{}
+	server := smtp.NewServer(backend)
+	server.Domain = "localhost"
+	server.AllowInsecureAuth = true
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Close()
+
+	address := listener.Addr().(*net.TCPAddr)
+	mailer := NewSmtpMailer("127.0.0.1", address.Port, "", "")
+
+	err = mailer.Send(context.Background(), Message{
+		From:    "no-reply@user.com",
+		To:      "test_user_1@user.com",
+		Subject: "Confirm your email",
+		Text:    "Hello",
+		Html:    "<p>Hello</p>",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "no-reply@user.com", backend.from)
+	assert.Equal(t, []string{"test_user_1@user.com"}, backend.to)
+	assert.Contains(t, backend.data, "Subject: Confirm your email")
+	assert.Contains(t, backend.data, "Content-Type: text/html; charset=utf-8")
+}
+
+func TestFileMailer_Send(t *testing.T) {
+	dir := t.TempDir()
+
+	err := NewFileMailer(dir).Send(context.Background(), Message{
+		From:    "no-reply@user.com",
+		To:      "test_user_1@user.com",
+		Subject: "Confirm your email",
+		Text:    "Hello",
+	})
+	assert.NoError(t, err)
+
+	files, err := os.ReadDir(filepath.Join(dir, "new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(files))
+
+	data, err := os.ReadFile(filepath.Join(dir, "new", files[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(t, string(data), "To: test_user_1@user.com")
+}
+
+func TestRender_LocaleFallback(t *testing.T) {
+	data := templateData{Email: "<b>test_user_1@user.com</b>", Link: "http://127.0.0.1/?token=1", ExpiresIn: "24h"}
+
+	message, err := Render("ru-RU", "en", "verify_email", data)
+	assert.NoError(t, err)
+	assert.Equal(t, "Подтвердите email", message.Subject)
+
+	message, err = Render("de", "en", "verify_email", data)
+	assert.NoError(t, err)
+	assert.Equal(t, "Confirm your email", message.Subject)
+	assert.Contains(t, message.Text, data.Link)
+	assert.Contains(t, message.Html, "&lt;b&gt;test_user_1@user.com&lt;/b&gt;")
+
+	_, err = Render("en", "en", "unknown", data)
+	assert.Error(t, err)
+}
+
+func TestSendWithRetries(t *testing.T) {
+	mailer := &failingMailer{failures: 2}
+
+	attempts, err := sendWithRetries(context.Background(), mailer, Message{To: "test_user_1@user.com"}, 3, time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, attempts)
+
+	mailer = &failingMailer{failures: 5}
+	attempts, err = sendWithRetries(context.Background(), mailer, Message{To: "test_user_1@user.com"}, 3, time.Millisecond)
+	assert.Error(t, err)
+	assert.Equal(t, 3, attempts)
+}
+
+// === Sys
+type failingMailer struct {
+	failures int
+}
+
+func (mailer *failingMailer) Send(ctx context.Context, message Message) error {
+	if mailer.failures > 0 {
+		mailer.failures--
+		return errors.New("temporary failure")
+	}
+	return nil
+}
+
+// smtpBackend is a local SMTP stand-in which keeps the last received message
+type smtpBackend struct {
+	from string
+	to   []string
+	data string
+}
+
+func (backend *smtpBackend) Login(state *smtp.ConnectionState, username, password string) (smtp.Session, error) {
+	return backend, nil
+}
+
+func (backend *smtpBackend) AnonymousLogin(state *smtp.ConnectionState) (smtp.Session, error) {
+	return backend, nil
+}
+
+func (backend *smtpBackend) Mail(from string, opts smtp.MailOptions) error {
+	backend.from = from
+	return nil
+}
+
+func (backend *smtpBackend) Rcpt(to string) error {
+	backend.to = append(backend.to, to)
+	return nil
+}
+
+func (backend *smtpBackend) Data(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	backend.data = strings.ReplaceAll(string(data), "\r\n", "\n")
+	return err
+}
+
+func (backend *smtpBackend) Reset() {}
+
+func (backend *smtpBackend) Logout() error {
+	return nil
+}
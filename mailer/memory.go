@@ -0,0 +1,52 @@
+package mailer
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryMailer keeps sent messages in memory, it is used by tests
+type MemoryMailer struct {
+	mutex    sync.Mutex
+	messages []Message
+}
+
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+func (mailer *MemoryMailer) Send(ctx context.Context, message Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	mailer.mutex.Lock()
+	defer mailer.mutex.Unlock()
+	mailer.messages = append(mailer.messages, message)
+	return nil
+}
+
+// Messages returns a copy of the sent messages
+func (mailer *MemoryMailer) Messages() []Message {
+	mailer.mutex.Lock()
+	defer mailer.mutex.Unlock()
+	return append([]Message(nil), mailer.messages...)
+}
+
+// Last returns the last message sent to the address
+func (mailer *MemoryMailer) Last(to string) (Message, bool) {
+	mailer.mutex.Lock()
+	defer mailer.mutex.Unlock()
+	for i := len(mailer.messages) - 1; i >= 0; i-- {
+		if mailer.messages[i].To == to {
+			return mailer.messages[i], true
+		}
+	}
+	return Message{}, false
+}
+
+func (mailer *MemoryMailer) Reset() {
+	mailer.mutex.Lock()
+	defer mailer.mutex.Unlock()
+	mailer.messages = nil
+}
@@ -0,0 +1,4 @@
+package mailer
+
+const ErrorUnknownDriver = "Unknown mail driver %s"
+const ErrorTemplateNotFound = "Mail template %s not found"
@@ -0,0 +1,33 @@
+package mailer
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+)
+
+const SendStatusSent = "sent"
+const SendStatusFailed = "failed"
+
+// SendLog records every templated email, the body is not stored because it can contain tokens
+type SendLog struct {
+	ID        uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4()"`
+	Recipient string    `gorm:"type:varchar(120);not null"`
+	Template  string    `gorm:"type:varchar(60);not null"`
+	Locale    string    `gorm:"type:varchar(20);not null"`
+	Status    string    `gorm:"type:varchar(20);not null"`
+	Attempts  int       `gorm:"not null;default:0"`
+	Error     string    `gorm:"type:text;null;default:null"`
+	CreatedAt time.Time `gorm:"type:timestamp;not null"`
+}
+
+func (SendLog) TableName() string {
+	return "mail_send_log"
+}
+
+func (p *SendLog) BeforeCreate(tx *gorm.DB) (err error) {
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	return
+}
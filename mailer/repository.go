@@ -0,0 +1,15 @@
+package mailer
+
+import (
+	"github.com/apiboxgo/library-utils/api_init"
+)
+
+func CreateSendLog(sendLog *SendLog) error {
+	return api_init.GetDbh().Create(sendLog).Error
+}
+
+func GetSendLogByRecipient(recipient string) ([]SendLog, error) {
+	var result []SendLog
+	err := api_init.GetDbh().Where("recipient = ?", recipient).Order("created_at DESC").Find(&result).Error
+	return result, err
+}
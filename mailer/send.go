@@ -0,0 +1,67 @@
+package mailer
+
+import (
+	"context"
+	"github.com/apiboxgo/library-utils/utils"
+	"time"
+)
+
+// SendTemplate renders the template for the recipient, sends it with retries and writes the send log
+func SendTemplate(ctx context.Context, to string, locale string, name string, data any) error {
+	config := LoadConfig()
+
+	mailer, err := Default()
+	if err != nil {
+		return err
+	}
+
+	message, err := Render(locale, config.Locale, name, data)
+	if err != nil {
+		return err
+	}
+	message.From = config.From
+	message.To = to
+
+	attempts, err := sendWithRetries(ctx, mailer, message, max(config.Attempts, 1), config.RetryDelay)
+
+	sendLog := &SendLog{
+		Recipient: to,
+		Template:  name,
+		Locale:    locale,
+		Status:    SendStatusSent,
+		Attempts:  attempts,
+		CreatedAt: time.Now(),
+	}
+	if err != nil {
+		sendLog.Status = SendStatusFailed
+		sendLog.Error = err.Error()
+	}
+
+	if logErr := CreateSendLog(sendLog); logErr != nil {
+		utils.LogError("Mail send log error", logErr)
+	}
+
+	return err
+}
+
+// sendWithRetries doubles the delay after every failed attempt and returns the amount of attempts made
+func sendWithRetries(ctx context.Context, mailer Mailer, message Message, attempts int, delay time.Duration) (int, error) {
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = mailer.Send(ctx, message); err == nil {
+			return attempt, nil
+		}
+
+		if attempt == attempts {
+			return attempt, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return attempt, ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+	return attempts, err
+}
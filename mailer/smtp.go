@@ -0,0 +1,37 @@
+package mailer
+
+import (
+	"context"
+	"net"
+	"net/smtp"
+	"strconv"
+)
+
+// SmtpMailer sends messages through the SMTP relay, STARTTLS is used when the server offers it
+type SmtpMailer struct {
+	address string
+	auth    smtp.Auth
+}
+
+func NewSmtpMailer(host string, port int, username string, password string) *SmtpMailer {
+	mailer := &SmtpMailer{
+		address: net.JoinHostPort(host, strconv.Itoa(port)),
+	}
+	if username != "" {
+		mailer.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return mailer
+}
+
+func (mailer *SmtpMailer) Send(ctx context.Context, message Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	data, err := message.Bytes()
+	if err != nil {
+		return err
+	}
+
+	return smtp.SendMail(mailer.address, mailer.auth, message.From, []string{message.To}, data)
+}
@@ -0,0 +1,88 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	htmlTemplate "html/template"
+	"io/fs"
+	"strings"
+	textTemplate "text/template"
+)
+
+// Templates are stored as templates/<locale>/<name>.txt with a "subject" block and optional
+// templates/<locale>/<name>.html. The html version is escaped by html/template.
+//
+//go:embed templates
+var templates embed.FS
+
+// Render builds the message from the template of the locale. When the locale has no such template, the base
+// language (ru for ru-RU) and then defaultLocale are tried.
+func Render(locale string, defaultLocale string, name string, data any) (Message, error) {
+	for _, candidate := range localeCandidates(locale, defaultLocale) {
+		message, err := renderLocale(candidate, name, data)
+		if err == nil {
+			return message, nil
+		}
+
+		if !errors.Is(err, fs.ErrNotExist) {
+			return Message{}, err
+		}
+	}
+	return Message{}, fmt.Errorf(ErrorTemplateNotFound, name)
+}
+
+func renderLocale(locale string, name string, data any) (Message, error) {
+	base := "templates/" + locale + "/" + name
+	if _, err := fs.Stat(templates, base+".txt"); err != nil {
+		return Message{}, err
+	}
+
+	text, err := textTemplate.ParseFS(templates, base+".txt")
+	if err != nil {
+		return Message{}, err
+	}
+
+	var subject, body bytes.Buffer
+	if err := text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return Message{}, err
+	}
+	if err := text.ExecuteTemplate(&body, name+".txt", data); err != nil {
+		return Message{}, err
+	}
+
+	message := Message{
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    strings.TrimSpace(body.String()) + "\n",
+	}
+
+	if _, err := fs.Stat(templates, base+".html"); errors.Is(err, fs.ErrNotExist) {
+		return message, nil
+	}
+
+	html, err := htmlTemplate.ParseFS(templates, base+".html")
+	if err != nil {
+		return Message{}, err
+	}
+
+	var htmlBody bytes.Buffer
+	if err := html.Execute(&htmlBody, data); err != nil {
+		return Message{}, err
+	}
+	message.Html = htmlBody.String()
+
+	return message, nil
+}
+
+func localeCandidates(locale string, defaultLocale string) []string {
+	var candidates []string
+	locale = strings.ToLower(strings.ReplaceAll(locale, "_", "-"))
+	if locale != "" {
+		candidates = append(candidates, locale)
+		if language, _, found := strings.Cut(locale, "-"); found {
+			candidates = append(candidates, language)
+		}
+	}
+	return append(candidates, defaultLocale)
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"github.com/apiboxgo/library-utils/api_init"
 	"github.com/gin-gonic/gin"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
@@ -12,19 +13,81 @@ import (
 	"os/signal"
 	"syscall"
 	"time"
+	"user-service/api/auth"
+	"user-service/api/job"
+	"user-service/api/oauth"
+	"user-service/api/service"
 	"user-service/api/user"
-	"user-service/api_init"
 	_ "user-service/docs"
+	"user-service/env"
+	"user-service/ratelimit"
 )
 
-func routes(config *api_init.InitGlobalStruct) *gin.Engine {
+func routes(config *api_init.InitGlobalStruct, limits ratelimit.Store) (*gin.Engine, error) {
 	r := gin.Default()
 
-	user.InitUserRoutes(r)
+	// Адрес клиента из X-Forwarded-For берётся только от перечисленных прокси, иначе его подменит любой клиент
+	if err := r.SetTrustedProxies(env.List("TRUSTED_PROXIES", nil)); err != nil {
+		return nil, err
+	}
+
+	// Лимит запросов подключается до маршрутов, иначе gin не применит его к ним.
+	// API ключи считаются по хешу ключа, без запроса к базе на каждый запрос
+	key := ratelimit.FirstKey(auth.RateLimitKey(), ratelimit.ClientIp)
+	r.Use(ratelimit.Middleware(limits, key, rateLimitRules()...))
+
+	// Внутренние сервисы входят по клиентскому сертификату или подписи запроса, без учётных данных пользователя
+	user.InitUserRoutes(r.Group("", service.Authenticate()))
+	admin := r.Group("", auth.RequireAuth(), auth.RequireVerifiedEmail(auth.ActionAdmin), auth.RequireRole(user.RoleAdmin, user.RoleSupport))
+	user.InitUserAdminRoutes(admin)
+	auth.InitAuthRoutes(r)
+	// Задачи видит только создавший их сервис или пользователь
+	job.InitJobRoutes(r.Group("", auth.OptionalAuth(), service.Identify()), user.JobOwner)
+	oauth.InitOauthRoutes(r)
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
-	return r
+	return r, nil
+}
+
+// rateLimitRules проверяются по порядку, к запросу применяется первое подходящее правило
+func rateLimitRules() []ratelimit.Rule {
+	return []ratelimit.Rule{
+		{
+			Name:   "user_create",
+			Method: http.MethodPost,
+			Path:   user.UriUser,
+			Limit:  ratelimit.LoadLimit("RATE_LIMIT_USER_CREATE", ratelimit.Limit{Requests: 5, Period: time.Minute}),
+		},
+		{
+			Name:  "auth",
+			Path:  auth.UriAuth + "/*",
+			Limit: ratelimit.LoadLimit("RATE_LIMIT_AUTH", ratelimit.Limit{Requests: 20, Period: time.Minute}),
+		},
+		{
+			Name:   "read",
+			Method: http.MethodGet,
+			Path:   "*",
+			Limit:  ratelimit.LoadLimit("RATE_LIMIT_READ", ratelimit.Limit{Requests: 600, Period: time.Minute}),
+		},
+		{
+			Name:  "default",
+			Path:  "*",
+			Limit: ratelimit.LoadLimit("RATE_LIMIT_DEFAULT", ratelimit.Limit{Requests: 120, Period: time.Minute}),
+		},
+	}
 }
 
+// @securityDefinitions.apikey	BearerAuth
+// @in							header
+// @name						Authorization
+// @description				Access token from /auth/login or a personal API key in form "Bearer <token>"
+// @securityDefinitions.apikey	ApiKeyAuth
+// @in							header
+// @name						X-Api-Key
+// @description				Personal API key from POST /user/{id}/api-keys
+// @securityDefinitions.apikey	ServiceSignature
+// @in							header
+// @name						X-Service-Signature
+// @description				HMAC-SHA256 of the internal service with X-Service-Name, X-Service-Timestamp and X-Service-Nonce
 func main() {
 
 	fmt.Println("Init main ...")
@@ -33,17 +96,60 @@ func main() {
 		log.Fatal(err)
 	}
 
-	router := routes(api_init.InitGlobal)
+	// Без секрета токены подписать нельзя, а без ключа нельзя зашифровать секреты MFA, поэтому не запускаемся
+	if err := auth.LoadConfig().Validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Ключ подписи ID токенов читается при старте, чтобы ошибка в файле ключа не всплыла при первом входе
+	if _, err := oauth.DefaultSigningKey(); err != nil {
+		log.Fatal(err)
+	}
+
+	user.InitUserJobs()
+	auth.InitAuthJobs()
+	// Завершение сессий отзывает и выданные в них OAuth токены
+	oauth.InitOauthHooks()
+	// Сохранённые email нормализуются заново, если правила нормализации изменились с прошлого запуска
+	if err := user.EnqueueEmailNormalization(); err != nil {
+		log.Fatal(err)
+	}
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	workers := job.StartWorkers(workersCtx)
+	user.StartSuspensionSweeper(workersCtx)
+
+	limits, err := ratelimit.New(ratelimit.LoadConfig())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Без SERVER_TLS_CERT_FILE сервер работает по HTTP, с клиентским CA проверяет сертификаты сервисов
+	tlsConfig, err := service.LoadTLSConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	router, err := routes(api_init.InitGlobal, limits)
+	if err != nil {
+		log.Fatal(err)
+	}
 	srv := &http.Server{
-		Addr:    ":" + api_init.InitGlobal.Cfg.ServerPort,
-		Handler: router,
+		Addr:      ":" + api_init.InitGlobal.Cfg.ServerPort,
+		Handler:   router,
+		TLSConfig: tlsConfig,
 	}
 
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 
 	go func() {
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		serve := srv.ListenAndServe
+		if tlsConfig != nil {
+			// Сертификат уже загружен в TLSConfig
+			serve = func() error { return srv.ListenAndServeTLS("", "") }
+		}
+
+		if err := serve(); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("server error: %v", err)
 		}
 	}()
@@ -62,5 +168,9 @@ func main() {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
 
+	// Прерванные задачи возвращаются в очередь и будут выполнены другим экземпляром
+	stopWorkers()
+	workers.Wait()
+
 	log.Println("Server exiting")
 }
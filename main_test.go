@@ -0,0 +1,42 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"user-service/ratelimit"
+)
+
+func TestRoutes_SpoofedForwardedFor(t *testing.T) {
+	assert.Equal(t, "10.0.0.1", clientIp(t, "10.0.0.1", "203.0.113.7"))
+
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8")
+	assert.Equal(t, "203.0.113.7", clientIp(t, "10.0.0.1", "203.0.113.7"))
+	assert.Equal(t, "192.0.2.1", clientIp(t, "192.0.2.1", "203.0.113.7"))
+}
+
+func TestRoutes_InvalidTrustedProxies(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "not an address")
+	_, err := routes(nil, ratelimit.NewMemoryStore())
+	assert.NotNil(t, err)
+}
+
+// === Sys
+// clientIp returns the client address which handlers see for the request from remoteIp with the X-Forwarded-For header
+func clientIp(t *testing.T, remoteIp string, forwardedFor string) string {
+	router, err := routes(nil, ratelimit.NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	router.GET("/test/client-ip", func(c *gin.Context) { c.String(http.StatusOK, c.ClientIP()) })
+
+	req := httptest.NewRequest(http.MethodGet, "/test/client-ip", nil)
+	req.RemoteAddr = remoteIp + ":12345"
+	req.Header.Set("X-Forwarded-For", forwardedFor)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w.Body.String()
+}
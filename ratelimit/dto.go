@@ -0,0 +1,5 @@
+package ratelimit
+
+type ErrorResponseDto struct {
+	Message string `json:"message"`
+}
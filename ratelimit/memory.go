@@ -0,0 +1,61 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often full buckets are dropped, a full bucket is the same as a missing one
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+	limit     Limit
+}
+
+// MemoryStore keeps buckets in the process, every instance of the service counts requests on its own
+type MemoryStore struct {
+	mutex   sync.Mutex
+	buckets map[string]*bucket
+	sweptAt time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]*bucket{}}
+}
+
+func (store *MemoryStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	if err := ctx.Err(); err != nil {
+		return Result{}, err
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if now.Sub(store.sweptAt) >= sweepInterval {
+		store.sweep(now)
+	}
+
+	item, exists := store.buckets[key]
+	if !exists {
+		item = &bucket{tokens: float64(limit.Requests), updatedAt: now}
+		store.buckets[key] = item
+	}
+
+	var result Result
+	item.tokens, result = take(refill(item.tokens, item.updatedAt, limit, now), limit)
+	item.updatedAt = now
+	item.limit = limit
+	return result, nil
+}
+
+func (store *MemoryStore) sweep(now time.Time) {
+	for key, item := range store.buckets {
+		if refill(item.tokens, item.updatedAt, item.limit, now) >= float64(item.limit.Requests) {
+			delete(store.buckets, key)
+		}
+	}
+	store.sweptAt = now
+}
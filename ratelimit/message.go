@@ -0,0 +1,6 @@
+package ratelimit
+
+const ErrorTooManyRequests = "Too many requests, try again later"
+const ErrorUnknownStore = "Unknown rate limit store %s"
+const ErrorInvalidLimit = "Invalid rate limit %s, expected requests/period (example: 10/1m)"
+const ErrorUnexpectedStoreResult = "Unexpected rate limit store result %v"
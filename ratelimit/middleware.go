@@ -0,0 +1,122 @@
+package ratelimit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/apiboxgo/library-utils/dictionary"
+	"github.com/apiboxgo/library-utils/utils"
+	"github.com/gin-gonic/gin"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const HeaderApiKey = "X-Api-Key"
+
+// KeyFunc returns the client of the request or an empty string when it can not tell
+type KeyFunc func(c *gin.Context) string
+
+// Rule limits requests of routes matching Method and Path, an empty Method matches any. Path is the route pattern as
+// registered (example: /user/:id), a trailing * matches every route starting with the rest.
+type Rule struct {
+	Name   string
+	Method string
+	Path   string
+	Limit  Limit
+}
+
+func (rule Rule) matches(method string, path string) bool {
+	if rule.Method != "" && rule.Method != method {
+		return false
+	}
+	if prefix, isPrefix := strings.CutSuffix(rule.Path, "*"); isPrefix {
+		return strings.HasPrefix(path, prefix)
+	}
+	return rule.Path == path
+}
+
+// Middleware limits the request by the first matching rule, requests of routes without a rule are not limited.
+// Every rule has own buckets per client. When the store fails the request is let through, so an outage of the store
+// does not stop the service.
+func Middleware(store Store, key KeyFunc, rules ...Rule) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rule, isFound := matchRule(rules, c.Request.Method, c.FullPath())
+		if !isFound {
+			c.Next()
+			return
+		}
+
+		client := key(c)
+		if client == "" {
+			client = ClientIp(c)
+		}
+
+		result, err := store.Take(c.Request.Context(), rule.Name+":"+client, rule.Limit, time.Now())
+		if err != nil {
+			utils.LogError(dictionary.SomethingWrong, err)
+			c.Next()
+			return
+		}
+
+		setHeaders(c, rule.Limit, result)
+		if !result.Allowed {
+			c.Header("Retry-After", seconds(result.RetryAfter))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, &ErrorResponseDto{
+				Message: ErrorTooManyRequests,
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// FirstKey returns the key of the first function which knows the client, for example the user before the address
+func FirstKey(keys ...KeyFunc) KeyFunc {
+	return func(c *gin.Context) string {
+		for _, key := range keys {
+			if value := key(c); value != "" {
+				return value
+			}
+		}
+		return ""
+	}
+}
+
+// ClientIp keys the request by the client address. It depends on trusted proxies of gin: X-Forwarded-For of other
+// addresses is ignored, so the engine has to trust only real proxies (TRUSTED_PROXIES), gin trusts all by default.
+func ClientIp(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// SecretKey returns the key of a secret the client presents, for example an API key. The secret is hashed, so it is
+// not stored by the store.
+func SecretKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return "key:" + hex.EncodeToString(sum[:16])
+}
+
+// === Sys
+func matchRule(rules []Rule, method string, path string) (Rule, bool) {
+	for _, rule := range rules {
+		if rule.matches(method, path) {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+// setHeaders writes RateLimit-* headers of the IETF draft, times are in whole seconds
+func setHeaders(c *gin.Context, limit Limit, result Result) {
+	c.Header("RateLimit-Policy", fmt.Sprintf("%d;w=%s", limit.Requests, seconds(limit.Period)))
+	c.Header("RateLimit-Limit", strconv.Itoa(result.Limit))
+	c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	c.Header("RateLimit-Reset", seconds(result.Reset))
+}
+
+func seconds(duration time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(duration.Seconds())))
+}
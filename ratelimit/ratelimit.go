@@ -0,0 +1,123 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"user-service/env"
+)
+
+const StoreMemory = "memory"
+const StoreRedis = "redis"
+
+// Limit is a token bucket which holds Requests tokens and refills all of them during Period
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// ParseLimit reads the limit in form requests/period, for example 10/1m
+func ParseLimit(value string) (Limit, error) {
+	requests, period, isFound := strings.Cut(strings.TrimSpace(value), "/")
+	if !isFound {
+		return Limit{}, fmt.Errorf(ErrorInvalidLimit, value)
+	}
+
+	limit := Limit{}
+	var err error
+	if limit.Requests, err = strconv.Atoi(requests); err != nil || limit.Requests <= 0 {
+		return Limit{}, fmt.Errorf(ErrorInvalidLimit, value)
+	}
+	if limit.Period, err = time.ParseDuration(period); err != nil || limit.Period <= 0 {
+		return Limit{}, fmt.Errorf(ErrorInvalidLimit, value)
+	}
+	return limit, nil
+}
+
+// LoadLimit returns the limit of the environment variable, def is used when it is empty or invalid
+func LoadLimit(key string, def Limit) Limit {
+	limit, err := ParseLimit(env.String(key, ""))
+	if err != nil {
+		return def
+	}
+	return limit
+}
+
+// interval is the time to refill one token
+func (limit Limit) interval() time.Duration {
+	return limit.Period / time.Duration(limit.Requests)
+}
+
+// Result of taking a token, Reset is the time until the bucket is full again
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// Store keeps buckets, key identifies the rule and the client
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error)
+}
+
+type Config struct {
+	Store    string
+	RedisUrl string
+	Prefix   string
+}
+
+func LoadConfig() Config {
+	return Config{
+		Store:    env.String("RATE_LIMIT_STORE", StoreMemory),
+		RedisUrl: env.String("RATE_LIMIT_REDIS_URL", "redis://localhost:6379/0"),
+		Prefix:   env.String("RATE_LIMIT_PREFIX", "rate_limit:"),
+	}
+}
+
+// New returns the store of the configured backend
+func New(config Config) (Store, error) {
+	switch config.Store {
+	case StoreMemory:
+		return NewMemoryStore(), nil
+	case StoreRedis:
+		return NewRedisStoreFromUrl(config.RedisUrl, config.Prefix)
+	}
+	return nil, fmt.Errorf(ErrorUnknownStore, config.Store)
+}
+
+// refill returns tokens of the bucket at now, elapsed time refills them up to the limit
+func refill(tokens float64, updatedAt time.Time, limit Limit, now time.Time) float64 {
+	elapsed := now.Sub(updatedAt)
+	if elapsed > 0 {
+		tokens += float64(elapsed) / float64(limit.interval())
+	}
+	return math.Min(tokens, float64(limit.Requests))
+}
+
+// take spends a token of the bucket having tokens and returns the tokens left with the result
+func take(tokens float64, limit Limit) (float64, Result) {
+	allowed := tokens >= 1
+	if allowed {
+		tokens--
+	}
+	return tokens, newResult(tokens, allowed, limit)
+}
+
+// newResult describes the bucket having tokens left after the request
+func newResult(tokens float64, allowed bool, limit Limit) Result {
+	result := Result{
+		Allowed:   allowed,
+		Limit:     limit.Requests,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     time.Duration((float64(limit.Requests) - tokens) * float64(limit.interval())),
+	}
+	if !allowed {
+		result.RetryAfter = time.Duration((1 - tokens) * float64(limit.interval()))
+	}
+	return result
+}
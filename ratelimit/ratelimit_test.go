@@ -0,0 +1,150 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseLimit(t *testing.T) {
+	limit, err := ParseLimit("10/1m")
+	assert.Nil(t, err)
+	assert.Equal(t, Limit{Requests: 10, Period: time.Minute}, limit)
+
+	for _, value := range []string{"", "10", "0/1m", "10/0s", "ten/1m", "10/minute"} {
+		_, err = ParseLimit(value)
+		assert.NotNil(t, err, value)
+	}
+}
+
+func TestMemoryStore_Take(t *testing.T) {
+	testStoreTake(t, NewMemoryStore())
+}
+
+func TestRedisStore_Take(t *testing.T) {
+	server := miniredis.RunT(t)
+	store, err := NewRedisStoreFromUrl("redis://"+server.Addr()+"/0", "test:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testStoreTake(t, store)
+	assert.True(t, server.Exists("test:client_1"))
+	assert.Greater(t, server.TTL("test:client_1"), time.Duration(0))
+}
+
+func TestMiddleware_Rules(t *testing.T) {
+	apiKey := func(c *gin.Context) string {
+		if value := c.GetHeader(HeaderApiKey); value != "" {
+			return SecretKey(value)
+		}
+		return ""
+	}
+
+	router := gin.New()
+	router.Use(Middleware(NewMemoryStore(), FirstKey(apiKey, ClientIp),
+		Rule{Name: "create", Method: http.MethodPost, Path: "/user", Limit: Limit{Requests: 1, Period: time.Minute}},
+		Rule{Name: "auth", Path: "/auth/*", Limit: Limit{Requests: 2, Period: time.Minute}},
+	))
+	router.POST("/user", func(c *gin.Context) { c.Status(http.StatusCreated) })
+	router.GET("/user", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.POST("/auth/login", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	w := sendRequest(router, http.MethodPost, "/user", "")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "1;w=60", w.Header().Get("RateLimit-Policy"))
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+
+	w = sendRequest(router, http.MethodPost, "/user", "")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+	assert.Equal(t, "60", w.Header().Get("RateLimit-Reset"))
+
+	// Another client and routes without a rule are not affected
+	w = sendRequest(router, http.MethodPost, "/user", "key_1")
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	w = sendRequest(router, http.MethodGet, "/user", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("RateLimit-Limit"))
+
+	// Rules have own buckets
+	w = sendRequest(router, http.MethodPost, "/auth/login", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Remaining"))
+}
+
+func TestClientIp_SpoofedForwardedFor(t *testing.T) {
+	router := gin.New()
+	if err := router.SetTrustedProxies(nil); err != nil {
+		t.Fatal(err)
+	}
+	router.Use(Middleware(NewMemoryStore(), ClientIp,
+		Rule{Name: "default", Path: "*", Limit: Limit{Requests: 1, Period: time.Minute}},
+	))
+	router.GET("/user", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	send := func(forwardedFor string) int {
+		req := httptest.NewRequest(http.MethodGet, "/user", nil)
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// A new X-Forwarded-For does not give the client a new bucket
+	assert.Equal(t, http.StatusOK, send("203.0.113.1"))
+	assert.Equal(t, http.StatusTooManyRequests, send("203.0.113.2"))
+}
+
+// === Sys
+func testStoreTake(t *testing.T, store Store) {
+	ctx := context.Background()
+	limit := Limit{Requests: 3, Period: time.Minute}
+	now := time.Now()
+
+	for remaining := 2; remaining >= 0; remaining-- {
+		result, err := store.Take(ctx, "client_1", limit, now)
+		assert.Nil(t, err)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, remaining, result.Remaining)
+	}
+
+	result, err := store.Take(ctx, "client_1", limit, now)
+	assert.Nil(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 20*time.Second, result.RetryAfter)
+	assert.Equal(t, time.Minute, result.Reset)
+
+	result, err = store.Take(ctx, "client_2", limit, now)
+	assert.Nil(t, err)
+	assert.True(t, result.Allowed)
+
+	// One token is refilled every 20 seconds
+	result, err = store.Take(ctx, "client_1", limit, now.Add(30*time.Second))
+	assert.Nil(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+	assert.Equal(t, 50*time.Second, result.Reset)
+
+	result, err = store.Take(ctx, "client_1", limit, now.Add(10*time.Minute))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, result.Remaining)
+}
+
+func sendRequest(router *gin.Engine, method string, uri string, apiKey string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, uri, nil)
+	if apiKey != "" {
+		req.Header.Set(HeaderApiKey, apiKey)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}